@@ -0,0 +1,76 @@
+package multipartdownloader
+
+import "time"
+
+// Adaptive concurrency. Instead of keeping nConns connections, a controller
+// measures the throughput of the download every adaptiveInterval and adjusts
+// the number of connections between a minimum and a maximum: it adds one
+// while that makes the download faster, and halves them as soon as the
+// mirrors start throttling (429 Too Many Requests, 503 Service Unavailable)
+// or failing.
+
+// Period of the measurements of the adaptive concurrency
+var adaptiveInterval = time.Second
+
+// Minimal relative gain in throughput for a new connection to be kept
+const adaptiveGain = 0.05
+
+// Periods without adding connections after one didn't help
+const adaptiveHold = 5
+
+// Adjust the number of connections at runtime, between min and max, starting
+// with nConns. The downloader opens at most max connections.
+func WithAdaptiveConcurrency(minConns, maxConns int) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.minConns = minConns
+		dldr.maxConns = maxConns
+	}
+}
+
+// Decides the number of connections from the measurements
+type concurrencyController struct {
+	min, max int
+	limit    int     // Connections allowed now
+	lastRate float64 // Bytes per second in the previous period
+	raised   bool    // A connection was added in the previous period
+	hold     int     // Periods left before trying to add connections again
+}
+
+// Internal: create a controller starting with the given number of connections
+func newConcurrencyController(minConns, maxConns, start int) *concurrencyController {
+	minConns = max(minConns, 1)
+	maxConns = max(maxConns, minConns)
+	return &concurrencyController{min: minConns, max: maxConns, limit: clamp(start, minConns, maxConns)}
+}
+
+// Internal: account for a period, returning the connections allowed now
+func (c *concurrencyController) update(bytes int64, errors int64, elapsed time.Duration) int {
+	rate := float64(bytes) / elapsed.Seconds()
+	switch {
+	case errors > 0:
+		// Back off, the mirrors are overwhelmed or limiting us
+		c.limit = clamp(c.limit/2, c.min, c.max)
+		c.raised = false
+		c.hold = adaptiveHold
+	case c.raised && rate < c.lastRate*(1+adaptiveGain):
+		// The last connection added didn't help
+		c.limit = clamp(c.limit-1, c.min, c.max)
+		c.raised = false
+		c.hold = adaptiveHold
+	case c.hold > 0:
+		c.hold--
+		c.raised = false
+	case c.limit < c.max:
+		c.limit++
+		c.raised = true
+	default:
+		c.raised = false
+	}
+	c.lastRate = rate
+	return c.limit
+}
+
+// Internal: limit a value to a range
+func clamp(v, lo, hi int) int {
+	return min(max(v, lo), hi)
+}
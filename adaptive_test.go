@@ -0,0 +1,68 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Connections are added while they make the download faster, and halved on errors
+func TestConcurrencyController(t *testing.T) {
+	c := newConcurrencyController(1, 8, 2)
+	steps := []struct {
+		bytes, errors int64
+		limit         int
+	}{
+		{1000, 0, 3}, // Try one more
+		{1500, 0, 4}, // It helped, try another
+		{1520, 0, 3}, // It didn't, go back
+		{1500, 0, 3}, // And hold
+		{1500, 0, 3},
+		{1500, 0, 3},
+		{1500, 0, 3},
+		{1500, 0, 3},
+		{1500, 0, 4},  // Then try again
+		{2000, 2, 2},  // Throttled
+		{2000, 0, 2},  // Hold
+		{2000, 10, 1}, // Throttled again, but not below the minimum
+	}
+	for i, step := range steps {
+		if got := c.update(step.bytes, step.errors, time.Second); got != step.limit {
+			t.Fatalf("Step %d: expected %d connections, got %d", i, step.limit, got)
+		}
+	}
+}
+
+// Starting with one connection, more are opened when they add throughput
+func TestAdaptiveConcurrency(t *testing.T) {
+	defer func(interval time.Duration) { adaptiveInterval = interval }(adaptiveInterval)
+	adaptiveInterval = 100 * time.Millisecond
+
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	var inFlight, peak int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			w = slowWriter{w}
+		}
+		http.ServeContent(w, r, "quijote.txt", time.Time{}, bytes.NewReader(data))
+	})
+	failOnError(t, downloadLocal(t, handler, 1, WithChunkSize(64<<10), WithAdaptiveConcurrency(1, 4)))
+	if peak < 2 {
+		t.Errorf("The download stayed with %d connection", peak)
+	}
+	if peak > 4 {
+		t.Errorf("%d connections open, more than the maximum", peak)
+	}
+}
@@ -0,0 +1,53 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Verification of files downloaded before, without downloading anything, to
+// audit a mirror or tell whether a cached copy can still be used. The file is
+// checked against what GatherInfo learnt of the sources: its size, the ETag
+// recorded in its provenance, the expected digests (given as options, from a
+// checksum file or from the headers of the sources) and its OpenPGP
+// signature.
+
+// The local file doesn't match the one the sources serve
+var ErrMismatch = errors.New("The file doesn't match its sources")
+
+// Check an existing file against the sources, after GatherInfo, without
+// downloading it. If filename is empty, the output file is checked. Returns
+// an error matching ErrMismatch if its size or ETag differ, and the errors of
+// the digest and signature checks otherwise.
+func (dldr *MultiDownloader) VerifyLocal(filename string) error {
+	if filename != "" {
+		dldr.setOutput(filename)
+	}
+	info, err := os.Stat(dldr.filename)
+	if err != nil {
+		return err
+	}
+
+	// Encoded sources serve another size than the decoded file
+	if dldr.encoding == "" && dldr.fileLength > 0 && info.Size() != dldr.fileLength {
+		return fmt.Errorf("%w: %s has %d bytes, the sources serve %d",
+			ErrMismatch, dldr.filename, info.Size(), dldr.fileLength)
+	}
+	if p, err := ReadProvenance(dldr.filename); err == nil && p.ETag != "" && dldr.ETag != "" &&
+		p.ETag != dldr.ETag {
+		return withHint(fmt.Errorf("%w: %s was downloaded with ETag %s, the sources serve %s",
+			ErrMismatch, dldr.filename, p.ETag, dldr.ETag),
+			"The file changed on the server since: download it again")
+	}
+	if err := dldr.verifyDigests(dldr.filename); err != nil {
+		return err
+	}
+	if dldr.checksPGPSignature() {
+		if err := dldr.verifyPGPSignature(dldr.filename); err != nil {
+			return err
+		}
+	}
+	dldr.logVerbose(dldr.filename, " matches its sources")
+	return nil
+}
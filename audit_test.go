@@ -0,0 +1,53 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestVerifyLocal(t *testing.T) {
+	data, err := os.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	etag := `"v1"`
+	fileServer := http.FileServer(http.Dir("./test"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	output := filepath.Join(t.TempDir(), "quijote.txt")
+	verify := func() error {
+		dldr := NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 1, 5*time.Second,
+			WithSHA1(quijoteSHA1), WithProvenance(ProvenanceSidecar))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		return dldr.VerifyLocal(output)
+	}
+
+	failOnError(t, os.WriteFile(output, data, 0666))
+	failOnError(t, verify())
+	failOnError(t, os.WriteFile(output+ProvenanceSuffix, []byte(`{"etag":"v0"}`), 0666))
+	if err := verify(); !errors.Is(err, ErrMismatch) {
+		t.Errorf("A file with another ETag should fail with ErrMismatch: %v", err)
+	}
+	os.Remove(output + ProvenanceSuffix)
+
+	failOnError(t, os.WriteFile(output, data[1:], 0666))
+	if err := verify(); !errors.Is(err, ErrMismatch) {
+		t.Errorf("A truncated file should fail with ErrMismatch: %v", err)
+	}
+	corrupt := append([]byte{'x'}, data[1:]...)
+	failOnError(t, os.WriteFile(output, corrupt, 0666))
+	if verify() == nil {
+		t.Error("A corrupt file should fail the check")
+	}
+	if _, err := os.Stat(output + ".part"); err == nil {
+		t.Error("Nothing should be downloaded")
+	}
+}
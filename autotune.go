@@ -0,0 +1,157 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Auto-tuning of the connections. Most users guess the number of connections
+// and the size of the chunks, and either leave the link idle or get
+// throttled. With WithAutoTune, the first source is probed once the size of
+// the file is known: a range of autoProbeSize alone, then autoProbeConns
+// ranges at once. Whether the parallel requests are faster than the one
+// alone tells how many connections the link takes, and their failing
+// (throttling, refused connections) that the server limits them. The chunks
+// are then sized on the bandwidth-delay product of each connection, so that
+// the latency of their requests is a small part of their transfer.
+//
+// Files too small for the probes to be worth it keep the connections given.
+
+// Bytes requested by each probe
+var autoProbeSize int64 = 256 << 10
+
+// Requests at once of the second probe
+const autoProbeConns = 4
+
+// Most connections chosen
+const autoMaxConns = 16
+
+// Transfer time of a chunk, in round trips of its request
+const autoChunkRTTs = 50
+
+// Bounds of the size of the chunks chosen
+const (
+	autoMinChunk = 1 << 20
+	autoMaxChunk = 64 << 20
+)
+
+// Choose the number of connections and the size of the chunks by probing the
+// first source, instead of using nConns
+func WithAutoTune() Option {
+	return func(dldr *MultiDownloader) {
+		dldr.autoTune = true
+	}
+}
+
+// Internal: the outcome of a probe request
+type probeResult struct {
+	bytes   int64
+	latency time.Duration // Until the response headers
+	elapsed time.Duration // Until the end of the body
+	err     error
+}
+
+// Internal: the rate of a probe, in bytes per second
+func (p probeResult) rate() float64 {
+	if p.elapsed <= 0 {
+		return 0
+	}
+	return float64(p.bytes) / p.elapsed.Seconds()
+}
+
+// Internal: probe the first source and set the connections and chunk size
+func (dldr *MultiDownloader) autoTuneConnections() {
+	if dldr.noRanges || dldr.segments != nil || dldr.fileLength < 4*(autoProbeConns+1)*autoProbeSize {
+		dldr.logVerbose("Too small to probe, keeping ", dldr.nConns, " connections")
+		return
+	}
+	u := dldr.urls[0]
+	alone := dldr.probeRange(u, Chunk{0, autoProbeSize})
+	if alone.err != nil {
+		dldr.logVerbose("Error probing ", u, ", keeping ", dldr.nConns, " connections: ", alone.err)
+		return
+	}
+	parallel := make([]probeResult, autoProbeConns)
+	var wg sync.WaitGroup
+	for i := range parallel {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			begin := int64(i+1) * autoProbeSize
+			parallel[i] = dldr.probeRange(u, Chunk{begin, begin + autoProbeSize})
+		}(i)
+	}
+	wg.Wait()
+
+	conns, chunk := tuneConnections(alone, parallel)
+	dldr.logVerbose("Probed ", u, ": ", int64(alone.rate()), " bytes/s alone, latency ",
+		alone.latency, ", choosing ", conns, " connections and chunks of ", chunk, " bytes")
+	dldr.nConns = conns
+	if dldr.chunkSize == 0 && dldr.maxChunk == 0 {
+		dldr.maxChunk = chunk
+	}
+}
+
+// Internal: download a range of a source, measuring it
+func (dldr *MultiDownloader) probeRange(u string, chunk Chunk) probeResult {
+	req, err := dldr.chunkRequest(0, chunk, u)
+	if err != nil {
+		return probeResult{err: err}
+	}
+	start := time.Now()
+	dldr.mirrors.requested(mirrorHost(u))
+	resp, err := dldr.chunkClient(u).Do(req)
+	if err != nil {
+		return probeResult{err: err}
+	}
+	defer resp.Body.Close()
+	result := probeResult{latency: time.Since(start)}
+	if resp.StatusCode != http.StatusPartialContent {
+		result.err = errors.New(fmt.Sprintf("Status %d probing %s", resp.StatusCode, u))
+		return result
+	}
+	result.bytes, result.err = io.Copy(io.Discard, resp.Body)
+	result.elapsed = time.Since(start)
+	if result.err == nil {
+		dldr.mirrors.record(mirrorHost(u), result.bytes, result.elapsed, result.latency)
+	}
+	return result
+}
+
+// Internal: the connections and size of the chunks for the probes of a source,
+// one alone and some at once
+func tuneConnections(alone probeResult, parallel []probeResult) (conns int, chunk int64) {
+	var bytes int64
+	var elapsed time.Duration
+	succeeded := 0
+	for _, p := range parallel {
+		if p.err == nil {
+			succeeded++
+			bytes += p.bytes
+			elapsed = max(elapsed, p.elapsed)
+		}
+	}
+	perConn := alone.rate()
+	switch {
+	case succeeded < len(parallel):
+		// The server limits the connections
+		conns = max(succeeded, 1)
+	case perConn == 0 || elapsed == 0:
+		conns = len(parallel)
+	default:
+		// Twice as many connections as the parallel requests were faster
+		rate := float64(bytes) / elapsed.Seconds()
+		conns = clamp(int(math.Round(2*rate/perConn)), 1, autoMaxConns)
+		perConn = rate / float64(conns)
+	}
+
+	// Enough round trips of each connection for its requests not to wait
+	bdp := perConn * alone.latency.Seconds()
+	chunk = min(max(int64(bdp*autoChunkRTTs), autoMinChunk), autoMaxChunk)
+	return conns, chunk
+}
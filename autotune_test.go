@@ -0,0 +1,67 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTuneConnections(t *testing.T) {
+	probe := func(bytes int64, latency, elapsed time.Duration) probeResult {
+		return probeResult{bytes: bytes, latency: latency, elapsed: elapsed}
+	}
+	alone := probe(1<<20, 10*time.Millisecond, time.Second) // 1 MiB/s
+	parallel := func(p probeResult) []probeResult {
+		return []probeResult{p, p, p, p}
+	}
+	for _, c := range []struct {
+		name     string
+		parallel []probeResult
+		conns    int
+		chunk    int64
+	}{
+		// 4 MiB/s, 2 MiB/s and 1 MiB/s together
+		{"scaling", parallel(probe(1<<20, 10*time.Millisecond, time.Second)), 8, autoMinChunk},
+		{"half", parallel(probe(1<<20, 10*time.Millisecond, 2*time.Second)), 4, autoMinChunk},
+		{"saturated", parallel(probe(1<<20, 10*time.Millisecond, 4*time.Second)), 2, autoMinChunk},
+		{"throttled", []probeResult{
+			probe(1<<20, 10*time.Millisecond, time.Second),
+			{err: errors.New("Status 503")}, {err: errors.New("Status 429")}, {err: errors.New("Status 429")},
+		}, 1, autoMinChunk},
+	} {
+		conns, chunk := tuneConnections(alone, c.parallel)
+		if conns != c.conns || chunk != c.chunk {
+			t.Errorf("%s: %d connections and chunks of %d, should be %d and %d", c.name, conns, chunk, c.conns, c.chunk)
+		}
+	}
+
+	// Long round trips of fast connections make larger chunks
+	fast := probe(100<<20, time.Second, time.Second)
+	if _, chunk := tuneConnections(fast, parallel(fast)); chunk != autoMaxChunk {
+		t.Errorf("Chunks of %d on a long fat link, should be %d", chunk, autoMaxChunk)
+	}
+}
+
+// The connections are chosen before the chunks are built
+func TestAutoTune(t *testing.T) {
+	defer func(size int64) { autoProbeSize = size }(autoProbeSize)
+	autoProbeSize = 1000
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+	dldr := NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 1, 5*time.Second, WithAutoTune())
+	chunks, err := dldr.GatherInfo()
+	failOnError(t, err)
+	if dldr.nConns < 1 || dldr.nConns > autoMaxConns || len(chunks) != dldr.nConns {
+		t.Errorf("%d chunks for %d connections", len(chunks), dldr.nConns)
+	}
+	if stats := dldr.MirrorStats(); len(stats) != 1 || stats[0].Requests != 1+autoProbeConns {
+		t.Errorf("The probes should be accounted to the mirror, got %+v", stats)
+	}
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	failOnError(t, dldr.CheckSHA256(quijoteSHA256))
+}
@@ -0,0 +1,170 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Consumers that need specific regions of the file (the pages of a database,
+// the index at the end of an archive...) can wait for them with WaitForRange
+// while the download goes on, instead of waiting for the whole file.
+
+// Returned (wrapped) when a range doesn't match its block checksums
+var ErrCorruptRange = errors.New("Corrupt data in range")
+
+// What's in the file so far, shared with WaitForRange
+type rangeProgress struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	written   rangeSet
+	rewritten bool  // Some range was written more than once
+	done      bool  // The download returned
+	err       error // What it returned
+}
+
+// Internal: create the progress of a download yet to start
+func newRangeProgress() *rangeProgress {
+	p := &rangeProgress{}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// Internal: start over with what's in the file before downloading the chunks
+func (p *rangeProgress) start(fileLength int64, chunks []Chunk) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.written = nil
+	p.rewritten, p.done, p.err = false, false, nil
+	missing := rangeSet{}
+	for _, c := range chunks {
+		if c.End > c.Begin {
+			missing.add(c.Begin, c.End)
+		}
+	}
+	begin := int64(0)
+	for _, c := range missing {
+		if c.Begin > begin {
+			p.written.add(begin, c.Begin)
+		}
+		begin = c.End
+	}
+	if begin < fileLength {
+		p.written.add(begin, fileLength)
+	}
+	p.cond.Broadcast()
+}
+
+// Internal: record a written range
+func (p *rangeProgress) add(begin, end int64) {
+	p.mu.Lock()
+	p.rewritten = p.rewritten || p.written.overlaps(begin, end)
+	p.written.add(begin, end)
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// Internal: record the end of the download
+func (p *rangeProgress) finish(err error) {
+	p.mu.Lock()
+	p.done, p.err = true, err
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// Internal: whether a range is entirely written. Must be called with the lock held.
+func (p *rangeProgress) covers(begin, end int64) bool {
+	return p.written.covers(begin, end)
+}
+
+// Internal: block until a range is written, or the download ends without it
+func (p *rangeProgress) wait(begin, end int64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for !p.covers(begin, end) {
+		if p.done {
+			if p.err != nil {
+				return p.err
+			}
+			return errors.New("The download finished without the range")
+		}
+		p.cond.Wait()
+	}
+	return nil
+}
+
+// Internal: whether a range is entirely written
+func (p *rangeProgress) coversRange(c Chunk) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.covers(c.Begin, c.End)
+}
+
+// Internal: whether all the file is written, each range only once
+func (p *rangeProgress) intact(fileLength int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.covers(0, fileLength) && !p.rewritten
+}
+
+// Block until the range [begin, end) of the file is downloaded, which can be
+// called while Download runs, or before it starts. With block checksums
+// (WithBlockChecksums), the blocks of the range are checked too. Returns the
+// error of the download if it fails before the range is complete.
+func (dldr *MultiDownloader) WaitForRange(begin, end int64) error {
+	if begin < 0 || end < begin || end > dldr.fileLength {
+		return errors.New(fmt.Sprintf("Invalid range %d-%d of a file of %d bytes",
+			begin, end, dldr.fileLength))
+	}
+	if dldr.encoding != "" {
+		return errors.New("Ranges of encoded downloads can't be waited for")
+	}
+	if err := dldr.written.wait(begin, end); err != nil {
+		return err
+	}
+	if dldr.blockSums != nil {
+		return dldr.verifyRange(begin, end)
+	}
+	return nil
+}
+
+// Internal: check the blocks overlapping a range against their checksums
+func (dldr *MultiDownloader) verifyRange(begin, end int64) error {
+	// Once complete, the file may have been renamed already
+	file, err := os.Open(dldr.partFilename)
+	if os.IsNotExist(err) {
+		file, err = os.Open(dldr.filename)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ctrl := dldr.blockSums
+	bs := int64(ctrl.BlockSize)
+	data := make([]byte, bs)
+	for b := begin / bs; b*bs < end; b++ {
+		n, err := file.ReadAt(data, b*bs)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		clear(data[n:])
+		if !ctrl.blockMatches(int(b), data) {
+			return fmt.Errorf("%w %d-%d", ErrCorruptRange, b*bs, min((b+1)*bs, ctrl.Length))
+		}
+	}
+	return nil
+}
+
+// Internal: the bytes written so far
+func (p *rangeProgress) total() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	total := int64(0)
+	for _, c := range p.written {
+		total += c.End - c.Begin
+	}
+	return total
+}
@@ -0,0 +1,93 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Set up a download of quijote.txt in two chunks from the given handler
+func setupLocal(t *testing.T, handler http.Handler, opts ...Option) *MultiDownloader {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/quijote.txt"}, 2, time.Duration(5000)*time.Millisecond, opts...)
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	return dldr
+}
+
+// The first half is available while the second one is still downloading
+func TestWaitForRange(t *testing.T) {
+	release := make(chan bool)
+	fileServer := http.FileServer(http.Dir("./test"))
+	dldr := setupLocal(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && !strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			<-release
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	result := make(chan error, 1)
+	go func() {
+		result <- dldr.Download(nil)
+	}()
+
+	failOnError(t, dldr.WaitForRange(1000, 50000))
+	partial, err := ioutil.ReadFile(dldr.partFilename)
+	failOnError(t, err)
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	if !bytes.Equal(partial[1000:50000], data[1000:50000]) {
+		t.Error("The range should be written when WaitForRange returns")
+	}
+	select {
+	case <-result:
+		t.Fatal("The download shouldn't be complete")
+	default:
+	}
+
+	close(release)
+	failOnError(t, dldr.WaitForRange(0, dldr.fileLength))
+	failOnError(t, <-result)
+}
+
+func TestWaitForRangeFailed(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	dldr := setupLocal(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && !strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			http.Error(w, "gone", http.StatusGone)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	go dldr.Download(nil)
+	if err := dldr.WaitForRange(dldr.fileLength-10, dldr.fileLength); err == nil {
+		t.Error("Waiting for a range that can't be downloaded should fail")
+	}
+}
+
+func TestWaitForRangeChecksums(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	ctrl, err := ParseZsync(bytes.NewReader(makeZsync(data, 2048, 1, 4, 16)))
+	failOnError(t, err)
+	corrupted := append([]byte(nil), data...)
+	copy(corrupted[200000:], "corrupted")
+	dldr := setupLocal(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "quijote.txt", time.Time{}, bytes.NewReader(corrupted))
+	}), WithBlockChecksums(ctrl))
+	failOnError(t, dldr.Download(nil))
+
+	failOnError(t, dldr.WaitForRange(0, 150000))
+	if err := dldr.WaitForRange(190000, 210000); !errors.Is(err, ErrCorruptRange) {
+		t.Errorf("The corrupted range should fail its checksums, got %v", err)
+	}
+}
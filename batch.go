@@ -0,0 +1,116 @@
+package multipartdownloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Batches of files downloaded to a directory in one call, for the "fetch
+// these 200 files" cases that don't need to manage the jobs themselves.
+// DownloadAll runs them with a Manager, batchDownloads at once, each file
+// with its own mirrors, name and checksum. Files whose names would be the
+// same get a suffix (.1, .2...), as with ConflictRename.
+
+// Downloads at once of DownloadAll
+const batchDownloads = 4
+
+// A file of a batch
+type JobSpec struct {
+	URLs     []string // Mirrors of the file
+	Name     string   // Name of the file in the directory, from the first URL if empty
+	Checksum string   // Digest the file must have, if any: sha256:<hex>, or md5, sha1, sha512, blake2b
+	Options  []Option // On top of the ones of DownloadAll
+}
+
+// The result of a file of a batch
+type BatchResult struct {
+	Name   string       // Name of the file in the directory
+	Result *FetchResult // nil if it failed
+	Err    error
+}
+
+// Download files to a directory, created if missing, with the given options.
+// The results are in the order of the files. The error tells how many failed
+// and wraps the first error. Canceling the context cancels the downloads
+// left.
+func DownloadAll(ctx context.Context, specs []JobSpec, dir string, opts ...Option) ([]BatchResult, error) {
+	m := NewManager(ManagerLimits{MaxDownloads: batchDownloads}, append([]Option{WithOutputDir(dir)}, opts...)...)
+	defer m.Close()
+	stop := context.AfterFunc(ctx, m.Close)
+	defer stop()
+
+	results := make([]BatchResult, len(specs))
+	jobs := make([]*ManagedJob, len(specs))
+	taken := make(map[string]bool)
+	for i, spec := range specs {
+		results[i].Name = batchName(spec, taken)
+		jobOpts := spec.Options
+		if spec.Checksum != "" {
+			checksum, err := parseChecksum(spec.Checksum)
+			if err != nil {
+				results[i].Err = err
+				continue
+			}
+			jobOpts = append([]Option{withChecksum(checksum)}, jobOpts...)
+		}
+		if len(spec.URLs) == 0 {
+			results[i].Err = errors.New("No URLs provided")
+			continue
+		}
+		jobs[i] = m.Add(Job{URLs: spec.URLs, Dest: results[i].Name, Options: jobOpts})
+	}
+
+	failed := 0
+	var first error
+	for i, j := range jobs {
+		if j != nil {
+			results[i].Result, results[i].Err = j.Wait()
+		}
+		if results[i].Err != nil {
+			failed++
+			if first == nil {
+				first = fmt.Errorf("%s: %w", results[i].Name, results[i].Err)
+			}
+		}
+	}
+	if failed > 0 {
+		return results, fmt.Errorf("%d of %d files failed, %w", failed, len(specs), first)
+	}
+	return results, nil
+}
+
+// Internal: the name of a file of a batch, not taken by another one
+func batchName(spec JobSpec, taken map[string]bool) string {
+	name := spec.Name
+	if name == "" && len(spec.URLs) > 0 {
+		name = urlToFilename(httpURL(spec.URLs[0]))
+	}
+	if name == "" {
+		name = "downloaded-file"
+	}
+	unique := name
+	for n := 1; taken[filepath.Clean(unique)]; n++ {
+		unique = fmt.Sprintf("%s.%d", name, n)
+	}
+	taken[filepath.Clean(unique)] = true
+	return unique
+}
+
+// Internal: parse a checksum given as <algorithm>:<hex>
+func parseChecksum(s string) (ChecksumEntry, error) {
+	algorithm, sum, ok := strings.Cut(s, ":")
+	if algorithm = normalizeAlgorithm(algorithm); !ok || algorithm == "" {
+		return ChecksumEntry{}, errors.New(fmt.Sprintf("Invalid checksum %s, should be like sha256:<hex>", s))
+	}
+	return ChecksumEntry{Algorithm: algorithm, Sum: strings.ToLower(sum)}, nil
+}
+
+// Internal: expect the digest of a checksum
+func withChecksum(e ChecksumEntry) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.setDigest(e)
+	}
+}
@@ -0,0 +1,51 @@
+package multipartdownloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownloadAll(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+	quijote := server.URL + "/quijote.txt"
+	dir := t.TempDir()
+	results, err := DownloadAll(context.Background(), []JobSpec{
+		{URLs: []string{quijote}},
+		{URLs: []string{quijote, quijote}, Checksum: "sha256:" + quijoteSHA256},
+		{URLs: []string{quijote}, Name: "copy.txt", Checksum: "SHA256:" + strings.Repeat("0", 64)},
+		{URLs: []string{server.URL + "/missing"}, Name: "missing"},
+		{URLs: []string{quijote}, Checksum: "crc:1234"},
+	}, dir, WithConnections(2))
+	if err == nil || !strings.HasPrefix(err.Error(), "3 of 5 files failed") {
+		t.Errorf("Unexpected error %v", err)
+	}
+	names := []string{"quijote.txt", "quijote.txt.1", "copy.txt", "missing", "quijote.txt.2"}
+	failed := []bool{false, false, true, true, true}
+	for i, r := range results {
+		if r.Name != names[i] || (r.Err != nil) != failed[i] {
+			t.Errorf("File %d: %s, %v, should be %s, failed=%v", i, r.Name, r.Err, names[i], failed[i])
+		}
+		if r.Err == nil {
+			info, err := os.Stat(filepath.Join(dir, r.Name))
+			failOnError(t, err)
+			if info.Size() != 317621 || r.Result.Filename != filepath.Join(dir, r.Name) {
+				t.Errorf("File %s: %d bytes, saved as %s", r.Name, info.Size(), r.Result.Filename)
+			}
+		}
+	}
+
+	// Nothing is left running once canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err = DownloadAll(ctx, []JobSpec{{URLs: []string{quijote}}}, t.TempDir())
+	if err == nil || !errors.Is(results[0].Err, ErrJobCanceled) {
+		t.Errorf("Canceled batch returned %v, %v", err, results[0].Err)
+	}
+}
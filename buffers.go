@@ -0,0 +1,64 @@
+package multipartdownloader
+
+import (
+	"sync"
+)
+
+// Buffers of the copies between the network and the file. Each connection
+// reads its responses into a write buffer before writing them to the file,
+// and the digests are computed reading the file through a read buffer. Small
+// buffers mean a system call for every few kilobytes, which is what limits
+// the throughput on fast links, so they default to 64 KiB and can be set with
+// WithBufferSizes. The buffers are pooled by size, and reused by the
+// connections and downloaders instead of allocating one per request.
+
+const (
+	defaultWriteBufferSize = 1 << 16
+	defaultReadBufferSize  = 1 << 16
+)
+
+// Set the size of the buffers of the connections (write) and of the reads of
+// the file (read), in bytes. Zero keeps the default size.
+func WithBufferSizes(write, read int) Option {
+	return func(dldr *MultiDownloader) {
+		if write > 0 {
+			dldr.writeBuffers = buffers(write)
+		}
+		if read > 0 {
+			dldr.readBuffers = buffers(read)
+		}
+	}
+}
+
+// Internal: reusable buffers of a size
+type bufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// Internal: the pools of buffers of each size
+var bufferPools sync.Map
+
+// Internal: the pool of buffers of a size, shared by all downloaders
+func buffers(size int) *bufferPool {
+	if p, ok := bufferPools.Load(size); ok {
+		return p.(*bufferPool)
+	}
+	p := &bufferPool{size: size}
+	p.pool.New = func() any {
+		buf := make([]byte, size)
+		return &buf
+	}
+	actual, _ := bufferPools.LoadOrStore(size, p)
+	return actual.(*bufferPool)
+}
+
+// Internal: take a buffer, to give back with put once done with it
+func (p *bufferPool) get() *[]byte {
+	return p.pool.Get().(*[]byte)
+}
+
+// Internal: give back a buffer taken with get
+func (p *bufferPool) put(buf *[]byte) {
+	p.pool.Put(buf)
+}
@@ -0,0 +1,33 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"testing"
+)
+
+// Files are downloaded and verified whatever the size of the buffers
+func TestBufferSizes(t *testing.T) {
+	for _, sizes := range [][2]int{{1000, 3000}, {1 << 20, 1 << 20}, {0, 0}} {
+		err := downloadLocal(t, http.FileServer(http.Dir("./test")), 4,
+			WithBufferSizes(sizes[0], sizes[1]), WithSHA256(quijoteSHA256))
+		if err != nil {
+			t.Errorf("Buffers of %v: %v", sizes, err)
+		}
+	}
+}
+
+// The buffers of a size are shared, and have that size
+func TestBufferPools(t *testing.T) {
+	if buffers(1234) != buffers(1234) {
+		t.Fatal("Buffers of the same size should share a pool")
+	}
+	buf := buffers(1234).get()
+	if len(*buf) != 1234 {
+		t.Errorf("Buffer of %d bytes, should be 1234", len(*buf))
+	}
+	buffers(1234).put(buf)
+	dldr := NewMultiDownloader(nil, 1, 0, WithBufferSizes(1234, 0))
+	if dldr.writeBuffers != buffers(1234) || dldr.readBuffers != buffers(defaultReadBufferSize) {
+		t.Error("The sizes of the buffers weren't set")
+	}
+}
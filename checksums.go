@@ -0,0 +1,159 @@
+package multipartdownloader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Checksum files, the way most publishers give the digests of their files:
+// SHA256SUMS, file.iso.sha256, MD5SUMS... With WithChecksumURL, GatherInfo
+// fetches one, finds the entry of the file, and the file is checked against
+// it like with WithSHA256. Both the GNU format of sha256sum and the others
+// ("<digest>  <name>") and the BSD one ("SHA256 (<name>) = <digest>") are
+// understood, and lines in neither format, like those of PGP signed files,
+// skipped. The algorithm of GNU entries comes from the length of the digest,
+// and the name of the checksum file (B2SUMS, file.sha512...) when the length
+// doesn't tell.
+
+// An entry of a checksum file
+type ChecksumEntry struct {
+	Algorithm string // MD5, SHA1, SHA256, SHA512 or BLAKE2b, empty if unknown
+	Sum       string // Digest, in hex
+	Name      string // Name of the file
+}
+
+var (
+	gnuChecksum = regexp.MustCompile(`^\\?([0-9a-fA-F]+) [ *](.+)$`)
+	bsdChecksum = regexp.MustCompile(`^([\w-]+) ?\((.+)\) ?= ([0-9a-fA-F]+)$`)
+)
+
+// Check the file against its entry in the checksum file at the URL, fetched
+// by GatherInfo
+func WithChecksumURL(url string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.checksumURL = url
+	}
+}
+
+// Set the MD5 the file must have. It's checked like the SHA-256 of
+// WithSHA256, but only catches accidental corruption.
+func WithMD5(hash string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.md5 = hash
+	}
+}
+
+// Parse a checksum file. The algorithm of GNU entries is guessed from the
+// name of the checksum file, if given, or else from the length of the digest.
+func ParseChecksums(r io.Reader, checksumName string) ([]ChecksumEntry, error) {
+	var entries []ChecksumEntry
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := bsdChecksum.FindStringSubmatch(line); m != nil {
+			entries = append(entries, ChecksumEntry{normalizeAlgorithm(m[1]), strings.ToLower(m[3]), m[2]})
+		} else if m := gnuChecksum.FindStringSubmatch(line); m != nil {
+			entries = append(entries, ChecksumEntry{
+				guessAlgorithm(checksumName, len(m[1])), strings.ToLower(m[1]), m[2]})
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// Internal: the name of an algorithm in a BSD entry, as in ChecksumEntry
+func normalizeAlgorithm(name string) string {
+	switch name := strings.ToUpper(strings.ReplaceAll(name, "-", "")); name {
+	case "MD5", "SHA1", "SHA256", "SHA512":
+		return name
+	case "BLAKE2B", "BLAKE2B512":
+		return "BLAKE2b"
+	}
+	return ""
+}
+
+// Internal: the algorithm of a GNU entry, from the name of the checksum
+// file if it names one with digests of this length, or else from the length
+func guessAlgorithm(checksumName string, digits int) string {
+	algorithms := []struct {
+		tag, algorithm string
+		digits         int
+	}{
+		{"B2", "BLAKE2b", 128}, {"BLAKE2", "BLAKE2b", 128}, {"SHA512", "SHA512", 128},
+		{"SHA256", "SHA256", 64}, {"SHA1", "SHA1", 40}, {"MD5", "MD5", 32},
+	}
+	name := strings.ToUpper(checksumName)
+	for _, a := range algorithms {
+		if strings.Contains(name, a.tag) && digits == a.digits {
+			return a.algorithm
+		}
+	}
+	for _, a := range algorithms[2:] {
+		if digits == a.digits {
+			return a.algorithm
+		}
+	}
+	return ""
+}
+
+// Internal: fetch the checksum file and take the digest of the file from
+// the entry with one of the given names
+func (dldr *MultiDownloader) loadChecksums(names []string) error {
+	req, err := dldr.newRequest("GET", dldr.checksumURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := dldr.httpClient(dldr.checksumURL, dldr.timeout).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("Failed fetching %s: %v", dldr.checksumURL, statusError(resp.StatusCode)))
+	}
+	entries, err := ParseChecksums(resp.Body, path.Base(req.URL.Path))
+	if err != nil {
+		return err
+	}
+
+	// Single entry files (file.iso.sha256) may not name the file
+	if len(entries) == 1 && entries[0].Name == "-" {
+		return dldr.setDigest(entries[0])
+	}
+	for _, name := range names {
+		for _, e := range entries {
+			if path.Base(strings.ReplaceAll(e.Name, "\\", "/")) == name {
+				dldr.logVerbose("Checksum file entry of ", name, ": ", e.Algorithm, " ", e.Sum)
+				return dldr.setDigest(e)
+			}
+		}
+	}
+	return withHint(errors.New(fmt.Sprintf("No entry for %s in %s", names[0], dldr.checksumURL)),
+		"Check that the checksum file is the one of this file")
+}
+
+// Internal: expect the digest of a checksum file entry
+func (dldr *MultiDownloader) setDigest(e ChecksumEntry) error {
+	switch e.Algorithm {
+	case "MD5":
+		dldr.md5 = e.Sum
+	case "SHA1":
+		dldr.sha1 = e.Sum
+	case "SHA256":
+		dldr.sha256 = e.Sum
+	case "SHA512":
+		dldr.sha512 = e.Sum
+	case "BLAKE2b":
+		dldr.blake2b = e.Sum
+	case "CRC32C":
+		dldr.crc32c = e.Sum
+	default:
+		return errors.New(fmt.Sprintf("Unknown algorithm of the checksum of %s", e.Name))
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const quijoteSHA256 = "1e9bb1b16f8810e44d6d5ede7005258518fa976719bc2ed254308e73c357cfcc"
+
+func TestParseChecksums(t *testing.T) {
+	testTable := []struct {
+		name    string
+		content string
+		entries []ChecksumEntry
+	}{
+		{"SHA256SUMS", quijoteSHA256 + "  quijote.txt\n" + quijoteSHA256 + " *other.bin\n",
+			[]ChecksumEntry{{"SHA256", quijoteSHA256, "quijote.txt"}, {"SHA256", quijoteSHA256, "other.bin"}}},
+		{"CHECKSUM", "-----BEGIN PGP SIGNED MESSAGE-----\nHash: SHA256\n\nSHA256 (quijote.txt) = " +
+			strings.ToUpper(quijoteSHA256) + "\n",
+			[]ChecksumEntry{{"SHA256", quijoteSHA256, "quijote.txt"}}},
+		{"B2SUMS", quijoteBLAKE2b + "  quijote.txt\n",
+			[]ChecksumEntry{{"BLAKE2b", quijoteBLAKE2b, "quijote.txt"}}},
+		{"checksums.txt", "45bb5fc96bb4c67778d288fba98eee48  quijote.txt\n",
+			[]ChecksumEntry{{"MD5", "45bb5fc96bb4c67778d288fba98eee48", "quijote.txt"}}},
+	}
+	for _, test := range testTable {
+		entries, err := ParseChecksums(strings.NewReader(test.content), test.name)
+		failOnError(t, err)
+		if !reflect.DeepEqual(entries, test.entries) {
+			t.Errorf("%s parsed as %v, should be %v", test.name, entries, test.entries)
+		}
+	}
+}
+
+func TestChecksumURL(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/SHA256SUMS":
+			w.Write([]byte(quijoteSHA256[1:] + "0  quijote2.txt\n" + quijoteSHA256 + "  quijote.txt\n"))
+		case "/SHA1SUMS":
+			w.Write([]byte(quijoteSHA1[1:] + "0  quijote.txt\n"))
+		default:
+			fileServer.ServeHTTP(w, r)
+		}
+	}))
+	defer server.Close()
+
+	for _, sums := range []string{"SHA256SUMS", "SHA1SUMS"} {
+		dldr := NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 2, 5*time.Second,
+			WithChecksumURL(server.URL+"/"+sums))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		if sums == "SHA256SUMS" && dldr.sha256 != quijoteSHA256 {
+			t.Errorf("The SHA-256 of the file should be taken from %s, got %q", sums, dldr.sha256)
+		}
+		_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+		failOnError(t, err)
+		if err := dldr.Download(nil); (err == nil) != (sums == "SHA256SUMS") {
+			t.Errorf("Downloading with %s: %v", sums, err)
+		}
+	}
+}
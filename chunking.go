@@ -0,0 +1,115 @@
+package multipartdownloader
+
+// Chunk sizing. By default the file is split in as many chunks as there are
+// connections, which for big files makes chunks of gigabytes, and a chunk
+// failing near its end costs all of it again. With a chunk size, or a
+// maximum one, there are more chunks than connections, which take the
+// pending chunks one after the other as they finish the previous ones.
+// Small files can be downloaded with a single request instead.
+
+// Size below which Fetch and godl download files with a single request
+const defaultSingleBelow = 64 << 10
+
+// Split the file in chunks of the given size, the last one maybe shorter
+func WithChunkSize(size int64) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.chunkSize = size
+	}
+}
+
+// Split the file in as many chunks as connections, and then split again the
+// ones larger than the given size
+func WithMaxChunk(size int64) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.maxChunk = size
+	}
+}
+
+// Download files smaller than the given size with a single plain request,
+// instead of one range request per connection. Files smaller than the number
+// of connections always are.
+func WithSingleConnectionBelow(size int64) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.singleBelow = size
+	}
+}
+
+// Internal: the largest chunk allowed, zero if there is no limit
+func (dldr *MultiDownloader) chunkLimit() int64 {
+	if dldr.chunkSize > 0 {
+		return dldr.chunkSize
+	}
+	return dldr.maxChunk
+}
+
+// Internal: build the chunks table with chunks of the given size
+func (dldr *MultiDownloader) buildSizedChunks(size int64) {
+	dldr.chunks = nil
+	for begin := int64(0); begin < dldr.fileLength; begin += size {
+		dldr.chunks = append(dldr.chunks, Chunk{begin, min(begin+size, dldr.fileLength)})
+	}
+}
+
+// Internal: split the chunks larger than max in equal parts no larger than it
+func splitChunks(chunks []Chunk, max int64) []Chunk {
+	if max <= 0 {
+		return chunks
+	}
+	var split []Chunk
+	for _, c := range chunks {
+		length := c.End - c.Begin
+		parts := (length + max - 1) / max
+		if parts <= 1 {
+			split = append(split, c)
+			continue
+		}
+		// Spread the remainder among the first parts, as buildChunks does
+		size, remainder := length/parts, length%parts
+		begin := c.Begin
+		for i := int64(0); i < parts; i++ {
+			end := begin + size
+			if i < remainder {
+				end++
+			}
+			split = append(split, Chunk{begin, end})
+			begin = end
+		}
+	}
+	return split
+}
+
+// Download the first and last size bytes of the file before the rest, as
+// players need the header and index (moov atom...) of media files to start
+// previews. WaitForRange tells when they are there.
+func WithEdgesFirst(size int64) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.edgesFirst = size
+	}
+}
+
+// Internal: cut the chunks at size bytes from each end of a file of the given
+// length, and put the chunks of the ends first: the beginning, then the end
+func edgeChunks(chunks []Chunk, size, length int64) []Chunk {
+	var cut []Chunk
+	for _, c := range chunks {
+		for _, boundary := range []int64{size, length - size} {
+			if c.Begin < boundary && boundary < c.End {
+				cut = append(cut, Chunk{c.Begin, boundary})
+				c.Begin = boundary
+			}
+		}
+		cut = append(cut, c)
+	}
+	var head, tail, middle []Chunk
+	for _, c := range cut {
+		switch {
+		case c.End <= size:
+			head = append(head, c)
+		case c.Begin >= length-size:
+			tail = append(tail, c)
+		default:
+			middle = append(middle, c)
+		}
+	}
+	return append(append(head, tail...), middle...)
+}
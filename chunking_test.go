@@ -0,0 +1,147 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestChunkSizing(t *testing.T) {
+	testTable := []struct {
+		fileLength int64
+		nConns     int
+		opt        Option
+		chunks     []Chunk
+	}{
+		{125, 2, WithChunkSize(50), []Chunk{{0, 50}, {50, 100}, {100, 125}}},
+		{125, 4, WithChunkSize(200), []Chunk{{0, 125}}},
+		{125, 2, WithMaxChunk(40), []Chunk{{0, 32}, {32, 63}, {63, 94}, {94, 125}}},
+		{125, 2, WithMaxChunk(63), []Chunk{{0, 63}, {63, 125}}},
+	}
+	for _, test := range testTable {
+		dldr := NewMultiDownloader(nil, test.nConns, time.Duration(1), test.opt)
+		dldr.fileLength = test.fileLength
+		dldr.buildChunks()
+		if !reflect.DeepEqual(dldr.chunks, test.chunks) {
+			t.Errorf("Chunks of %d bytes: %v instead of %v", test.fileLength, dldr.chunks, test.chunks)
+		}
+	}
+}
+
+// More chunks than connections are all downloaded
+func TestChunkSizeDownload(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	var gets int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+	failOnError(t, downloadLocal(t, handler, 3, WithChunkSize(20000)))
+	if gets != 16 {
+		t.Errorf("A file of 317621 bytes in chunks of 20000 should take 16 requests, took %d", gets)
+	}
+}
+
+// Free connections take any pending chunk, the failed ones included, and no
+// more connections than asked for are open at once
+func TestChunkWorkers(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	var gets, inFlight, maxInFlight int32
+	var failedOnce atomic.Bool
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			fileServer.ServeHTTP(w, r)
+			return
+		}
+		atomic.AddInt32(&gets, 1)
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			peak := atomic.LoadInt32(&maxInFlight)
+			if n <= peak || atomic.CompareAndSwapInt32(&maxInFlight, peak, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		if r.Header.Get("Range") == "bytes=100000-119999" && !failedOnce.Swap(true) {
+			http.Error(w, "Try again", http.StatusServiceUnavailable)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+	failOnError(t, downloadLocal(t, handler, 3, WithChunkSize(20000)))
+	if gets != 17 {
+		t.Errorf("16 chunks and a failed one should take 17 requests, took %d", gets)
+	}
+	if maxInFlight > 3 {
+		t.Errorf("%d requests at once with 3 connections", maxInFlight)
+	}
+}
+
+// Small files are downloaded with a single plain request
+func TestSingleConnection(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	var ranges []string
+	var mu sync.Mutex
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+	failOnError(t, downloadLocal(t, handler, 4, WithSingleConnectionBelow(1<<20)))
+	if !reflect.DeepEqual(ranges, []string{""}) {
+		t.Errorf("Expected a single request without range, got %q", ranges)
+	}
+
+	// Files smaller than the number of connections too
+	dldr := NewMultiDownloader(nil, 4, time.Second)
+	dldr.fileLength = 3
+	dldr.buildChunks()
+	if !reflect.DeepEqual(dldr.chunks, []Chunk{{0, 3}}) {
+		t.Errorf("Expected a single chunk, got %v", dldr.chunks)
+	}
+}
+
+// The ends of the file are cut from their chunks and put first
+func TestEdgeChunks(t *testing.T) {
+	chunks := []Chunk{{0, 40}, {40, 80}, {80, 120}}
+	got := edgeChunks(chunks, 10, 120)
+	want := []Chunk{{0, 10}, {110, 120}, {10, 40}, {40, 80}, {80, 110}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+	// Ends overlapping each other
+	got = edgeChunks([]Chunk{{0, 15}}, 10, 15)
+	want = []Chunk{{0, 10}, {10, 15}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+}
+
+// The ends are requested first
+func TestEdgesFirstDownload(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	var ranges []string
+	var mu sync.Mutex
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+	failOnError(t, downloadLocal(t, handler, 1, WithEdgesFirst(1000)))
+	want := []string{"bytes=0-999", "bytes=316621-317620", "bytes=1000-316620"}
+	if !reflect.DeepEqual(ranges, want) {
+		t.Errorf("Expected the requests %v, got %v", want, ranges)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	md "github.com/alvatar/multipart-downloader"
+	"github.com/alvatar/multipart-downloader/service"
+)
+
+// The download manager daemon. With -daemon, godl runs as a service until
+// stopped, downloading the jobs dropped in the jobs directory of its state
+// directory, as JSON files like
+//
+//	{"urls": ["https://example.com/file.iso"], "dest": "file.iso"}
+//
+// The files are saved in the downloads directory of the state directory,
+// unless dest is an absolute path. Finished jobs are moved to the done or
+// failed directory, with their result. Stopping the daemon interrupts the
+// running downloads, which checkpoint what they wrote in their control files:
+// their jobs stay in the jobs directory, and resume at the next start.
+
+// Period of the scans of the jobs directory
+var daemonPoll = time.Second
+
+// A job file, and its result once finished
+type daemonJob struct {
+	URLs  []string `json:"urls"`
+	Dest  string   `json:"dest,omitempty"`
+	File  string   `json:"file,omitempty"` // Path of the file downloaded
+	Size  int64    `json:"size,omitempty"`
+	Error string   `json:"error,omitempty"`
+}
+
+// The state of a daemon
+type daemon struct {
+	dir     string // State directory
+	manager *md.Manager
+	mu      sync.Mutex
+	added   map[string]bool // Job files given to the manager, by name
+	waiting sync.WaitGroup  // Goroutines waiting for the jobs
+}
+
+// Run the daemon with the limits and options of the flags
+func daemonMain() error {
+	limits := md.ManagerLimits{MaxDownloads: int(*maxDownloads), MaxConns: int(*maxTotalConns)}
+	if *maxRate != "" {
+		rate, err := parseSize(*maxRate)
+		if err != nil {
+			return err
+		}
+		limits.MaxRate = rate
+	}
+	var opts []md.Option
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "n" { // Otherwise the default of Fetch
+			opts = append(opts, md.WithConnections(int(*nConns)))
+		}
+	})
+	md.SetVerbose(*verbose)
+	return runDaemon(*stateDir, limits, opts)
+}
+
+// Run the daemon until stopped, with the state in the given directory
+func runDaemon(dir string, limits md.ManagerLimits, opts []md.Option) error {
+	for _, sub := range []string{"jobs", "done", "failed", "downloads"} {
+		if err := os.MkdirAll(filepath.Join(dir, sub), 0755); err != nil {
+			return err
+		}
+	}
+	return service.Run("godl", func(ctx context.Context) error {
+		opts = append([]md.Option{
+			md.WithOutputDir(filepath.Join(dir, "downloads")),
+			md.WithResume(true),
+		}, opts...)
+		d := &daemon{dir: dir, manager: md.NewManager(limits, opts...), added: make(map[string]bool)}
+		log.Println("Downloading the jobs of", filepath.Join(dir, "jobs"))
+		service.Notify(service.Ready)
+
+		ticker := time.NewTicker(daemonPoll)
+		defer ticker.Stop()
+		for ctx.Err() == nil {
+			d.scan()
+			service.Notify("STATUS=" + d.status())
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+			}
+		}
+
+		service.Notify(service.Stopping)
+		log.Println("Stopping, checkpointing the running downloads")
+		d.manager.Close()
+		d.waiting.Wait()
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		log.Println("Stopped,", len(d.added), "interrupted jobs will resume at the next start")
+		return nil
+	})
+}
+
+// Internal: give the new job files to the manager
+func (d *daemon) scan() {
+	entries, err := os.ReadDir(filepath.Join(d.dir, "jobs"))
+	if err != nil {
+		log.Println("Error reading the jobs:", err)
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		d.mu.Lock()
+		added := d.added[name]
+		d.mu.Unlock()
+		if added {
+			continue
+		}
+
+		var job daemonJob
+		data, err := os.ReadFile(filepath.Join(d.dir, "jobs", name))
+		if err == nil {
+			err = json.Unmarshal(data, &job)
+		}
+		if err == nil && len(job.URLs) == 0 {
+			err = errors.New("No URLs provided")
+		}
+		if err != nil {
+			job.Error = err.Error()
+			d.finish(name, job, "failed")
+			continue
+		}
+
+		log.Println("Job", name+":", strings.Join(job.URLs, " "))
+		d.mu.Lock()
+		d.added[name] = true
+		d.mu.Unlock()
+		j := d.manager.Add(md.Job{URLs: job.URLs, Dest: job.Dest})
+		d.waiting.Add(1)
+		go func() {
+			defer d.waiting.Done()
+			result, err := j.Wait()
+			if errors.Is(err, md.ErrJobCanceled) {
+				return // Interrupted, resumed at the next start
+			}
+			state := "done"
+			if err != nil {
+				job.Error = err.Error()
+				state = "failed"
+			} else {
+				job.File, job.Size = result.Filename, result.Size
+			}
+			d.finish(name, job, state)
+			d.mu.Lock()
+			delete(d.added, name)
+			d.mu.Unlock()
+		}()
+	}
+}
+
+// Internal: move a job file to the directory of its state, with its result
+func (d *daemon) finish(name string, job daemonJob, state string) {
+	if job.Error != "" {
+		log.Println("Job", name, "failed:", job.Error)
+	} else {
+		log.Println("Job", name, "done:", job.File)
+	}
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(d.dir, state, name), append(data, '\n'), 0644)
+	}
+	if err == nil {
+		err = os.Remove(filepath.Join(d.dir, "jobs", name))
+	}
+	if err != nil {
+		log.Println("Error moving job", name, "to", state+":", err)
+	}
+}
+
+// Internal: the status of the daemon, for systemctl status
+func (d *daemon) status() string {
+	s := d.manager.Stats()
+	return fmt.Sprintf("%d running, %d queued, %d done, %d failed", s.Running, s.Queued, s.Done, s.Failed)
+}
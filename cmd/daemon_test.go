@@ -0,0 +1,103 @@
+//go:build !windows
+
+package main
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The daemon downloads the jobs dropped in its state directory, telling
+// systemd, until stopped
+func TestDaemon(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("../test")))
+	defer server.Close()
+	dir := t.TempDir()
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	notified := func(state string) {
+		t.Helper()
+		buf := make([]byte, 256)
+		for {
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			n, err := conn.Read(buf)
+			if err != nil {
+				t.Fatalf("Waiting for %s: %v", state, err)
+			}
+			if string(buf[:n]) == state {
+				return
+			}
+		}
+	}
+
+	binary := filepath.Join(dir, "godl")
+	if out, err := exec.Command("go", "build", "-o", binary, ".").CombinedOutput(); err != nil {
+		t.Fatalf("Building godl: %v\n%s", err, out)
+	}
+	stateDir := filepath.Join(dir, "state")
+	cmd := exec.Command(binary, "-daemon", "-state-dir", stateDir)
+	cmd.Env = append(os.Environ(), "NOTIFY_SOCKET="+socket)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Process.Kill()
+	notified("READY=1")
+
+	writeJob := func(name, content string) {
+		tmp := filepath.Join(stateDir, name+".tmp")
+		if err := os.WriteFile(tmp, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, filepath.Join(stateDir, "jobs", name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeJob("quijote.json", `{"urls": ["`+server.URL+`/quijote.txt"]}`)
+	writeJob("broken.json", `{"urls": []}`)
+
+	// Jobs are moved once finished
+	waitFile := func(path string) []byte {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); ; {
+			if data, err := os.ReadFile(path); err == nil {
+				return data
+			}
+			if time.Now().After(deadline) {
+				t.Fatal(path, " wasn't written")
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	var job daemonJob
+	if err := json.Unmarshal(waitFile(filepath.Join(stateDir, "done", "quijote.json")), &job); err != nil {
+		t.Fatal(err)
+	}
+	if job.Size != 317621 || job.File != filepath.Join(stateDir, "downloads", "quijote.txt") {
+		t.Errorf("Unexpected result %+v", job)
+	}
+	var broken daemonJob
+	if err := json.Unmarshal(waitFile(filepath.Join(stateDir, "failed", "broken.json")), &broken); err != nil {
+		t.Fatal(err)
+	}
+	if broken.Error != "No URLs provided" {
+		t.Errorf("The broken job failed with %q", broken.Error)
+	}
+
+	cmd.Process.Signal(syscall.SIGTERM)
+	notified("STOPPING=1")
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("The daemon should stop cleanly, got %v", err)
+	}
+}
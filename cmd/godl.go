@@ -1,42 +1,364 @@
 package main
 
 import (
+	"crypto"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	md "github.com/alvatar/multipart-downloader"
+	"github.com/alvatar/multipart-downloader/progressbar"
+	"github.com/alvatar/multipart-downloader/service"
 )
 
 var (
 	nConns = flag.Uint("n", 1, "Number of concurrent connections")
 	sha256 = flag.String(
 		"S", "", "File containing SHA-256 hash, or a SHA-256 string")
+	sha1    = flag.String("sha1", "", "A SHA-1 string to check the downloaded file")
+	sha512  = flag.String("sha512", "", "A SHA-512 string to check the downloaded file")
+	crc32   = flag.String("crc32", "", "A CRC-32 (8 hex digits) to check the downloaded file")
+	crc32c  = flag.String("crc32c", "", "A CRC-32C (8 hex digits) to check the downloaded file")
+	blake2b = flag.String("blake2b", "", "A BLAKE2b-512 string (b2sum) to check the downloaded file")
+	blake3  = flag.String("blake3", "", "A BLAKE3 string (b3sum) to check the downloaded file")
+	xxhash  = flag.String("xxhash", "", "A XXH64 (16 hex digits) to check the downloaded file")
+	sumsURL = flag.String("checksums", "", "URL of a checksum file (SHA256SUMS, file.sha256...) with the digest of the file")
+	sri     = flag.String("integrity", "", "An integrity string (sha512-<base64>, sha256:<hex>) to check the downloaded file")
+	keyring = flag.String("keyring", "", "OpenPGP keyring (gpg --export) the file must be signed with")
+	sigURL  = flag.String("sig", "", "URL of the detached signature of the file for -keyring (default: URL.asc or URL.sig)")
 	useEtag = flag.Bool("E", false, "Verify using ETag as MD5")
 	timeout = flag.Uint(
-		"t", 5000, "Timeout for all connections in milliseconds")
+		"t", 5000, "Timeout of the probes of the sources in milliseconds")
 	output  = flag.String("o", "", "Output file")
+	dir     = flag.String("d", "", "Directory of the output file, created if missing")
 	verbose = flag.Bool("v", false, "Verbose output")
+	magic   = flag.String(
+		"m", "", "Expected file type, checked before downloading (zip, gzip, iso, elf...)")
+	zsyncFile = flag.String(
+		"zsync", "", "zsync control file (path or URL), for downloading only the changes")
+	seed           = flag.String("seed", "", "Older local version of the file, used with -zsync")
+	hls            = flag.Bool("hls", false, "The URL is an HLS playlist, download the stream it describes")
+	dash           = flag.Bool("dash", false, "The URL is a DASH manifest, download the stream it describes")
+	representation = flag.String("representation", "", "Representation ID to download with -dash (default: best video)")
+	lfsPointer     = flag.String(
+		"lfs", "", "Git LFS pointer file of the object to download, the URL being the repository")
+	oci = flag.Bool(
+		"oci", false, "Download a blob from a container registry, given as registry/repository@sha256:...")
+	quotaSize      = flag.String("quota", "", "Maximum transfer per period, like 500M or 2G")
+	quotaPeriod    = flag.String("quota-period", "day", "Period of the -quota: day or month")
+	resume         = flag.Bool("c", false, "Continue an interrupted download")
+	chunkSize      = flag.String("chunk-size", "", "Size of the chunks, like 8M (default: one chunk per connection)")
+	split          = flag.Bool("split", true, "Split what's left of slow chunks for idle connections")
+	singleBelow    = flag.String("single-below", "64K", "Download files smaller than this with a single request")
+	resumeSamples  = flag.Int("resume-samples", 0, "Random samples of the partial file checked against the sources with -c")
+	timestamping   = flag.Bool("N", false, "Don't download the file if the local one is up to date")
+	verifyOnly     = flag.Bool("verify", false, "Check the local file against the sources (size, ETag, checksums, signature) without downloading it")
+	follow         = flag.Duration("follow", 0, "Keep polling a growing file at this interval")
+	followStable   = flag.Int("follow-stable", 3, "Polls without growth after which a followed file is complete")
+	mixedETags     = flag.Bool("mixed-etags", false, "Accept sources serving the file with different ETags, if they agree on its size")
+	checkRedirect  = flag.Bool("check-redirects", false, "Follow redirects to other hosts only if they serve the same file")
+	addRedirects   = flag.Bool("redirect-mirrors", false, "Use the targets of redirects as mirrors too (implies -check-redirects)")
+	mirrorConns    = flag.Uint("mirror-conns", 0, "Maximum connections to each mirror (host) at once")
+	weightMirrors  = flag.Bool("weight-mirrors", false, "Benchmark the mirrors and send more chunks to the fastest")
+	mirrorBreaker  = flag.Duration("mirror-cooldown", 0, "Leave out mirrors failing 3 times in a row for this long, like 30s")
+	minSources     = flag.Int("min-sources", 1, "Sources that must answer the probes, the others being left out")
+	retries        = flag.Int("retries", 0, "Retries of the chunks failing with all the sources, waiting longer each time")
+	maxRate        = flag.String("limit-rate", "", "Limit the bandwidth of all the connections together to this per second, like 500K")
+	connRate       = flag.String("limit-conn-rate", "", "Limit the bandwidth of each connection to this per second, like 100K")
+	rateSchedule   = flag.String("rate-schedule", "", "Limit the bandwidth by time of day, like 00:00-08:00=0,1M (no limit at night, 1M otherwise)")
+	bufferSize     = flag.String("buffer-size", "", "Size of the buffer of each connection, like 1M (64K by default)")
+	speedLimit     = flag.String("speed-limit", "", "Drop requests slower than this per second (like 10K) for -speed-time")
+	speedTime      = flag.Duration("speed-time", 30*time.Second, "Time below -speed-limit before a request is dropped")
+	connectTimeout = flag.Duration("connect-timeout", 0, "Time to open a connection, TLS handshake included, like 10s")
+	headerTimeout  = flag.Duration("header-timeout", 0, "Time to wait for the headers of a response, like 30s")
+	idleTimeout    = flag.Duration("idle-timeout", 0, "Drop requests receiving nothing for this long, like 1m")
+	maxTime        = flag.Duration("max-time", 0, "Stop the download once it has taken this long, like 2h")
+	edgesFirst     = flag.String("edges-first", "", "Download this much (like 1M) of each end of the file first, for media previews")
+	hedge          = flag.Bool("hedge", false, "Race two mirrors for the requests of the end of the download")
+	preconnect     = flag.Bool("preconnect", false, "Open the connections to the mirrors before downloading")
+	tempDir        = flag.String("temp-dir", "", "Directory of the partial file, instead of next to the output file")
+	tempSuffix     = flag.String("temp-suffix", "", "Suffix of the partial file (default .part)")
+	preallocate    = flag.Bool("preallocate", false, "Reserve the disk space of the file before downloading")
+	decode         = flag.Bool("decode", false, "Decode files the sources only serve compressed while downloading them")
+	decompress     = flag.Bool("decompress", false, "Decompress .gz, .zst and .br files while downloading them")
+	extract        = flag.String("extract", "", "Extract the tar archive (.tar, .tar.gz, .tar.zst) into this directory while downloading it")
+	remoteTime     = flag.Bool("R", false, "Give the file the modification time of the remote one")
+	provenance     = flag.String("provenance", "", "Record the source URL, ETag and checksum of the file in its extended attributes (xattr) or a sidecar file (sidecar)")
+	fileMode       = flag.String("mode", "", "Mode bits of the output file, in octal like 0640")
+	owner          = flag.String("owner", "", "Owner of the output file, as user, user:group or :group (needs privileges)")
+	spaceMargin    = flag.String("space-margin", "", "Free disk space (like 1G) to keep on top of the size of the file")
+	nameTemplate   = flag.String("name-template", "", "Name the file from a template with {host}, {path}, {name}, {etag}, {sha256} and {date}")
+	conflict       = flag.String("conflict", "overwrite", "What to do when the output file exists: overwrite, skip, error or rename (to name.1...)")
+	pieceFile      = flag.String("pieces", "", "File with the SHA-1 or SHA-256 of each piece of the file (one per line), checked as they're downloaded")
+	pieceSize      = flag.String("piece-size", "", "Size of the pieces of -pieces (like 256K)")
+	merkleRoot     = flag.String("merkle-root", "", "Root (hex) of the Merkle tree of the digests of -pieces, which must match it")
+	maxConns       = flag.Uint("max-conns", 0, "Adjust the connections to the throughput, up to this number (starting with -n)")
+	autoTune       = flag.Bool("auto", false, "Choose the connections and the size of the chunks by probing the first source, instead of -n")
+	eventsFile     = flag.String("events", "", "Write the events of the download to this file as JSON lines (- for stderr)")
+	daemonMode     = flag.Bool("daemon", false, "Run as a service, downloading the jobs of the state directory until stopped")
+	stateDir       = flag.String("state-dir", service.StateDir("godl"), "State directory of -daemon, with its jobs and downloads")
+	maxDownloads   = flag.Uint("max-downloads", 4, "Downloads at once with -daemon")
+	maxTotalConns  = flag.Uint("max-total-conns", 0, "Connections at once across all the downloads of -daemon")
+	useDisposition = flag.Bool(
+		"J", false, "Name the file as suggested by the server (Content-Disposition)")
 )
 
 func exitOnError(err error) {
 	if err != nil {
+		var hint *md.Hint
+		if errors.As(err, &hint) {
+			log.Println(err)
+			log.Fatal("Hint: ", hint.Remedy)
+		}
 		log.Fatal(err)
 		os.Exit(1)
 	}
 }
 
+// Check the MD5 of the file given by its ETag, refusing ETags that aren't one
+func checkETagMD5(dldr *md.MultiDownloader) error {
+	md5sum, ok := dldr.ETagMD5()
+	if !ok {
+		return errors.New(fmt.Sprintf("The ETag %q isn't an MD5 of the file (weak, multipart upload...), can't verify it with -E", dldr.ETag))
+	}
+	return dldr.CheckMD5(md5sum)
+}
+
+// Read a zsync control file, either local or remote
+func loadZsync(location string) (*md.ZsyncControl, error) {
+	var r io.ReadCloser
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		resp, err := http.Get(location)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.New(
+				fmt.Sprintf("Failed fetching %s: status %d", location, resp.StatusCode))
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(location)
+		if err != nil {
+			return nil, err
+		}
+		r = f
+	}
+	defer r.Close()
+	return md.ParseZsync(r)
+}
+
+// Read a Git LFS pointer file
+func loadLFSPointer(filename string) (*md.LFSPointer, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return md.ParseLFSPointer(f)
+}
+
+// Read the digests of the pieces of a file, one per line in hex, SHA-1 or
+// SHA-256 depending on their length
+func loadPieceHashes(filename string, size int64) (*md.PieceHashes, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	sums := strings.Fields(string(data))
+	h := crypto.SHA256.New
+	if len(sums) > 0 && len(sums[0]) == 2*crypto.SHA1.Size() {
+		h = crypto.SHA1.New
+	}
+	return md.ParsePieceHashes(size, h, sums)
+}
+
+// Parse a size with an optional K, M, G or T suffix (powers of 1024)
+func parseSize(s string) (int64, error) {
+	multiplier := int64(1)
+	if n := len(s); n > 0 {
+		switch strings.ToUpper(s[n-1:]) {
+		case "K":
+			multiplier = 1 << 10
+		case "M":
+			multiplier = 1 << 20
+		case "G":
+			multiplier = 1 << 30
+		case "T":
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			s = s[:n-1]
+		}
+	}
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || size < 0 {
+		return 0, errors.New(fmt.Sprintf("Invalid size %q", s))
+	}
+	return size * multiplier, nil
+}
+
+// Parse an owner as user, user:group or :group, by name or ID, -1 keeping either
+func parseOwner(s string) (uid, gid int, err error) {
+	userName, groupName, _ := strings.Cut(s, ":")
+	uid, gid = -1, -1
+	if userName != "" {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			if u, err = user.LookupId(userName); err != nil {
+				return 0, 0, err
+			}
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, errors.New(fmt.Sprintf("The user %s has no numeric ID", userName))
+		}
+	}
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			if g, err = user.LookupGroupId(groupName); err != nil {
+				return 0, 0, err
+			}
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, errors.New(fmt.Sprintf("The group %s has no numeric ID", groupName))
+		}
+	}
+	return uid, gid, nil
+}
+
+// Load the transfer quota, kept in the user configuration directory
+func loadQuota(size, period string) (*md.Quota, error) {
+	limit, err := parseSize(size)
+	if err != nil {
+		return nil, err
+	}
+	var p md.QuotaPeriod
+	switch period {
+	case "day":
+		p = md.Daily
+	case "month":
+		p = md.Monthly
+	default:
+		return nil, errors.New(fmt.Sprintf("Unknown quota period %q", period))
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, "godl")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return md.NewQuota(limit, p, filepath.Join(dir, "quota-"+period+".json"))
+}
+
+// Parse a schedule of bandwidth limits like 00:00-08:00=0,1M: windows of the
+// day with their rate (0 for no limit), and the rate outside them
+func parseRateSchedule(s string) (md.RateSchedule, error) {
+	var schedule md.RateSchedule
+	clock := func(hhmm string) (time.Duration, error) {
+		t, err := time.Parse("15:04", hhmm)
+		if err != nil {
+			return 0, errors.New(fmt.Sprintf("Invalid time of day %q", hhmm))
+		}
+		return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		window, rate, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			limit, err := parseSize(window)
+			if err != nil {
+				return schedule, err
+			}
+			schedule.Default = limit
+			continue
+		}
+		start, end, found := strings.Cut(window, "-")
+		if !found {
+			return schedule, errors.New(fmt.Sprintf("Invalid window %q, like 00:00-08:00", window))
+		}
+		w := md.RateWindow{}
+		var err error
+		if w.Start, err = clock(start); err != nil {
+			return schedule, err
+		}
+		if w.End, err = clock(end); err != nil {
+			return schedule, err
+		}
+		if w.Rate, err = parseSize(rate); err != nil {
+			return schedule, err
+		}
+		schedule.Windows = append(schedule.Windows, w)
+	}
+	return schedule, nil
+}
+
 func main() {
 	flag.Parse()
 	log.SetPrefix("godl: ")
-	if len(flag.Args()) == 0 {
+	urls := flag.Args()
+
+	if *daemonMode {
+		exitOnError(daemonMain())
+		return
+	}
+
+	// Delta downloads may take the URLs from the control file
+	var zsyncCtrl *md.ZsyncControl
+	if *zsyncFile != "" {
+		if *seed == "" {
+			log.Fatal("-zsync requires an older version of the file given with -seed")
+		}
+		var err error
+		zsyncCtrl, err = loadZsync(*zsyncFile)
+		exitOnError(err)
+		if len(urls) == 0 {
+			urls = zsyncCtrl.ResolveURLs(*zsyncFile)
+		}
+	}
+
+	if len(urls) == 0 {
 		log.Fatal("No URLs provided")
 		os.Exit(1)
 	}
 
+	// Streaming to stdout or extracting leaves no file to check or update
+	// afterwards
+	toStdout := *output == "-"
+	if toStdout && *extract != "" {
+		log.Fatal("-o - can't be used with -extract")
+	}
+	if *verifyOnly && (toStdout || *extract != "") {
+		log.Fatal("-verify checks a file, it can't be used with -o - or -extract")
+	}
+	if toStdout || *extract != "" {
+		checksums := *sha256 + *sha1 + *sha512 + *crc32 + *crc32c + *blake2b + *blake3 + *xxhash + *sumsURL + *sri + *keyring
+		if checksums != "" || *useEtag || *follow > 0 || zsyncCtrl != nil || *lfsPointer != "" {
+			log.Fatal("-o - and -extract can't be used with checksums, -keyring, -E, -follow, -zsync or -lfs")
+		}
+	}
+	if toStdout {
+		*output = ""
+	}
+
 	// Register signals
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc,
@@ -45,8 +367,15 @@ func main() {
 		syscall.SIGINT,
 		syscall.SIGTERM,
 		syscall.SIGQUIT)
+	// While following a file, what was downloaded so far is complete
+	stopFollow := make(chan struct{})
+	var following atomic.Bool
 	go func() {
 		<-sigc
+		if following.Load() {
+			close(stopFollow)
+			return
+		}
 		log.Fatal("Exit with incomplete download")
 		os.Exit(1)
 	}()
@@ -57,48 +386,360 @@ func main() {
 	}
 
 	// Initialize download
+	var opts []md.Option
+	if *magic != "" {
+		sig, ok := md.LookupSignature(*magic)
+		if !ok {
+			log.Fatal("Unknown file type: ", *magic)
+		}
+		opts = append(opts, md.WithSignature(sig))
+	}
+	if *quotaSize != "" {
+		quota, err := loadQuota(*quotaSize, *quotaPeriod)
+		exitOnError(err)
+		opts = append(opts, md.WithQuota(quota))
+	}
+	if zsyncCtrl != nil {
+		// Blocks that fail their checksums are downloaded again
+		opts = append(opts, md.WithBlockChecksums(zsyncCtrl))
+	}
+	if *resume {
+		opts = append(opts, md.WithResume(true), md.WithResumeSampling(*resumeSamples))
+	}
+	if *sha256 != "" {
+		opts = append(opts, md.WithSHA256(*sha256))
+	}
+	if *sha1 != "" {
+		opts = append(opts, md.WithSHA1(*sha1))
+	}
+	if *sha512 != "" {
+		opts = append(opts, md.WithSHA512(*sha512))
+	}
+	if *crc32 != "" {
+		opts = append(opts, md.WithCRC32(*crc32))
+	}
+	if *crc32c != "" {
+		opts = append(opts, md.WithCRC32C(*crc32c))
+	}
+	if *blake2b != "" {
+		opts = append(opts, md.WithBLAKE2b(*blake2b))
+	}
+	if *blake3 != "" {
+		opts = append(opts, md.WithBLAKE3(*blake3))
+	}
+	if *xxhash != "" {
+		opts = append(opts, md.WithXXHash(*xxhash))
+	}
+	if *sumsURL != "" {
+		opts = append(opts, md.WithChecksumURL(*sumsURL))
+	}
+	if *sri != "" {
+		opts = append(opts, md.WithIntegrity(*sri))
+	}
+	if *keyring != "" {
+		keys, err := os.ReadFile(*keyring)
+		exitOnError(err)
+		opts = append(opts, md.WithPGPSignature(*sigURL, keys))
+	} else if *sigURL != "" {
+		log.Fatal("-sig needs a -keyring to check the signature against")
+	}
+	if *chunkSize != "" {
+		size, err := parseSize(*chunkSize)
+		exitOnError(err)
+		opts = append(opts, md.WithChunkSize(size))
+	}
+	if *singleBelow != "" {
+		size, err := parseSize(*singleBelow)
+		exitOnError(err)
+		opts = append(opts, md.WithSingleConnectionBelow(size))
+	}
+	if *mixedETags {
+		opts = append(opts, md.WithMixedETags(true))
+	}
+	if *checkRedirect || *addRedirects {
+		opts = append(opts, md.WithRedirectCheck(*addRedirects))
+	}
+	if *mirrorConns > 0 {
+		opts = append(opts, md.WithMirrorConns(int(*mirrorConns)))
+	}
+	if *weightMirrors {
+		opts = append(opts, md.WithMirrorWeighting(true))
+	}
+	if *minSources > 1 {
+		opts = append(opts, md.WithMinSources(*minSources))
+	}
+	if *mirrorBreaker > 0 {
+		opts = append(opts, md.WithMirrorBreaker(3, *mirrorBreaker))
+	}
+	if *retries > 0 {
+		opts = append(opts, md.WithRetryPolicy(md.RetryPolicy{
+			MaxRetries: *retries,
+			BaseDelay:  time.Second,
+			MaxDelay:   30 * time.Second,
+			Jitter:     0.5,
+		}))
+	}
+	if *maxRate != "" {
+		rate, err := parseSize(*maxRate)
+		exitOnError(err)
+		opts = append(opts, md.WithMaxRate(rate))
+	}
+	if *rateSchedule != "" {
+		schedule, err := parseRateSchedule(*rateSchedule)
+		exitOnError(err)
+		opts = append(opts, md.WithRateSchedule(schedule))
+	}
+	if *connRate != "" {
+		rate, err := parseSize(*connRate)
+		exitOnError(err)
+		opts = append(opts, md.WithConnRate(rate))
+	}
+	if *bufferSize != "" {
+		size, err := parseSize(*bufferSize)
+		exitOnError(err)
+		opts = append(opts, md.WithBufferSizes(int(size), 0))
+	}
+	if *speedLimit != "" {
+		limit, err := parseSize(*speedLimit)
+		exitOnError(err)
+		opts = append(opts, md.WithLowSpeedLimit(limit, *speedTime))
+	}
+	if *connectTimeout > 0 {
+		opts = append(opts, md.WithConnectTimeout(*connectTimeout))
+	}
+	if *headerTimeout > 0 {
+		opts = append(opts, md.WithResponseHeaderTimeout(*headerTimeout))
+	}
+	if *idleTimeout > 0 {
+		opts = append(opts, md.WithIdleTimeout(*idleTimeout))
+	}
+	if *maxTime > 0 {
+		opts = append(opts, md.WithMaxTime(*maxTime))
+	}
+	if *edgesFirst != "" {
+		size, err := parseSize(*edgesFirst)
+		exitOnError(err)
+		opts = append(opts, md.WithEdgesFirst(size))
+	}
+	if *hedge {
+		opts = append(opts, md.WithHedging(true))
+	}
+	if *preconnect {
+		opts = append(opts, md.WithPreconnect(true))
+	}
+	if *dir != "" {
+		opts = append(opts, md.WithOutputDir(*dir))
+	}
+	if *tempDir != "" {
+		opts = append(opts, md.WithTempDir(*tempDir))
+	}
+	if *tempSuffix != "" {
+		opts = append(opts, md.WithTempSuffix(*tempSuffix))
+	}
+	if *preallocate {
+		opts = append(opts, md.WithPreallocation(true))
+	}
+	if *decode {
+		opts = append(opts, md.WithDecoding(true))
+	}
+	if *decompress {
+		// The compression is the one of the output name, or the URL's
+		name := *output
+		if name == "" {
+			name = path.Base(strings.SplitN(urls[0], "?", 2)[0])
+		}
+		filter, decompressed := md.FilterForName(name)
+		if filter == nil {
+			log.Fatal("-decompress: unknown compression for ", name)
+		}
+		opts = append(opts, md.WithOutputFilters(filter))
+		*output = decompressed
+	}
+	if *extract != "" {
+		opts = append(opts, md.WithExtraction(*extract))
+	}
+	if toStdout {
+		opts = append(opts, md.WithOutputWriter(os.Stdout))
+	}
+	if *maxConns > 0 {
+		opts = append(opts, md.WithAdaptiveConcurrency(1, int(*maxConns)))
+	}
+	if *autoTune {
+		opts = append(opts, md.WithAutoTune())
+	}
+	if !*split {
+		opts = append(opts, md.WithSplitting(false))
+	}
+	if *eventsFile == "-" {
+		opts = append(opts, md.WithEventWriter(os.Stderr))
+	} else if *eventsFile != "" {
+		events, err := os.Create(*eventsFile)
+		exitOnError(err)
+		defer events.Close()
+		opts = append(opts, md.WithEventWriter(events))
+	}
+	if *pieceFile != "" {
+		size, err := parseSize(*pieceSize)
+		exitOnError(err)
+		pieces, err := loadPieceHashes(*pieceFile, size)
+		exitOnError(err)
+		if *merkleRoot != "" {
+			root, err := hex.DecodeString(*merkleRoot)
+			exitOnError(err)
+			pieces, err = md.NewMerkleTree(pieces.Size, pieces.Hash, root, pieces.Sums)
+			exitOnError(err)
+		}
+		opts = append(opts, md.WithPieceHashes(pieces))
+	} else if *merkleRoot != "" {
+		log.Fatal("-merkle-root needs the digests of the pieces (-pieces)")
+	}
+	if *spaceMargin != "" {
+		margin, err := parseSize(*spaceMargin)
+		exitOnError(err)
+		opts = append(opts, md.WithSpaceMargin(margin))
+	}
+	if *fileMode != "" {
+		mode, err := strconv.ParseUint(*fileMode, 8, 32)
+		if err != nil || mode > 0777 {
+			exitOnError(errors.New(fmt.Sprintf("Invalid mode %q", *fileMode)))
+		}
+		opts = append(opts, md.WithFileMode(os.FileMode(mode)))
+	}
+	if *owner != "" {
+		uid, gid, err := parseOwner(*owner)
+		exitOnError(err)
+		opts = append(opts, md.WithOwner(uid, gid))
+	}
+	switch *conflict {
+	case "overwrite":
+	case "skip":
+		opts = append(opts, md.WithConflictPolicy(md.ConflictSkip))
+	case "error":
+		opts = append(opts, md.WithConflictPolicy(md.ConflictError))
+	case "rename":
+		opts = append(opts, md.WithConflictPolicy(md.ConflictRename))
+	default:
+		exitOnError(errors.New("-conflict must be overwrite, skip, error or rename"))
+	}
+	switch *provenance {
+	case "":
+	case "xattr":
+		opts = append(opts, md.WithProvenance(md.ProvenanceXattr))
+	case "sidecar":
+		opts = append(opts, md.WithProvenance(md.ProvenanceSidecar))
+	default:
+		exitOnError(errors.New("-provenance must be xattr or sidecar"))
+	}
+	if *remoteTime {
+		opts = append(opts, md.WithRemoteTime(true))
+	}
+	if *timestamping {
+		opts = append(opts, md.WithTimestamping(""))
+	}
+	if *verbose {
+		opts = append(opts, md.WithSourceCallback(func(r md.SourceResult) {
+			if r.Err != nil {
+				log.Println("Source", r.URL, "failed:", r.Err)
+			} else {
+				log.Println("Source", r.URL, "ready,", r.FileLength, "bytes")
+			}
+		}))
+	}
+	if *useDisposition {
+		opts = append(opts, md.WithFilenameResolver(md.ContentDispositionResolver))
+	}
+	if *nameTemplate != "" {
+		opts = append(opts, md.WithFilenameResolver(md.TemplateResolver(*nameTemplate)))
+	}
 	dldr := md.NewMultiDownloader(
-		flag.Args(),
+		urls,
 		int(*nConns),
-		time.Duration(*timeout)*time.Millisecond)
+		time.Duration(*timeout)*time.Millisecond,
+		opts...)
 	md.SetVerbose(*verbose)
 
 	// Gather info from all sources
-	chunks, err := dldr.GatherInfo()
+	var err error
+	switch {
+	case *lfsPointer != "":
+		var pointer *md.LFSPointer
+		pointer, err = loadLFSPointer(*lfsPointer)
+		exitOnError(err)
+		_, err = dldr.GatherLFSInfo(urls[0], *pointer)
+		if *output == "" {
+			// Replace the pointer by the object, as git lfs checkout does
+			*output = *lfsPointer
+		}
+	case *oci:
+		var ref md.OCIReference
+		ref, err = md.ParseOCIReference(urls[0])
+		exitOnError(err)
+		_, err = dldr.GatherOCIInfo(ref)
+	case *hls:
+		_, err = dldr.GatherHLSInfo()
+	case *dash:
+		_, err = dldr.GatherDASHInfo(*representation)
+	default:
+		_, err = dldr.GatherInfo()
+	}
 	exitOnError(err)
 
+	// Check the file already downloaded instead
+	if *verifyOnly {
+		exitOnError(dldr.VerifyLocal(*output))
+		if *useEtag {
+			exitOnError(checkETagMD5(dldr))
+		}
+		if *verbose {
+			log.Println("The file matches its sources")
+		}
+		return
+	}
+
 	// Prepare the file to write individual blocks on
 	_, err = dldr.SetupFile(*output)
+	if errors.Is(err, md.ErrNotModified) || errors.Is(err, md.ErrAlreadyComplete) ||
+		errors.Is(err, md.ErrSkipped) {
+		if *verbose {
+			log.Println(err, "- nothing to download")
+		}
+		return
+	}
 	exitOnError(err)
 
-	// Perform download
-	if *verbose {
-		// Setup bar visualization
-		v := NewProgress(chunks)
-		err = dldr.Download(
-			func(feedback []md.ConnectionProgress) {
-				v.Update(feedback)
-			})
+	// Perform download, drawing the progress bars until it ends
+	var rendered <-chan struct{}
+	if *verbose && !toStdout {
+		rendered = progressbar.New(os.Stderr).Start(dldr.Progress())
+	}
+	if zsyncCtrl != nil {
+		err = dldr.DownloadDelta(*seed, zsyncCtrl, nil)
 	} else {
 		err = dldr.Download(nil)
 	}
 	exitOnError(err)
-
-	// Perform SHA256 check if requested
-	if *sha256 != "" {
-		err := dldr.CheckSHA256(*sha256)
-		exitOnError(err)
-		if err != nil {
-			log.Fatal(err)
-			os.Exit(1)
-		} else if *verbose {
-			log.Println("SHA-256 checked successfully")
+	if rendered != nil {
+		<-rendered
+	}
+	if *verbose {
+		stats := dldr.Stats()
+		log.Printf("%s in %v (%s/s on average, %s/s at most), %d retries",
+			progressbar.FormatBytes(stats.Bytes), stats.Duration.Round(time.Millisecond),
+			progressbar.FormatBytes(int64(stats.AvgSpeed)), progressbar.FormatBytes(int64(stats.PeakSpeed)),
+			stats.Retries)
+		for _, m := range stats.Mirrors {
+			log.Printf("  %s: %s, %d failures", m.Host, progressbar.FormatBytes(m.Bytes), m.Failures)
 		}
 	}
 
+	// The SHA-256 was checked as the file was downloaded
+	if *sha256 != "" && *verbose {
+		log.Println("SHA-256 checked successfully")
+	}
+
 	// Perform MD5SUM from ETag if requested
 	if *useEtag {
-		err := dldr.CheckMD5(dldr.ETag)
+		err := checkETagMD5(dldr)
 		exitOnError(err)
 		if err != nil {
 			log.Fatal(err)
@@ -107,4 +748,15 @@ func main() {
 			log.Println("MD5SUM checked successfully")
 		}
 	}
+
+	// Append what's added to a growing file
+	if *follow > 0 {
+		following.Store(true)
+		err := dldr.Follow(md.FollowOptions{
+			Interval:    *follow,
+			StableAfter: *followStable,
+			Stop:        stopFollow,
+		})
+		exitOnError(err)
+	}
 }
@@ -1,9 +1,13 @@
 package main
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNoArgs(t *testing.T) {
@@ -48,3 +52,30 @@ func TestUrl(t *testing.T) {
 	}
 	os.Remove("tmp_file")
 }
+
+func TestLoadZsyncNotFound(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	_, err := loadZsync(server.URL + "/file.zsync")
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("A missing control file should be reported as such, got: %v", err)
+	}
+}
+
+func TestParseRateSchedule(t *testing.T) {
+	schedule, err := parseRateSchedule("00:00-08:00=0, 22:30-23:00=100K, 1M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schedule.Default != 1<<20 || len(schedule.Windows) != 2 {
+		t.Fatalf("Unexpected schedule %+v", schedule)
+	}
+	if w := schedule.Windows[1]; w.Start != 22*time.Hour+30*time.Minute || w.End != 23*time.Hour || w.Rate != 100<<10 {
+		t.Errorf("Unexpected window %+v", w)
+	}
+	for _, bad := range []string{"00:00=1M", "25:00-08:00=0", "00:00-08:00=fast"} {
+		if _, err := parseRateSchedule(bad); err == nil {
+			t.Errorf("Schedule %q should be invalid", bad)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package multipartdownloader
+
+import (
+	"io"
+	"os"
+)
+
+// Write coalescing. The connections receive their responses in reads of a
+// few kilobytes, and writing each of them on its own means a system call for
+// each, and a file fragmented by the connections writing next to each other.
+// Instead, each connection gathers what it receives in a block of 1 MiB by
+// default, aligned on a multiple of the size in the file, and writes it once
+// full, or once the response ends or fails. Ranges are only marked written
+// (in the control file, and for the streams) once they are in the file.
+
+const defaultCoalesceSize = 1 << 20
+
+// Gather the data of each connection in blocks of this size before writing
+// them to the file. Zero writes the data as it is received.
+func WithWriteCoalescing(size int) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.coalesceSize = size
+	}
+}
+
+// Internal: writer of a sequence of bytes of a file from an offset, in blocks
+// aligned on their size
+type blockWriter struct {
+	out     *io.OffsetWriter
+	offset  int64  // Where the block goes in the file
+	block   []byte // Not written yet, nil to write through
+	written func(begin, end int64)
+}
+
+// Internal: a writer from an offset of a file, gathering the data in blocks of
+// the size of the buffer, or writing it through without one. The function is
+// called with each range written.
+func newBlockWriter(f *os.File, offset int64, buf []byte, written func(begin, end int64)) *blockWriter {
+	return &blockWriter{
+		out:     io.NewOffsetWriter(f, offset),
+		offset:  offset,
+		block:   buf[:0],
+		written: written,
+	}
+}
+
+// Internal: add data to the block, writing it once it reaches a boundary
+func (w *blockWriter) Write(p []byte) (int, error) {
+	if cap(w.block) == 0 {
+		return w.writeOut(p)
+	}
+	size := int64(cap(w.block))
+	total := len(p)
+	for len(p) > 0 {
+		end := w.offset + int64(len(w.block))
+		room := size - end%size // Up to the next boundary
+		take := int(min(room, int64(len(p))))
+		w.block = append(w.block, p[:take]...)
+		p = p[take:]
+		if int64(take) == room {
+			if err := w.Flush(); err != nil {
+				return total - len(p), err
+			}
+		}
+	}
+	return total, nil
+}
+
+// Internal: write the block, full or not
+func (w *blockWriter) Flush() error {
+	if len(w.block) == 0 {
+		return nil
+	}
+	n, err := w.writeOut(w.block)
+	w.block = w.block[:copy(w.block, w.block[n:])]
+	return err
+}
+
+// Internal: write data at the offset, and report it written
+func (w *blockWriter) writeOut(p []byte) (int, error) {
+	// According to doc: "Clients of WriteAt can execute parallel WriteAt calls on the
+	// same destination if the ranges do not overlap."
+	n, err := w.out.Write(p)
+	if n > 0 {
+		w.written(w.offset, w.offset+int64(n))
+		w.offset += int64(n)
+	}
+	return n, err
+}
@@ -0,0 +1,52 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// The blocks are written once they reach a multiple of their size
+func TestBlockWriter(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "blocks"))
+	failOnError(t, err)
+	defer f.Close()
+	var written [][2]int64
+	w := newBlockWriter(f, 5, make([]byte, 8), func(begin, end int64) {
+		written = append(written, [2]int64{begin, end})
+	})
+	for _, s := range []string{"abc", "defghij", "klmnopqrst"} {
+		n, err := w.Write([]byte(s))
+		failOnError(t, err)
+		if n != len(s) {
+			t.Errorf("Wrote %d bytes of %d", n, len(s))
+		}
+	}
+	expected := [][2]int64{{5, 8}, {8, 16}, {16, 24}}
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("Written %v before flushing, should be %v", written, expected)
+	}
+	failOnError(t, w.Flush())
+	expected = append(expected, [2]int64{24, 25})
+	if !reflect.DeepEqual(written, expected) {
+		t.Errorf("Written %v, should be %v", written, expected)
+	}
+	data, err := os.ReadFile(f.Name())
+	failOnError(t, err)
+	if string(data[5:]) != "abcdefghijklmnopqrst" {
+		t.Errorf("Wrote %q", data)
+	}
+}
+
+// Files are downloaded whatever the size of the blocks, or without them
+func TestWriteCoalescing(t *testing.T) {
+	for _, size := range []int{0, 1000, 1 << 20} {
+		err := downloadLocal(t, http.FileServer(http.Dir("./test")), 4,
+			WithWriteCoalescing(size), WithSHA256(quijoteSHA256))
+		if err != nil {
+			t.Errorf("Blocks of %d bytes: %v", size, err)
+		}
+	}
+}
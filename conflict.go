@@ -0,0 +1,69 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// What to do when the output file already exists, checked by SetupFile. By
+// default it's overwritten once the download completes. The expected digest
+// (WithSHA256) and timestamping are checked first: a file that is already
+// there and up to date is never downloaded again.
+
+// Policy for an output file that already exists
+type ConflictPolicy int
+
+const (
+	ConflictOverwrite ConflictPolicy = iota // Replace it once the download completes
+	ConflictSkip                            // SetupFile returns ErrSkipped
+	ConflictError                           // SetupFile returns ErrFileExists
+	ConflictRename                          // Save to name.1, name.2... the first free one
+)
+
+// Returned by SetupFile when the output file exists, with ConflictSkip
+var ErrSkipped = errors.New("The output file already exists, skipped")
+
+// Returned by SetupFile when the output file exists, with ConflictError
+var ErrFileExists = errors.New("The output file already exists")
+
+// Choose what to do when the output file already exists
+func WithConflictPolicy(policy ConflictPolicy) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.conflict = policy
+	}
+}
+
+// Internal: apply the conflict policy if the output file exists. Renaming
+// picks the first name whose file doesn't exist, so that an interrupted
+// download to it is resumed.
+func (dldr *MultiDownloader) resolveConflict() error {
+	if dldr.conflict == ConflictOverwrite || dldr.output != nil || dldr.extractDir != "" {
+		return nil
+	}
+	if _, err := os.Lstat(dldr.filename); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	switch dldr.conflict {
+	case ConflictSkip:
+		dldr.logVerbose(dldr.filename, " already exists, skipping it")
+		return ErrSkipped
+	case ConflictError:
+		return withHint(fmt.Errorf("%w: %s", ErrFileExists, dldr.filename),
+			"Remove it, or choose another name or conflict policy")
+	}
+	base := dldr.filename
+	for n := 1; ; n++ {
+		name := fmt.Sprintf("%s.%d", base, n)
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			dldr.logVerbose(base, " already exists, saving to ", name)
+			dldr.filename = name
+			dldr.partFilename = dldr.partName(name)
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
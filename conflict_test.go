@@ -0,0 +1,57 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConflictPolicy(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+	data, err := os.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+
+	testTable := []struct {
+		policy ConflictPolicy
+		err    error
+		saved  string // File the download is saved to, if any
+	}{
+		{ConflictOverwrite, nil, "quijote.txt"},
+		{ConflictSkip, ErrSkipped, ""},
+		{ConflictError, ErrFileExists, ""},
+		{ConflictRename, nil, "quijote.txt.2"},
+	}
+	for _, test := range testTable {
+		dir := t.TempDir()
+		output := filepath.Join(dir, "quijote.txt")
+		for _, name := range []string{output, output + ".1"} {
+			failOnError(t, os.WriteFile(name, []byte("existing"), 0666))
+		}
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/quijote.txt"}, 2, 5*time.Second, WithConflictPolicy(test.policy))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(output)
+		if !errors.Is(err, test.err) {
+			t.Errorf("Policy %d: SetupFile returned %v instead of %v", test.policy, err, test.err)
+		}
+		if err == nil {
+			failOnError(t, dldr.Download(nil))
+		}
+		for _, name := range []string{"quijote.txt", "quijote.txt.1", "quijote.txt.2"} {
+			content, err := os.ReadFile(filepath.Join(dir, name))
+			if name == test.saved {
+				if string(content) != string(data) {
+					t.Errorf("Policy %d: the file should be saved to %s", test.policy, name)
+				}
+			} else if err == nil && string(content) != "existing" {
+				t.Errorf("Policy %d: %s shouldn't be changed", test.policy, name)
+			}
+		}
+	}
+}
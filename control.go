@@ -0,0 +1,344 @@
+package multipartdownloader
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// The file changed on the server since the download was interrupted
+var ErrRemoteChanged = errors.New("The remote file has changed")
+
+// Control files keep track of the progress of a download, so that it can be
+// resumed after a crash or an interruption. Next to the partial file, a
+// small binary file holds a bitmap with one bit per block of fileWriteChunk
+// bytes, set once the block is written. Bits are updated in place as the
+// data arrives, so at most one buffer per connection is lost.
+//
+// Layout, big endian:
+//
+//	magic       [4]byte "MDCF"
+//	version     uint16
+//	blockSize   uint32
+//	fileLength  int64
+//	validator   uint16 length + bytes (If-Range validator of the file)
+//	bitmap      uint32 length + bytes
+
+const (
+	controlFileSuffix = ".ctl"
+	controlMagic      = "MDCF"
+	controlVersion    = 1
+)
+
+// A set of disjoint byte ranges, sorted and merged
+type rangeSet []Chunk
+
+// Internal: add a range, returning the merged range that now contains it
+func (s *rangeSet) add(begin, end int64) Chunk {
+	ranges := *s
+	// First range that ends at or after begin, the one it may touch
+	i := sort.Search(len(ranges), func(i int) bool { return ranges[i].End >= begin })
+	j := i
+	merged := Chunk{begin, end}
+	for j < len(ranges) && ranges[j].Begin <= end {
+		merged.Begin = min(merged.Begin, ranges[j].Begin)
+		merged.End = max(merged.End, ranges[j].End)
+		j++
+	}
+	ranges = append(ranges[:i], append([]Chunk{merged}, ranges[j:]...)...)
+	*s = ranges
+	return merged
+}
+
+// Internal: whether some of a range is in the set
+func (s rangeSet) overlaps(begin, end int64) bool {
+	i := sort.Search(len(s), func(i int) bool { return s[i].End > begin })
+	return i < len(s) && s[i].Begin < end
+}
+
+// Internal: whether a range is entirely in the set
+func (s rangeSet) covers(begin, end int64) bool {
+	if begin >= end {
+		return true
+	}
+	for _, c := range s {
+		if c.Begin <= begin && end <= c.End {
+			return true
+		}
+	}
+	return false
+}
+
+// The progress of a download, as kept in its control file
+type controlFile struct {
+	file         *os.File
+	mu           sync.Mutex
+	blockSize    int64
+	fileLength   int64
+	validator    string
+	bitmap       []byte
+	bitmapOffset int64    // Position of the bitmap in the file
+	written      rangeSet // Bytes known to be written
+}
+
+// Internal: path of the control file of a partial file
+func controlPath(partFilename string) string {
+	return partFilename + controlFileSuffix
+}
+
+// Internal: create a control file for a download with nothing written yet
+func createControl(path string, fileLength int64, validator string) (*controlFile, error) {
+	if len(validator) > 0xffff {
+		validator = ""
+	}
+	ctl := &controlFile{
+		blockSize:  fileWriteChunk,
+		fileLength: fileLength,
+		validator:  validator,
+	}
+	ctl.bitmap = make([]byte, (ctl.blocks()+7)/8)
+
+	header := make([]byte, 0, 24+len(validator))
+	header = append(header, controlMagic...)
+	header = binary.BigEndian.AppendUint16(header, controlVersion)
+	header = binary.BigEndian.AppendUint32(header, uint32(ctl.blockSize))
+	header = binary.BigEndian.AppendUint64(header, uint64(fileLength))
+	header = binary.BigEndian.AppendUint16(header, uint16(len(validator)))
+	header = append(header, validator...)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(ctl.bitmap)))
+	ctl.bitmapOffset = int64(len(header))
+
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := file.Write(append(header, ctl.bitmap...)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	ctl.file = file
+	return ctl, nil
+}
+
+// Internal: open the control file of an interrupted download
+func loadControl(path string) (*controlFile, error) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		return nil, err
+	}
+	ctl, err := readControl(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	ctl.file = file
+	return ctl, nil
+}
+
+// Internal: parse a control file
+func readControl(r io.Reader) (*controlFile, error) {
+	invalid := errors.New("Invalid control file")
+	var fixed struct {
+		Magic      [4]byte
+		Version    uint16
+		BlockSize  uint32
+		FileLength int64
+		ValidLen   uint16
+	}
+	if err := binary.Read(r, binary.BigEndian, &fixed); err != nil {
+		return nil, invalid
+	}
+	if string(fixed.Magic[:]) != controlMagic || fixed.Version != controlVersion ||
+		fixed.BlockSize == 0 || fixed.FileLength < 0 {
+		return nil, invalid
+	}
+	validator := make([]byte, fixed.ValidLen)
+	if _, err := io.ReadFull(r, validator); err != nil {
+		return nil, invalid
+	}
+	var bitmapLen uint32
+	if err := binary.Read(r, binary.BigEndian, &bitmapLen); err != nil {
+		return nil, invalid
+	}
+	ctl := &controlFile{
+		blockSize:    int64(fixed.BlockSize),
+		fileLength:   fixed.FileLength,
+		validator:    string(validator),
+		bitmapOffset: 24 + int64(fixed.ValidLen),
+	}
+	if int64(bitmapLen) != (ctl.blocks()+7)/8 {
+		return nil, invalid
+	}
+	ctl.bitmap = make([]byte, bitmapLen)
+	if _, err := io.ReadFull(r, ctl.bitmap); err != nil {
+		return nil, invalid
+	}
+	for _, c := range ctl.ranges(true) {
+		ctl.written.add(c.Begin, c.End)
+	}
+	return ctl, nil
+}
+
+// Internal: number of blocks of the file
+func (ctl *controlFile) blocks() int64 {
+	return (ctl.fileLength + ctl.blockSize - 1) / ctl.blockSize
+}
+
+// Internal: record that a range of the file was written, updating the
+// bits of the blocks it completes
+func (ctl *controlFile) markWritten(begin, end int64) error {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	merged := ctl.written.add(begin, end)
+
+	// Blocks entirely inside the merged range, the last one may be short
+	first := (merged.Begin + ctl.blockSize - 1) / ctl.blockSize
+	last := merged.End / ctl.blockSize
+	if merged.End == ctl.fileLength {
+		last = ctl.blocks()
+	}
+	lo, hi := int64(-1), int64(-1)
+	for b := first; b < last; b++ {
+		if ctl.bitmap[b/8]&(0x80>>(b%8)) == 0 {
+			ctl.bitmap[b/8] |= 0x80 >> (b % 8)
+			if lo < 0 {
+				lo = b / 8
+			}
+			hi = b / 8
+		}
+	}
+	if lo < 0 {
+		return nil
+	}
+	_, err := ctl.file.WriteAt(ctl.bitmap[lo:hi+1], ctl.bitmapOffset+lo)
+	return err
+}
+
+// Internal: the ranges of the file whose blocks are all written, or none is
+func (ctl *controlFile) ranges(written bool) []Chunk {
+	var chunks []Chunk
+	start := int64(-1)
+	for b := int64(0); b <= ctl.blocks(); b++ {
+		in := b < ctl.blocks() && (ctl.bitmap[b/8]&(0x80>>(b%8)) != 0) == written
+		if in && start < 0 {
+			start = b
+		} else if !in && start >= 0 {
+			chunks = append(chunks, Chunk{start * ctl.blockSize, min(b*ctl.blockSize, ctl.fileLength)})
+			start = -1
+		}
+	}
+	return chunks
+}
+
+// Internal: close the control file
+func (ctl *controlFile) close() error {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	return ctl.file.Close()
+}
+
+// Keep a control file next to the partial file, so that an interrupted
+// download resumes where it stopped when set up again. Disabled by default,
+// when SetupFile always starts over.
+func WithResume(enabled bool) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.resume = enabled
+	}
+}
+
+// Internal: pick up an interrupted download of the same file, setting the
+// chunks to what is missing. Returns false if there is nothing to resume.
+func (dldr *MultiDownloader) resumeDownload() bool {
+	if !dldr.resume || dldr.segments != nil {
+		return false
+	}
+	if dldr.noRanges {
+		dldr.logVerbose("The sources don't accept range requests, can't resume")
+		return false
+	}
+	if dldr.streams() {
+		dldr.logVerbose("The file is streamed, can't resume")
+		return false
+	}
+	if dldr.decodesOnTheFly() {
+		dldr.logVerbose("The file is decoded while downloading, can't resume")
+		return false
+	}
+	ctl, err := loadControl(controlPath(dldr.partFilename))
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(dldr.partFilename)
+	if err != nil || info.Size() != dldr.fileLength ||
+		ctl.fileLength != dldr.fileLength || ctl.validator != dldr.validator() {
+		dldr.logVerbose("Can't resume from ", dldr.partFilename, ", starting over")
+		ctl.close()
+		return false
+	}
+	if dldr.samples > 0 && !dldr.checkSamples(ctl) {
+		dldr.logWarning("The partial file differs from the sources, starting over")
+		ctl.close()
+		return false
+	}
+	missing := rangeSet{}
+	for _, c := range ctl.ranges(false) {
+		missing.add(c.Begin, c.End)
+	}
+	if dldr.checksPieces() {
+		bad, err := dldr.resumePieces(ctl)
+		if err != nil {
+			dldr.logVerbose("Can't check the pieces of ", dldr.partFilename, ", starting over: ", err)
+			ctl.close()
+			return false
+		}
+		for _, c := range bad {
+			missing.add(c.Begin, c.End)
+		}
+	}
+	dldr.control = ctl
+	dldr.ifRange = ctl.validator
+	dldr.chunks = balanceChunks(missing, dldr.nConns)
+	dldr.logVerbose("Resuming download, ", len(ctl.ranges(true)), " ranges already written")
+	return true
+}
+
+// Internal: the validator of the file, to make sure the ranges still come
+// from the same file. Its ETag if known and strong, or else its modification
+// date.
+func (dldr *MultiDownloader) validator() string {
+	if dldr.ETag != "" && !dldr.weakETag {
+		return `"` + dldr.ETag + `"`
+	}
+	return dldr.lastModified
+}
+
+// Internal: download the file from scratch after it changed on the server,
+// throwing away what was downloaded of the old one. The connections of the
+// old one must have stopped.
+func (dldr *MultiDownloader) restart(feedbackFunc func([]ConnectionProgress)) error {
+	// What was streamed can't be taken back
+	if dldr.streams() {
+		return fmt.Errorf("%w while streaming it", ErrRemoteChanged)
+	}
+	if dldr.control != nil {
+		dldr.control.close()
+		dldr.control = nil
+	}
+	dldr.ifRange = ""
+	os.Remove(controlPath(dldr.partFilename))
+	os.Remove(trustedPath(dldr.partFilename))
+	os.Remove(dldr.partFilename)
+
+	filename := dldr.filename
+	if _, err := dldr.GatherInfo(); err != nil {
+		return err
+	}
+	if _, err := dldr.SetupFile(filename); err != nil {
+		return err
+	}
+	return dldr.Download(feedbackFunc)
+}
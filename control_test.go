@@ -0,0 +1,284 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRangeSet(t *testing.T) {
+	var s rangeSet
+	s.add(10, 20)
+	s.add(30, 40)
+	if merged := s.add(20, 30); merged != (Chunk{10, 40}) || len(s) != 1 {
+		t.Errorf("Adjacent ranges should be merged, got %v", s)
+	}
+	s.add(0, 5)
+	s.add(50, 60)
+	if merged := s.add(3, 55); merged != (Chunk{0, 60}) || len(s) != 1 {
+		t.Errorf("Overlapping ranges should be merged, got %v", s)
+	}
+}
+
+func TestResumeFromControlFile(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	fileServer := http.FileServer(http.Dir("./test"))
+	cutLimit := 5*fileWriteChunk + 100
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w = &cuttingWriter{w, cutLimit}
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+	var served int64
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fileServer.ServeHTTP(&countingWriter{w, &served}, r)
+	}))
+	defer good.Close()
+	output := filepath.Join(t.TempDir(), "quijote.txt")
+
+	// Every connection drops, the download is interrupted
+	dldr := NewMultiDownloader(
+		[]string{flaky.URL + "/quijote.txt"}, 4, time.Duration(5000)*time.Millisecond, WithResume(true))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(output)
+	failOnError(t, err)
+	if err := dldr.Download(nil); err == nil {
+		t.Fatal("The download from the flaky server should fail")
+	}
+	time.Sleep(50 * time.Millisecond) // Let the dropped connections finish writing
+
+	ctl, err := loadControl(controlPath(dldr.partFilename))
+	failOnError(t, err)
+	written := ctl.ranges(true)
+	ctl.close()
+	if len(written) != 4 {
+		t.Fatalf("The control file should record what each connection wrote, got %v", written)
+	}
+	partial, err := ioutil.ReadFile(dldr.partFilename)
+	failOnError(t, err)
+	for _, c := range written {
+		if c.End-c.Begin < 4*fileWriteChunk || !bytes.Equal(partial[c.Begin:c.End], data[c.Begin:c.End]) {
+			t.Errorf("Range %v isn't correctly written", c)
+		}
+	}
+
+	// Setting it up again only downloads the rest
+	dldr = NewMultiDownloader(
+		[]string{good.URL + "/quijote.txt"}, 4, time.Duration(5000)*time.Millisecond, WithResume(true))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(output)
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	downloaded, err := ioutil.ReadFile(output)
+	failOnError(t, err)
+	if !bytes.Equal(data, downloaded) {
+		t.Error("The resumed download differs from the original")
+	}
+	if atomic.LoadInt64(&served) >= int64(len(data)) {
+		t.Errorf("Resuming downloaded %d bytes, the whole file is %d", served, len(data))
+	}
+	if _, err := os.Stat(controlPath(dldr.partFilename)); !os.IsNotExist(err) {
+		t.Error("The control file should be removed after a complete download")
+	}
+}
+
+// Without WithResume, SetupFile starts over even if a control file is there
+func TestResumeDisabled(t *testing.T) {
+	dir := t.TempDir()
+	partFilename := filepath.Join(dir, "file.bin") + tmpFileSuffix
+	failOnError(t, ioutil.WriteFile(partFilename, bytes.Repeat([]byte("x"), 10000), 0644))
+	ctl, err := createControl(controlPath(partFilename), 10000, "")
+	failOnError(t, err)
+	failOnError(t, ctl.markWritten(0, 10000))
+	ctl.close()
+
+	dldr := NewMultiDownloader(nil, 2, time.Second)
+	dldr.fileLength = 10000
+	dldr.buildChunks()
+	_, err = dldr.SetupFile(filepath.Join(dir, "file.bin"))
+	failOnError(t, err)
+	if len(dldr.chunks) != 2 || dldr.control != nil {
+		t.Error("Downloads shouldn't be resumed by default")
+	}
+	partial, err := ioutil.ReadFile(partFilename)
+	failOnError(t, err)
+	if !bytes.Equal(partial, make([]byte, 10000)) {
+		t.Error("The partial file should be created again")
+	}
+}
+
+// A file changed since the interrupted download is downloaded again
+func TestResumeRemoteChanged(t *testing.T) {
+	old := bytes.Repeat([]byte("old "), 5000)
+	changed := bytes.Repeat([]byte("new!"), 6000)
+	var version int32
+	var ranged int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, etag := old, `"v1"`
+		if atomic.LoadInt32(&version) > 0 {
+			content, etag = changed, `"v2"`
+		}
+		if r.Method == "GET" && r.Header.Get("If-Range") == `"v1"` {
+			atomic.AddInt32(&ranged, 1)
+		}
+		w.Header().Set("Etag", etag)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	output := filepath.Join(t.TempDir(), "file.bin")
+
+	// Half of the old file was downloaded
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/file.bin"}, 2, time.Duration(5000)*time.Millisecond, WithResume(true))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(output)
+	failOnError(t, err)
+	ctl, err := createControl(controlPath(dldr.partFilename), int64(len(old)), dldr.validator())
+	failOnError(t, err)
+	half := int64(len(old) / 2)
+	failOnError(t, ioutil.WriteFile(dldr.partFilename, append(old[:half], make([]byte, len(old)-int(half))...), 0644))
+	failOnError(t, ctl.markWritten(0, half))
+	ctl.close()
+
+	// It changes right after checking it, the resumed ranges must not mix both
+	dldr = NewMultiDownloader(
+		[]string{server.URL + "/file.bin"}, 2, time.Duration(5000)*time.Millisecond, WithResume(true))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(output)
+	failOnError(t, err)
+	if dldr.control == nil {
+		t.Fatal("The download should be resumed")
+	}
+	atomic.StoreInt32(&version, 1)
+	failOnError(t, dldr.Download(nil))
+
+	if atomic.LoadInt32(&ranged) == 0 {
+		t.Error("Resumed ranges should be requested with If-Range")
+	}
+	downloaded, err := ioutil.ReadFile(output)
+	failOnError(t, err)
+	if !bytes.Equal(downloaded, changed) {
+		t.Error("The changed file should be downloaded from scratch")
+	}
+	if _, err := os.Stat(controlPath(dldr.partFilename)); !os.IsNotExist(err) {
+		t.Error("The control file should be removed after a complete download")
+	}
+}
+
+// The connections still downloading the old file are stopped before starting
+// over
+func TestRestartStopsConnections(t *testing.T) {
+	old := bytes.Repeat([]byte("old "), 5000)
+	changed := bytes.Repeat([]byte("new!"), 6000)
+	var version, resumed, stopped, early int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&version) == 0 {
+			w.Header().Set("Etag", `"v1"`)
+			http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(old))
+			return
+		}
+		if r.Header.Get("If-Range") == "" {
+			// The server takes a moment to notice a closed connection
+			for i := 0; i < 100 && atomic.LoadInt32(&stopped) == 0; i++ {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if atomic.LoadInt32(&stopped) == 0 {
+				atomic.AddInt32(&early, 1)
+			}
+		} else if atomic.AddInt32(&resumed, 1) == 1 {
+			// Still downloading the old file when the other one sees it changed
+			select {
+			case <-r.Context().Done():
+				atomic.StoreInt32(&stopped, 1)
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		w.Header().Set("Etag", `"v2"`)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(changed))
+	}))
+	defer server.Close()
+	output := filepath.Join(t.TempDir(), "file.bin")
+
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/file.bin"}, 2, time.Duration(5000)*time.Millisecond, WithResume(true))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(output)
+	failOnError(t, err)
+	ctl, err := createControl(controlPath(dldr.partFilename), int64(len(old)), dldr.validator())
+	failOnError(t, err)
+	failOnError(t, ctl.markWritten(0, 100))
+	ctl.close()
+
+	dldr = NewMultiDownloader(
+		[]string{server.URL + "/file.bin"}, 2, time.Duration(5000)*time.Millisecond, WithResume(true))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(output)
+	failOnError(t, err)
+	atomic.StoreInt32(&version, 1)
+	failOnError(t, dldr.Download(nil))
+
+	if n := atomic.LoadInt32(&early); n > 0 {
+		t.Errorf("%d requests started over before the old connections stopped", n)
+	}
+	downloaded, err := ioutil.ReadFile(output)
+	failOnError(t, err)
+	if !bytes.Equal(downloaded, changed) {
+		t.Error("The changed file should be downloaded from scratch")
+	}
+}
+
+// Resuming a partial file damaged meanwhile starts over with WithResumeSampling
+func TestResumeSampling(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+	output := filepath.Join(t.TempDir(), "quijote.txt")
+
+	for _, damaged := range []bool{false, true} {
+		// Half of the file was downloaded
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/quijote.txt"}, 2, time.Duration(5000)*time.Millisecond,
+			WithResume(true), WithResumeSampling(3))
+		_, err = dldr.GatherInfo()
+		failOnError(t, err)
+		half := int64(len(data) / 2)
+		partial := append(append([]byte(nil), data[:half]...), make([]byte, len(data)-int(half))...)
+		if damaged {
+			partial = append(bytes.ToUpper(data[:half]), partial[half:]...)
+		}
+		failOnError(t, ioutil.WriteFile(output+tmpFileSuffix, partial, 0644))
+		ctl, err := createControl(controlPath(output+tmpFileSuffix), dldr.fileLength, dldr.validator())
+		failOnError(t, err)
+		failOnError(t, ctl.markWritten(0, half))
+		ctl.close()
+
+		_, err = dldr.SetupFile(output)
+		failOnError(t, err)
+		if resumed := dldr.control != nil; resumed == damaged {
+			t.Errorf("Damaged %v partial file resumed: %v", damaged, resumed)
+		}
+		failOnError(t, dldr.Download(nil))
+		downloaded, err := ioutil.ReadFile(output)
+		failOnError(t, err)
+		if !bytes.Equal(downloaded, data) {
+			t.Errorf("The download differs from the original, damaged %v", damaged)
+		}
+	}
+}
@@ -0,0 +1,482 @@
+package multipartdownloader
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MPEG-DASH support. As with HLS, the manifest is expanded into the list of
+// segments of one representation, which become the chunks of the output.
+// Only static (on demand) manifests are supported.
+
+// A parsed MPD manifest
+type DASHManifest struct {
+	Periods []DASHPeriod
+}
+
+// A period of the presentation, with the representations it offers
+type DASHPeriod struct {
+	Representations []DASHRepresentation
+}
+
+// A representation of a media stream, with its segments
+type DASHRepresentation struct {
+	ID        string
+	Bandwidth int64
+	MimeType  string
+	Codecs    string
+	Width     int
+	Height    int
+	Segments  []MediaSegment
+}
+
+// MPD elements, only what is needed to list the segments
+type mpdXML struct {
+	Type     string      `xml:"type,attr"`
+	Duration string      `xml:"mediaPresentationDuration,attr"`
+	BaseURL  string      `xml:"BaseURL"`
+	Periods  []mpdPeriod `xml:"Period"`
+}
+
+type mpdPeriod struct {
+	Duration        string              `xml:"duration,attr"`
+	BaseURL         string              `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *mpdSegmentList     `xml:"SegmentList"`
+	AdaptationSets  []mpdAdaptationSet  `xml:"AdaptationSet"`
+}
+
+type mpdAdaptationSet struct {
+	MimeType        string              `xml:"mimeType,attr"`
+	ContentType     string              `xml:"contentType,attr"`
+	Codecs          string              `xml:"codecs,attr"`
+	BaseURL         string              `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *mpdSegmentList     `xml:"SegmentList"`
+	Representations []mpdRepresentation `xml:"Representation"`
+}
+
+type mpdRepresentation struct {
+	ID              string              `xml:"id,attr"`
+	Bandwidth       int64               `xml:"bandwidth,attr"`
+	MimeType        string              `xml:"mimeType,attr"`
+	Codecs          string              `xml:"codecs,attr"`
+	Width           int                 `xml:"width,attr"`
+	Height          int                 `xml:"height,attr"`
+	BaseURL         string              `xml:"BaseURL"`
+	SegmentTemplate *mpdSegmentTemplate `xml:"SegmentTemplate"`
+	SegmentList     *mpdSegmentList     `xml:"SegmentList"`
+}
+
+type mpdSegmentTemplate struct {
+	Media          string       `xml:"media,attr"`
+	Initialization string       `xml:"initialization,attr"`
+	StartNumber    *int64       `xml:"startNumber,attr"`
+	Duration       int64        `xml:"duration,attr"`
+	Timescale      int64        `xml:"timescale,attr"`
+	Timeline       *mpdTimeline `xml:"SegmentTimeline"`
+}
+
+type mpdTimeline struct {
+	S []struct {
+		T *int64 `xml:"t,attr"`
+		D int64  `xml:"d,attr"`
+		R int64  `xml:"r,attr"`
+	} `xml:"S"`
+}
+
+type mpdSegmentList struct {
+	Initialization *struct {
+		SourceURL string `xml:"sourceURL,attr"`
+		Range     string `xml:"range,attr"`
+	} `xml:"Initialization"`
+	SegmentURLs []struct {
+		Media      string `xml:"media,attr"`
+		MediaRange string `xml:"mediaRange,attr"`
+	} `xml:"SegmentURL"`
+}
+
+// Parse an MPD manifest, expanding the segments of every representation.
+// Relative URLs are resolved against base.
+func ParseMPD(r io.Reader, base *url.URL) (*DASHManifest, error) {
+	var mpd mpdXML
+	if err := xml.NewDecoder(r).Decode(&mpd); err != nil {
+		return nil, err
+	}
+	if mpd.Type == "dynamic" {
+		return nil, errors.New("Live DASH streams are not supported")
+	}
+	base, err := resolveBaseURL(base, mpd.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := &DASHManifest{}
+	for _, period := range mpd.Periods {
+		durationSpec := period.Duration
+		if durationSpec == "" && len(mpd.Periods) == 1 {
+			durationSpec = mpd.Duration
+		}
+		duration := -1.0
+		if durationSpec != "" {
+			if duration, err = parseISODuration(durationSpec); err != nil {
+				return nil, err
+			}
+		}
+		periodBase, err := resolveBaseURL(base, period.BaseURL)
+		if err != nil {
+			return nil, err
+		}
+
+		var reps []DASHRepresentation
+		for _, set := range period.AdaptationSets {
+			setBase, err := resolveBaseURL(periodBase, set.BaseURL)
+			if err != nil {
+				return nil, err
+			}
+			for _, rep := range set.Representations {
+				repBase, err := resolveBaseURL(setBase, rep.BaseURL)
+				if err != nil {
+					return nil, err
+				}
+				r := DASHRepresentation{
+					ID:        rep.ID,
+					Bandwidth: rep.Bandwidth,
+					MimeType:  firstNonEmpty(rep.MimeType, set.MimeType),
+					Codecs:    firstNonEmpty(rep.Codecs, set.Codecs),
+					Width:     rep.Width,
+					Height:    rep.Height,
+				}
+				if r.MimeType == "" && set.ContentType != "" {
+					r.MimeType = set.ContentType + "/"
+				}
+				template := mergeTemplates(rep.SegmentTemplate, set.SegmentTemplate, period.SegmentTemplate)
+				list := rep.SegmentList
+				if list == nil {
+					list = set.SegmentList
+				}
+				if list == nil {
+					list = period.SegmentList
+				}
+				switch {
+				case template != nil:
+					r.Segments, err = expandTemplate(template, &rep, repBase, duration)
+				case list != nil:
+					r.Segments, err = expandList(list, repBase)
+				default:
+					// SegmentBase or nothing at all: a single file
+					r.Segments = []MediaSegment{{URL: repBase.String(), Offset: -1, Length: -1}}
+				}
+				if err != nil {
+					return nil, err
+				}
+				reps = append(reps, r)
+			}
+		}
+		manifest.Periods = append(manifest.Periods, DASHPeriod{reps})
+	}
+	return manifest, nil
+}
+
+// Resolve a DASH manifest into the segments of one representation. The first
+// URL must point to the MPD. The representation is chosen by its ID or, if
+// representationID is empty, as the video representation with the highest
+// bandwidth. This replaces GatherInfo for streams.
+func (dldr *MultiDownloader) GatherDASHInfo(representationID string) (chunks []Chunk, err error) {
+	if len(dldr.urls) == 0 {
+		return nil, errors.New("No URLs provided")
+	}
+	var manifest *DASHManifest
+	err = dldr.fetchManifest(dldr.urls[0], func(r io.Reader, base *url.URL) error {
+		manifest, err = ParseMPD(r, base)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []MediaSegment
+	for i, period := range manifest.Periods {
+		rep, ok := selectRepresentation(period.Representations, representationID)
+		if !ok {
+			return nil, errors.New(
+				fmt.Sprintf("No representation %q in period %d", representationID, i+1))
+		}
+		dldr.logVerbose("Selected representation: ", rep.ID, " (", rep.Bandwidth, " bps)")
+		segments = append(segments, rep.Segments...)
+	}
+	if len(segments) == 0 {
+		return nil, errors.New("The manifest has no segments")
+	}
+
+	if err := dldr.setupSegments(segments); err != nil {
+		return nil, err
+	}
+	dldr.filename, err = dldr.resolveFilename(SourceInfo{
+		URL:         dldr.urls[0],
+		FileLength:  dldr.fileLength,
+		DefaultName: streamFilename(dldr.urls[0], segments),
+	})
+	if err != nil {
+		return nil, err
+	}
+	dldr.setResolvedOutput(dldr.filename)
+
+	dldr.logVerbose("Segments: ", len(segments))
+	dldr.logVerbose("File length: ", dldr.fileLength, " bytes")
+	dldr.logVerbose("File name: ", dldr.filename)
+	return dldr.chunks, nil
+}
+
+// Internal: pick a representation by ID, or the best video one
+func selectRepresentation(reps []DASHRepresentation, id string) (DASHRepresentation, bool) {
+	var best *DASHRepresentation
+	for i := range reps {
+		r := &reps[i]
+		if id != "" {
+			if r.ID == id {
+				return *r, true
+			}
+			continue
+		}
+		if best == nil {
+			best = r
+			continue
+		}
+		isVideo := strings.HasPrefix(r.MimeType, "video/")
+		bestIsVideo := strings.HasPrefix(best.MimeType, "video/")
+		if isVideo && !bestIsVideo || isVideo == bestIsVideo && r.Bandwidth > best.Bandwidth {
+			best = r
+		}
+	}
+	if best == nil {
+		return DASHRepresentation{}, false
+	}
+	return *best, true
+}
+
+// Internal: combine a segment template with the ones it inherits from
+func mergeTemplates(templates ...*mpdSegmentTemplate) *mpdSegmentTemplate {
+	var merged *mpdSegmentTemplate
+	for _, t := range templates {
+		if t == nil {
+			continue
+		}
+		if merged == nil {
+			copied := *t
+			merged = &copied
+			continue
+		}
+		if merged.Media == "" {
+			merged.Media = t.Media
+		}
+		if merged.Initialization == "" {
+			merged.Initialization = t.Initialization
+		}
+		if merged.StartNumber == nil {
+			merged.StartNumber = t.StartNumber
+		}
+		if merged.Duration == 0 {
+			merged.Duration = t.Duration
+		}
+		if merged.Timescale == 0 {
+			merged.Timescale = t.Timescale
+		}
+		if merged.Timeline == nil {
+			merged.Timeline = t.Timeline
+		}
+	}
+	return merged
+}
+
+// Internal: list the segments described by a SegmentTemplate
+func expandTemplate(
+	t *mpdSegmentTemplate,
+	rep *mpdRepresentation,
+	base *url.URL,
+	periodDuration float64) ([]MediaSegment, error) {
+	timescale := t.Timescale
+	if timescale == 0 {
+		timescale = 1
+	}
+	number := int64(1)
+	if t.StartNumber != nil {
+		number = *t.StartNumber
+	}
+	var segments []MediaSegment
+	add := func(tmpl string, number, time, duration int64, init bool) error {
+		u, err := resolveBaseURL(base, fillTemplate(tmpl, rep, number, time))
+		if err != nil {
+			return err
+		}
+		segments = append(segments, MediaSegment{
+			URL:      u.String(),
+			Duration: float64(duration) / float64(timescale),
+			Offset:   -1,
+			Length:   -1,
+			Init:     init,
+		})
+		return nil
+	}
+	if t.Initialization != "" {
+		if err := add(t.Initialization, 0, 0, 0, true); err != nil {
+			return nil, err
+		}
+	}
+	if t.Media == "" {
+		return segments, nil
+	}
+
+	if t.Timeline != nil {
+		time := int64(0)
+		periodEnd := int64(math.Ceil(periodDuration * float64(timescale)))
+		for i, s := range t.Timeline.S {
+			if s.T != nil {
+				time = *s.T
+			}
+			if s.D <= 0 {
+				return nil, errors.New("Invalid segment duration in SegmentTimeline")
+			}
+			repeat := s.R
+			if repeat < 0 {
+				// Repeat until the next S, or the end of the period
+				end := periodEnd
+				if i+1 < len(t.Timeline.S) && t.Timeline.S[i+1].T != nil {
+					end = *t.Timeline.S[i+1].T
+				}
+				if end <= 0 {
+					return nil, errors.New("Open-ended SegmentTimeline without period duration")
+				}
+				repeat = (end-time+s.D-1)/s.D - 1
+			}
+			for r := int64(0); r <= repeat; r++ {
+				if err := add(t.Media, number, time, s.D, false); err != nil {
+					return nil, err
+				}
+				number++
+				time += s.D
+			}
+		}
+		return segments, nil
+	}
+
+	if t.Duration <= 0 || periodDuration < 0 {
+		return nil, errors.New("Can't tell the number of segments of the SegmentTemplate")
+	}
+	count := int64(math.Ceil(periodDuration * float64(timescale) / float64(t.Duration)))
+	for i := int64(0); i < count; i++ {
+		if err := add(t.Media, number+i, i*t.Duration, t.Duration, false); err != nil {
+			return nil, err
+		}
+	}
+	return segments, nil
+}
+
+// Internal: list the segments of a SegmentList
+func expandList(list *mpdSegmentList, base *url.URL) ([]MediaSegment, error) {
+	var segments []MediaSegment
+	add := func(uri, byteRange string, init bool) error {
+		u, err := resolveBaseURL(base, uri)
+		if err != nil {
+			return err
+		}
+		seg := MediaSegment{URL: u.String(), Offset: -1, Length: -1, Init: init}
+		if byteRange != "" {
+			var first, last int64
+			if _, err := fmt.Sscanf(byteRange, "%d-%d", &first, &last); err != nil || last < first {
+				return errors.New(fmt.Sprintf("Invalid byte range %q", byteRange))
+			}
+			seg.Offset, seg.Length = first, last-first+1
+		}
+		segments = append(segments, seg)
+		return nil
+	}
+	if list.Initialization != nil {
+		if err := add(list.Initialization.SourceURL, list.Initialization.Range, true); err != nil {
+			return nil, err
+		}
+	}
+	for _, s := range list.SegmentURLs {
+		if err := add(s.Media, s.MediaRange, false); err != nil {
+			return nil, err
+		}
+	}
+	return segments, nil
+}
+
+var templateIdentifier = regexp.MustCompile(`\$(RepresentationID|Number|Bandwidth|Time)(%0(\d+)d)?\$`)
+
+// Internal: substitute the identifiers of a URL template
+func fillTemplate(tmpl string, rep *mpdRepresentation, number, time int64) string {
+	filled := templateIdentifier.ReplaceAllStringFunc(tmpl, func(id string) string {
+		m := templateIdentifier.FindStringSubmatch(id)
+		var value int64
+		switch m[1] {
+		case "RepresentationID":
+			return rep.ID
+		case "Number":
+			value = number
+		case "Bandwidth":
+			value = rep.Bandwidth
+		case "Time":
+			value = time
+		}
+		if m[3] != "" {
+			width, _ := strconv.Atoi(m[3])
+			return fmt.Sprintf("%0*d", width, value)
+		}
+		return strconv.FormatInt(value, 10)
+	})
+	return strings.Replace(filled, "$$", "$", -1)
+}
+
+// Internal: resolve a BaseURL element relative to the enclosing one
+func resolveBaseURL(base *url.URL, ref string) (*url.URL, error) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return base, nil
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	return base.ResolveReference(u), nil
+}
+
+var isoDuration = regexp.MustCompile(
+	`^P(?:(\d+(?:\.\d+)?)Y)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)D)?` +
+		`(?:T(?:(\d+(?:\.\d+)?)H)?(?:(\d+(?:\.\d+)?)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// Internal: parse an ISO 8601 duration (PT1H2M3.5S) into seconds
+func parseISODuration(s string) (float64, error) {
+	m := isoDuration.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, errors.New(fmt.Sprintf("Invalid duration %q", s))
+	}
+	units := []float64{365 * 86400, 30 * 86400, 86400, 3600, 60, 1}
+	seconds := 0.0
+	for i, unit := range units {
+		if m[i+1] != "" {
+			v, _ := strconv.ParseFloat(m[i+1], 64)
+			seconds += v * unit
+		}
+	}
+	return seconds, nil
+}
+
+// Internal: first of the strings that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
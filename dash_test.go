@@ -0,0 +1,157 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMPD(t *testing.T) {
+	mpd := `<?xml version="1.0"?>
+<MPD xmlns="urn:mpeg:dash:schema:mpd:2011" type="static" mediaPresentationDuration="PT9.5S">
+  <BaseURL>media/</BaseURL>
+  <Period>
+    <AdaptationSet mimeType="video/mp4">
+      <SegmentTemplate media="$RepresentationID$/seg-$Number%03d$.m4s" initialization="$RepresentationID$/init.mp4" duration="4" startNumber="0"/>
+      <Representation id="v1" bandwidth="500000" width="640" height="360"/>
+      <Representation id="v2" bandwidth="900000" width="1280" height="720">
+        <SegmentTemplate timescale="10" media="$RepresentationID$/t$Time$.m4s">
+          <SegmentTimeline><S t="0" d="40" r="1"/><S d="15"/></SegmentTimeline>
+        </SegmentTemplate>
+      </Representation>
+    </AdaptationSet>
+    <AdaptationSet mimeType="audio/mp4">
+      <Representation id="a1" bandwidth="2000000">
+        <BaseURL>audio.mp4</BaseURL>
+        <SegmentList>
+          <Initialization sourceURL="audio.mp4" range="0-99"/>
+          <SegmentURL mediaRange="100-599"/>
+          <SegmentURL mediaRange="600-899"/>
+        </SegmentList>
+      </Representation>
+    </AdaptationSet>
+  </Period>
+</MPD>`
+	base, _ := url.Parse("http://example.com/stream/manifest.mpd")
+	manifest, err := ParseMPD(strings.NewReader(mpd), base)
+	failOnError(t, err)
+	if len(manifest.Periods) != 1 || len(manifest.Periods[0].Representations) != 3 {
+		t.Fatalf("Wrong manifest: %+v", manifest)
+	}
+	reps := manifest.Periods[0].Representations
+	expected := [][]MediaSegment{
+		{
+			{"http://example.com/stream/media/v1/init.mp4", 0, -1, -1, true},
+			{"http://example.com/stream/media/v1/seg-000.m4s", 4, -1, -1, false},
+			{"http://example.com/stream/media/v1/seg-001.m4s", 4, -1, -1, false},
+			{"http://example.com/stream/media/v1/seg-002.m4s", 4, -1, -1, false},
+		},
+		{
+			{"http://example.com/stream/media/v2/init.mp4", 0, -1, -1, true},
+			{"http://example.com/stream/media/v2/t0.m4s", 4, -1, -1, false},
+			{"http://example.com/stream/media/v2/t40.m4s", 4, -1, -1, false},
+			{"http://example.com/stream/media/v2/t80.m4s", 1.5, -1, -1, false},
+		},
+		{
+			{"http://example.com/stream/media/audio.mp4", 0, 0, 100, true},
+			{"http://example.com/stream/media/audio.mp4", 0, 100, 500, false},
+			{"http://example.com/stream/media/audio.mp4", 0, 600, 300, false},
+		},
+	}
+	for r := range expected {
+		if len(reps[r].Segments) != len(expected[r]) {
+			t.Errorf("Representation %s has segments %+v", reps[r].ID, reps[r].Segments)
+			continue
+		}
+		for i := range expected[r] {
+			if reps[r].Segments[i] != expected[r][i] {
+				t.Errorf("Segment %d of %s is %+v, should be %+v",
+					i, reps[r].ID, reps[r].Segments[i], expected[r][i])
+			}
+		}
+	}
+	if best, _ := selectRepresentation(reps, ""); best.ID != "v2" {
+		t.Errorf("Selected %s, should prefer the best video representation", best.ID)
+	}
+
+	live := `<MPD type="dynamic"><Period/></MPD>`
+	if _, err := ParseMPD(strings.NewReader(live), base); err == nil {
+		t.Error("Live manifests should be rejected")
+	}
+}
+
+func TestParseISODuration(t *testing.T) {
+	testTable := []struct {
+		duration string
+		seconds  float64
+	}{
+		{"PT0S", 0},
+		{"PT9.5S", 9.5},
+		{"PT1H2M3S", 3723},
+		{"P1DT1S", 86401},
+	}
+	for _, test := range testTable {
+		seconds, err := parseISODuration(test.duration)
+		failOnError(t, err)
+		if seconds != test.seconds {
+			t.Errorf("%s parsed as %v seconds, should be %v", test.duration, seconds, test.seconds)
+		}
+	}
+	if _, err := parseISODuration("1H"); err == nil {
+		t.Error("Invalid durations should be rejected")
+	}
+}
+
+func TestDASHDownload(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	nSegments := 5
+	segLength := (len(data) + nSegments - 1) / nSegments
+	mux := http.NewServeMux()
+	for i := 0; i < nSegments; i++ {
+		end := (i + 1) * segLength
+		if end > len(data) {
+			end = len(data)
+		}
+		seg := data[i*segLength : end]
+		mux.HandleFunc(fmt.Sprintf("/dash/hi/%d.m4s", i+1), func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "seg.m4s", time.Time{}, bytes.NewReader(seg))
+		})
+	}
+	mux.HandleFunc("/dash/manifest.mpd", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/dash+xml")
+		fmt.Fprintf(w, `<MPD type="static" mediaPresentationDuration="PT%dS"><Period>
+<AdaptationSet mimeType="video/mp4"><SegmentTemplate media="$RepresentationID$/$Number$.m4s" duration="2"/>
+<Representation id="lo" bandwidth="1000"/><Representation id="hi" bandwidth="5000"/>
+</AdaptationSet></Period></MPD>`, 2*nSegments)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/dash/manifest.mpd"}, 3, time.Duration(5000)*time.Millisecond)
+	if _, err := dldr.GatherDASHInfo("missing"); err == nil {
+		t.Error("Unknown representations should be reported")
+	}
+	_, err = dldr.GatherDASHInfo("")
+	failOnError(t, err)
+	if len(dldr.chunks) != nSegments || dldr.fileLength != int64(len(data)) {
+		t.Fatalf("Wrong segments: %v", dldr.chunks)
+	}
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), dldr.filename))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+
+	downloaded, err := ioutil.ReadFile(dldr.filename)
+	failOnError(t, err)
+	if !bytes.Equal(data, downloaded) {
+		t.Error("Stream downloaded from the DASH manifest differs from the original")
+	}
+}
@@ -0,0 +1,70 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+)
+
+// Disk space preflight. Before creating the partial file, SetupFile checks
+// that the file system has room for the whole file, plus a safety margin,
+// rather than failing with a full disk in the middle of the download. When
+// the partial file is in another directory than the output file, the file
+// system of the output file is checked as well, as the file may be copied
+// there once complete.
+
+// Matches the error returned when the file doesn't fit, with errors.Is
+var ErrInsufficientSpace = errors.New("Insufficient disk space")
+
+// Returned by freeSpace where the platform can't tell
+var errSpaceUnknown = errors.New("Free disk space unknown")
+
+// The file doesn't fit in the file system of a directory
+type InsufficientSpaceError struct {
+	Dir       string
+	Needed    int64 // Bytes needed, including the margin
+	Available int64 // Bytes available to the user
+}
+
+func (e *InsufficientSpaceError) Error() string {
+	return fmt.Sprintf("%v in %s: %d bytes needed, %d available", ErrInsufficientSpace, e.Dir, e.Needed, e.Available)
+}
+
+func (e *InsufficientSpaceError) Is(target error) bool {
+	return target == ErrInsufficientSpace
+}
+
+// Require this many bytes free on top of the size of the file. None by default.
+func WithSpaceMargin(bytes int64) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.spaceMargin = bytes
+	}
+}
+
+// Internal: check that the file fits where it's written, skipping file
+// systems whose free space is unknown
+func (dldr *MultiDownloader) checkSpace() error {
+	if dldr.fileLength <= 0 {
+		return nil
+	}
+	needed := dldr.fileLength + dldr.spaceMargin
+	dirs := []string{filepath.Dir(dldr.partFilename)}
+	if dir := filepath.Dir(dldr.filename); dir != dirs[0] {
+		dirs = append(dirs, dir)
+	}
+	for _, dir := range dirs {
+		available, err := freeSpace(dir)
+		if errors.Is(err, errSpaceUnknown) {
+			dldr.logVerbose(err, " in ", dir)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if available < needed {
+			return withHint(&InsufficientSpaceError{dir, needed, available},
+				"Free some space, or save the file somewhere else")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package multipartdownloader
+
+// Internal: the free space isn't known on this platform
+func freeSpace(dir string) (int64, error) {
+	return 0, errSpaceUnknown
+}
@@ -0,0 +1,41 @@
+//go:build linux || darwin || freebsd || windows
+
+package multipartdownloader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestInsufficientSpace(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+
+	for _, margin := range []int64{0, 1 << 60} {
+		output := filepath.Join(t.TempDir(), "quijote.txt")
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/quijote.txt"}, 2, 5*time.Second, WithSpaceMargin(margin))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(output)
+		if margin == 0 {
+			failOnError(t, err)
+			continue
+		}
+		var spaceErr *InsufficientSpaceError
+		if !errors.Is(err, ErrInsufficientSpace) || !errors.As(err, &spaceErr) {
+			t.Fatalf("The file shouldn't fit with a margin of %d, got %v", margin, err)
+		}
+		if spaceErr.Needed != dldr.fileLength+margin {
+			t.Errorf("%d bytes should be needed, not %d", dldr.fileLength+margin, spaceErr.Needed)
+		}
+		if _, err := os.Stat(dldr.partFilename); !os.IsNotExist(err) {
+			t.Errorf("The partial file shouldn't be created")
+		}
+	}
+}
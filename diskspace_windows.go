@@ -0,0 +1,16 @@
+package multipartdownloader
+
+import "golang.org/x/sys/windows"
+
+// Internal: bytes available to the user in the volume of the directory
+func freeSpace(dir string) (int64, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var available, total, free uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &available, &total, &free); err != nil {
+		return 0, err
+	}
+	return int64(available), nil
+}
@@ -1,33 +1,79 @@
 package multipartdownloader
 
 import (
+	"context"
 	"crypto/md5"
 	"crypto/sha256"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	tmpFileSuffix  = ".part"
-	fileWriteChunk = 1 << 12
-	fileReadChunk  = 1 << 12
+	fileWriteChunk = 1 << 12 // Blocks of the control file
 )
 
+// Returned by SetupFile when the output file already has the expected digest
+var ErrAlreadyComplete = errors.New("The file is already downloaded")
+
+// Returned (wrapped) when the partial file can't be written, which stops the
+// download
+var errWrite = errors.New("Error writing the file")
+
+// Internal: stops the copy of a response at the end of its chunk, once the
+// chunk is cut short
+var errChunkEnd = errors.New("End of the chunk")
+
 // Info gathered from different sources
 type urlInfo struct {
 	url         string
 	fileLength  int64
 	etag        string
+	lastMod     string // Last-Modified of the file, if any
 	connSuccess bool
 	statusCode  int
+	header      http.Header
+	encoding    string // Content-Encoding of the file, empty for identity
+	final       string // URL answering after redirects
+	noRanges    bool   // Doesn't advertise Accept-Ranges: bytes
+}
+
+// The result of probing a source, reported as soon as it's known
+type SourceResult struct {
+	URL        string
+	FileLength int64
+	ETag       string
+	StatusCode int   // Zero if the connection failed
+	Ranges     bool  // Whether it advertises range requests (Accept-Ranges: bytes)
+	Err        error // Why the source can't be used, nil if it can
+}
+
+// Internal: the result of the probe reported to the caller
+func (info urlInfo) result(err error) SourceResult {
+	return SourceResult{
+		URL:        info.url,
+		FileLength: info.fileLength,
+		ETag:       info.etag,
+		StatusCode: info.statusCode,
+		Ranges:     info.connSuccess && !info.noRanges,
+		Err:        err,
+	}
 }
 
 // Chunk boundaries
@@ -42,28 +88,159 @@ type ConnectionProgress struct {
 	Begin   int64
 	End     int64
 	Current int64
+	Speed   float64       // Bytes per second, moving average
+	ETA     time.Duration // Time left to reach End at that speed, 0 if unknown
 }
 
 // The file downloader
 type MultiDownloader struct {
 	urls         []string      // List of all sources for the file
 	nConns       int           // Number of max concurrent connections to use
-	timeout      time.Duration // Timeout for all connections
+	timeout      time.Duration // Timeout of the probes and other small requests
 	fileLength   int64         // Size of the file. It could be larger than 4GB.
 	filename     string        // Output filename
 	partFilename string        // Incomplete output filename
 	ETag         string        // ETag (if available) of the file
+	lastModified string        // Last-Modified date of the file, if all sources agree
 	chunks       []Chunk       // A table of the chunks the file is divided into
+	signature    *Signature    // Expected magic bytes of the file, if any
+	segments     []segment     // Sources of each chunk, for segmented streams
+	resolver     FilenameResolver
+	header       http.Header  // Extra headers sent with every request
+	sha256       string       // Expected SHA-256 of the file, checked before renaming it
+	sha1         string       // Expected SHA-1 of the file, checked before renaming it
+	sha512       string       // Expected SHA-512 of the file, checked before renaming it
+	crc32        string       // Expected CRC-32 of the file, checked before renaming it
+	crc32c       string       // Expected CRC-32C of the file, checked before renaming it
+	blake2b      string       // Expected BLAKE2b-512 of the file, checked before renaming it
+	blake3       string       // Expected BLAKE3 of the file, checked before renaming it
+	xxhash       string       // Expected XXH64 of the file, checked before renaming it
+	md5          string       // Expected MD5 of the file, checked before renaming it
+	checksumURL  string       // Checksum file with the digest of the file, if any
+	integrity    string       // Integrity string of the file (sha256-<base64>...), if any
+	hashes       []customHash // Digests of the file computed with hashes of the caller
+	headerSums   bool         // The digests were taken from the headers of the sources
+	pgpURL       string       // Detached OpenPGP signature of the file, guessed when empty
+	pgpKeyring   []byte       // Keys the file must be signed with, if any
+	pgpSignature []byte       // Detached OpenPGP signature fetched by GatherInfo
+	pieces       *PieceHashes // Expected digests of the pieces of the file, if any
+	pieceState   *pieceState  // Pieces checked so far
+	encoding     string       // Content-Encoding served by the sources, empty for identity
+	quota        *Quota       // Transfer quota, if any
+	limiter      *rateLimiter // Bandwidth limit of all the connections
+	connRate     int64        // Bytes per second of each connection, 0 for no limit
+	resume       bool         // Keep a control file to resume interrupted downloads
+	control      *controlFile
+	schedule     *RateSchedule
+	ifRange      string // Validator sent with If-Range when resuming, if any
+	tenant       *Tenant
+	samples      int // Samples of the written ranges checked when resuming
+	chunkSize    int64
+	maxChunk     int64
+	noSplitting  bool
+	queued       *queueJob       // Priority in a queue, if any
+	minConns     int             // Fewest connections with WithAdaptiveConcurrency
+	maxConns     int             // Most connections with WithAdaptiveConcurrency, 0 if fixed
+	autoTune     bool            // Choose the connections and chunk size by probing
+	ctx          context.Context // Canceling all the requests, if any
+	spanCtx      context.Context // Context of the span of the download running, if any
+	logger       *slog.Logger    // Logger of the downloader, if not the default
+	singleBelow  int64           // Size below which files are downloaded with a single request
+	single       bool            // Downloading with a single request
+	noRanges     bool            // No source accepts range requests
+	mirrors      *mirrorSet      // Connections to each mirror
+	benchmark    bool            // Measure the mirrors before downloading
+	retry        Retrier         // Retries of the chunks after all the sources failed
+	lowSpeed     int64           // Bytes per second below which requests are dropped
+	lowSpeedTime time.Duration   // Time below the low speed limit before dropping a request
+	idleTimeout  time.Duration   // Time without receiving anything before dropping a request
+	edgesFirst   int64           // Bytes at each end of the file downloaded first
+	hedging      bool            // Race two mirrors for the requests of the tail
+	preconnect   bool            // Open the connections to the mirrors in GatherInfo
+	sameRedirect bool            // Follow redirects to other hosts only if they serve the same file
+	addRedirects bool            // Use the targets of redirects as mirrors too
+	mixedETags   bool            // Accept sources serving the file with different ETags
+	weakETag     bool            // The ETag is weak (W/"..."), not for range requests
+	redirects    map[string]bool // Redirect targets checked, and whether they serve the file
+	onSource     func(SourceResult)
+	minSources   int            // Sources that must answer the probes, 1 if zero
+	deadSources  []SourceResult // Sources left out because their probe failed
+	onSummary    func(ProgressSummary)
+	events       chan ProgressEvent
+	downloads    int             // Downloads running, one inside another
+	blockSums    *ZsyncControl   // Block checksums of the file, if any
+	written      *rangeProgress  // Ranges written so far, for WaitForRange
+	sources      []rangeSource   // Mirror each chunk was downloaded from
+	repairing    bool            // Downloading corrupt ranges again
+	timestamping bool            // Skip the download if the output file is up to date
+	knownETag    string          // ETag of the existing output file, for timestamping
+	remoteTime   bool            // Give the file the modification time of the remote one
+	outputDir    string          // Directory of relative output names
+	outputPath   string          // Output file replacing the resolved name
+	tempSuffix   string          // Suffix of the partial file, if not the default
+	tempDir      string          // Directory of the partial file, if not next to the output
+	preallocate  bool            // Reserve the disk space of the partial file
+	output       io.Writer       // Writer the file is streamed to, instead of saved
+	streaming    bool            // Streaming to the output writer
+	decoding     bool            // Decode encoded files while downloading them
+	filters      []OutputFilter  // Filters of the file on its way to the output
+	extractDir   string          // Directory the archive is extracted to, if any
+	sinks        []io.Writer     // Writers getting the file in order as it's downloaded
+	provenance   ProvenanceStore // Where the provenance of the file is recorded, if anywhere
+	fileMode     os.FileMode     // Mode bits of the output file, if not the default
+	owner        *[2]int         // Owner and group of the output file, if not the default
+	spaceMargin  int64           // Free space required on top of the size of the file
+	conflict     ConflictPolicy  // What to do when the output file already exists
+
+	tracerProvider   trace.TracerProvider
+	metrics          *Metrics     // Prometheus metrics of the downloads, if any
+	hooks            hookList     // Called along the downloads
+	eventWriter      *eventWriter // Writing the events as JSON lines, if any
+	stats            statsRecorder
+	progressInterval time.Duration // Shortest time between two reports of the progress
+	coalesceSize     int           // Bytes of the blocks written by the connections
+	sharedTransports *transportSet // Transports of the mirrors shared with other downloaders, if any
+	writeBuffers     *bufferPool   // Buffers of the connections
+	readBuffers      *bufferPool   // Buffers of the reads of the file
+	connectTimeout   time.Duration // Time to open a connection, TLS handshake included
+	headerTimeout    time.Duration // Time to wait for the headers of a response
+	maxTime          time.Duration // Longest time a download may take
+
+	// Read by the accessors while downloading
+	state    atomic.Int32               // DownloadState
+	table    atomic.Pointer[chunkTable] // Chunks of the download running or last run
+	gathered atomic.Bool                // The info was gathered, sources added are probed
+
+	tlsConfig           *tls.Config                // Base TLS configuration for all mirrors
+	tlsSessionCacheSize int                        // TLS sessions cached per mirror
+	transports          map[string]*http.Transport // Transport of each mirror
+	mu                  sync.Mutex
 }
 
 func NewMultiDownloader(
 	urls []string,
 	nConns int,
-	timeout time.Duration) *MultiDownloader {
-	return &MultiDownloader{
-		urls:    urls,
-		nConns:  nConns,
-		timeout: timeout}
+	timeout time.Duration,
+	opts ...Option) *MultiDownloader {
+	dldr := &MultiDownloader{
+		urls:                urls,
+		nConns:              nConns,
+		timeout:             timeout,
+		resolver:            URLFilenameResolver,
+		written:             newRangeProgress(),
+		mirrors:             newMirrorSet(),
+		limiter:             newRateLimiter(0),
+		retry:               RetryPolicy{},
+		progressInterval:    defaultProgressInterval,
+		coalesceSize:        defaultCoalesceSize,
+		writeBuffers:        buffers(defaultWriteBufferSize),
+		readBuffers:         buffers(defaultReadBufferSize),
+		tlsSessionCacheSize: defaultTLSSessionCacheSize}
+	for _, opt := range opts {
+		opt(dldr)
+	}
+	dldr.mirrors.log = dldr.logVerbose
+	return dldr
 }
 
 // Get the info of the file, using the HTTP HEAD request
@@ -72,97 +249,327 @@ func (dldr *MultiDownloader) GatherInfo() (chunks []Chunk, err error) {
 		return nil, errors.New("No URLs provided")
 	}
 
-	results := make(chan urlInfo)
-	defer close(results)
+	// Buffered, so that the probes still running when returning early don't
+	// block. They are canceled then.
+	results := make(chan urlInfo, len(dldr.urls))
+	ctx, endTrace := dldr.traceGatherInfo()
+	defer func() {
+		endTrace(err)
+	}()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	// Connect to all sources concurrently
 	getHead := func(url string) {
-		client := http.Client{
-			Timeout: time.Duration(dldr.timeout),
-		}
-		resp, err := client.Head(url)
-		if err != nil {
-			results <- urlInfo{url: url, connSuccess: false, statusCode: 0}
-			return
-		}
-		defer resp.Body.Close()
-		flen, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 0, 64)
-		etag := resp.Header.Get("Etag")
-		if err != nil {
-			log.Println("Error reading Content-Length from HTTP header")
-			flen = 0
-		}
-		results <- urlInfo{
-			url:         url,
-			fileLength:  flen,
-			etag:        etag,
-			connSuccess: true,
-			statusCode:  resp.StatusCode,
-		}
+		results <- dldr.probe(ctx, url)
 	}
 	for _, url := range dldr.urls {
 		go getHead(url)
 	}
 
-	// Gather the results and return if something is wrong
-	resArray := make([]urlInfo, len(dldr.urls))
+	// Gather the results as they arrive, leaving out the failed sources, and
+	// return once too many failed
+	resArray := make([]urlInfo, 0, len(dldr.urls))
+	dldr.deadSources = nil
+	minimum := min(max(dldr.minSources, 1), len(dldr.urls))
 	for i := 0; i < len(dldr.urls); i++ {
 		r := <-results
-		resArray[i] = r
+		var err error
 		if !r.connSuccess || r.statusCode != 200 {
-			return nil, errors.New(
-				fmt.Sprintf("Failed connection to URL %s", resArray[i].url))
+			err = withHint(errors.New(
+				fmt.Sprintf("Failed connection to URL %s", r.url)), probeRemedy(r))
+		}
+		if dldr.onSource != nil {
+			dldr.onSource(r.result(err))
+		}
+		if err != nil {
+			dldr.deadSources = append(dldr.deadSources, r.result(err))
+			if len(dldr.urls)-len(dldr.deadSources) < minimum {
+				return nil, err
+			}
+			dldr.logWarning(err.Error() + ", leaving it out")
+			continue
 		}
+		resArray = append(resArray, r)
+	}
+
+	// Only the sources serving the same representation can share the chunks
+	resArray = dldr.compatibleSources(resArray)
+	urls := make([]string, len(resArray))
+	for i, r := range resArray {
+		urls[i] = r.url
+	}
+	dldr.setSourceURLs(urls)
+	dldr.encoding = resArray[0].encoding
+	if err := dldr.checkDecodable(dldr.encoding); err != nil {
+		return nil, err
 	}
 
 	// Check that all sources agree on file length and Etag
 	// Empty Etags are also accepted
 	commonFileLength := resArray[0].fileLength
-	commonEtag := resArray[0].etag
-	for _, r := range resArray[1:] {
-		if r.fileLength != commonFileLength ||
-			(len(r.etag) != 0 && r.etag != commonEtag) {
-			return nil, errors.New("URLs must point to the same file")
+	commonEtag := ""
+	mixedEtags, weakEtag := false, false
+	for _, r := range resArray {
+		if r.fileLength != commonFileLength {
+			return nil, withHint(errors.New("URLs must point to the same file"),
+				"Leave out the sources serving a different version of the file")
+		}
+		if r.etag == "" {
+			continue
 		}
+		if commonEtag == "" {
+			commonEtag = r.etag
+		} else if !sameETags(r.etag, commonEtag) {
+			if !dldr.mixedETags {
+				return nil, withHint(errors.New("URLs must point to the same file"),
+					"Leave out the sources serving a different version of the file, "+
+						"or accept differing ETags (-mixed-etags) if they serve the same bytes")
+			}
+			mixedEtags = true
+		}
+		_, weak := parseETag(r.etag)
+		weakEtag = weakEtag || weak
+	}
+	if mixedEtags {
+		dldr.logVerbose("The sources serve the file with different ETags")
+		commonEtag = ""
 	}
 	dldr.fileLength = commonFileLength
+	dldr.ETag, dldr.weakETag = "", false // From a previous gathering
 	if commonEtag != "" {
-		dldr.ETag = commonEtag[1 : len(commonEtag)-1] // Remove the surrounding ""
+		dldr.ETag, _ = parseETag(commonEtag)
+		dldr.weakETag = weakEtag // Of any source
+	}
+	// Without range requests, the file can only be downloaded sequentially
+	dldr.noRanges = true
+	for _, r := range resArray {
+		dldr.noRanges = dldr.noRanges && r.noRanges
+	}
+	if dldr.noRanges {
+		dldr.logVerbose("No source accepts range requests, downloading with a single one")
+	}
+	dldr.lastModified = resArray[0].lastMod
+	for _, r := range resArray[1:] {
+		if r.lastMod != dldr.lastModified {
+			dldr.lastModified = ""
+		}
+	}
+	if dldr.sameRedirect {
+		dldr.acceptRedirects(resArray)
+	}
+	dldr.filename, err = dldr.resolveFilename(resArray[0].sourceInfo())
+	if err != nil {
+		return nil, err
 	}
-	dldr.filename = urlToFilename(resArray[0].url)
-	dldr.partFilename = dldr.filename + tmpFileSuffix
+	dldr.setResolvedOutput(dldr.filename)
 
-	logVerbose("File length: ", dldr.fileLength, " bytes")
-	logVerbose("File name: ", dldr.filename)
-	logVerbose("Parts file name: ", dldr.partFilename)
-	logVerbose("Etag: ", dldr.ETag)
+	// The checksum file names the file as the sources do
+	if dldr.checksumURL != "" {
+		names := []string{urlToFilename(resArray[0].final), urlToFilename(resArray[0].url), filepath.Base(dldr.filename)}
+		if err := dldr.loadChecksums(names); err != nil {
+			return nil, err
+		}
+	}
+	dldr.headerDigests(resArray)
+	if mixedEtags && !dldr.hasDigests() {
+		dldr.logWarning("The sources serve the file with different ETags, and there's no checksum to verify it")
+	}
+	if dldr.checksPGPSignature() {
+		if _, err := readKeyring(dldr.pgpKeyring); err != nil {
+			return nil, err
+		}
+		if err := dldr.loadPGPSignature(resArray[0].url); err != nil {
+			return nil, err
+		}
+	}
+
+	dldr.logVerbose("File length: ", dldr.fileLength, " bytes")
+	dldr.logVerbose("File name: ", dldr.filename)
+	dldr.logVerbose("Parts file name: ", dldr.partFilename)
+	dldr.logVerbose("Etag: ", dldr.ETag)
+
+	if dldr.autoTune {
+		dldr.autoTuneConnections()
+	}
 
 	// Build the chunks table, necessary for constructing requests
 	dldr.buildChunks()
+	dldr.gathered.Store(true)
+
+	if dldr.preconnect {
+		dldr.warmUp()
+	}
 
 	return dldr.chunks, nil
 }
 
-// Prepare the file used for writing the blocks of data
-func (dldr *MultiDownloader) SetupFile(filename string) (os.FileInfo, error) {
+// Internal: query a single source for the file info, within the timeout
+func (dldr *MultiDownloader) probe(ctx context.Context, url string) urlInfo {
+	if dldr.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dldr.timeout)
+		defer cancel()
+	}
+	client := dldr.httpClient(url, dldr.timeout)
+	if isDAV(url) {
+		info, err := dldr.propfind(ctx, client, url)
+		if err != nil {
+			dldr.logVerbose("PROPFIND failed for ", url, ": ", err)
+			return urlInfo{url: url, connSuccess: false, statusCode: 0}
+		}
+		return info
+	}
+	req, err := dldr.newRequest("HEAD", url, nil)
+	if err != nil {
+		return urlInfo{url: url, connSuccess: false, statusCode: 0}
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	req.Header.Set("Want-Digest", "sha-512, sha-256, sha;q=0.5, md5;q=0.3")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return urlInfo{url: url, connSuccess: false, statusCode: 0}
+	}
+	defer resp.Body.Close()
+
+	// Some WebDAV servers don't answer HEAD, or answer it without a length
+	lengthHeader := resp.Header.Get("Content-Length")
+	if resp.StatusCode == http.StatusMethodNotAllowed ||
+		resp.StatusCode == http.StatusNotImplemented ||
+		(resp.StatusCode == http.StatusOK && lengthHeader == "") {
+		if info, err := dldr.propfind(ctx, client, url); err == nil {
+			return info
+		}
+	}
+
+	// Presigned URLs and some CDNs refuse HEAD, but not a GET of a byte
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusMethodNotAllowed {
+		if info, err := dldr.probeGet(ctx, client, url); err == nil {
+			return info
+		}
+	}
+
+	flen, err := strconv.ParseInt(lengthHeader, 0, 64)
+	etag := resp.Header.Get("Etag")
+	if err != nil {
+		dldr.logWarning("Error reading Content-Length from HTTP header")
+		flen = 0
+	}
+	return urlInfo{
+		url:         url,
+		fileLength:  flen,
+		etag:        etag,
+		lastMod:     resp.Header.Get("Last-Modified"),
+		connSuccess: true,
+		statusCode:  resp.StatusCode,
+		header:      resp.Header,
+		encoding:    contentEncoding(resp.Header),
+		final:       resp.Request.URL.String(),
+		noRanges:    !acceptsRanges(resp.Header),
+	}
+}
+
+// Internal: whether a response advertises range requests
+func acceptsRanges(header http.Header) bool {
+	for _, unit := range strings.Split(header.Get("Accept-Ranges"), ",") {
+		if strings.TrimSpace(strings.ToLower(unit)) == "bytes" {
+			return true
+		}
+	}
+	return false
+}
+
+// Prepare the file used for writing the blocks of data. With WithResume, an
+// interrupted download of the same file is picked up instead. With
+// WithTimestamping, returns ErrNotModified if the output file is up to date.
+// If the output file already has the expected digest (WithSHA256), returns
+// ErrAlreadyComplete. If the output file exists, WithConflictPolicy decides
+// what to do with it. If the file doesn't fit on the disk, returns an
+// InsufficientSpaceError, matching ErrInsufficientSpace.
+func (dldr *MultiDownloader) SetupFile(filename string) (_ os.FileInfo, err error) {
+	defer func() {
+		dldr.setupDone(err)
+	}()
+	if err := dldr.checkIntegrity(); err != nil {
+		return nil, err
+	}
 	if filename != "" {
-		dldr.filename = filename
-		dldr.partFilename = filename + tmpFileSuffix
+		dldr.setOutput(filename)
+	}
+	if err := dldr.makeOutputDirs(); err != nil {
+		return nil, err
+	}
+
+	if dldr.trustsDigests() {
+		if info, err := os.Stat(dldr.filename); err == nil && info.Size() == dldr.fileLength &&
+			dldr.verifyDigests(dldr.filename) == nil &&
+			(!dldr.checksPGPSignature() || dldr.verifyPGPSignature(dldr.filename) == nil) {
+			dldr.logVerbose(dldr.filename, " already has the expected digest")
+			return info, ErrAlreadyComplete
+		}
 	}
 
+	if dldr.timestamping {
+		if info, err := os.Stat(dldr.filename); err == nil {
+			unchanged, err := dldr.notModified(info)
+			if err != nil {
+				return nil, err
+			}
+			if unchanged {
+				return info, ErrNotModified
+			}
+		}
+	}
+
+	if err := dldr.resolveConflict(); err != nil {
+		return nil, err
+	}
+
+	dldr.pieceState = nil
+	if dldr.resumeDownload() {
+		return os.Stat(dldr.partFilename)
+	}
+	if dldr.resume {
+		os.Remove(controlPath(dldr.partFilename))
+		os.Remove(trustedPath(dldr.partFilename))
+	}
+
+	if err := dldr.checkSpace(); err != nil {
+		return nil, err
+	}
 	file, err := os.Create(dldr.partFilename)
 	if err != nil {
 		return nil, err
 	}
+	defer file.Close()
+	if dldr.preallocate {
+		if err := dldr.allocate(file); err != nil {
+			file.Close()
+			os.Remove(dldr.partFilename)
+			return nil, err
+		}
+	}
 
 	// Force file size in order to write arbitrary chunks
-	err = file.Truncate(dldr.fileLength)
-	fileInfo, err := file.Stat()
-	return fileInfo, err
+	if err := file.Truncate(dldr.fileLength); err != nil {
+		return nil, err
+	}
+	return file.Stat()
 }
 
 // Internal: build the chunks table, deciding boundaries
 func (dldr *MultiDownloader) buildChunks() {
+	dldr.single = dldr.fileLength < dldr.singleBelow || dldr.fileLength < int64(dldr.nConns) ||
+		dldr.noRanges || dldr.decodesOnTheFly()
+	if dldr.single {
+		dldr.chunks = []Chunk{{0, dldr.fileLength}}
+		return
+	}
+	if dldr.chunkSize > 0 && dldr.fileLength > 0 {
+		dldr.buildSizedChunks(dldr.chunkSize)
+		return
+	}
+
 	// The algorithm takes care of possible rounding errors splitting into chunks
 	// by taking out the remainder and distributing it among the first chunks
 	n := int64(dldr.nConns)
@@ -181,86 +588,568 @@ func (dldr *MultiDownloader) buildChunks() {
 		boundary = nextBoundary
 		nextBoundary = nextBoundary + chunkSize
 	}
+	dldr.chunks = splitChunks(dldr.chunks, dldr.maxChunk)
+}
+
+// Internal: build the request of the chunk i to the given URL, unless it's a
+// segment, which has its own
+func (dldr *MultiDownloader) chunkRequest(i int, chunk Chunk, selectedUrl string) (*http.Request, error) {
+	if dldr.segments != nil {
+		seg := dldr.segments[i]
+		req, err := dldr.newRequest("GET", seg.url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if seg.offset >= 0 {
+			req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d",
+				seg.offset, seg.offset+chunk.End-chunk.Begin-1))
+		}
+		req.Header.Set("Accept-Encoding", "identity")
+		return req, nil
+	}
+
+	req, err := dldr.newRequest("GET", httpURL(selectedUrl), nil)
+	if err != nil {
+		return nil, err
+	}
+	// Small files are downloaded with a plain request
+	if !dldr.single || chunk != (Chunk{0, dldr.fileLength}) || dldr.ifRange != "" {
+		req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", chunk.Begin, chunk.End-1))
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	if dldr.ifRange != "" {
+		req.Header.Set("If-Range", dldr.ifRange)
+	}
+	return req, nil
 }
 
 // Perform the multipart download
 //
-// This algorithm handles download splitting the file into n blocks. If a connection fails, it
-// will try with other sources (as different sources may have different connection limits) then,
-// if it still fails, it will wait until other process is done. Thus, nConns really means the
-// MAXIMUM allowed connections, which will be tried at first and then adjusted.
-// The alternative approach of dividing into nSize blocks and spawn threads requests from a pool
-// of tasks has been discarded to avoid the overhead of performing potentially too many HTTP
-// requests, as a result of each thread performing many requests instead of the minimum necessary.
+// This algorithm handles download splitting the file into blocks, downloaded by a pool of
+// nConns workers, one per connection. Each worker takes any pending block, so that fast
+// connections download more of them. If a connection fails, it will try with other sources (as
+// different sources may have different connection limits) then, if it still fails, the block is
+// left for another worker and the connection is dropped. Thus, nConns really means the MAXIMUM
+// allowed connections, which will be tried at first and then adjusted.
+// When no block is pending, an idle worker takes half of what's left of the slowest one.
 //
-// The designed algorithm tries to minimize the amount of successful HTTP requests.
+// The designed algorithm tries to minimize the amount of successful HTTP requests: by default
+// there is one block per connection, and blocks are only split when a connection is idle.
 //
 // As a result of the approach taken, the number of concurrent connections can drop if no source
 // is available to accomodate the request. In any case, setting a reasonable limit is left to the
 // Take into consideration that some servers may ban your IP for some amount of time if you flood
 // them with too many requests.
 func (dldr *MultiDownloader) Download(feedbackFunc func([]ConnectionProgress)) (err error) {
-	done := make(chan bool)
-	failed := make(chan bool)
-	available := make(chan bool, dldr.nConns)
+	dldr.enterDownload()
+	defer dldr.leaveDownload()
+	endTrace := dldr.traceDownload()
+	defer func() {
+		endTrace(err)
+	}()
+	if dldr.downloads == 1 {
+		started := time.Now()
+		stopStats := dldr.stats.begin()
+		dldr.state.Store(int32(StateDownloading))
+		defer func() {
+			dldr.downloadDone(err)
+		}()
+		if dldr.schedule != nil {
+			defer dldr.followSchedule()()
+		}
+		dldr.hooks.start(dldr.filename, dldr.fileLength)
+		defer func() {
+			stopStats()
+			dldr.metrics.downloaded(time.Since(started), err)
+			dldr.hooks.end(dldr.filename, err)
+		}()
+		if dldr.maxTime > 0 {
+			endTime := dldr.limitTime()
+			defer func() {
+				err = endTime(err)
+			}()
+		}
+	}
+	if dldr.streams() && !dldr.streaming {
+		return dldr.streamDownload(feedbackFunc)
+	}
 
-	progress := make(chan ConnectionProgress)
+	// Let WaitForRange know of the ranges written, and when it's over
+	dldr.written.start(dldr.fileLength, dldr.chunks)
+	defer func() {
+		dldr.written.finish(err)
+	}()
+	hashed := dldr.hashWhileWritten()
 
-	// Parallel download, wait for all to return
-	downloadChunk := func(f *os.File, i int) {
-		numUrls := len(dldr.urls)
-		for {
-			// Block until there are connections available (all goroutines at first)
-			<-available
+	// Make sure no source is serving the wrong file before committing to it
+	if dldr.signature != nil {
+		if err := dldr.checkSignatures(); err != nil {
+			return err
+		}
+	}
 
+	if err := dldr.checkIntegrity(); err != nil {
+		return err
+	}
+	if dldr.checksPieces() {
+		if err := dldr.startPieces(); err != nil {
+			return err
+		}
+	}
+
+	// The connections kept for the chunks aren't needed afterwards
+	defer dldr.closeIdleConnections()
+
+	// Don't even start a download that doesn't fit in the quota
+	if dldr.quota != nil {
+		needed := int64(0)
+		for _, c := range dldr.chunks {
+			needed += c.End - c.Begin
+		}
+		if err := dldr.quota.check(needed); err != nil {
+			return err
+		}
+		defer dldr.quota.Save()
+	}
+
+	// Nor one that doesn't fit in the disk left to its tenant
+	if dldr.tenant != nil {
+		if err := dldr.tenant.begin(dldr, dldr.fileLength); err != nil {
+			return err
+		}
+		defer func() {
+			dldr.tenant.end(dldr, err)
+		}()
+	}
+
+	// Resumed, recovered or repaired ranges are limited in size too
+	if dldr.segments == nil {
+		dldr.chunks = splitChunks(dldr.chunks, dldr.chunkLimit())
+		if dldr.edgesFirst > 0 {
+			dldr.chunks = edgeChunks(dldr.chunks, dldr.edgesFirst, dldr.fileLength)
+		}
+	}
+
+	if dldr.benchmark && dldr.segments == nil && len(dldr.sourceURLs()) > 1 {
+		dldr.benchmarkMirrors()
+	}
+
+	var control *controlFile // Written blocks, nil if not tracked
+	table := newChunkTable(dldr.chunks)
+	dldr.table.Store(table)
+	var received, errCount atomic.Int64 // For the adaptive concurrency
+
+	var progress *progressTracker // Progress of the connections, if reported
+
+	// Account for n bytes received. Stops all connections once the quota is
+	// used up.
+	transferred := func(n int) error {
+		received.Add(int64(n))
+		dldr.metrics.transferred(n)
+		dldr.stats.bytes.Add(int64(n))
+		if dldr.quota != nil {
+			if err := dldr.quota.consume(int64(n)); err != nil {
+				return err
+			}
+		}
+		if dldr.tenant != nil {
+			dldr.tenant.transferred(n)
+		}
+		dldr.limiter.wait(n)
+		return nil
+	}
+
+	// Copy the body of a response to its chunk in the file. Responses shorter
+	// or longer than the chunk are for something else, or were cut. If the
+	// chunk is split meanwhile, the copy stops at its new end. If the
+	// connection is preempted, the rest of the chunk is queued again.
+	copyChunk := func(f *os.File, i int, chunk Chunk, body io.Reader, preempted <-chan struct{}) error {
+		cursor := chunk.Begin
+		end := chunk.End
+		var block []byte
+		if dldr.coalesceSize > 0 {
+			pooled := buffers(dldr.coalesceSize).get()
+			defer buffers(dldr.coalesceSize).put(pooled)
+			block = *pooled
+		}
+		out := newBlockWriter(f, chunk.Begin, block, func(begin, end int64) {
+			if control != nil {
+				if err := control.markWritten(begin, end); err != nil {
+					dldr.logVerbose("Error updating the control file: ", err)
+				}
+			}
+			dldr.written.add(begin, end)
+		})
+		var stop error // Why the writes stopped the copy
+		sink := writerFunc(func(p []byte) (int, error) {
+			if int64(len(p)) > end-cursor {
+				if end == chunk.End {
+					stop = errors.New(fmt.Sprintf("Response for chunk %d is longer than the chunk", i))
+					return 0, stop
+				}
+				p = p[:end-cursor] // The rest is another chunk's now
+			}
+			n, err := out.Write(p)
+			if err != nil {
+				stop = fmt.Errorf("%w: %v", errWrite, err)
+				return n, stop
+			}
+			cursor += int64(n)
+			end = table.advance(i, cursor)
+			if err := transferred(n); err != nil {
+				stop = err
+				return n, stop
+			}
+
+			// Send progress if feedback function is provided
+			if progress != nil {
+				progress.update(ConnectionProgress{
+					Id:      i,
+					Begin:   chunk.Begin,
+					End:     end,
+					Current: cursor,
+				})
+			}
+
+			select {
+			case <-preempted:
+				dldr.logVerbose("Preempted, requeuing ", table.yield(i, cursor))
+				end = cursor
+			default:
+			}
+			if cursor >= end && end < chunk.End {
+				stop = errChunkEnd
+				return n, stop
+			}
+			return n, nil
+		})
+
+		// Through the buffers of the pool, unless the body writes itself
+		buf := dldr.writeBuffers.get()
+		defer dldr.writeBuffers.put(buf)
+		_, err := io.CopyBuffer(sink, body, *buf)
+		// What was received is kept, even if the response was cut
+		if errFlush := out.Flush(); errFlush != nil && (stop == nil || stop == errChunkEnd) {
+			stop = fmt.Errorf("%w: %v", errWrite, errFlush)
+		}
+		switch {
+		case stop == errChunkEnd:
+			return nil
+		case stop != nil:
+			return stop
+		case cursor < end:
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("%w for chunk %d: %v", errTruncated, i, err)
+		}
+		return nil
+	}
+
+	// Decode the body of the single request of an encoded file into the file.
+	// The progress is the one of the encoded body, whose length is known.
+	copyDecoded := func(f *os.File, i int, chunk Chunk, body io.Reader) error {
+		cursor := chunk.Begin
+		encoded := countingReader{body, func(n int) error {
+			cursor += int64(n)
+			table.advance(i, cursor)
+			if progress != nil {
+				progress.update(ConnectionProgress{
+					Id:      i,
+					Begin:   chunk.Begin,
+					End:     chunk.End,
+					Current: cursor,
+				})
+			}
+			return transferred(n)
+		}}
+		zr, err := newDecoder(dldr.encoding, encoded)
+		if err == nil {
+			defer zr.Close()
+			var n int64
+			buf := dldr.writeBuffers.get()
+			n, err = io.CopyBuffer(io.NewOffsetWriter(f, 0), zr, *buf)
+			dldr.writeBuffers.put(buf)
+			if err == nil && cursor == chunk.End {
+				return f.Truncate(n)
+			}
+			if err == nil {
+				err = errors.New(fmt.Sprintf("%d bytes received instead of %d", cursor-chunk.Begin, chunk.End-chunk.Begin))
+			}
+		}
+		if errors.Is(err, ErrQuotaExceeded) {
+			return err
+		}
+		return fmt.Errorf("%w for chunk %d: %v", errTruncated, i, err)
+	}
+
+	// Download a chunk, trying each URL in turn. Returns ErrRemoteChanged or
+	// ErrQuotaExceeded when the whole download has to stop.
+	fetchChunk := func(ctx context.Context, f *os.File, i int) error {
+		// Nothing to fetch for empty chunks
+		if chunk := table.start(i); chunk.End <= chunk.Begin {
+			return nil
+		}
+
+		// The connections of a tenant are shared by all its downloads
+		release := func() {}
+		if dldr.tenant != nil {
+			var err error
+			if release, err = dldr.tenant.acquireConn(ctx); err != nil {
+				return err
+			}
+		}
+		// And those of a queue by all of its own, by priority
+		var preempted <-chan struct{}
+		if dldr.queued != nil {
+			releaseTenant := release
+			var releaseQueued func()
+			var err error
+			if releaseQueued, preempted, err = dldr.queued.queue.acquire(ctx, dldr.queued); err != nil {
+				releaseTenant()
+				return err
+			}
+			release = func() {
+				releaseQueued()
+				releaseTenant()
+			}
+			if dldr.segments != nil {
+				preempted = nil // Segments can't be cut
+			}
+		}
+		defer release()
+		defer dldr.metrics.connect()()
+
+		err := errors.New(fmt.Sprintf("No source for chunk %d", i))
+		permanent := make(map[string]bool) // Sources failing in a way not worth retrying
+		failures := make(map[string]int)   // Failures of the chunk with each source
+		attempts, lastURL := 0, ""
+		for round := 0; ; round++ {
+			// The sources as they are now, some may have been added or removed
+			urls, numUrls := dldr.sourceURLs(), 1 // Each segment has its own URL
+			if dldr.segments == nil {
+				numUrls = len(urls)
+			}
+			tried := make(map[int]bool)
+			// Corrupt pieces are downloaded again from other mirrors, if any
+			avoid := make(map[int]bool)
+			for k, url := range urls {
+				if permanent[url] {
+					tried[k] = true
+				} else if table.avoided(i)[url] {
+					avoid[k] = true
+				}
+			}
+			if len(avoid)+len(tried) < numUrls {
+				for k := range avoid {
+					tried[k] = true
+				}
+			}
+			// Leave out the source for the chunk, unless tried again later
+			again, wait := false, time.Duration(0)
+			giveUp := func(source string, req *http.Request) {
+				failures[source]++
+				mirror := source
+				if mirror == "" {
+					mirror = req.URL.String() // The segment, for streams
+				}
+				if delay, ok := dldr.retry.ShouldRetry(err, failures[source], mirror); ok {
+					again, wait = true, max(wait, delay)
+				} else {
+					permanent[source] = true
+				}
+			}
 			for try := 0; try < numUrls; try++ { // Try each URL before signaling failure
-				client := &http.Client{}
-				// Select URL in a Round-Robin fashion, each try is done with the next i
-				selectedUrl := dldr.urls[(i+try)%numUrls]
+				// Select the URL in a Round-Robin fashion, each try is done with
+				// the next i, skipping the mirrors with no connections left
+				k, selectedUrl, releaseMirror := 0, "", func() {}
+				if dldr.segments == nil {
+					var releaseConn func()
+					k, releaseConn = dldr.mirrors.acquire(ctx, urls, i+try, tried)
+					if k < 0 {
+						if errCtx := ctx.Err(); errCtx != nil {
+							return errCtx
+						}
+						break
+					}
+					tried[k] = true
+					selectedUrl, releaseMirror = urls[k], releaseConn
+				} else if permanent[selectedUrl] {
+					break
+				}
+
+				if round > 0 || try > 0 {
+					dldr.metrics.retried()
+					dldr.stats.retries.Add(1)
+				}
 
 				// Send per-range requests
-				req, err := http.NewRequest("GET", selectedUrl, nil)
-				if err != nil {
+				chunk := table.start(i)
+				req, errReq := dldr.chunkRequest(i, chunk, selectedUrl)
+				if errReq != nil {
+					releaseMirror()
+					err = errReq
+					permanent[selectedUrl] = true
 					continue
 				}
-				req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", dldr.chunks[i].Begin, dldr.chunks[i].End))
-				resp, err := client.Do(req)
-				if err != nil {
+				req, span := dldr.traceChunk(req.WithContext(ctx), i, chunk, round)
+				event := ChunkEvent{Id: i, Begin: chunk.Begin, End: chunk.End, URL: req.URL.String(), Retry: attempts}
+				if attempts > 0 {
+					dldr.hooks.retry(event, err)
+				}
+				if lastURL != "" && lastURL != event.URL {
+					dldr.hooks.mirrorSwitch(i, lastURL, event.URL)
+				}
+				attempts, lastURL = attempts+1, event.URL
+				dldr.hooks.chunkStart(event)
+				// Drop the request if it's too slow, or idle
+				stopWatch := func() error { return nil }
+				if (dldr.lowSpeed > 0 && dldr.lowSpeedTime > 0) || dldr.idleTimeout > 0 {
+					ctx, cancel := context.WithCancel(req.Context())
+					req = req.WithContext(ctx)
+					watch := dldr.watchChunk(func() int64 { return table.cursor(i) }, cancel)
+					// The context ends with the attempt, not with all of them
+					stopWatch = func() error {
+						defer cancel()
+						return watch()
+					}
+				}
+				started := time.Now()
+				if selectedUrl != "" {
+					dldr.mirrors.requested(mirrorHost(selectedUrl))
+				}
+				var resp *http.Response
+				if alt, altK, releaseAlt := dldr.hedgeRequest(i, chunk, req, urls, k, table.tail()); alt != nil {
+					dldr.mirrors.requested(mirrorHost(urls[altK]))
+					var n int
+					resp, n, errReq = dldr.hedge([2]*http.Request{req, alt})
+					if n == 1 {
+						releaseMirror()
+						req, k, selectedUrl, releaseMirror = alt, altK, urls[altK], releaseAlt
+					} else {
+						releaseAlt()
+					}
+				} else {
+					resp, errReq = dldr.chunkClient(req.URL.String()).Do(req)
+				}
+				latency := time.Since(started)
+				if errReq != nil {
+					if cause := stopWatch(); cause != nil {
+						errReq = fmt.Errorf("%w: %v", cause, errReq)
+					}
+					endChunkSpan(span, req, 0, 0, errReq)
+					releaseMirror()
+					err = errReq
+					errCount.Add(1)
+					if selectedUrl != "" {
+						dldr.mirrors.failed(mirrorHost(selectedUrl), err)
+					}
+					dldr.metrics.mirrorFailed(req.URL.Host)
+					dldr.logVerbose(err)
+					giveUp(selectedUrl, req)
 					continue
 				}
-				defer resp.Body.Close()
-
-				// Read response and process it in chunks
-				buf := make([]byte, fileWriteChunk)
-				cursor := dldr.chunks[i].Begin
-				for {
-					n, err := io.ReadFull(resp.Body, buf)
-					if err == io.EOF {
-						done <- true // Signal success
-						return
+				// The whole file instead of the range: it changed since the
+				// download was interrupted
+				if resp.StatusCode == http.StatusOK && req.Header.Get("If-Range") != "" {
+					resp.Body.Close()
+					stopWatch()
+					releaseMirror()
+					err = fmt.Errorf("%w at %s", ErrRemoteChanged, req.URL)
+					endChunkSpan(span, req, resp.StatusCode, 0, err)
+					return err
+				}
+				if resp.StatusCode != http.StatusPartialContent &&
+					(resp.StatusCode != http.StatusOK || req.Header.Get("Range") != "") {
+					err = statusError(resp.StatusCode)
+				} else if encoding := contentEncoding(resp.Header); encoding != dldr.encoding {
+					err = errors.New("Unexpected Content-Encoding " + encodingName(encoding))
+				} else if dldr.decodesOnTheFly() {
+					err = copyDecoded(f, i, chunk, dldr.limitConn(resp.Body))
+				} else {
+					err = copyChunk(f, i, chunk, dldr.limitConn(resp.Body), preempted)
+				}
+				resp.Body.Close()
+				releaseMirror()
+				endChunkSpan(span, req, resp.StatusCode, table.cursor(i)-chunk.Begin, err)
+				if cause := stopWatch(); cause != nil && err != nil {
+					// Keep what was written, the next mirror sends the rest
+					err = fmt.Errorf("%w: %v", cause, err)
+					if dldr.segments == nil && !dldr.decodesOnTheFly() {
+						if written := table.trim(i); written.End > written.Begin {
+							dldr.recordSource(written, selectedUrl)
+						}
 					}
-					// According to doc: "Clients of WriteAt can execute parallel WriteAt calls on the
-					// same destination if the ranges do not overlap."
-					_, errWr := f.WriteAt(buf[:n], cursor)
-					if errWr != nil {
-						log.Fatal(errWr)
-						break
+				}
+				if err != nil {
+					errCount.Add(1)
+					if !errors.Is(err, ErrQuotaExceeded) && !errors.Is(err, errWrite) {
+						if selectedUrl != "" {
+							dldr.mirrors.failed(mirrorHost(selectedUrl), err)
+						}
+						dldr.metrics.mirrorFailed(req.URL.Host)
 					}
-					cursor += int64(n)
-
-					// Send progress if feedback function is provided
-					if feedbackFunc != nil {
-						progress <- ConnectionProgress{
-							Id:      i,
-							Begin:   dldr.chunks[i].Begin,
-							End:     dldr.chunks[i].End,
-							Current: cursor,
+				}
+				if err == nil {
+					event.URL, event.Bytes, event.Duration = req.URL.String(), table.cursor(i)-chunk.Begin, time.Since(started)
+					dldr.hooks.chunkComplete(event)
+					if dldr.segments == nil {
+						done := table.get(i)
+						dldr.recordSource(done, selectedUrl)
+						dldr.mirrors.record(mirrorHost(selectedUrl), done.End-chunk.Begin, time.Since(started), latency)
+					}
+					if dldr.checksPieces() {
+						bad, errPieces := dldr.checkPieces(table.get(i))
+						if errPieces != nil {
+							return errPieces
+						}
+						for _, c := range bad {
+							table.add(c, dldr.suspectURLs([]Chunk{c}))
 						}
 					}
+					return nil
 				}
+				if errors.Is(err, ErrQuotaExceeded) || errors.Is(err, errWrite) {
+					return err
+				}
+				giveUp(selectedUrl, req)
+				dldr.logVerbose(err, " from ", req.URL)
+			}
+
+			// Try the sources left again after a while, unless none is
+			if !again {
+				return err
 			}
+			if errWait := waitRetry(ctx, wait); errWait != nil {
+				return errWait
+			}
+		}
+	}
 
-			failed <- true // Signal failure
+	// Each connection is a worker taking any pending chunk. A connection
+	// failing with all the sources is dropped.
+	type chunkResult struct {
+		i   int
+		err error
+	}
+	nWorkers, limit := dldr.nConns, dldr.nConns
+	var controller *concurrencyController
+	if dldr.maxConns > 0 {
+		controller = newConcurrencyController(dldr.minConns, dldr.maxConns, dldr.nConns)
+		nWorkers, limit = controller.max, controller.limit
+	}
+	work := make(chan int, nWorkers)
+	results := make(chan chunkResult, nWorkers)
+	defer close(work)
+	var fetching sync.WaitGroup // Chunks handed to the workers, not over yet
+	worker := func(ctx context.Context, f *os.File) {
+		for i := range work {
+			err := fetchChunk(ctx, f, i)
+			fetching.Done()
+			results <- chunkResult{i, err}
+			if err != nil {
+				return
+			}
 		}
 	}
 
@@ -268,56 +1157,222 @@ func (dldr *MultiDownloader) Download(feedbackFunc func([]ConnectionProgress)) (
 	if err != nil {
 		return
 	}
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	// Keep track of the written blocks, to resume if interrupted
+	if dldr.resume && dldr.segments == nil && dldr.control == nil {
+		dldr.control, err = createControl(
+			controlPath(dldr.partFilename), dldr.fileLength, dldr.validator())
+		if err != nil {
+			return
+		}
+	}
+	control = dldr.control
 
-	for i := 0; i < dldr.nConns; i++ {
-		go downloadChunk(file, i)
+	// Resuming an interrupted download doesn't check the same pieces again
+	if control != nil && dldr.checksPieces() {
+		defer func() {
+			if err != nil {
+				dldr.checkPieces(Chunk{0, dldr.fileLength})
+				dldr.saveTrusted()
+			}
+		}()
+	}
+	if control != nil {
+		defer func() {
+			control.close()
+			dldr.control = nil
+		}()
+	}
 
-		// We start making all requested connections available
-		available <- true
+	// Handle progress feedback. The connections never wait for it, and
+	// everything is reported once the download ends.
+	if feedbackFunc != nil || dldr.onSummary != nil || dldr.events != nil || dldr.eventWriter != nil {
+		progress = newProgressTracker(dldr.chunks)
+		reported := dldr.reportProgress(progress, feedbackFunc)
+		defer func() {
+			progress.finish()
+			<-reported
+		}()
 	}
 
-	// Handle progress feedback
-	if feedbackFunc != nil {
-		progressArray := make([]ConnectionProgress, dldr.nConns)
-		for i := 0; i < dldr.nConns; i++ {
-			progressArray[i] = ConnectionProgress{
-				Id:      i,
-				Begin:   dldr.chunks[i].Begin,
-				End:     dldr.chunks[i].End,
-				Current: dldr.chunks[i].Begin,
+	// Hand the pending chunks to the idle workers, up to the limit of
+	// connections. When there are none left, an idle worker takes half of
+	// what's left of the slowest chunk.
+	idle, running := nWorkers, 0
+	dispatch := func() {
+		for idle > 0 && running < limit {
+			i, ok := table.next()
+			if !ok && !dldr.noSplitting && dldr.segments == nil && !dldr.single {
+				if j, split := table.split(); split {
+					dldr.logVerbose("Splitting chunk ", table.get(j))
+					i, ok = table.next()
+				}
+			}
+			if !ok {
+				return
 			}
+			idle--
+			running++
+			fetching.Add(1)
+			work <- i
 		}
+	}
+	// The connections still running are stopped when returning, before the
+	// file is closed or downloaded again
+	ctx, cancel := context.WithCancel(dldr.context())
+	stopWorkers := func() {
+		cancel()
+		fetching.Wait()
+	}
+	defer stopWorkers()
+	for i := 0; i < nWorkers; i++ {
+		go worker(ctx, file)
+	}
+	dispatch()
+
+	// Measure the throughput to adjust the limit of connections
+	var limits chan int
+	if controller != nil {
+		limits = make(chan int)
+		stop := make(chan struct{})
+		defer close(stop)
 		go func() {
-			complete := 0
-			for complete < dldr.nConns {
-				p := <-progress
-				progressArray[p.Id] = p
-				feedbackFunc(progressArray)
-				if p.Current >= p.End {
-					complete++
+			ticker := time.NewTicker(adaptiveInterval)
+			defer ticker.Stop()
+			last := time.Now()
+			for {
+				select {
+				case now := <-ticker.C:
+					n := controller.update(received.Swap(0), errCount.Swap(0), now.Sub(last))
+					last = now
+					select {
+					case limits <- n:
+					case <-stop:
+						return
+					}
+				case <-stop:
+					return
 				}
 			}
 		}()
 	}
 
-	remainingChunks := dldr.nConns
+	completed := 0
 	failedCount := 0
-	for remainingChunks > 0 {
-		// Block until a goroutine either succeeded or failed
+	for completed < table.len() {
+		// Block until a worker either succeeded or failed, or the limit of
+		// connections changed
+		var r chunkResult
 		select {
-		case <-done:
-			remainingChunks--
-			available <- true // Does not block up to nConns items
-		case <-failed:
+		case r = <-results:
+			running--
+		case n := <-limits:
+			if n != limit {
+				dldr.logVerbose("Connections: ", n)
+			}
+			limit = n
+			dispatch()
+			continue
+		}
+		switch {
+		case r.err == nil:
+			completed++
+			idle++
+		case errors.Is(r.err, ErrRemoteChanged):
+			dldr.logVerbose(r.err, ", starting over")
+			stopWorkers()
+			return dldr.restart(feedbackFunc)
+		case errors.Is(r.err, ErrQuotaExceeded) || errors.Is(r.err, ErrCorruptPiece) ||
+			errors.Is(r.err, errWrite):
+			return r.err
+		default:
+			if err := dldr.context().Err(); err != nil {
+				return err
+			}
+			table.retry(r.i)
 			failedCount++
-			if failedCount >= dldr.nConns {
-				return errors.New("The file couldn't be downloaded from any source. Aborting.")
+			if failedCount >= nWorkers {
+				return withHint(
+					errors.New("The file couldn't be downloaded from any source. Aborting."),
+					"Try again later or with fewer connections, the servers may limit them")
+			}
+		}
+		dispatch()
+	}
+	dldr.chunks = table.snapshot()
+
+	// Everything is written: the file is closed before being decoded,
+	// verified and moved
+	errClose := file.Close()
+	file = nil
+	if errClose != nil {
+		return errClose
+	}
+
+	// Pieces written without their chunk completing, when it was trimmed
+	if dldr.checksPieces() {
+		bad, errPieces := dldr.checkPieces(Chunk{0, dldr.fileLength})
+		if errPieces != nil {
+			return errPieces
+		}
+		if len(bad) > 0 {
+			return dldr.refetch(bad, feedbackFunc)
+		}
+	}
+
+	if !dldr.decodesOnTheFly() {
+		if err = decodeFile(dldr.partFilename, dldr.encoding); err != nil {
+			return
+		}
+	}
+	endVerify := dldr.traceVerify()
+	if err = dldr.verifyDownload(hashed); err != nil {
+		endVerify(err)
+		// With block checksums, only the corrupt blocks are downloaded again
+		if dldr.blockSums != nil && dldr.segments == nil && !dldr.repairing {
+			bad, errBlocks := dldr.corruptRanges(dldr.partFilename)
+			if errBlocks == nil && len(bad) > 0 {
+				dldr.logVerbose(err)
+				return dldr.refetch(bad, feedbackFunc)
 			}
 		}
+		return
+	}
+	if dldr.checksPGPSignature() {
+		if err = dldr.verifyPGPSignature(dldr.partFilename); err != nil {
+			endVerify(err)
+			return
+		}
+	}
+	endVerify(nil)
+	if len(dldr.expectedDigests()) > 0 || dldr.checksPGPSignature() {
+		dldr.hooks.verified(dldr.filename)
 	}
 
-	err = os.Rename(dldr.partFilename, dldr.filename)
-	return
+	// The partial file is still being streamed
+	if dldr.streams() {
+		return
+	}
+	return dldr.finishFile()
+}
+
+// Internal: move the complete partial file to the output file
+func (dldr *MultiDownloader) finishFile() error {
+	if err := dldr.moveFile(dldr.partFilename, dldr.filename); err != nil {
+		return err
+	}
+	os.Remove(controlPath(dldr.partFilename))
+	os.Remove(trustedPath(dldr.partFilename))
+	if err := dldr.setPermissions(dldr.filename); err != nil {
+		return err
+	}
+	dldr.setModTime()
+	return dldr.recordProvenance()
 }
 
 // Check SHA-256 of downloaded file
@@ -334,7 +1389,9 @@ func (dldr *MultiDownloader) CheckSHA256(sha256hash string) (err error) {
 	}()
 
 	// Compute the SHA256
-	buf := make([]byte, fileReadChunk)
+	pooled := dldr.readBuffers.get()
+	defer dldr.readBuffers.put(pooled)
+	buf := *pooled
 	hash := sha256.New()
 	for {
 		n, err := file.Read(buf)
@@ -377,7 +1434,9 @@ func (dldr *MultiDownloader) CheckMD5(md5sum string) (err error) {
 	}()
 
 	// Compute the MD5SUM
-	buf := make([]byte, fileReadChunk)
+	pooled := dldr.readBuffers.get()
+	defer dldr.readBuffers.put(pooled)
+	buf := *pooled
 	hash := md5.New()
 	for {
 		n, err := file.Read(buf)
@@ -409,6 +1468,84 @@ func (dldr *MultiDownloader) CheckMD5(md5sum string) (err error) {
 ////////////////////////////////////////////////////////////////////////////////
 // Auxiliary functions
 
+// Writer calling a function
+type writerFunc func(p []byte) (int, error)
+
+func (w writerFunc) Write(p []byte) (int, error) {
+	return w(p)
+}
+
+// Get the context of the requests of the downloader
+func (dldr *MultiDownloader) context() context.Context {
+	if dldr.ctx == nil {
+		return context.Background()
+	}
+	return dldr.ctx
+}
+
+// Create a request carrying the extra headers of the downloader
+func (dldr *MultiDownloader) newRequest(method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(dldr.context(), method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range dldr.header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	return req, nil
+}
+
+// Get the partial file of an output file
+func (dldr *MultiDownloader) partName(filename string) string {
+	dir := dldr.tempDir
+	if dir == "" && dldr.tenant != nil {
+		dir = dldr.tenant.limits.TempDir
+	}
+	return partPath(dir, filename, dldr.partSuffix())
+}
+
+// Rename a file, copying it if it's on another file system. The copy is
+// written next to the destination and renamed, so that the destination is
+// never left half-written.
+func (dldr *MultiDownloader) moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	in, errOpen := os.Open(src)
+	if errOpen != nil {
+		return err
+	}
+	defer in.Close()
+	info, errStat := in.Stat()
+	if errStat != nil {
+		return err
+	}
+	out, errCreate := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".*")
+	if errCreate != nil {
+		return err
+	}
+	dldr.logVerbose("Copying ", src, " to ", dst, ": ", err)
+	_, errCopy := io.Copy(out, in)
+	if errCopy == nil {
+		errCopy = out.Sync()
+	}
+	if errCopy == nil {
+		errCopy = out.Chmod(info.Mode().Perm())
+	}
+	if errClose := out.Close(); errCopy == nil {
+		errCopy = errClose
+	}
+	if errCopy == nil {
+		errCopy = os.Rename(out.Name(), dst)
+	}
+	if errCopy != nil {
+		os.Remove(out.Name())
+		return errCopy
+	}
+	return os.Remove(src)
+}
+
 // Get the name of the file from the URL
 func urlToFilename(urlStr string) string {
 	url, err := url.Parse(urlStr)
@@ -418,3 +1555,40 @@ func urlToFilename(urlStr string) string {
 	_, f := path.Split(url.Path)
 	return f
 }
+
+// Compute the digest of a whole file
+func hashFile(filename string, h hash.Hash) ([]byte, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	buf := buffers(defaultReadBufferSize).get()
+	defer buffers(defaultReadBufferSize).put(buf)
+	if _, err := io.CopyBuffer(h, file, *buf); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Split the largest chunks in halves until there are at least n chunks, so
+// that all connections can be used
+func balanceChunks(chunks []Chunk, n int) []Chunk {
+	for len(chunks) > 0 && len(chunks) < n {
+		largest := 0
+		for i, c := range chunks {
+			if c.End-c.Begin > chunks[largest].End-chunks[largest].Begin {
+				largest = i
+			}
+		}
+		c := chunks[largest]
+		if c.End-c.Begin < 2 {
+			break
+		}
+		middle := c.Begin + (c.End-c.Begin)/2
+		chunks = append(chunks[:largest+1], chunks[largest:]...)
+		chunks[largest] = Chunk{c.Begin, middle}
+		chunks[largest+1] = Chunk{middle, c.End}
+	}
+	return chunks
+}
@@ -2,12 +2,23 @@ package multipartdownloader
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -279,3 +290,285 @@ func TestConnectionDropLocal(t *testing.T) {
 	shutdown <- true
 	shutdown <- true
 }
+
+// Download from a local server, returning the error of Download
+func downloadLocal(t *testing.T, handler http.Handler, nConns int, opts ...Option) error {
+	server := httptest.NewServer(handler)
+	defer server.Close()
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/quijote.txt"}, nConns, time.Duration(5000)*time.Millisecond, opts...)
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	return dldr.Download(nil)
+}
+
+// Chunks are requested with the exact inclusive range of HTTP
+func TestChunkRanges(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	var mu sync.Mutex
+	var ranges []string
+	err := downloadLocal(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			mu.Lock()
+			ranges = append(ranges, r.Header.Get("Range"))
+			mu.Unlock()
+		}
+		fileServer.ServeHTTP(w, r)
+	}), 3)
+	failOnError(t, err)
+	sort.Strings(ranges)
+	expected := []string{"bytes=0-105873", "bytes=105874-211747", "bytes=211748-317620"}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("Requested ranges %v, should be %v", ranges, expected)
+	}
+}
+
+// Servers ignoring the range send the whole file, which can't fill a chunk
+func TestIgnoredRange(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	err = downloadLocal(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes") // But it doesn't
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	}), 2)
+	if err == nil {
+		t.Error("Full responses to ranged requests should be rejected")
+	}
+}
+
+// Servers not advertising ranges are downloaded from with a single request
+func TestNoRanges(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	}))
+	defer server.Close()
+	dldr := NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 3, 5*time.Second, WithResume(true))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	out := filepath.Join(t.TempDir(), "quijote.txt")
+	_, err = dldr.SetupFile(out)
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	got, err := ioutil.ReadFile(out)
+	failOnError(t, err)
+	if !bytes.Equal(got, data) || gets != 1 {
+		t.Errorf("Expected the file in a single request, got %d requests", gets)
+	}
+}
+
+// Stops responding after limit bytes, as a dropped connection
+type cuttingWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *cuttingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		w.ResponseWriter.Write(p[:w.limit])
+		panic(http.ErrAbortHandler)
+	}
+	w.limit -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func TestTruncatedResponse(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	err := downloadLocal(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w = &cuttingWriter{w, 10000}
+		}
+		fileServer.ServeHTTP(w, r)
+	}), 2)
+	if err == nil {
+		t.Error("Truncated responses should make the download fail")
+	}
+}
+
+// Responses going on past their chunk are for something else
+func TestLongerResponse(t *testing.T) {
+	data, err := ioutil.ReadFile("./test/quijote.txt")
+	failOnError(t, err)
+	fileServer := http.FileServer(http.Dir("./test"))
+	err = downloadLocal(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.Header.Get("Range") != "bytes=0-158810" {
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(data) // The whole file for the second chunk
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}), 2)
+	if err == nil {
+		t.Error("Responses longer than the chunk should make the download fail")
+	}
+}
+
+// Files shorter than the number of connections have empty chunks
+func TestEmptyChunks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "ab", time.Time{}, strings.NewReader("ab"))
+	}))
+	defer server.Close()
+	dldr := NewMultiDownloader([]string{server.URL + "/ab"}, 4, time.Duration(5000)*time.Millisecond)
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "ab"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	downloaded, err := ioutil.ReadFile(dldr.filename)
+	failOnError(t, err)
+	if string(downloaded) != "ab" {
+		t.Errorf("Downloaded %q instead of \"ab\"", downloaded)
+	}
+}
+
+// Sources are reported as they answer, and a failing one doesn't wait for
+// the others
+func TestGatherInfoStreaming(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	fast := httptest.NewServer(fileServer)
+	defer fast.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+
+	var reported []SourceResult
+	dldr := NewMultiDownloader(
+		[]string{slow.URL + "/quijote.txt", fast.URL + "/quijote.txt"}, 2,
+		time.Duration(5000)*time.Millisecond,
+		WithSourceCallback(func(r SourceResult) { reported = append(reported, r) }))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	if len(reported) != 2 || reported[0].URL != fast.URL+"/quijote.txt" ||
+		reported[0].Err != nil || reported[0].FileLength != dldr.fileLength {
+		t.Errorf("The fast source should be reported first, got %+v", reported)
+	}
+
+	reported = nil
+	dldr = NewMultiDownloader(
+		[]string{slow.URL + "/quijote.txt", fast.URL + "/missing.txt"}, 2,
+		time.Duration(5000)*time.Millisecond, WithMinSources(2),
+		WithSourceCallback(func(r SourceResult) { reported = append(reported, r) }))
+	start := time.Now()
+	if _, err := dldr.GatherInfo(); err == nil {
+		t.Error("A missing file should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("A failing source should return right away, took %v", elapsed)
+	}
+	if len(reported) != 1 || reported[0].StatusCode != http.StatusNotFound || reported[0].Err == nil {
+		t.Errorf("The failing source should be reported, got %+v", reported)
+	}
+	// The slow probe finishes after returning, without blocking or panicking
+	time.Sleep(400 * time.Millisecond)
+}
+
+// Every source gets the whole timeout, however long the others take
+func TestGatherInfoTimeout(t *testing.T) {
+	hang := make(chan bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer server.Close()
+	defer close(hang)
+	dldr := NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 2, 100*time.Millisecond)
+	start := time.Now()
+	if _, err := dldr.GatherInfo(); err == nil {
+		t.Error("A source not answering should fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("The timeout should stop the probe, took %v", elapsed)
+	}
+}
+
+// A file already downloaded with the expected digest isn't downloaded again
+func TestAlreadyComplete(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	var gets int32
+	fileServer := http.FileServer(http.Dir("./test"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	output := filepath.Join(t.TempDir(), "quijote.txt")
+	sum := fmt.Sprintf("%x", sha256.Sum256(data))
+
+	for _, content := range [][]byte{data, bytes.ToUpper(data)} {
+		failOnError(t, ioutil.WriteFile(output, content, 0644))
+		atomic.StoreInt32(&gets, 0)
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/quijote.txt"}, 2, time.Duration(5000)*time.Millisecond, WithSHA256(sum))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(output)
+		complete := bytes.Equal(content, data)
+		if errors.Is(err, ErrAlreadyComplete) != complete {
+			t.Fatalf("SetupFile returned %v", err)
+		}
+		if !complete {
+			failOnError(t, err)
+			failOnError(t, dldr.Download(nil))
+		}
+		if n := atomic.LoadInt32(&gets); (n == 0) != complete {
+			t.Errorf("%d requests downloading a file complete=%v", n, complete)
+		}
+	}
+
+	// Not with the checksums of the sources, nor with CRCs
+	withMD5 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-MD5", "RbtfyWu0xnd40oj7qY7uSA==")
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer withMD5.Close()
+	for _, dldr := range []*MultiDownloader{
+		NewMultiDownloader([]string{withMD5.URL + "/quijote.txt"}, 2, 5*time.Second),
+		NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 2, 5*time.Second,
+			WithCRC32(fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)))),
+	} {
+		failOnError(t, ioutil.WriteFile(output, data, 0644))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		if _, err := dldr.SetupFile(output); errors.Is(err, ErrAlreadyComplete) {
+			t.Errorf("%v taken as already complete without a digest of the caller", dldr.urls)
+		}
+	}
+}
+
+// No handle of the output file is left open once downloaded
+func TestFileClosed(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("Can't list the open files")
+	}
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+	dir := t.TempDir()
+	dldr := NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 3, 5*time.Second, WithResume(true))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(dir, "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	fds, err := os.ReadDir("/proc/self/fd")
+	failOnError(t, err)
+	for _, fd := range fds {
+		if target, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); err == nil &&
+			strings.HasPrefix(target, dir) {
+			t.Errorf("%s is still open", target)
+		}
+	}
+}
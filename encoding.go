@@ -0,0 +1,174 @@
+package multipartdownloader
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Mirrors don't always agree on the representation of the file: some serve
+// it compressed with a Content-Encoding (often gzip) even when asked for the
+// plain bytes. Byte ranges refer to the encoded representation, so chunks
+// from such mirrors can't be decoded on their own nor mixed with the chunks
+// of the others. Those mirrors are left out of the download instead. If no
+// mirror serves the plain file, the encoded one is downloaded and decoded
+// once complete, which is only possible for gzip and deflate. With
+// WithDecoding, it's decoded while downloading instead, with a single
+// request: the decoded bytes can't be mapped back to encoded ranges.
+
+// Decode the file while downloading it, when the sources only serve it
+// encoded, instead of once complete. It's then downloaded with a single
+// request, and can't be resumed.
+func WithDecoding(enabled bool) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.decoding = enabled
+	}
+}
+
+// Internal: whether the file is decoded while downloading
+func (dldr *MultiDownloader) decodesOnTheFly() bool {
+	return dldr.decoding && dldr.encoding != ""
+}
+
+// Internal: the content coding of a response, empty for identity
+func contentEncoding(header http.Header) string {
+	encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+	if encoding == "identity" {
+		return ""
+	}
+	return encoding
+}
+
+// Internal: keep the sources serving the same representation of the file,
+// preferring the plain one, and explain why the others are excluded
+func (dldr *MultiDownloader) compatibleSources(sources []urlInfo) []urlInfo {
+	groups := make(map[string][]urlInfo)
+	var order []string
+	for _, s := range sources {
+		if _, ok := groups[s.encoding]; !ok {
+			order = append(order, s.encoding)
+		}
+		groups[s.encoding] = append(groups[s.encoding], s)
+	}
+	if len(order) == 1 {
+		return sources
+	}
+
+	chosen, ok := "", len(groups[""]) > 0
+	if !ok {
+		chosen = order[0]
+		for _, encoding := range order[1:] {
+			if len(groups[encoding]) > len(groups[chosen]) {
+				chosen = encoding
+			}
+		}
+	}
+	for _, encoding := range order {
+		if encoding == chosen {
+			continue
+		}
+		for _, s := range groups[encoding] {
+			dldr.logWarning(fmt.Sprintf("Excluding %s: it serves the file with Content-Encoding %s, "+
+				"which can't be combined with the %s content of the other sources",
+				s.url, encoding, encodingName(chosen)))
+		}
+	}
+	return groups[chosen]
+}
+
+// Internal: printable name of a content coding
+func encodingName(encoding string) string {
+	if encoding == "" {
+		return "identity"
+	}
+	return encoding
+}
+
+// Internal: check that the file can be decoded when the sources only serve
+// it encoded
+func (dldr *MultiDownloader) checkDecodable(encoding string) error {
+	switch encoding {
+	case "":
+		return nil
+	case "gzip", "x-gzip", "deflate":
+		dldr.logWarning(fmt.Sprintf("The sources only serve the file with Content-Encoding %s, "+
+			"it will be decoded", encoding))
+		return nil
+	}
+	return withHint(errors.New(fmt.Sprintf(
+		"The sources only serve the file with Content-Encoding %s, which can't be decoded", encoding)),
+		"Add a source serving the plain file, or one compressed with gzip or deflate")
+}
+
+// Internal: replace a downloaded file by its decoded content
+func decodeFile(filename, encoding string) error {
+	if encoding == "" {
+		return nil
+	}
+	in, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	zr, err := newDecoder(encoding, in)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	tmp := filename + ".decoded"
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	buf := buffers(defaultReadBufferSize).get()
+	_, err = io.CopyBuffer(out, zr, *buf)
+	buffers(defaultReadBufferSize).put(buf)
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// Internal: reader of the decoded content of an encoded one. Deflate is the
+// zlib format, but some servers send raw deflate data instead.
+func newDecoder(encoding string, r io.Reader) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		br := bufio.NewReader(r)
+		if header, err := br.Peek(2); err == nil &&
+			header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	}
+	return nil, errors.New(fmt.Sprintf("Can't decode Content-Encoding %s", encoding))
+}
+
+// Reader reporting the bytes read, and failing if the report does
+type countingReader struct {
+	r     io.Reader
+	count func(n int) error
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		if errCount := c.count(n); errCount != nil {
+			return n, errCount
+		}
+	}
+	return n, err
+}
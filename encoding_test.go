@@ -0,0 +1,148 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A mirror ignoring Accept-Encoding and always serving the file gzipped
+func newGzipServer(t *testing.T, data []byte) *httptest.Server {
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(data)
+	zw.Close()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("Content-Length", strconv.Itoa(compressed.Len()))
+		http.ServeContent(w, r, "quijote.txt", time.Time{}, bytes.NewReader(compressed.Bytes()))
+	}))
+}
+
+func TestMixedEncodings(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	gzipped := newGzipServer(t, data)
+	defer gzipped.Close()
+	plain := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer plain.Close()
+
+	dldr := NewMultiDownloader(
+		[]string{gzipped.URL + "/quijote.txt", plain.URL + "/quijote.txt"},
+		4,
+		time.Duration(5000)*time.Millisecond)
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	if len(dldr.urls) != 1 || dldr.urls[0] != plain.URL+"/quijote.txt" {
+		t.Fatalf("Only the plain source should be used, got %v", dldr.urls)
+	}
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	downloaded, err := ioutil.ReadFile(dldr.filename)
+	failOnError(t, err)
+	if !bytes.Equal(data, downloaded) {
+		t.Error("The downloaded file differs from the original")
+	}
+}
+
+func TestCompatibleSources(t *testing.T) {
+	sources := []urlInfo{
+		{url: "a", encoding: "gzip"},
+		{url: "b", encoding: "br"},
+		{url: "c", encoding: "gzip"},
+	}
+	kept := (&MultiDownloader{}).compatibleSources(sources)
+	if len(kept) != 2 || kept[0].url != "a" || kept[1].url != "c" {
+		t.Errorf("The most common encoding should be kept without a plain source, got %v", kept)
+	}
+	header := http.Header{}
+	header.Set("Content-Encoding", " Identity")
+	if contentEncoding(header) != "" {
+		t.Error("The identity coding should be normalized")
+	}
+}
+
+// Without a plain source, the gzipped file is downloaded and decoded
+func TestOnlyEncodedSources(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	first := newGzipServer(t, data)
+	defer first.Close()
+	second := newGzipServer(t, data)
+	defer second.Close()
+
+	dldr := NewMultiDownloader(
+		[]string{first.URL + "/quijote.txt", second.URL + "/quijote.txt"},
+		4,
+		time.Duration(5000)*time.Millisecond)
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	downloaded, err := ioutil.ReadFile(dldr.filename)
+	failOnError(t, err)
+	if !bytes.Equal(data, downloaded) {
+		t.Error("The file should be decoded after downloading")
+	}
+
+	brotli := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		http.ServeContent(w, r, "quijote.txt", time.Time{}, bytes.NewReader([]byte("not really brotli")))
+	}))
+	defer brotli.Close()
+	dldr = NewMultiDownloader([]string{brotli.URL + "/quijote.txt"}, 2, time.Duration(5000)*time.Millisecond)
+	if _, err := dldr.GatherInfo(); err == nil {
+		t.Error("Encodings that can't be decoded should be reported")
+	}
+}
+
+// With WithDecoding, the file is decoded with a single request while downloading
+func TestDecodeOnTheFly(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	gzipped := newGzipServer(t, data)
+	defer gzipped.Close()
+
+	var deflated bytes.Buffer
+	zw := zlib.NewWriter(&deflated)
+	zw.Write(data)
+	zw.Close()
+	var gets atomic.Int32
+	deflate := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			gets.Add(1)
+		}
+		w.Header().Set("Content-Encoding", "deflate")
+		w.Header().Set("Content-Length", strconv.Itoa(deflated.Len()))
+		http.ServeContent(w, r, "quijote.txt", time.Time{}, bytes.NewReader(deflated.Bytes()))
+	}))
+	defer deflate.Close()
+
+	for _, url := range []string{gzipped.URL, deflate.URL} {
+		gets.Store(0)
+		dldr := NewMultiDownloader([]string{url + "/quijote.txt"}, 4, 5*time.Second, WithDecoding(true))
+		_, err = dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+		failOnError(t, err)
+		failOnError(t, dldr.Download(nil))
+		downloaded, err := ioutil.ReadFile(dldr.filename)
+		failOnError(t, err)
+		if !bytes.Equal(data, downloaded) {
+			t.Errorf("The file from %s should be decoded while downloading", url)
+		}
+		if url == deflate.URL && gets.Load() != 1 {
+			t.Errorf("Expected a single request, got %d", gets.Load())
+		}
+	}
+}
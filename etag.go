@@ -0,0 +1,67 @@
+package multipartdownloader
+
+import (
+	"encoding/hex"
+	"strings"
+)
+
+// ETags of the sources. They are compared by their value, whether weak
+// (W/"...") or not. A weak ETag can't be sent in If-Range, so an interrupted
+// download is resumed checking the modification date of the file instead.
+// Only a strong ETag of 32 hex digits may be the MD5 of the file: those of
+// the objects uploaded in parts to S3 are like "<hex>-<parts>", the MD5 of
+// the MD5 of the parts.
+//
+// By default, the sources must serve the file with the
+// same ETag, if any. Mirrors often serve the same bytes with different ETags
+// though (another server, another upload of the object): WithMixedETags
+// accepts them, relying on the length of the file and on its checksums, if
+// any, instead. The file then has no ETag, so resuming it checks its
+// modification date, if the sources agree on it, and nothing otherwise.
+
+// Accept sources serving the file with different ETags, as long as they
+// agree on its length
+func WithMixedETags(allowed bool) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.mixedETags = allowed
+	}
+}
+
+// Get the MD5 of the file given by its ETag, if it's one: a strong ETag of
+// 32 hex digits. Weak ETags and those of multipart uploads aren't.
+func (dldr *MultiDownloader) ETagMD5() (string, bool) {
+	if dldr.weakETag || len(dldr.ETag) != 32 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(dldr.ETag); err != nil {
+		return "", false
+	}
+	return strings.ToLower(dldr.ETag), true
+}
+
+// Internal: whether a source serving the file with an ETag serves the file
+// of the downloader, as far as ETags tell
+func (dldr *MultiDownloader) sameETag(etag string) bool {
+	value, _ := parseETag(etag)
+	return dldr.mixedETags || etag == "" || dldr.ETag == "" || value == dldr.ETag
+}
+
+// Internal: whether two ETags are the same, weak or not
+func sameETags(a, b string) bool {
+	valueA, _ := parseETag(a)
+	valueB, _ := parseETag(b)
+	return valueA == valueB
+}
+
+// Internal: the value of an ETag, without W/ and the quotes, and whether it's
+// weak. Values sent without quotes are taken as they are.
+func parseETag(etag string) (value string, weak bool) {
+	value = strings.TrimSpace(etag)
+	if strings.HasPrefix(value, "W/") {
+		value, weak = value[2:], true
+	}
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+	return value, weak
+}
@@ -0,0 +1,130 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Sources serving the file with different ETags are refused, unless allowed
+func TestMixedETags(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	withETag := func(etag string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Etag", etag)
+			fileServer.ServeHTTP(w, r)
+		}))
+	}
+	first, second := withETag(`"abc"`), withETag(`"def-2"`)
+	defer first.Close()
+	defer second.Close()
+	urls := []string{first.URL + "/quijote.txt", second.URL + "/quijote.txt"}
+
+	dldr := NewMultiDownloader(urls, 2, 5*time.Second)
+	_, err := dldr.GatherInfo()
+	var hint *Hint
+	if !errors.As(err, &hint) {
+		t.Fatalf("Expected differing ETags to be refused with a hint, got %v", err)
+	}
+
+	dldr = NewMultiDownloader(urls, 2, 5*time.Second, WithMixedETags(true), WithSHA256(quijoteSHA256))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	if dldr.ETag != "" {
+		t.Errorf("The file has the ETag %q of one of the sources", dldr.ETag)
+	}
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+}
+
+func TestParseETag(t *testing.T) {
+	for etag, want := range map[string][2]interface{}{
+		`"abc"`:     {"abc", false},
+		`W/"abc"`:   {"abc", true},
+		` "abc" `:   {"abc", false},
+		`abc`:       {"abc", false},
+		`"`:         {`"`, false},
+		`W/`:        {"", true},
+		`""`:        {"", false},
+		`"abc-12"`:  {"abc-12", false},
+		`W/"a"b"`:   {`a"b`, true},
+		"":          {"", false},
+		`W/"d41d8"`: {"d41d8", true},
+	} {
+		value, weak := parseETag(etag)
+		if value != want[0] || weak != want[1] {
+			t.Errorf("%s: expected %v, got %q and %v", etag, want, value, weak)
+		}
+	}
+}
+
+// Only strong ETags of 32 hex digits are taken as MD5, weak ones aren't sent
+// in If-Range, and weak and strong ETags of the same value are the same
+func TestETagKinds(t *testing.T) {
+	const md5sum = "d41d8cd98f00b204e9800998ecf8427e"
+	for etag, isMD5 := range map[string]bool{
+		`"` + md5sum + `"`:       true,
+		md5sum:                   true,
+		`W/"` + md5sum + `"`:     false,
+		`"` + md5sum + `-3"`:     false,
+		`"` + md5sum[:31] + `x"`: false,
+		`"abc"`:                  false,
+	} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Etag", etag)
+			w.Header().Set("Last-Modified", "Wed, 21 Oct 2015 07:28:00 GMT")
+			w.Header().Set("Content-Length", "0")
+		}))
+		dldr := NewMultiDownloader([]string{server.URL + "/file"}, 1, 5*time.Second)
+		_, err := dldr.GatherInfo()
+		server.Close()
+		failOnError(t, err)
+		if _, ok := dldr.ETagMD5(); ok != isMD5 {
+			t.Errorf("%s: expected MD5 %v, got %v", etag, isMD5, ok)
+		}
+		if weak := strings.HasPrefix(etag, "W/"); weak != (dldr.validator() != `"`+dldr.ETag+`"`) {
+			t.Errorf("%s: unexpected validator %s", etag, dldr.validator())
+		}
+	}
+
+	weak := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `W/"abc"`)
+		w.Header().Set("Content-Length", "0")
+	}))
+	defer weak.Close()
+	strong := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "0")
+		w.Header().Set("Etag", `"abc"`)
+	}))
+	defer strong.Close()
+	dldr := NewMultiDownloader([]string{weak.URL + "/file", strong.URL + "/file"}, 1, 5*time.Second)
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	if dldr.ETag != "abc" || dldr.validator() != "" {
+		t.Errorf("Expected the ETag abc, weak, got %q and the validator %q", dldr.ETag, dldr.validator())
+	}
+
+	// Gathering again forgets the previous ETag
+	etag := `W/"abc"`
+	changing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "0")
+		if etag != "" {
+			w.Header().Set("Etag", etag)
+		}
+	}))
+	defer changing.Close()
+	dldr = NewMultiDownloader([]string{changing.URL + "/file"}, 1, 5*time.Second)
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	etag = ""
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	if dldr.ETag != "" || dldr.weakETag {
+		t.Errorf("Expected no ETag, got %q (weak %v)", dldr.ETag, dldr.weakETag)
+	}
+}
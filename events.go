@@ -0,0 +1,102 @@
+package multipartdownloader
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Machine-readable events of downloads, for wrappers in other languages and
+// orchestration tools. With WithEventWriter, each hook and progress report
+// of the downloader is written to a writer as a JSON object on its own line:
+//
+//	{"time":"...","type":"start","file":"quijote.txt","size":317621}
+//	{"time":"...","type":"chunk_start","chunk":{"id":0,"begin":0,"end":158810,"url":"...","retry":0}}
+//	{"time":"...","type":"progress","progress":{"total_bytes":317621,"downloaded_bytes":4096,...}}
+//	{"time":"...","type":"complete","file":"quijote.txt"}
+//
+// The types are start, chunk_start, chunk_complete, retry, mirror_switch,
+// verified, complete, error and progress. Durations are in nanoseconds.
+
+// An event of a download, as written by WithEventWriter
+type JSONEvent struct {
+	Time     time.Time        `json:"time"`
+	Type     string           `json:"type"`
+	File     string           `json:"file,omitempty"`
+	Size     int64            `json:"size,omitempty"`
+	Chunk    *ChunkEvent      `json:"chunk,omitempty"`
+	From     string           `json:"from,omitempty"`  // Source left, for mirror_switch
+	To       string           `json:"to,omitempty"`    // Source taken, for mirror_switch
+	Error    string           `json:"error,omitempty"` // Cause of retry and error
+	Progress *ProgressSummary `json:"progress,omitempty"`
+}
+
+// Write the events of the downloads to a writer, one JSON object per line.
+// The writer is only written to by one goroutine at a time.
+func WithEventWriter(w io.Writer) Option {
+	return func(dldr *MultiDownloader) {
+		ew := &eventWriter{enc: json.NewEncoder(w), log: dldr.logWarning}
+		dldr.eventWriter = ew
+		dldr.hooks = append(dldr.hooks, ew.hooks())
+	}
+}
+
+// Internal: the encoder of the events of a downloader
+type eventWriter struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	log    func(...interface{})
+	failed bool // Writing failed already, and was logged
+}
+
+// Internal: write an event, stamping its time
+func (ew *eventWriter) write(event JSONEvent) {
+	event.Time = time.Now().UTC()
+	ew.mu.Lock()
+	defer ew.mu.Unlock()
+	if err := ew.enc.Encode(event); err != nil && !ew.failed {
+		ew.failed = true
+		ew.log("Error writing the events: ", err)
+	}
+}
+
+// Internal: write the progress of the download
+func (ew *eventWriter) progress(summary ProgressSummary) {
+	ew.write(JSONEvent{Type: "progress", Progress: &summary})
+}
+
+// Internal: hooks writing the events
+func (ew *eventWriter) hooks() Hooks {
+	chunk := func(kind string) func(ChunkEvent) {
+		return func(e ChunkEvent) {
+			ew.write(JSONEvent{Type: kind, Chunk: &e})
+		}
+	}
+	return Hooks{
+		OnStart: func(filename string, size int64) {
+			ew.write(JSONEvent{Type: "start", File: filename, Size: size})
+		},
+		OnChunkStart:    chunk("chunk_start"),
+		OnChunkComplete: chunk("chunk_complete"),
+		OnRetry: func(e ChunkEvent, cause error) {
+			event := JSONEvent{Type: "retry", Chunk: &e}
+			if cause != nil {
+				event.Error = cause.Error()
+			}
+			ew.write(event)
+		},
+		OnMirrorSwitch: func(chunk int, from, to string) {
+			ew.write(JSONEvent{Type: "mirror_switch", Chunk: &ChunkEvent{Id: chunk}, From: from, To: to})
+		},
+		OnVerified: func(filename string) {
+			ew.write(JSONEvent{Type: "verified", File: filename})
+		},
+		OnComplete: func(filename string) {
+			ew.write(JSONEvent{Type: "complete", File: filename})
+		},
+		OnError: func(err error) {
+			ew.write(JSONEvent{Type: "error", Error: err.Error()})
+		},
+	}
+}
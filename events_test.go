@@ -0,0 +1,40 @@
+package multipartdownloader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestEventWriter(t *testing.T) {
+	var buf bytes.Buffer
+	err := downloadLocal(t, http.FileServer(http.Dir("./test")), 2, WithEventWriter(&buf))
+	failOnError(t, err)
+
+	var events []JSONEvent
+	types := make(map[string]int)
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var e JSONEvent
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Line %q isn't a JSON event: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+		types[e.Type]++
+	}
+	if len(events) < 2 || events[0].Type != "start" || events[len(events)-1].Type != "complete" {
+		t.Fatalf("Events should go from start to complete, got %v", types)
+	}
+	if events[0].Size != 317621 {
+		t.Errorf("The start event should have the size of the file, got %d", events[0].Size)
+	}
+	if types["chunk_start"] != 2 || types["chunk_complete"] != 2 || types["error"] != 0 {
+		t.Errorf("Expected the events of 2 chunks, got %v", types)
+	}
+	last := events[len(events)-2]
+	if last.Type != "progress" || last.Progress.Percent != 100 {
+		t.Errorf("The final progress should come before completing, got %+v", last)
+	}
+}
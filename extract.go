@@ -0,0 +1,150 @@
+package multipartdownloader
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Extraction of tar archives while downloading. The archive is streamed in
+// order (see WithOutputWriter) to a tar reader extracting its entries, after
+// decompressing it if it's gzip or Zstandard. The parts of the partial file
+// already extracted are released on file systems that can punch holes
+// (Linux), so a large tarball never needs to be on disk as a whole.
+//
+// Entries are only extracted inside the directory: absolute paths, paths
+// going up, and links pointing outside are refused. Devices and FIFOs are
+// skipped.
+
+// Extract the downloaded tar archive (optionally compressed with gzip or
+// zstd) into dir, created if missing, instead of saving it
+func WithExtraction(dir string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.extractDir = dir
+	}
+}
+
+// Internal: a writer extracting the tar archive written to it into dir.
+// Closing it waits for the end of the extraction, and returns its error.
+func (dldr *MultiDownloader) extractWriter(dir string) io.WriteCloser {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := dldr.extractTar(pr, dir)
+		if err == nil {
+			// Trailing padding after the end of the archive
+			_, err = io.Copy(io.Discard, pr)
+		}
+		pr.CloseWithError(err)
+		done <- err
+	}()
+	return &filteredWriter{pw, done}
+}
+
+// Internal: extract a tar archive, decompressing it first if it's gzip or
+// Zstandard
+func (dldr *MultiDownloader) extractTar(r io.Reader, dir string) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(4)
+	var in io.Reader = br
+	var filter OutputFilter
+	switch {
+	case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+		filter = GzipFilter
+	case bytes.HasPrefix(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		filter = ZstdFilter
+	}
+	if filter != nil {
+		dr, err := filter(br)
+		if err != nil {
+			return err
+		}
+		defer dr.Close()
+		in = dr
+	}
+
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return err
+	}
+	tr := tar.NewReader(in)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := dldr.extractEntry(tr, hdr, dir); err != nil {
+			return err
+		}
+	}
+}
+
+// Internal: extract an entry of a tar archive into dir
+func (dldr *MultiDownloader) extractEntry(tr *tar.Reader, hdr *tar.Header, dir string) error {
+	target, err := insideDir(dir, hdr.Name)
+	if err != nil {
+		return err
+	}
+	mode := os.FileMode(hdr.Mode).Perm()
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, mode|0700)
+	case tar.TypeReg, tar.TypeRegA:
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return err
+		}
+		// Never write through a link extracted before
+		os.Remove(target)
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+		return err
+	case tar.TypeSymlink:
+		linked := hdr.Linkname
+		if !filepath.IsAbs(linked) {
+			linked = filepath.Join(filepath.Dir(hdr.Name), linked)
+		}
+		if _, err := insideDir(dir, linked); err != nil || filepath.IsAbs(hdr.Linkname) {
+			return errors.New(fmt.Sprintf("Link %s points outside of the archive: %s", hdr.Name, hdr.Linkname))
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return err
+		}
+		os.Remove(target)
+		return os.Symlink(hdr.Linkname, target)
+	case tar.TypeLink:
+		source, err := insideDir(dir, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+			return err
+		}
+		os.Remove(target)
+		return os.Link(source, target)
+	}
+	dldr.logVerbose("Skipping ", hdr.Name, " of type ", string(hdr.Typeflag))
+	return nil
+}
+
+// Internal: the path of an entry of an archive in dir, if it's inside of it
+func insideDir(dir, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", errors.New(fmt.Sprintf("Entry %s of the archive is outside of it", name))
+	}
+	return filepath.Join(dir, clean), nil
+}
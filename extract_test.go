@@ -0,0 +1,109 @@
+package multipartdownloader
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Internal: a tar archive of the given entries
+func makeTar(t *testing.T, entries []tar.Header, contents map[string]string) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range entries {
+		hdr.Size = int64(len(contents[hdr.Name]))
+		failOnError(t, tw.WriteHeader(&hdr))
+		tw.Write([]byte(contents[hdr.Name]))
+	}
+	failOnError(t, tw.Close())
+	return buf.Bytes()
+}
+
+// Internal: download an archive with WithExtraction into dir
+func extractLocal(t *testing.T, archive []byte, dir string) error {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(archive))
+	}))
+	defer ts.Close()
+	dldr := NewMultiDownloader([]string{ts.URL + "/archive.tar"}, 3, 5*time.Second,
+		WithChunkSize(4<<10), WithExtraction(dir))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "archive.tar"))
+	failOnError(t, err)
+	return dldr.Download(nil)
+}
+
+// Plain, gzip and zstd archives are extracted while downloading
+func TestExtraction(t *testing.T) {
+	big := string(bytes.Repeat([]byte("0123456789"), 5000))
+	archive := makeTar(t, []tar.Header{
+		{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "dir/big.txt", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "dir/link", Typeflag: tar.TypeSymlink, Linkname: "big.txt"},
+		{Name: "small.txt", Typeflag: tar.TypeReg, Mode: 0600},
+	}, map[string]string{"dir/big.txt": big, "small.txt": "small"})
+	var gz, zst bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(archive)
+	gw.Close()
+	zw, err := zstd.NewWriter(&zst)
+	failOnError(t, err)
+	zw.Write(archive)
+	zw.Close()
+
+	for _, data := range [][]byte{archive, gz.Bytes(), zst.Bytes()} {
+		dir := filepath.Join(t.TempDir(), "out")
+		failOnError(t, extractLocal(t, data, dir))
+		got, err := os.ReadFile(filepath.Join(dir, "dir", "link"))
+		failOnError(t, err)
+		if string(got) != big {
+			t.Error("The big file wasn't extracted")
+		}
+		got, err = os.ReadFile(filepath.Join(dir, "small.txt"))
+		failOnError(t, err)
+		if string(got) != "small" {
+			t.Error("The small file wasn't extracted")
+		}
+	}
+}
+
+// Entries can't be extracted outside of the directory
+func TestExtractionOutside(t *testing.T) {
+	for _, hdr := range []tar.Header{
+		{Name: "../evil.txt", Typeflag: tar.TypeReg},
+		{Name: "/tmp/evil.txt", Typeflag: tar.TypeReg},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../.."},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		{Name: "hard", Typeflag: tar.TypeLink, Linkname: "../evil.txt"},
+	} {
+		parent := t.TempDir()
+		archive := makeTar(t, []tar.Header{hdr}, map[string]string{hdr.Name: "evil"})
+		if err := extractLocal(t, archive, filepath.Join(parent, "out")); err == nil {
+			t.Errorf("Expected %s -> %s to be refused", hdr.Name, hdr.Linkname)
+		}
+		if _, err := os.Lstat(filepath.Join(parent, "evil.txt")); err == nil {
+			t.Errorf("%s was extracted outside of the directory", hdr.Name)
+		}
+	}
+}
+
+// The padding after the end of an archive is accepted
+func TestExtractTrailingPadding(t *testing.T) {
+	archive := makeTar(t, []tar.Header{{Name: "a.txt", Typeflag: tar.TypeReg}}, map[string]string{"a.txt": "a"})
+	archive = append(archive, make([]byte, 10240)...)
+	dir := t.TempDir()
+	w := (&MultiDownloader{}).extractWriter(dir)
+	_, err := io.Copy(w, bytes.NewReader(archive))
+	failOnError(t, err)
+	failOnError(t, w.Close())
+}
@@ -0,0 +1,73 @@
+package multipartdownloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Defaults of Fetch, which can be changed with WithConnections and WithTimeout.
+// Files smaller than defaultSingleBelow are downloaded with a single request.
+const (
+	fetchConns   = 4
+	fetchTimeout = 30 * time.Second
+)
+
+// The result of Fetch
+type FetchResult struct {
+	Filename string         // Path of the file
+	Size     int64          // Bytes of the file
+	ETag     string         // ETag of the file, if the sources sent one
+	Sources  []string       // Sources the file was downloaded from
+	Dead     []SourceResult // Sources left out because their probe failed
+	Skipped  bool           // The file was already complete or up to date, nothing was downloaded
+	Duration time.Duration  // Time taken
+	Stats    Stats          // Statistics of the download, zero if skipped
+}
+
+// Download a file in one call: gather the info of the sources, download
+// the file to dest (or the name given by the sources if empty), check it
+// and rename it. By default 4 connections are used, with a timeout of 30
+// seconds, and files under 64 KiB are downloaded with a single request. The
+// download can be canceled with the context.
+func Fetch(ctx context.Context, urls []string, dest string, opts ...Option) (*FetchResult, error) {
+	start := time.Now()
+	opts = append([]Option{WithContext(ctx), WithSingleConnectionBelow(defaultSingleBelow)}, opts...)
+	dldr := NewMultiDownloader(urls, fetchConns, fetchTimeout, opts...)
+	if _, err := dldr.GatherInfo(); err != nil {
+		return nil, err
+	}
+
+	_, err := dldr.SetupFile(dest)
+	skipped := errors.Is(err, ErrAlreadyComplete) || errors.Is(err, ErrNotModified) ||
+		errors.Is(err, ErrSkipped)
+	if err != nil && !skipped {
+		return nil, err
+	}
+	if !skipped {
+		if err := dldr.Download(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := os.Stat(dldr.filename)
+	if err != nil {
+		return nil, err
+	}
+	if !skipped && info.Size() != dldr.fileLength {
+		return nil, errors.New(fmt.Sprintf(
+			"Downloaded %d bytes instead of %d", info.Size(), dldr.fileLength))
+	}
+	return &FetchResult{
+		Filename: dldr.filename,
+		Size:     info.Size(),
+		ETag:     dldr.ETag,
+		Sources:  dldr.Sources(),
+		Dead:     dldr.deadSources,
+		Skipped:  skipped,
+		Duration: time.Since(start),
+		Stats:    dldr.Stats(),
+	}, nil
+}
@@ -0,0 +1,60 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The file is downloaded and renamed in one call
+func TestFetch(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+	dest := filepath.Join(t.TempDir(), "quijote.txt")
+
+	result, err := Fetch(context.Background(), []string{server.URL + "/quijote.txt"}, dest)
+	failOnError(t, err)
+	want, err := os.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	got, err := os.ReadFile(dest)
+	failOnError(t, err)
+	if !bytes.Equal(got, want) {
+		t.Error("Downloaded file differs from the source")
+	}
+	if result.Filename != dest || result.Size != int64(len(want)) || result.Skipped {
+		t.Errorf("Wrong result: %+v", result)
+	}
+
+	// Nothing to do the second time if the digest is known
+	sum := sha256.Sum256(want)
+	result, err = Fetch(context.Background(), []string{server.URL + "/quijote.txt"}, dest,
+		WithSHA256(hex.EncodeToString(sum[:])))
+	failOnError(t, err)
+	if !result.Skipped {
+		t.Error("The complete file was downloaded again")
+	}
+}
+
+// Canceling the context stops the download
+func TestFetchCanceled(t *testing.T) {
+	data := make([]byte, 1<<20)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(slowWriter{w}, r, "data.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, err := Fetch(ctx, []string{server.URL + "/data.bin"}, filepath.Join(t.TempDir(), "data.bin"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the deadline of the context, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package multipartdownloader
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// Info about a source of the file, as gathered when probing it
+type SourceInfo struct {
+	URL        string
+	Header     http.Header // Response headers, nil if the source isn't plain HTTP
+	FileLength int64
+	ETag       string
+	SHA256     string // Expected SHA-256 of the file, if known
+
+	// Name the downloader picks by itself for sources whose URL doesn't name
+	// the file, such as the manifest of a stream. Empty for plain sources.
+	DefaultName string
+}
+
+// Decides the name of the output file from the info of a source. Applications
+// with their own naming conventions (content-addressed stores, date-based
+// layouts...) plug in their own implementation with WithFilenameResolver.
+type FilenameResolver interface {
+	ResolveFilename(info SourceInfo) (string, error)
+}
+
+// Adapter to use ordinary functions as FilenameResolver
+type FilenameResolverFunc func(info SourceInfo) (string, error)
+
+func (f FilenameResolverFunc) ResolveFilename(info SourceInfo) (string, error) {
+	return f(info)
+}
+
+// Default resolver: the last element of the URL path, or the default name
+// for sources that have one
+var URLFilenameResolver FilenameResolver = FilenameResolverFunc(
+	func(info SourceInfo) (string, error) {
+		return info.defaultName(), nil
+	})
+
+// Resolver using the filename suggested by the Content-Disposition header,
+// falling back to the default resolver when there is none
+var ContentDispositionResolver FilenameResolver = FilenameResolverFunc(
+	func(info SourceInfo) (string, error) {
+		if info.Header != nil {
+			_, params, err := mime.ParseMediaType(info.Header.Get("Content-Disposition"))
+			if name := params["filename"]; err == nil && name != "" {
+				// Never let the server choose the directory
+				name = filepath.Base(strings.Replace(name, "\\", "/", -1))
+				if name != "." && name != ".." && name != "/" {
+					return name, nil
+				}
+			}
+		}
+		return info.defaultName(), nil
+	})
+
+// Set the strategy naming the output file, URLFilenameResolver by default.
+// SetupFile can still override the name.
+func WithFilenameResolver(resolver FilenameResolver) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.resolver = resolver
+	}
+}
+
+// Internal: name the output file with the resolver, from the info of a
+// source and what the downloader knows of the file
+func (dldr *MultiDownloader) resolveFilename(info SourceInfo) (string, error) {
+	info.SHA256 = dldr.sha256
+	return dldr.resolver.ResolveFilename(info)
+}
+
+// Internal: the public view of the info of a source
+func (info urlInfo) sourceInfo() SourceInfo {
+	return SourceInfo{
+		URL:        info.url,
+		Header:     info.header,
+		FileLength: info.fileLength,
+		ETag:       info.etag,
+	}
+}
+
+// Internal: the name used when there is nothing better
+func (info SourceInfo) defaultName() string {
+	if info.DefaultName != "" {
+		return info.DefaultName
+	}
+	return urlToFilename(info.URL)
+}
@@ -0,0 +1,68 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestContentDispositionResolver(t *testing.T) {
+	testTable := []struct {
+		disposition string
+		filename    string
+	}{
+		{`attachment; filename="report-2024.pdf"`, "report-2024.pdf"},
+		{`attachment; filename="../../etc/passwd"`, "passwd"},
+		{`attachment; filename="..\evil.exe"`, "evil.exe"},
+		{`inline`, "download.php"},
+		{``, "download.php"},
+	}
+	for _, test := range testTable {
+		header := http.Header{}
+		header.Set("Content-Disposition", test.disposition)
+		name, err := ContentDispositionResolver.ResolveFilename(SourceInfo{
+			URL:    "https://example.com/download.php?id=3",
+			Header: header,
+		})
+		failOnError(t, err)
+		if name != test.filename {
+			t.Errorf("Content-Disposition %q resolved to %q, should be %q",
+				test.disposition, name, test.filename)
+		}
+	}
+}
+
+func TestFilenameResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"8a3f"`)
+		w.Header().Set("Content-Disposition", `attachment; filename="quijote.txt"`)
+		http.ServeFile(w, r, "test/quijote.txt")
+	}))
+	defer server.Close()
+
+	contentAddressed := FilenameResolverFunc(func(info SourceInfo) (string, error) {
+		return "objects/" + info.ETag[1:len(info.ETag)-1], nil
+	})
+	testTable := []struct {
+		resolver FilenameResolver
+		filename string
+	}{
+		{nil, "get"},
+		{ContentDispositionResolver, "quijote.txt"},
+		{contentAddressed, "objects/8a3f"},
+	}
+	for _, test := range testTable {
+		var opts []Option
+		if test.resolver != nil {
+			opts = append(opts, WithFilenameResolver(test.resolver))
+		}
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/files/get"}, 1, time.Duration(5000)*time.Millisecond, opts...)
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		if dldr.filename != test.filename {
+			t.Errorf("Resolved filename is %q, should be %q", dldr.filename, test.filename)
+		}
+	}
+}
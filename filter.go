@@ -0,0 +1,113 @@
+package multipartdownloader
+
+import (
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Output filters transform the file on its way to the destination, such as
+// decompressing a .zst archive while it's downloaded instead of in a second
+// pass over it. The chunks are downloaded to the partial file as usual, and
+// streamed in order through the filters as soon as they are contiguous.
+// Checksums (WithSHA256...) are those of the downloaded file, before the
+// filters.
+
+// Transforms a stream, like a decompressor
+type OutputFilter func(r io.Reader) (io.ReadCloser, error)
+
+// Decompress gzip
+var GzipFilter OutputFilter = func(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// Decompress Zstandard
+var ZstdFilter OutputFilter = func(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+// Decompress Brotli
+var BrotliFilter OutputFilter = func(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(brotli.NewReader(r)), nil
+}
+
+// Decompressors of the file name extensions
+var extensionFilters = map[string]OutputFilter{
+	".gz":  GzipFilter,
+	".tgz": GzipFilter,
+	".zst": ZstdFilter,
+	".br":  BrotliFilter,
+}
+
+// Pass the file through the filters, in order, before writing it to its
+// destination (the output file or writer)
+func WithOutputFilters(filters ...OutputFilter) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.filters = append(dldr.filters, filters...)
+	}
+}
+
+// Get the decompressor of a file from the extension of its name (.gz, .tgz,
+// .zst, .br), and the name of the decompressed file. Returns nil if the name
+// isn't one of a compressed file.
+func FilterForName(name string) (OutputFilter, string) {
+	lower := strings.ToLower(name)
+	for ext, filter := range extensionFilters {
+		if strings.HasSuffix(lower, ext) {
+			base := name[:len(name)-len(ext)]
+			if ext == ".tgz" {
+				base += ".tar"
+			}
+			return filter, base
+		}
+	}
+	return nil, name
+}
+
+// Internal: a writer passing what's written through the filters to w.
+// Closing it waits for the end of the filtered stream, and returns its error.
+func filterWriter(w io.Writer, filters []OutputFilter) io.WriteCloser {
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		var r io.Reader = pr
+		var err error
+		for _, filter := range filters {
+			var fr io.ReadCloser
+			if fr, err = filter(r); err != nil {
+				break
+			}
+			defer fr.Close()
+			r = fr
+		}
+		if err == nil {
+			_, err = io.Copy(w, r)
+		}
+		// Unblock the writer if the filters stop early
+		pr.CloseWithError(err)
+		done <- err
+	}()
+	return &filteredWriter{pw, done}
+}
+
+// The writing end of filterWriter
+type filteredWriter struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (f *filteredWriter) Write(p []byte) (int, error) {
+	return f.pw.Write(p)
+}
+
+func (f *filteredWriter) Close() error {
+	f.pw.Close()
+	return <-f.done
+}
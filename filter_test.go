@@ -0,0 +1,71 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compressed files are decompressed while downloading
+func TestOutputFilters(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	var gz, zst, br bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(data)
+	gw.Close()
+	zw, err := zstd.NewWriter(&zst)
+	failOnError(t, err)
+	zw.Write(data)
+	zw.Close()
+	bw := brotli.NewWriter(&br)
+	bw.Write(data)
+	bw.Close()
+	files := map[string][]byte{"/q.txt.gz": gz.Bytes(), "/q.txt.zst": zst.Bytes(), "/q.txt.br": br.Bytes()}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(files[r.URL.Path]))
+	}))
+	defer ts.Close()
+
+	for name := range files {
+		filter, output := FilterForName(name)
+		if output != "/q.txt" {
+			t.Errorf("Expected %s to be decompressed to /q.txt, got %s", name, output)
+		}
+		dir := t.TempDir()
+		dldr := NewMultiDownloader([]string{ts.URL + name}, 3, 5*time.Second,
+			WithChunkSize(4<<10), WithOutputFilters(filter))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(filepath.Join(dir, output))
+		failOnError(t, err)
+		failOnError(t, dldr.Download(nil))
+		got, err := os.ReadFile(filepath.Join(dir, output))
+		failOnError(t, err)
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s wasn't decompressed", name)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+			t.Errorf("Expected only the decompressed file, found %d files", len(entries))
+		}
+	}
+
+	// Corrupt data fails the download
+	dldr := NewMultiDownloader([]string{ts.URL + "/q.txt.gz"}, 2, 5*time.Second, WithOutputFilters(ZstdFilter))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "q.txt"))
+	failOnError(t, err)
+	if err := dldr.Download(nil); err == nil {
+		t.Error("Expected gzip data to fail with the zstd filter")
+	}
+}
@@ -0,0 +1,143 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Tail-follow mode, for files that grow while they are downloaded (logs,
+// uploads in progress...). Once the advertised length is downloaded, Follow
+// keeps polling the first source and appends whatever was added to the
+// file, until it stops growing or a stop condition is met.
+
+// Bytes before the end of the file downloaded again with the new data, to
+// make sure that the file grew and wasn't replaced
+const followOverlap = 1 << 10
+
+// Returned (wrapped) when a followed file changes instead of growing
+var ErrFileReplaced = errors.New("The file was replaced instead of growing")
+
+// When and how long a file is followed
+type FollowOptions struct {
+	Interval    time.Duration   // Time between polls
+	StableAfter int             // Polls without growth after which the file is complete (default 3)
+	MaxDuration time.Duration   // Stop following after this long, zero for no limit
+	Stop        <-chan struct{} // Closed to stop following
+}
+
+// Follow a downloaded file as it grows, appending the new data to it. Returns
+// nil once a stop condition is met, the file being complete up to the last poll.
+func (dldr *MultiDownloader) Follow(opts FollowOptions) error {
+	if dldr.segments != nil || dldr.encoding != "" {
+		return errors.New("Only plain files can be followed")
+	}
+	if opts.Interval <= 0 {
+		return errors.New("The interval between polls must be positive")
+	}
+	if opts.StableAfter <= 0 {
+		opts.StableAfter = 3
+	}
+	file, err := os.OpenFile(dldr.filename, os.O_RDWR, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var deadline <-chan time.Time
+	if opts.MaxDuration > 0 {
+		timer := time.NewTimer(opts.MaxDuration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for stable := 0; stable < opts.StableAfter; {
+		select {
+		case <-opts.Stop:
+			return nil
+		case <-deadline:
+			return nil
+		case <-time.After(opts.Interval):
+		}
+		grown, err := dldr.appendGrowth(file)
+		if err != nil {
+			return err
+		}
+		if grown {
+			stable = 0
+		} else {
+			stable++
+		}
+	}
+	dldr.logVerbose("The file stopped growing at ", dldr.fileLength, " bytes")
+	return nil
+}
+
+// Internal: append to the file what was added to it since the last poll,
+// returning whether it grew
+func (dldr *MultiDownloader) appendGrowth(file *os.File) (bool, error) {
+	url := dldr.urls[0]
+	info := dldr.probe(dldr.context(), url)
+	if !info.connSuccess || info.statusCode != http.StatusOK {
+		return false, errors.New(fmt.Sprintf("Failed connection to URL %s", url))
+	}
+	if info.fileLength < dldr.fileLength {
+		return false, withHint(fmt.Errorf("%w: it shrank to %d bytes", ErrFileReplaced, info.fileLength),
+			"Download the file again from the start")
+	}
+	if info.fileLength == dldr.fileLength {
+		return false, nil
+	}
+
+	begin := max(0, dldr.fileLength-followOverlap)
+	req, err := dldr.newRequest("GET", httpURL(url), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", begin, info.fileLength-1))
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := dldr.httpClient(url, 0).Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return false, errors.New(fmt.Sprintf("Unexpected status %d", resp.StatusCode))
+	}
+
+	// What we have must be unchanged
+	remote := make([]byte, dldr.fileLength-begin)
+	local := make([]byte, len(remote))
+	if _, err := io.ReadFull(resp.Body, remote); err != nil {
+		return false, err
+	}
+	if _, err := file.ReadAt(local, begin); err != nil {
+		return false, err
+	}
+	if !bytes.Equal(local, remote) {
+		return false, withHint(ErrFileReplaced, "Download the file again from the start")
+	}
+
+	added := info.fileLength - dldr.fileLength
+	n, err := io.Copy(io.NewOffsetWriter(file, dldr.fileLength), io.LimitReader(resp.Body, added))
+	dldr.fileLength += n
+	if dldr.tenant != nil {
+		dldr.tenant.transferred(int(n))
+	}
+	if dldr.quota != nil {
+		if errQuota := dldr.quota.consume(n); err == nil {
+			err = errQuota
+		}
+	}
+	if err == nil && n < added {
+		err = errors.New(fmt.Sprintf("Truncated response, %d of %d bytes", n, added))
+	}
+	if err != nil {
+		return n > 0, err
+	}
+	dldr.logVerbose("The file grew to ", dldr.fileLength, " bytes")
+	return true, nil
+}
@@ -0,0 +1,76 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A file whose content can change while it's served
+type growingFile struct {
+	mu      sync.Mutex
+	content []byte
+}
+
+func (g *growingFile) set(content []byte) {
+	g.mu.Lock()
+	g.content = content
+	g.mu.Unlock()
+}
+
+func (g *growingFile) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	content := g.content
+	g.mu.Unlock()
+	http.ServeContent(w, r, "log.txt", time.Time{}, bytes.NewReader(content))
+}
+
+func followLocal(t *testing.T, file *growingFile, change func()) ([]byte, error) {
+	server := httptest.NewServer(file)
+	defer server.Close()
+	dldr := NewMultiDownloader([]string{server.URL + "/log.txt"}, 3, time.Duration(5000)*time.Millisecond)
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "log.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+
+	go change()
+	err = dldr.Follow(FollowOptions{Interval: 20 * time.Millisecond, StableAfter: 5, MaxDuration: 5 * time.Second})
+	downloaded, errRead := ioutil.ReadFile(dldr.filename)
+	failOnError(t, errRead)
+	return downloaded, err
+}
+
+func TestFollow(t *testing.T) {
+	content := bytes.Repeat([]byte("line of the log\n"), 1000)
+	file := &growingFile{content: content}
+	downloaded, err := followLocal(t, file, func() {
+		for i := 0; i < 3; i++ {
+			content = append(content, bytes.Repeat([]byte("another line\n"), 100*(i+1))...)
+			file.set(content)
+			time.Sleep(30 * time.Millisecond)
+		}
+	})
+	failOnError(t, err)
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("The followed file has %d bytes instead of %d", len(downloaded), len(content))
+	}
+}
+
+func TestFollowReplaced(t *testing.T) {
+	content := bytes.Repeat([]byte("line of the log\n"), 1000)
+	file := &growingFile{content: content}
+	_, err := followLocal(t, file, func() {
+		file.set(bytes.Repeat([]byte("rotated log\n"), 2000))
+	})
+	if !errors.Is(err, ErrFileReplaced) {
+		t.Errorf("Replacing the file should stop following it, got %v", err)
+	}
+}
@@ -1,15 +1,34 @@
 module github.com/alvatar/multipart-downloader
 
-go 1.21.0
+go 1.22
 
 require (
+	github.com/ProtonMail/go-crypto v1.1.6
+	github.com/andybalholm/brotli v1.2.6
+	github.com/cespare/xxhash/v2 v2.3.0
 	github.com/hydrogen18/stoppableListener v0.0.0-20161101122645-827d760f0663
-	github.com/sethgrid/multibar v0.0.0-20160417171508-4bf4cf7b87d6
+	github.com/klauspost/compress v1.18.0
+	github.com/prometheus/client_golang v1.20.5
+	go.opentelemetry.io/otel v1.32.0
+	go.opentelemetry.io/otel/sdk v1.32.0
+	go.opentelemetry.io/otel/trace v1.32.0
+	golang.org/x/crypto v0.25.0
+	golang.org/x/sys v0.27.0
+	lukechampine.com/blake3 v1.4.1
 )
 
 require (
-	github.com/kless/term v0.0.0-20161130133337-e551c64f56c0 // indirect
-	github.com/sethgrid/curse v0.0.0-20181231162520-d4ee583ebf0f // indirect
-	github.com/tredoe/term v0.0.0-20161130133337-e551c64f56c0 // indirect
-	golang.org/x/sys v0.11.0 // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cloudflare/circl v1.3.7 // indirect
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/cpuid/v2 v2.0.9 // indirect
+	github.com/kylelemons/godebug v1.1.0 // indirect
+	github.com/munnerz/goautoneg v0.0.0-20191010083416-a7dc8b61c822 // indirect
+	github.com/prometheus/client_model v0.6.1 // indirect
+	github.com/prometheus/common v0.55.0 // indirect
+	github.com/prometheus/procfs v0.15.1 // indirect
+	go.opentelemetry.io/otel/metric v1.32.0 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
 )
@@ -0,0 +1,114 @@
+package multipartdownloader
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Downloads running in the background. Start returns right away with a
+// Handle, which tells when the download is over and how it went, its
+// progress so far, and cancels it: one goroutine can run and supervise many
+// downloads without a goroutine and a callback of its own for each.
+//
+// The info is gathered and the file set up by Start if they weren't before.
+// ErrJobCanceled is the error of a download canceled with its handle.
+
+// A download running in the background
+type Handle struct {
+	dldr   *MultiDownloader
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+	err    error // Set before done is closed
+
+	mu       sync.Mutex
+	progress ProgressSummary // The latest
+}
+
+// Start downloading in the background, gathering the info and setting up the
+// file first if needed. Returns an error only if the download can't start.
+func (dldr *MultiDownloader) Start() (*Handle, error) {
+	if len(dldr.urls) == 0 {
+		return nil, errors.New("No URLs provided")
+	}
+	if dldr.State() == StateDownloading {
+		return nil, errors.New("The download is already running")
+	}
+	ctx, cancel := context.WithCancelCause(dldr.context())
+	dldr.ctx = ctx
+	h := &Handle{dldr: dldr, cancel: cancel, done: make(chan struct{})}
+	go func() {
+		defer close(h.done)
+		defer cancel(nil)
+		h.err = h.run()
+		if h.err != nil && context.Cause(ctx) == ErrJobCanceled {
+			h.err = ErrJobCanceled
+		}
+	}()
+	return h, nil
+}
+
+// Internal: gather the info, set up the file and download it, as needed
+func (h *Handle) run() error {
+	dldr := h.dldr
+	if dldr.State() == StateNew {
+		if dldr.chunks == nil {
+			if _, err := dldr.GatherInfo(); err != nil {
+				return err
+			}
+		}
+		_, err := dldr.SetupFile("")
+		if errors.Is(err, ErrAlreadyComplete) || errors.Is(err, ErrNotModified) || errors.Is(err, ErrSkipped) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return dldr.Download(func(progress []ConnectionProgress) {
+		summary := Summarize(dldr.fileLength, progress)
+		h.mu.Lock()
+		h.progress = summary
+		h.mu.Unlock()
+	})
+}
+
+// Channel closed once the download is over
+func (h *Handle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Get the error of the download once it's over, nil while it runs or if it
+// succeeded
+func (h *Handle) Err() error {
+	select {
+	case <-h.done:
+		return h.err
+	default:
+		return nil
+	}
+}
+
+// Wait for the download to be over, returning its error
+func (h *Handle) Wait() error {
+	<-h.done
+	return h.err
+}
+
+// Get the latest progress of the download
+func (h *Handle) Progress() ProgressSummary {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.progress
+}
+
+// Cancel the download, its error being then ErrJobCanceled. With WithResume,
+// what was written is kept to resume it.
+func (h *Handle) Cancel() {
+	h.cancel(ErrJobCanceled)
+}
+
+// Get the downloader of the download, for its state and statistics
+func (h *Handle) Downloader() *MultiDownloader {
+	return h.dldr
+}
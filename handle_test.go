@@ -0,0 +1,108 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Start gathers the info, sets up the file and downloads it in the
+// background
+func TestStart(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+	dldr := NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 3, 5*time.Second,
+		WithOutputDir(t.TempDir()), WithSHA256(quijoteSHA256))
+	h, err := dldr.Start()
+	failOnError(t, err)
+	<-h.Done()
+	failOnError(t, h.Err())
+	if p := h.Progress(); p.DownloadedBytes != 317621 || p.Percent != 100 {
+		t.Errorf("Unexpected final progress %+v", p)
+	}
+	if state := h.Downloader().State(); state != StateDone {
+		t.Errorf("State %s once downloaded", state)
+	}
+}
+
+// A download canceled with its handle stops with ErrJobCanceled
+func TestStartCancel(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	started := make(chan struct{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			started <- struct{}{}
+			<-r.Context().Done()
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	dldr := NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 2, 5*time.Second,
+		WithOutputDir(t.TempDir()))
+	h, err := dldr.Start()
+	failOnError(t, err)
+	<-started
+	if err := h.Err(); err != nil {
+		t.Errorf("Error %v while running", err)
+	}
+	if _, err := dldr.Start(); err == nil {
+		t.Error("Started twice")
+	}
+	h.Cancel()
+	if err := h.Wait(); !errors.Is(err, ErrJobCanceled) {
+		t.Errorf("Expected ErrJobCanceled, got %v", err)
+	}
+}
+
+// A download waiting for a connection held by another one is canceled too
+func TestStartCancelWaiting(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	started := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && r.URL.Path == "/busy/quijote.txt" {
+			started <- struct{}{}
+			select {
+			case <-stop:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		http.StripPrefix("/busy", fileServer).ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	for name, option := range map[string]Option{
+		"tenant": WithTenant(NewTenant("alice", TenantLimits{MaxConns: 1})),
+		"queue":  WithQueue(NewQueue(1), 0),
+	} {
+		busy := NewMultiDownloader([]string{server.URL + "/busy/quijote.txt"}, 1, 5*time.Second,
+			WithOutputDir(t.TempDir()), option)
+		hBusy, err := busy.Start()
+		failOnError(t, err)
+		<-started
+
+		waiting := NewMultiDownloader([]string{server.URL + "/busy/quijote.txt"}, 2, 5*time.Second,
+			WithOutputDir(t.TempDir()), option)
+		h, err := waiting.Start()
+		failOnError(t, err)
+		for waiting.State() != StateDownloading {
+			time.Sleep(time.Millisecond)
+		}
+		h.Cancel()
+		select {
+		case <-h.Done():
+			if !errors.Is(h.Err(), ErrJobCanceled) {
+				t.Errorf("%s: expected ErrJobCanceled, got %v", name, h.Err())
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: the waiting download wasn't canceled", name)
+		}
+		hBusy.Cancel()
+		hBusy.Wait()
+	}
+}
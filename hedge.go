@@ -0,0 +1,117 @@
+package multipartdownloader
+
+import (
+	"context"
+	"io"
+	"net/http"
+)
+
+// Hedged requests for the tail of a download. Once every chunk has had a
+// connection, the requests for what's left (split or failed chunks) are sent
+// to two mirrors at once: the first to respond is kept and the other one is
+// cancelled, so that a slow or overloaded mirror doesn't hold the end of the
+// download back.
+
+// Enable or disable hedging the requests of the tail of the download with a
+// second mirror. Disabled by default, as it costs a request per chunk.
+func WithHedging(enabled bool) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.hedging = enabled
+	}
+}
+
+// The outcome of one of the requests of a hedge
+type hedgeResult struct {
+	n    int
+	resp *http.Response
+	err  error
+}
+
+// A body cancelling its request when closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// Internal: whether a response can be the one of a chunk
+func (r hedgeResult) ok() bool {
+	return r.err == nil && r.resp.StatusCode/100 == 2
+}
+
+// Internal: send the requests of a chunk to two mirrors, keeping the first
+// successful response and cancelling the other request. Returns the response
+// and which request it's for. If both fail, the last failure is returned.
+func (dldr *MultiDownloader) hedge(reqs [2]*http.Request) (*http.Response, int, error) {
+	results := make(chan hedgeResult, len(reqs))
+	var cancels [2]context.CancelFunc
+	for n, req := range reqs {
+		ctx, cancel := context.WithCancel(req.Context())
+		cancels[n] = cancel
+		go func(n int, req *http.Request) {
+			resp, err := dldr.chunkClient(req.URL.String()).Do(req)
+			if resp != nil {
+				resp.Body = cancelBody{resp.Body, cancel}
+			}
+			results <- hedgeResult{n, resp, err}
+		}(n, req.WithContext(ctx))
+	}
+
+	// Close the response of the loser, whenever it comes
+	discard := func() {
+		if r := <-results; r.resp != nil {
+			r.resp.Body.Close()
+		} else {
+			cancels[r.n]()
+		}
+	}
+
+	first := <-results
+	if first.ok() {
+		cancels[1-first.n]()
+		go discard()
+		dldr.logVerbose("Hedged request won by ", reqs[first.n].URL)
+		return first.resp, first.n, nil
+	}
+	if first.resp != nil {
+		first.resp.Body.Close()
+	} else {
+		cancels[first.n]()
+	}
+	second := <-results
+	if second.resp == nil {
+		cancels[second.n]()
+	}
+	return second.resp, second.n, second.err
+}
+
+// Internal: the request of the chunk i racing with req, sent to the mirror k
+// of urls, if the download is in its tail and another mirror has a connection left.
+// Returns the mirror of the request and the function releasing its connection.
+func (dldr *MultiDownloader) hedgeRequest(i int, chunk Chunk, req *http.Request, urls []string, k int, tail bool) (*http.Request, int, func()) {
+	if !dldr.hedging || !tail || dldr.segments != nil {
+		return nil, -1, nil
+	}
+	// The same server would be as slow
+	others := make(map[int]bool)
+	for n, u := range urls {
+		if sameHost(u, urls[k]) {
+			others[n] = true
+		}
+	}
+	alt, release := dldr.mirrors.tryAcquire(urls, k+1, others)
+	if alt < 0 {
+		return nil, -1, nil
+	}
+	altReq, err := dldr.chunkRequest(i, chunk, urls[alt])
+	if err != nil {
+		release()
+		return nil, -1, nil
+	}
+	return altReq.WithContext(req.Context()), alt, release
+}
@@ -0,0 +1,63 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The last chunk is requested from both mirrors, the slow one is cancelled
+func TestHedgedRequest(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	cancelled := make(chan bool, 1)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			select {
+			case <-r.Context().Done():
+				cancelled <- true
+				return
+			case <-time.After(5 * time.Second):
+				cancelled <- false
+			}
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer slow.Close()
+	fast := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer fast.Close()
+
+	urls := []string{slow.URL + "/data.bin", fast.URL + "/data.bin"}
+	dldr := NewMultiDownloader(urls, 1, 10*time.Second, WithHedging(true))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	dldr.urls = urls // In the order of the probes
+	out := filepath.Join(t.TempDir(), "data.bin")
+	_, err = dldr.SetupFile(out)
+	failOnError(t, err)
+	started := time.Now()
+	failOnError(t, dldr.Download(nil))
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("Expected the fast mirror to win, the download took %v", elapsed)
+	}
+	got, err := os.ReadFile(out)
+	failOnError(t, err)
+	if !bytes.Equal(got, data) {
+		t.Error("Downloaded file differs from the source")
+	}
+	select {
+	case c := <-cancelled:
+		if !c {
+			t.Error("Expected the request to the slow mirror to be cancelled")
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected a request to the slow mirror")
+	}
+}
@@ -0,0 +1,45 @@
+package multipartdownloader
+
+import "net/http"
+
+// Common failures come with a hint of what to do about them, which can be
+// found with errors.As and shown to the user:
+//
+//	var hint *Hint
+//	if errors.As(err, &hint) {
+//		log.Println("Hint:", hint.Remedy)
+//	}
+
+// An error with a suggestion of how to get past it
+type Hint struct {
+	Err    error
+	Remedy string // What to do about the error
+}
+
+func (h *Hint) Error() string {
+	return h.Err.Error()
+}
+
+func (h *Hint) Unwrap() error {
+	return h.Err
+}
+
+// Internal: attach a remedy to an error
+func withHint(err error, remedy string) error {
+	return &Hint{Err: err, Remedy: remedy}
+}
+
+// Internal: the remedy for a source that couldn't be probed
+func probeRemedy(info urlInfo) string {
+	switch {
+	case !info.connSuccess:
+		return "Check the URL and the network connection, or leave this source out"
+	case info.statusCode == http.StatusUnauthorized || info.statusCode == http.StatusForbidden:
+		return "The server refused access: it may need credentials, like an Authorization header or a cookie"
+	case info.statusCode == http.StatusNotFound || info.statusCode == http.StatusGone:
+		return "The file isn't there: check the URL, or leave this source out"
+	case info.statusCode == http.StatusTooManyRequests || info.statusCode == http.StatusServiceUnavailable:
+		return "The server is overloaded or limiting requests: try again later"
+	}
+	return "Leave this source out, or try again later"
+}
@@ -0,0 +1,53 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHints(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+
+	gather := func(urls ...string) error {
+		dldr := NewMultiDownloader(urls, 2, time.Duration(5000)*time.Millisecond)
+		_, err := dldr.GatherInfo()
+		return err
+	}
+	var hint *Hint
+	err := gather(server.URL + "/missing.txt")
+	if !errors.As(err, &hint) || !strings.Contains(hint.Remedy, "check the URL") {
+		t.Errorf("A missing file should hint at checking the URL, got %v", err)
+	}
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "quijote.txt", time.Time{}, strings.NewReader("another file"))
+	}))
+	defer other.Close()
+	err = gather(server.URL+"/quijote.txt", other.URL+"/quijote.txt")
+	if err == nil {
+		t.Fatal("Different files should be refused")
+	}
+	if !errors.As(err, &hint) || hint.Remedy == "" {
+		t.Errorf("Different files should come with a hint, got %v", err)
+	}
+
+	// Hints keep the error they wrap
+	quota, err := NewQuota(10, Daily, "")
+	failOnError(t, err)
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/quijote.txt"}, 2, time.Duration(5000)*time.Millisecond, WithQuota(quota))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	err = dldr.Download(nil)
+	var quotaErr *QuotaError
+	if !errors.As(err, &hint) || !errors.As(err, &quotaErr) || !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("The quota error should come with a hint, got %v", err)
+	}
+}
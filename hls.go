@@ -0,0 +1,312 @@
+package multipartdownloader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// HLS (HTTP Live Streaming) support. The playlist is resolved to its list of
+// segments, and every segment becomes a chunk of the output file, so they
+// are fetched concurrently by the usual download machinery and end up
+// concatenated in order.
+
+// A piece of the output file fetched from its own URL, as in segmented streams
+type segment struct {
+	url    string
+	offset int64 // Offset of the segment in the remote resource, -1 for all of it
+}
+
+// A parsed M3U8 playlist, either a master playlist listing variants or a
+// media playlist listing segments
+type HLSPlaylist struct {
+	Variants []HLSVariant
+	Segments []MediaSegment
+	Ended    bool // The playlist is complete (#EXT-X-ENDLIST), not a live one
+}
+
+// A variant stream of a master playlist
+type HLSVariant struct {
+	URL        string
+	Bandwidth  int64
+	Resolution string
+}
+
+// A media segment of a stream, or an initialization section
+type MediaSegment struct {
+	URL      string
+	Duration float64
+	Offset   int64 // Offset of a byte range segment, -1 for whole resources
+	Length   int64 // Length of a byte range segment, -1 if unknown
+	Init     bool  // Initialization section rather than media segment
+}
+
+// Parse an M3U8 playlist. Relative URIs are resolved against base.
+func ParseM3U8(r io.Reader, base *url.URL) (*HLSPlaylist, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "#EXTM3U" {
+		return nil, errors.New("Not an M3U8 playlist")
+	}
+
+	playlist := &HLSPlaylist{}
+	var variant *HLSVariant
+	duration := 0.0
+	pendingRange := ""                   // #EXT-X-BYTERANGE applying to the next URI
+	nextOffset := make(map[string]int64) // Where byte ranges without offset continue
+	resolve := func(uri string) (string, error) {
+		ref, err := url.Parse(uri)
+		if err != nil {
+			return "", err
+		}
+		return base.ResolveReference(ref).String(), nil
+	}
+	byteRange := func(spec, uri string) (int64, int64, error) {
+		parts := strings.SplitN(spec, "@", 2)
+		n, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return 0, 0, err
+		}
+		o := nextOffset[uri]
+		if len(parts) == 2 {
+			if o, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+				return 0, 0, err
+			}
+		}
+		return o, n, nil
+	}
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+			bandwidth, _ := strconv.ParseInt(attrs["BANDWIDTH"], 10, 64)
+			variant = &HLSVariant{Bandwidth: bandwidth, Resolution: attrs["RESOLUTION"]}
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.SplitN(strings.TrimPrefix(line, "#EXTINF:"), ",", 2)[0]
+			duration, _ = strconv.ParseFloat(value, 64)
+		case strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
+			pendingRange = strings.TrimPrefix(line, "#EXT-X-BYTERANGE:")
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-MAP:"))
+			uri, err := resolve(attrs["URI"])
+			if err != nil {
+				return nil, err
+			}
+			init := MediaSegment{URL: uri, Offset: -1, Length: -1, Init: true}
+			if spec, ok := attrs["BYTERANGE"]; ok {
+				if init.Offset, init.Length, err = byteRange(spec, uri); err != nil {
+					return nil, err
+				}
+				nextOffset[uri] = init.Offset + init.Length
+			}
+			playlist.Segments = append(playlist.Segments, init)
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-KEY:"))
+			if attrs["METHOD"] != "NONE" {
+				return nil, errors.New("Encrypted HLS streams are not supported")
+			}
+		case line == "#EXT-X-ENDLIST":
+			playlist.Ended = true
+		case strings.HasPrefix(line, "#"):
+			// Other tags are not relevant for downloading
+		default:
+			uri, err := resolve(line)
+			if err != nil {
+				return nil, err
+			}
+			if variant != nil {
+				variant.URL = uri
+				playlist.Variants = append(playlist.Variants, *variant)
+				variant = nil
+				continue
+			}
+			seg := MediaSegment{URL: uri, Duration: duration, Offset: -1, Length: -1}
+			if pendingRange != "" {
+				if seg.Offset, seg.Length, err = byteRange(pendingRange, uri); err != nil {
+					return nil, err
+				}
+				nextOffset[uri] = seg.Offset + seg.Length
+			}
+			playlist.Segments = append(playlist.Segments, seg)
+			duration = 0
+			pendingRange = ""
+		}
+	}
+	return playlist, scanner.Err()
+}
+
+// Internal: parse an attribute list (KEY=value,KEY="quoted, value")
+func parseAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+	for len(s) > 0 {
+		eq := strings.Index(s, "=")
+		if eq < 0 {
+			break
+		}
+		key := strings.TrimSpace(s[:eq])
+		s = s[eq+1:]
+		var value string
+		if strings.HasPrefix(s, "\"") {
+			end := strings.Index(s[1:], "\"")
+			if end < 0 {
+				end = len(s) - 1
+			}
+			value = s[1 : end+1]
+			s = s[min(end+2, len(s)):]
+		} else if comma := strings.Index(s, ","); comma >= 0 {
+			value, s = s[:comma], s[comma:]
+		} else {
+			value, s = s, ""
+		}
+		attrs[key] = value
+		s = strings.TrimPrefix(s, ",")
+	}
+	return attrs
+}
+
+// Resolve an HLS playlist into the segments to download. The first URL must
+// point to a master or media playlist; for master playlists the variant with
+// the highest bandwidth is chosen. This replaces GatherInfo for streams.
+func (dldr *MultiDownloader) GatherHLSInfo() (chunks []Chunk, err error) {
+	if len(dldr.urls) == 0 {
+		return nil, errors.New("No URLs provided")
+	}
+	playlist, err := dldr.fetchPlaylist(dldr.urls[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(playlist.Variants) > 0 {
+		best := playlist.Variants[0]
+		for _, v := range playlist.Variants[1:] {
+			if v.Bandwidth > best.Bandwidth {
+				best = v
+			}
+		}
+		dldr.logVerbose("Selected variant: ", best.URL, " (", best.Bandwidth, " bps)")
+		if playlist, err = dldr.fetchPlaylist(best.URL); err != nil {
+			return nil, err
+		}
+	}
+	if len(playlist.Segments) == 0 {
+		return nil, errors.New("The playlist has no segments")
+	}
+	if !playlist.Ended {
+		dldr.logWarning("Live playlist: only the segments listed now will be downloaded")
+	}
+
+	if err := dldr.setupSegments(playlist.Segments); err != nil {
+		return nil, err
+	}
+	dldr.filename, err = dldr.resolveFilename(SourceInfo{
+		URL:         dldr.urls[0],
+		FileLength:  dldr.fileLength,
+		DefaultName: streamFilename(dldr.urls[0], playlist.Segments),
+	})
+	if err != nil {
+		return nil, err
+	}
+	dldr.setResolvedOutput(dldr.filename)
+
+	dldr.logVerbose("Segments: ", len(playlist.Segments))
+	dldr.logVerbose("File length: ", dldr.fileLength, " bytes")
+	dldr.logVerbose("File name: ", dldr.filename)
+	return dldr.chunks, nil
+}
+
+// Internal: fetch and parse a playlist
+func (dldr *MultiDownloader) fetchPlaylist(playlistURL string) (playlist *HLSPlaylist, err error) {
+	err = dldr.fetchManifest(playlistURL, func(r io.Reader, base *url.URL) error {
+		playlist, err = ParseM3U8(r, base)
+		return err
+	})
+	return
+}
+
+// Internal: fetch a stream manifest and parse it, relative to its final URL
+func (dldr *MultiDownloader) fetchManifest(
+	manifestURL string, parse func(io.Reader, *url.URL) error) error {
+	req, err := dldr.newRequest("GET", manifestURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := dldr.httpClient(manifestURL, dldr.timeout).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(
+			fmt.Sprintf("Failed fetching manifest %s: status %d", manifestURL, resp.StatusCode))
+	}
+	return parse(resp.Body, resp.Request.URL)
+}
+
+// Internal: lay the segments out one after the other in the output file,
+// asking the server for the length of those that aren't byte ranges
+func (dldr *MultiDownloader) setupSegments(segments []MediaSegment) error {
+	lengths := make([]int64, len(segments))
+	errs := make([]error, len(segments))
+	available := make(chan bool, dldr.nConns)
+	var wg sync.WaitGroup
+	for i, seg := range segments {
+		if seg.Length >= 0 {
+			lengths[i] = seg.Length
+			continue
+		}
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			available <- true
+			defer func() { <-available }()
+			info := dldr.probe(dldr.context(), url)
+			if !info.connSuccess || info.statusCode != http.StatusOK {
+				errs[i] = errors.New(fmt.Sprintf("Failed connection to URL %s", url))
+				return
+			}
+			if info.header != nil && info.header.Get("Content-Length") == "" {
+				// Can't lay out the segments without knowing where each one ends
+				errs[i] = errors.New(fmt.Sprintf("Unknown length of segment %s", url))
+				return
+			}
+			lengths[i] = info.fileLength
+		}(i, seg.URL)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	dldr.segments = make([]segment, len(segments))
+	dldr.chunks = make([]Chunk, len(segments))
+	boundary := int64(0)
+	for i, seg := range segments {
+		dldr.segments[i] = segment{url: seg.URL, offset: seg.Offset}
+		dldr.chunks[i] = Chunk{boundary, boundary + lengths[i]}
+		boundary += lengths[i]
+	}
+	dldr.fileLength = boundary
+	return nil
+}
+
+// Internal: name the output of a stream after its manifest, with the
+// extension of the media segments
+func streamFilename(manifestURL string, segments []MediaSegment) string {
+	name := urlToFilename(manifestURL)
+	name = strings.TrimSuffix(name, path.Ext(name))
+	ext := path.Ext(urlToFilename(segments[len(segments)-1].URL))
+	if ext == "" || ext == ".m4s" || segments[0].Init {
+		ext = ".mp4" // Fragmented MP4
+	}
+	return name + ext
+}
@@ -0,0 +1,204 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Serve the test file split into segments, along with playlists for it
+func newHLSServer(t *testing.T, data []byte, nSegments int) *httptest.Server {
+	segLength := (len(data) + nSegments - 1) / nSegments
+	var media, byteRange strings.Builder
+	media.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:10\n")
+	byteRange.WriteString("#EXTM3U\n#EXT-X-VERSION:4\n")
+	mux := http.NewServeMux()
+	for i := 0; i*segLength < len(data); i++ {
+		end := (i + 1) * segLength
+		if end > len(data) {
+			end = len(data)
+		}
+		seg := data[i*segLength : end]
+		fmt.Fprintf(&media, "#EXTINF:9.97,\nsegments/seg%d.ts\n", i)
+		fmt.Fprintf(&byteRange, "#EXTINF:10,\n#EXT-X-BYTERANGE:%d\n/quijote.txt\n", len(seg))
+		mux.HandleFunc(fmt.Sprintf("/hls/segments/seg%d.ts", i), func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "seg.ts", time.Time{}, bytes.NewReader(seg))
+		})
+	}
+	media.WriteString("#EXT-X-ENDLIST\n")
+	byteRange.WriteString("#EXT-X-ENDLIST\n")
+	master := "#EXTM3U\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=150000,RESOLUTION=416x234,CODECS=\"avc1.42e00a,mp4a.40.2\"\nlow/missing.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=640000,RESOLUTION=640x360,CODECS=\"avc1.42e00a,mp4a.40.2\"\nmedia.m3u8\n"
+	playlists := map[string]string{
+		"/hls/master.m3u8":    master,
+		"/hls/media.m3u8":     media.String(),
+		"/hls/byterange.m3u8": byteRange.String(),
+	}
+	for p, content := range playlists {
+		content := content
+		mux.HandleFunc(p, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/vnd.apple.mpegurl")
+			w.Write([]byte(content))
+		})
+	}
+	mux.HandleFunc("/quijote.txt", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "quijote.txt", time.Time{}, bytes.NewReader(data))
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestParseM3U8(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-MAP:URI="init.mp4",BYTERANGE="720@0"
+#EXTINF:4.0,
+#EXT-X-BYTERANGE:1000@720
+video.mp4
+#EXTINF:4.0,
+#EXT-X-BYTERANGE:500
+video.mp4
+#EXT-X-ENDLIST
+`
+	base, _ := url.Parse("http://example.com/stream/index.m3u8")
+	pl, err := ParseM3U8(strings.NewReader(playlist), base)
+	failOnError(t, err)
+	expected := []MediaSegment{
+		{"http://example.com/stream/init.mp4", 0, 0, 720, true},
+		{"http://example.com/stream/video.mp4", 4, 720, 1000, false},
+		{"http://example.com/stream/video.mp4", 4, 1720, 500, false},
+	}
+	if !pl.Ended || len(pl.Segments) != len(expected) {
+		t.Fatalf("Wrong playlist: %+v", pl)
+	}
+	for i := range expected {
+		if pl.Segments[i] != expected[i] {
+			t.Errorf("Segment %d is %+v, should be %+v", i, pl.Segments[i], expected[i])
+		}
+	}
+	if streamFilename(base.String(), pl.Segments) != "index.mp4" {
+		t.Error("Fragmented MP4 streams should be saved as .mp4")
+	}
+
+	encrypted := "#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"key\"\n#EXTINF:4,\na.ts\n"
+	if _, err := ParseM3U8(strings.NewReader(encrypted), base); err == nil {
+		t.Error("Encrypted playlists should be rejected")
+	}
+}
+
+func TestHLSDownload(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	server := newHLSServer(t, data, 7)
+	defer server.Close()
+
+	for _, playlist := range []string{"master.m3u8", "media.m3u8", "byterange.m3u8"} {
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/hls/" + playlist}, 3, time.Duration(5000)*time.Millisecond)
+		_, err := dldr.GatherHLSInfo()
+		failOnError(t, err)
+		if len(dldr.chunks) != 7 || dldr.fileLength != int64(len(data)) {
+			t.Errorf("Wrong segments for %s: %v", playlist, dldr.chunks)
+		}
+		_, err = dldr.SetupFile(filepath.Join(t.TempDir(), dldr.filename))
+		failOnError(t, err)
+		err = dldr.Download(nil)
+		failOnError(t, err)
+
+		downloaded, err := ioutil.ReadFile(dldr.filename)
+		failOnError(t, err)
+		if !bytes.Equal(data, downloaded) {
+			t.Errorf("Stream downloaded from %s differs from the original", playlist)
+		}
+	}
+}
+
+// Segments must end where their probed length says
+func TestHLSSegmentLengths(t *testing.T) {
+	playlist := "#EXTM3U\n#EXTINF:4,\na.ts\n#EXTINF:4,\nb.ts\n#EXT-X-ENDLIST\n"
+	testTable := []struct {
+		name   string
+		handle func(w http.ResponseWriter, r *http.Request)
+	}{
+		// The length of the second segment is unknown
+		{"unknown", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+			w.Write([]byte("BBBB"))
+		}},
+		// The second segment grew after being probed
+		{"longer", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "HEAD" {
+				w.Header().Set("Content-Length", "4")
+				return
+			}
+			w.Write([]byte("BBBBCCCC"))
+		}},
+	}
+	for _, test := range testTable {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/index.m3u8", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(playlist))
+		})
+		mux.HandleFunc("/a.ts", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "a.ts", time.Time{}, strings.NewReader("AAAA"))
+		})
+		mux.HandleFunc("/b.ts", test.handle)
+		server := httptest.NewServer(mux)
+
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/index.m3u8"}, 2, time.Duration(5000)*time.Millisecond)
+		_, err := dldr.GatherHLSInfo()
+		if err == nil {
+			_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "index.ts"))
+			failOnError(t, err)
+			err = dldr.Download(nil)
+		}
+		if err == nil {
+			t.Errorf("Segment with %s length should make the download fail", test.name)
+		}
+		server.Close()
+	}
+}
+
+// Streams are named through the filename resolver too
+func TestHLSFilenameResolver(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	server := newHLSServer(t, data, 3)
+	defer server.Close()
+
+	var defaultName string
+	resolver := FilenameResolverFunc(func(info SourceInfo) (string, error) {
+		defaultName = info.DefaultName
+		return "episode-01" + filepath.Ext(info.DefaultName), nil
+	})
+	testTable := []struct {
+		opts     []Option
+		filename string
+	}{
+		{nil, "media.ts"},
+		{[]Option{WithFilenameResolver(ContentDispositionResolver)}, "media.ts"},
+		{[]Option{WithFilenameResolver(resolver)}, "episode-01.ts"},
+	}
+	for _, test := range testTable {
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/hls/media.m3u8"}, 2, time.Duration(5000)*time.Millisecond, test.opts...)
+		_, err := dldr.GatherHLSInfo()
+		failOnError(t, err)
+		if dldr.filename != test.filename {
+			t.Errorf("Stream named %q, should be %q", dldr.filename, test.filename)
+		}
+	}
+	if defaultName != "media.ts" {
+		t.Errorf("The resolver got %q as default name", defaultName)
+	}
+}
@@ -0,0 +1,106 @@
+package multipartdownloader
+
+import (
+	"time"
+)
+
+// Hooks into the lifecycle of downloads, for integrators wanting to know
+// more than the bytes received: when the download starts and ends, each
+// attempt at a chunk, the retries and mirror switches, and the verification
+// of the file. Any number of hooks can be registered with WithHooks, and are
+// called in the order they were.
+//
+// The hooks of the chunks are called from the goroutines of the connections,
+// concurrently, and must not block for long: the connection waits for them.
+
+// An attempt at downloading a chunk
+type ChunkEvent struct {
+	Id       int           `json:"id"`                 // Index of the chunk
+	Begin    int64         `json:"begin"`              // Beginning of the chunk in the file
+	End      int64         `json:"end"`                // End of the chunk in the file, not included
+	URL      string        `json:"url"`                // Source requested
+	Retry    int           `json:"retry"`              // Attempts at the chunk before this one
+	Bytes    int64         `json:"bytes,omitempty"`    // Bytes written by the attempt, once complete
+	Duration time.Duration `json:"duration,omitempty"` // Time taken by the attempt, once complete
+}
+
+// Functions called along a download, any of them nil
+type Hooks struct {
+	OnStart         func(filename string, size int64)   // The download starts
+	OnChunkStart    func(ChunkEvent)                    // A chunk is requested
+	OnChunkComplete func(ChunkEvent)                    // A chunk is written
+	OnRetry         func(event ChunkEvent, cause error) // A chunk is requested again after an error
+	OnMirrorSwitch  func(chunk int, from, to string)    // A chunk is requested from another source
+	OnVerified      func(filename string)               // The digests or signature of the file match
+	OnComplete      func(filename string)               // The file is downloaded
+	OnError         func(error)                         // The download failed
+}
+
+// Register hooks called along the downloads
+func WithHooks(hooks Hooks) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.hooks = append(dldr.hooks, hooks)
+	}
+}
+
+// Internal: the hooks registered with a downloader
+type hookList []Hooks
+
+func (l hookList) start(filename string, size int64) {
+	for _, h := range l {
+		if h.OnStart != nil {
+			h.OnStart(filename, size)
+		}
+	}
+}
+
+func (l hookList) chunkStart(event ChunkEvent) {
+	for _, h := range l {
+		if h.OnChunkStart != nil {
+			h.OnChunkStart(event)
+		}
+	}
+}
+
+func (l hookList) chunkComplete(event ChunkEvent) {
+	for _, h := range l {
+		if h.OnChunkComplete != nil {
+			h.OnChunkComplete(event)
+		}
+	}
+}
+
+func (l hookList) retry(event ChunkEvent, cause error) {
+	for _, h := range l {
+		if h.OnRetry != nil {
+			h.OnRetry(event, cause)
+		}
+	}
+}
+
+func (l hookList) mirrorSwitch(chunk int, from, to string) {
+	for _, h := range l {
+		if h.OnMirrorSwitch != nil {
+			h.OnMirrorSwitch(chunk, from, to)
+		}
+	}
+}
+
+func (l hookList) verified(filename string) {
+	for _, h := range l {
+		if h.OnVerified != nil {
+			h.OnVerified(filename)
+		}
+	}
+}
+
+// Internal: OnComplete or OnError, depending on how the download ended
+func (l hookList) end(filename string, err error) {
+	for _, h := range l {
+		if err == nil && h.OnComplete != nil {
+			h.OnComplete(filename)
+		} else if err != nil && h.OnError != nil {
+			h.OnError(err)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Serves the info of the file, but fails the chunks
+func failingChunks() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		http.FileServer(http.Dir("./test")).ServeHTTP(w, r)
+	})
+}
+
+func TestHooks(t *testing.T) {
+	good := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer good.Close()
+	bad := httptest.NewServer(failingChunks())
+	defer bad.Close()
+
+	var mu sync.Mutex
+	calls := make(map[string]int)
+	called := func(name string) {
+		mu.Lock()
+		calls[name]++
+		mu.Unlock()
+	}
+	written := int64(0)
+	hooks := Hooks{
+		OnStart: func(filename string, size int64) {
+			if size != 317621 {
+				t.Errorf("OnStart should get the size of the file, got %d", size)
+			}
+			called("start")
+		},
+		OnChunkStart: func(ChunkEvent) { called("chunk") },
+		OnChunkComplete: func(e ChunkEvent) {
+			mu.Lock()
+			written += e.Bytes
+			mu.Unlock()
+			called("complete")
+		},
+		OnRetry: func(e ChunkEvent, cause error) {
+			if e.Retry == 0 || cause == nil {
+				t.Errorf("OnRetry should get the attempts and the error before, got %d and %v", e.Retry, cause)
+			}
+			called("retry")
+		},
+		OnMirrorSwitch: func(chunk int, from, to string) {
+			if !strings.HasPrefix(from, bad.URL) || !strings.HasPrefix(to, good.URL) {
+				t.Errorf("Chunk %d should switch from the failing mirror, switched from %s to %s", chunk, from, to)
+			}
+			called("switch")
+		},
+		OnVerified: func(string) { called("verified") },
+		OnComplete: func(string) { called("done") },
+		OnError:    func(error) { called("error") },
+	}
+
+	dldr := NewMultiDownloader(
+		[]string{bad.URL + "/quijote.txt", good.URL + "/quijote.txt"},
+		2, 5*time.Second, WithHooks(hooks), WithSHA256(quijoteSHA256))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+
+	for _, name := range []string{"start", "verified", "done"} {
+		if calls[name] != 1 {
+			t.Errorf("Hook %s should be called once, got %d", name, calls[name])
+		}
+	}
+	if calls["retry"] == 0 || calls["switch"] == 0 || calls["error"] != 0 {
+		t.Errorf("Expected retries and mirror switches but no error, got %v", calls)
+	}
+	if calls["chunk"] != calls["complete"]+calls["retry"] {
+		t.Errorf("Each chunk should be requested until complete, got %v", calls)
+	}
+	if written != 317621 {
+		t.Errorf("Complete chunks should add up to the file, got %d bytes", written)
+	}
+
+	// A download failing with all the sources
+	calls = make(map[string]int)
+	dldr = NewMultiDownloader([]string{bad.URL + "/quijote.txt"}, 2, 5*time.Second, WithHooks(hooks))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	if err := dldr.Download(nil); err == nil {
+		t.Fatal("Download from a failing source should fail")
+	}
+	if calls["error"] != 1 || calls["done"] != 0 {
+		t.Errorf("OnError should be called once the download fails, got %v", calls)
+	}
+}
@@ -0,0 +1,100 @@
+package multipartdownloader
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+)
+
+// Digests as strings naming their algorithm, as manifests and lockfiles give
+// them: Subresource Integrity ("sha256-<base64>", "sha512-<base64>"...), or
+// "<algorithm>:<hex>" like the digests of container images. Verify checks a
+// file against any of them, and WithIntegrity has it checked while it's
+// downloaded.
+
+// Hashes of the algorithms of integrity strings
+var integrityAlgorithms = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+	"md5":    md5.New,
+}
+
+// Parse an integrity string, "sha256-<base64>" or "sha256:<hex>". Of SRI
+// lists of digests, separated by spaces, the strongest is taken.
+func ParseIntegrity(s string) (ChecksumEntry, error) {
+	var best ChecksumEntry
+	for _, field := range strings.Fields(s) {
+		field, _, _ = strings.Cut(field, "?") // SRI options
+		var e ChecksumEntry
+		if algorithm, value, ok := strings.Cut(field, ":"); ok {
+			if _, err := hex.DecodeString(value); err != nil {
+				return ChecksumEntry{}, errors.New(fmt.Sprintf("Invalid digest in %q", field))
+			}
+			e = ChecksumEntry{Algorithm: strings.ToLower(algorithm), Sum: strings.ToLower(value)}
+		} else if algorithm, value, ok := strings.Cut(field, "-"); ok {
+			sum, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return ChecksumEntry{}, errors.New(fmt.Sprintf("Invalid digest in %q", field))
+			}
+			e = ChecksumEntry{Algorithm: strings.ToLower(algorithm), Sum: hex.EncodeToString(sum)}
+		}
+		h, known := integrityAlgorithms[e.Algorithm]
+		if !known || len(e.Sum) != 2*h().Size() {
+			return ChecksumEntry{}, errors.New(fmt.Sprintf("Unsupported integrity %q", field))
+		}
+		if best.Sum == "" || len(e.Sum) > len(best.Sum) {
+			best = e
+		}
+	}
+	if best.Sum == "" {
+		return ChecksumEntry{}, errors.New("Empty integrity string")
+	}
+	return best, nil
+}
+
+// Check the file against the digest of an integrity string while it's
+// downloaded, like with WithSHA256
+func WithIntegrity(integrity string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.integrity = integrity
+	}
+}
+
+// Check downloaded file against an integrity string
+func (dldr *MultiDownloader) Verify(integrity string) error {
+	e, err := ParseIntegrity(integrity)
+	if err != nil {
+		return err
+	}
+	return checkFileDigest(dldr.filename, strings.ToUpper(e.Algorithm), integrityAlgorithms[e.Algorithm](), e.Sum)
+}
+
+// Internal: the digest of the integrity string of the file, if any
+func (dldr *MultiDownloader) integrityDigest() []expectedDigest {
+	if dldr.integrity == "" {
+		return nil
+	}
+	e, err := ParseIntegrity(dldr.integrity)
+	if err != nil {
+		return nil // Refused by checkIntegrity
+	}
+	return []expectedDigest{{strings.ToUpper(e.Algorithm), integrityAlgorithms[e.Algorithm](), e.Sum}}
+}
+
+// Internal: refuse invalid integrity strings before downloading
+func (dldr *MultiDownloader) checkIntegrity() error {
+	if dldr.integrity == "" {
+		return nil
+	}
+	_, err := ParseIntegrity(dldr.integrity)
+	return err
+}
@@ -0,0 +1,183 @@
+package multipartdownloader
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Git LFS support. A pointer file stands in the repository for the real
+// object, which is located through the batch API of the LFS server and then
+// downloaded as usual, checking its SHA-256 against the object ID.
+
+const lfsMediaType = "application/vnd.git-lfs+json"
+
+// The object referenced by a Git LFS pointer file
+type LFSPointer struct {
+	OID  string // SHA-256 of the object, in hex
+	Size int64
+}
+
+var lfsOID = regexp.MustCompile(`^sha256:([0-9a-f]{64})$`)
+
+// Parse a Git LFS pointer file
+func ParseLFSPointer(r io.Reader) (*LFSPointer, error) {
+	scanner := bufio.NewScanner(r)
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), "version https://git-lfs") {
+		return nil, errors.New("Not a Git LFS pointer")
+	}
+	pointer := &LFSPointer{Size: -1}
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(scanner.Text(), " ")
+		switch key {
+		case "oid":
+			m := lfsOID.FindStringSubmatch(value)
+			if m == nil {
+				return nil, errors.New(fmt.Sprintf("Unsupported LFS object ID %q", value))
+			}
+			pointer.OID = m[1]
+		case "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return nil, errors.New(fmt.Sprintf("Invalid LFS object size %q", value))
+			}
+			pointer.Size = size
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if pointer.OID == "" || pointer.Size < 0 {
+		return nil, errors.New("Incomplete Git LFS pointer")
+	}
+	return pointer, nil
+}
+
+// Batch API messages
+type lfsBatchRequest struct {
+	Operation string      `json:"operation"`
+	Transfers []string    `json:"transfers"`
+	Objects   []lfsObject `json:"objects"`
+}
+
+type lfsObject struct {
+	OID     string `json:"oid"`
+	Size    int64  `json:"size"`
+	Actions *struct {
+		Download *struct {
+			Href   string            `json:"href"`
+			Header map[string]string `json:"header"`
+		} `json:"download"`
+	} `json:"actions,omitempty"`
+	Error *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+type lfsBatchResponse struct {
+	Transfer string      `json:"transfer"`
+	Objects  []lfsObject `json:"objects"`
+	Message  string      `json:"message"`
+}
+
+// Resolve a Git LFS object into its download URL, using the batch API of the
+// repository endpoint (https://host/org/repo.git or its .../info/lfs URL).
+// This replaces GatherInfo: the length is known from the pointer and the
+// SHA-256 of the download is verified against the object ID before the file
+// is given its final name. Headers set with WithHeader authenticate the batch
+// request; the object itself is fetched with the headers of the LFS server's
+// answer only.
+func (dldr *MultiDownloader) GatherLFSInfo(endpoint string, pointer LFSPointer) (chunks []Chunk, err error) {
+	batch, err := json.Marshal(lfsBatchRequest{
+		Operation: "download",
+		Transfers: []string{"basic"},
+		Objects:   []lfsObject{{OID: pointer.OID, Size: pointer.Size}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	batchURL := lfsEndpoint(endpoint) + "/objects/batch"
+	req, err := dldr.newRequest("POST", batchURL, bytes.NewReader(batch))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", lfsMediaType)
+	req.Header.Set("Content-Type", lfsMediaType)
+	resp, err := dldr.httpClient(batchURL, dldr.timeout).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result lfsBatchResponse
+	decodeErr := json.NewDecoder(resp.Body).Decode(&result)
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf(
+			"LFS batch request to %s failed with status %d %s", batchURL, resp.StatusCode, result.Message))
+	}
+	if decodeErr != nil {
+		return nil, decodeErr
+	}
+	if result.Transfer != "" && result.Transfer != "basic" {
+		return nil, errors.New(fmt.Sprintf("Unsupported LFS transfer adapter %q", result.Transfer))
+	}
+	if len(result.Objects) != 1 || result.Objects[0].OID != pointer.OID {
+		return nil, errors.New("The LFS server didn't return the requested object")
+	}
+	object := result.Objects[0]
+	if object.Error != nil {
+		return nil, errors.New(fmt.Sprintf(
+			"LFS object %s: %s (%d)", pointer.OID, object.Error.Message, object.Error.Code))
+	}
+	if object.Actions == nil || object.Actions.Download == nil {
+		return nil, errors.New(fmt.Sprintf("No download action for LFS object %s", pointer.OID))
+	}
+
+	// The object is usually on another host (object storage, CDN) with its
+	// own credentials: only the headers of the download action are sent there,
+	// never the ones given for the LFS server
+	download := object.Actions.Download
+	dldr.urls = []string{download.Href}
+	dldr.header = http.Header{}
+	for key, value := range download.Header {
+		dldr.header.Set(key, value)
+	}
+	dldr.fileLength = pointer.Size
+	dldr.sha256 = pointer.OID
+	dldr.filename, err = dldr.resolveFilename(SourceInfo{
+		URL:        download.Href,
+		FileLength: pointer.Size,
+	})
+	if err != nil {
+		return nil, err
+	}
+	dldr.setResolvedOutput(dldr.filename)
+
+	dldr.logVerbose("LFS object: ", pointer.OID)
+	dldr.logVerbose("File length: ", dldr.fileLength, " bytes")
+	dldr.logVerbose("File name: ", dldr.filename)
+
+	dldr.buildChunks()
+	return dldr.chunks, nil
+}
+
+// Internal: the LFS server URL of a repository
+func lfsEndpoint(endpoint string) string {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	switch {
+	case strings.HasSuffix(endpoint, "/info/lfs"):
+		return endpoint
+	case strings.HasSuffix(endpoint, ".git"):
+		return endpoint + "/info/lfs"
+	default:
+		return endpoint + ".git/info/lfs"
+	}
+}
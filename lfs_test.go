@@ -0,0 +1,115 @@
+package multipartdownloader
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLFSPointer(t *testing.T) {
+	pointer, err := ParseLFSPointer(strings.NewReader(
+		"version https://git-lfs.github.com/spec/v1\n" +
+			"oid sha256:4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393\n" +
+			"size 12345\n"))
+	failOnError(t, err)
+	expected := LFSPointer{"4d7a214614ab2935c943f9e0ff69d22eadbb8f32b1258daaa5e2ca24d17e2393", 12345}
+	if *pointer != expected {
+		t.Errorf("Parsed pointer is %+v, should be %+v", *pointer, expected)
+	}
+	if _, err := ParseLFSPointer(strings.NewReader("just a text file\n")); err == nil {
+		t.Error("Files that aren't pointers should be rejected")
+	}
+	if _, err := ParseLFSPointer(strings.NewReader(
+		"version https://git-lfs.github.com/spec/v1\noid sha256:abc\nsize 3\n")); err == nil {
+		t.Error("Malformed object IDs should be rejected")
+	}
+}
+
+func TestLFSEndpoint(t *testing.T) {
+	for _, endpoint := range []string{
+		"https://example.com/org/repo",
+		"https://example.com/org/repo.git",
+		"https://example.com/org/repo.git/info/lfs/",
+	} {
+		if lfsEndpoint(endpoint) != "https://example.com/org/repo.git/info/lfs" {
+			t.Errorf("Wrong LFS server URL for %s: %s", endpoint, lfsEndpoint(endpoint))
+		}
+	}
+}
+
+func TestLFSDownload(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	oid := fmt.Sprintf("%x", sha256.Sum256(data))
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/org/repo.git/info/lfs/objects/batch":
+			if r.Header.Get("X-Git-Token") != "repo-token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			var batch lfsBatchRequest
+			if err := json.NewDecoder(r.Body).Decode(&batch); err != nil || batch.Operation != "download" {
+				w.WriteHeader(http.StatusUnprocessableEntity)
+				return
+			}
+			w.Header().Set("Content-Type", lfsMediaType)
+			obj := batch.Objects[0]
+			fmt.Fprintf(w, `{"transfer":"basic","objects":[{"oid":%q,"size":%d,"actions":`+
+				`{"download":{"href":"%s/storage/%s","header":{"Authorization":"RemoteAuth s3cr3t"}}}}]}`,
+				obj.OID, obj.Size, server.URL, obj.OID)
+		case strings.HasPrefix(r.URL.Path, "/storage/"):
+			if r.Header.Get("Authorization") != "RemoteAuth s3cr3t" || r.Header.Get("X-Git-Token") != "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			// Every object has the same content, so only one of them matches its OID
+			http.ServeFile(w, r, "test/quijote.txt")
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	testTable := []struct {
+		oid string
+		ok  bool
+	}{
+		{oid, true},
+		{strings.Repeat("0", 64), false},
+	}
+	for _, test := range testTable {
+		// The token of the Git host must not reach the storage
+		dldr := NewMultiDownloader(nil, 3, time.Duration(5000)*time.Millisecond,
+			WithHeader("X-Git-Token", "repo-token"))
+		_, err := dldr.GatherLFSInfo(server.URL+"/org/repo", LFSPointer{test.oid, int64(len(data))})
+		failOnError(t, err)
+		output := filepath.Join(t.TempDir(), "quijote.txt")
+		_, err = dldr.SetupFile(output)
+		failOnError(t, err)
+		err = dldr.Download(nil)
+		if test.ok {
+			failOnError(t, err)
+			if _, err := os.Stat(output); err != nil {
+				t.Error("The verified download should have its final name")
+			}
+		} else {
+			if err == nil {
+				t.Error("Downloads not matching the object ID should fail")
+			}
+			if _, err := os.Stat(output); err == nil {
+				t.Error("Downloads not matching the object ID shouldn't be renamed")
+			}
+		}
+	}
+}
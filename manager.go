@@ -0,0 +1,299 @@
+package multipartdownloader
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// Download managers run many downloads under global limits, for batch users
+// who would otherwise have to orchestrate the downloaders themselves. Jobs
+// added to a manager wait in its queue, in the order they were added, until
+// fewer than MaxDownloads are running. The downloads running share the
+// connections and bandwidth of the manager, and the transports of the
+// mirrors: their idle connections and TLS sessions. Jobs with their own TLS
+// configuration or timeouts get transports of their own.
+//
+// Jobs have priorities: the queue is ordered by priority, and then by the
+// order the jobs were added, unless moved to the front. With MaxConns, the
+// connections are those of a priority queue (see Queue), so that a running
+// job of higher priority takes the connections of the jobs of lower one: an
+// urgent download can jump ahead of a bulk backfill without canceling it.
+// Priorities can be changed while the jobs are queued or running.
+//
+// The limits of the bandwidth are those of a tenant of the manager, so the
+// jobs can't be given a tenant of their own.
+
+// Returned by the jobs canceled, queued or running, and by the downloads
+// canceled with their Handle
+var ErrJobCanceled = errors.New("The download was canceled")
+
+// Limits of a manager. Zero values mean no limit.
+type ManagerLimits struct {
+	MaxDownloads int   // Downloads running at once
+	MaxConns     int   // Connections open at once, across all the downloads
+	MaxRate      int64 // Bytes per second, across all the downloads
+}
+
+// A download for a manager
+type Job struct {
+	URLs     []string
+	Dest     string   // As for Fetch: the output file, or the name given by the sources if empty
+	Options  []Option // On top of the ones of the manager
+	Priority int      // Higher first, changed with SetPriority
+}
+
+// State of a job
+type JobState int
+
+const (
+	JobQueued JobState = iota
+	JobRunning
+	JobDone
+	JobFailed
+	JobCanceled
+)
+
+func (s JobState) String() string {
+	return [...]string{"queued", "running", "done", "failed", "canceled"}[s]
+}
+
+// A job added to a manager
+type ManagedJob struct {
+	Job
+
+	manager *Manager
+	ctx     context.Context
+	cancel  context.CancelFunc
+	done    chan struct{}
+	queued  *queueJob // Its connections, if limited
+
+	// Guarded by the lock of the manager
+	priority int // Current priority, the one of the Job at first
+	seq      int // Order in the queue among the jobs of the same priority
+	state    JobState
+	result   *FetchResult
+	err      error
+}
+
+// Statistics of a manager
+type ManagerStats struct {
+	Queued          int   // Jobs waiting to run
+	Running         int   // Jobs running
+	Done            int   // Jobs finished successfully
+	Failed          int   // Jobs finished with an error, or canceled
+	Connections     int   // Connections open
+	BytesDownloaded int64 // Bytes received by all the jobs
+}
+
+// Runs jobs under global limits
+type Manager struct {
+	limits     ManagerLimits
+	opts       []Option
+	tenant     *Tenant
+	conns      *Queue // Connections of the jobs, nil if unlimited
+	transports transportSet
+	ctx        context.Context
+	cancel     context.CancelFunc
+
+	mu      sync.Mutex
+	queue   []*ManagedJob
+	running int
+	seq     int // Of the last job added
+	first   int // Of the last job moved to the front
+	stats   ManagerStats
+	jobs    sync.WaitGroup
+}
+
+// Create a manager with the given limits, whose jobs are downloaded with the
+// given options, as Fetch does
+func NewManager(limits ManagerLimits, opts ...Option) *Manager {
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		limits: limits,
+		opts:   opts,
+		tenant: NewTenant("manager", TenantLimits{MaxRate: limits.MaxRate}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	if limits.MaxConns > 0 {
+		m.conns = NewQueue(limits.MaxConns)
+	}
+	return m
+}
+
+// Add a job to the queue of the manager, starting it right away if the
+// limit of downloads allows it
+func (m *Manager) Add(job Job) *ManagedJob {
+	ctx, cancel := context.WithCancel(m.ctx)
+	j := &ManagedJob{Job: job, manager: m, ctx: ctx, cancel: cancel, done: make(chan struct{}), priority: job.Priority}
+	if m.conns != nil {
+		j.queued = &queueJob{queue: m.conns, priority: job.Priority}
+	}
+	m.jobs.Add(1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.seq++
+	j.seq = m.seq
+	m.queue = append(m.queue, j)
+	m.schedule()
+	return j
+}
+
+// Wait for all the jobs added to finish
+func (m *Manager) Wait() {
+	m.jobs.Wait()
+}
+
+// Cancel all the jobs, queued or running, wait for them to stop, and close
+// the idle connections to the mirrors
+func (m *Manager) Close() {
+	m.cancel()
+	m.mu.Lock()
+	queued := m.queue
+	m.queue = nil
+	m.mu.Unlock()
+	for _, j := range queued {
+		m.finish(j, nil, ErrJobCanceled)
+	}
+	m.jobs.Wait()
+	m.transports.closeIdleConnections()
+}
+
+// Get a snapshot of the statistics of the manager
+func (m *Manager) Stats() ManagerStats {
+	tenant := m.tenant.Stats()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := m.stats
+	stats.Queued = len(m.queue)
+	stats.Running = m.running
+	stats.Connections = tenant.Connections
+	if m.conns != nil {
+		stats.Connections = m.conns.Stats().Connections // Not those waiting
+	}
+	stats.BytesDownloaded = tenant.BytesDownloaded
+	return stats
+}
+
+// Internal: start the queued jobs the limit of downloads allows. Must be
+// called with the lock held.
+func (m *Manager) schedule() {
+	sort.SliceStable(m.queue, func(i, k int) bool {
+		a, b := m.queue[i], m.queue[k]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		return a.seq < b.seq
+	})
+	for len(m.queue) > 0 && (m.limits.MaxDownloads <= 0 || m.running < m.limits.MaxDownloads) {
+		j := m.queue[0]
+		m.queue = m.queue[1:]
+		m.running++
+		j.state = JobRunning
+		go m.run(j)
+	}
+}
+
+// Internal: download a job, and start the next ones
+func (m *Manager) run(j *ManagedJob) {
+	opts := append(append([]Option{}, m.opts...), j.Options...)
+	opts = append(opts, WithTenant(m.tenant), withTransports(&m.transports))
+	if j.queued != nil {
+		opts = append(opts, withQueueJob(j.queued))
+	}
+	result, err := Fetch(j.ctx, j.URLs, j.Dest, opts...)
+	m.mu.Lock()
+	m.running--
+	m.schedule()
+	m.mu.Unlock()
+	if err != nil && j.ctx.Err() != nil {
+		err = ErrJobCanceled
+	}
+	m.finish(j, result, err)
+}
+
+// Internal: record the end of a job
+func (m *Manager) finish(j *ManagedJob, result *FetchResult, err error) {
+	m.mu.Lock()
+	j.result, j.err = result, err
+	switch {
+	case err == nil:
+		j.state = JobDone
+		m.stats.Done++
+	case errors.Is(err, ErrJobCanceled):
+		j.state = JobCanceled
+		m.stats.Failed++
+	default:
+		j.state = JobFailed
+		m.stats.Failed++
+	}
+	m.mu.Unlock()
+	j.cancel()
+	close(j.done)
+	m.jobs.Done()
+}
+
+// Change the priority of the job, queued or running: a queued job moves in
+// the queue, and a running one gets the connections before the jobs of lower
+// priority
+func (j *ManagedJob) SetPriority(priority int) {
+	m := j.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j.priority = priority
+	if j.queued != nil {
+		q := j.queued.queue
+		q.mu.Lock()
+		j.queued.priority = priority
+		q.schedule()
+		q.mu.Unlock()
+	}
+	m.schedule()
+}
+
+// Move a queued job to the front of the queue, ahead of the other jobs of
+// its priority. Raise its priority too for it to go ahead of all of them.
+func (j *ManagedJob) MoveToFront() {
+	m := j.manager
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.first--
+	j.seq = m.first
+	m.schedule()
+}
+
+// Get the state of the job
+func (j *ManagedJob) State() JobState {
+	j.manager.mu.Lock()
+	defer j.manager.mu.Unlock()
+	return j.state
+}
+
+// Wait for the job to finish, returning the result of its download
+func (j *ManagedJob) Wait() (*FetchResult, error) {
+	<-j.done
+	return j.result, j.err
+}
+
+// Get a channel closed once the job is finished
+func (j *ManagedJob) Done() <-chan struct{} {
+	return j.done
+}
+
+// Cancel the job, queued or running. It finishes with ErrJobCanceled.
+func (j *ManagedJob) Cancel() {
+	m := j.manager
+	m.mu.Lock()
+	for i, q := range m.queue {
+		if q == j {
+			m.queue = append(m.queue[:i], m.queue[i+1:]...)
+			m.mu.Unlock()
+			m.finish(j, nil, ErrJobCanceled)
+			return
+		}
+	}
+	m.mu.Unlock()
+	j.cancel()
+}
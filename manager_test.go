@@ -0,0 +1,187 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Internal: a server of the test files counting the GET requests served at
+// once, each taking a while
+type concurrencyServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	active  int
+	maximum int
+}
+
+func newConcurrencyServer() *concurrencyServer {
+	s := &concurrencyServer{}
+	fileServer := http.FileServer(http.Dir("./test"))
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			s.mu.Lock()
+			s.active++
+			s.maximum = max(s.maximum, s.active)
+			s.mu.Unlock()
+			defer func() {
+				s.mu.Lock()
+				s.active--
+				s.mu.Unlock()
+			}()
+			time.Sleep(50 * time.Millisecond)
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	return s
+}
+
+func (s *concurrencyServer) max() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.maximum
+}
+
+func TestManagerMaxDownloads(t *testing.T) {
+	server := newConcurrencyServer()
+	defer server.Close()
+	m := NewManager(ManagerLimits{MaxDownloads: 2}, WithConnections(1))
+	defer m.Close()
+	dir := t.TempDir()
+	var jobs []*ManagedJob
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		jobs = append(jobs, m.Add(Job{URLs: []string{server.URL + "/quijote.txt"}, Dest: filepath.Join(dir, name)}))
+	}
+	if stats := m.Stats(); stats.Running != 2 || stats.Queued != 3 {
+		t.Errorf("%d jobs running and %d queued, should be 2 and 3", stats.Running, stats.Queued)
+	}
+	m.Wait()
+	for _, j := range jobs {
+		result, err := j.Wait()
+		failOnError(t, err)
+		if result.Size != 317621 || j.State() != JobDone {
+			t.Errorf("Job %s: %d bytes, %v", j.Dest, result.Size, j.State())
+		}
+	}
+	if n := server.max(); n != 2 {
+		t.Errorf("%d downloads at once, should be 2", n)
+	}
+	if stats := m.Stats(); stats.Done != 5 || stats.BytesDownloaded != 5*317621 {
+		t.Errorf("Unexpected statistics %+v", stats)
+	}
+	if n := len(m.transports.transports); n != 1 {
+		t.Errorf("%d transports for a single mirror", n)
+	}
+}
+
+func TestManagerMaxConns(t *testing.T) {
+	server := newConcurrencyServer()
+	defer server.Close()
+	m := NewManager(ManagerLimits{MaxConns: 3}, WithConnections(4), WithSingleConnectionBelow(0))
+	defer m.Close()
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		m.Add(Job{URLs: []string{server.URL + "/quijote.txt"}, Dest: filepath.Join(dir, name)})
+	}
+	m.Wait()
+	if stats := m.Stats(); stats.Done != 3 {
+		t.Errorf("Unexpected statistics %+v", stats)
+	}
+	if n := server.max(); n > 3 {
+		t.Errorf("%d connections at once, should be at most 3", n)
+	}
+}
+
+func TestManagerCancel(t *testing.T) {
+	server := newConcurrencyServer()
+	defer server.Close()
+	m := NewManager(ManagerLimits{MaxDownloads: 1})
+	dir := t.TempDir()
+	running := m.Add(Job{URLs: []string{server.URL + "/quijote.txt"}, Dest: filepath.Join(dir, "a")})
+	queued := m.Add(Job{URLs: []string{server.URL + "/quijote.txt"}, Dest: filepath.Join(dir, "b")})
+	last := m.Add(Job{URLs: []string{server.URL + "/quijote.txt"}, Dest: filepath.Join(dir, "c")})
+	if running.State() != JobRunning || queued.State() != JobQueued {
+		t.Errorf("Jobs %v and %v, should be running and queued", running.State(), queued.State())
+	}
+	queued.Cancel()
+	if _, err := queued.Wait(); !errors.Is(err, ErrJobCanceled) || queued.State() != JobCanceled {
+		t.Errorf("Canceled job returned %v, %v", err, queued.State())
+	}
+	_, err := running.Wait()
+	failOnError(t, err)
+	m.Close()
+	if _, err := last.Wait(); err != nil && !errors.Is(err, ErrJobCanceled) {
+		t.Errorf("Job stopped by Close returned %v", err)
+	}
+}
+
+// Jobs of higher priority, or moved to the front, are started first
+func TestManagerPriorities(t *testing.T) {
+	server := newConcurrencyServer()
+	defer server.Close()
+	m := NewManager(ManagerLimits{MaxDownloads: 1, MaxConns: 2}, WithConnections(2))
+	defer m.Close()
+	dir := t.TempDir()
+	var mu sync.Mutex
+	var order []string
+	var recorded sync.WaitGroup
+	add := func(name string, priority int) *ManagedJob {
+		j := m.Add(Job{URLs: []string{server.URL + "/quijote.txt"}, Dest: filepath.Join(dir, name), Priority: priority})
+		recorded.Add(1)
+		go func() {
+			defer recorded.Done()
+			<-j.Done()
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}()
+		return j
+	}
+	add("running", 0)
+	add("bulk1", 0)
+	bulk2 := add("bulk2", 0)
+	add("urgent", 10)
+	late := add("late", 0)
+	demoted := add("demoted", 5)
+	late.MoveToFront()
+	bulk2.SetPriority(1)
+	demoted.SetPriority(-1)
+	recorded.Wait()
+	mu.Lock()
+	defer mu.Unlock()
+	expected := []string{"running", "urgent", "bulk2", "late", "bulk1", "demoted"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Jobs finished in the order %v, should be %v", order, expected)
+	}
+	if n := server.max(); n > 2 {
+		t.Errorf("%d connections at once, should be at most 2", n)
+	}
+}
+
+// A running job of higher priority takes the connections of the others
+func TestManagerPreemption(t *testing.T) {
+	server := newConcurrencyServer()
+	defer server.Close()
+	m := NewManager(ManagerLimits{MaxConns: 2}, WithConnections(2), WithSingleConnectionBelow(0),
+		WithMaxChunk(20000))
+	defer m.Close()
+	dir := t.TempDir()
+	bulk := m.Add(Job{URLs: []string{server.URL + "/quijote.txt"}, Dest: filepath.Join(dir, "bulk")})
+	time.Sleep(100 * time.Millisecond)
+	urgent := m.Add(Job{URLs: []string{server.URL + "/quijote.txt"}, Dest: filepath.Join(dir, "urgent"), Priority: 1})
+	_, err := urgent.Wait()
+	failOnError(t, err)
+	if bulk.State() != JobRunning {
+		t.Errorf("The bulk job is %v, should still be running", bulk.State())
+	}
+	_, err = bulk.Wait()
+	failOnError(t, err)
+	if stats := m.conns.Stats(); stats.Preempted != 0 || stats.Connections != 0 {
+		t.Errorf("Unexpected connections left %+v", stats)
+	}
+}
@@ -0,0 +1,161 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+)
+
+// Merkle trees of the pieces of a file. The digests of the pieces are the
+// leaves of a binary tree whose nodes hash the concatenation of their two
+// children, the last node of a level with an odd number of them going up
+// unchanged. The root vouches for all the piece digests, so a single trusted
+// digest is enough to check each piece as it's downloaded, with digests of
+// the pieces fetched from anywhere.
+//
+// When the download can be resumed (WithResume), the pieces checked are
+// recorded next to the partial file, under the root of their tree. A resumed
+// download checks the pieces written before it was interrupted, downloading
+// the corrupt ones again, and trusts the ones checked in earlier runs without
+// reading them again.
+
+// The digests of the pieces don't make up the expected tree
+var ErrMerkleRoot = errors.New("The piece digests don't match the Merkle root")
+
+// Suffix of the file recording the pieces checked, next to the partial file
+const trustedSuffix = ".pieces"
+
+// Root of the Merkle tree of some leaves, nil if there are none
+func MerkleRoot(h func() hash.Hash, leaves [][]byte) []byte {
+	level := leaves
+	for len(level) > 1 {
+		var parents [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				parents = append(parents, level[i])
+				continue
+			}
+			node := h()
+			node.Write(level[i])
+			node.Write(level[i+1])
+			parents = append(parents, node.Sum(nil))
+		}
+		level = parents
+	}
+	if len(level) == 0 {
+		return nil
+	}
+	return level[0]
+}
+
+// Root of the Merkle tree of the digests of the pieces
+func (p *PieceHashes) Root() []byte {
+	return MerkleRoot(p.Hash, p.Sums)
+}
+
+// Take the digests of the pieces of a file, for WithPieceHashes, only if
+// they make up the Merkle tree with the given root. Returns an error
+// matching ErrMerkleRoot otherwise.
+func NewMerkleTree(size int64, h func() hash.Hash, root []byte, leaves [][]byte) (*PieceHashes, error) {
+	if size <= 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid piece size %d", size))
+	}
+	pieces := &PieceHashes{Size: size, Hash: h, Sums: leaves}
+	if computed := pieces.Root(); !bytes.Equal(computed, root) {
+		return nil, fmt.Errorf("%w: expected=%x computed=%x", ErrMerkleRoot, root, computed)
+	}
+	return pieces, nil
+}
+
+// Internal: path of the record of the pieces checked of a partial file
+func trustedPath(partFilename string) string {
+	return partFilename + trustedSuffix
+}
+
+// Internal: the pieces checked in earlier runs, recorded as the hex root of
+// their tree on a line, followed by a bitmap with one bit per piece. Nothing
+// is trusted if the record is missing or for another tree.
+func (dldr *MultiDownloader) loadTrusted(n int64) []bool {
+	trusted := make([]bool, n)
+	data, err := os.ReadFile(trustedPath(dldr.partFilename))
+	if err != nil {
+		return trusted
+	}
+	root, bitmap, found := bytes.Cut(data, []byte("\n"))
+	if !found || string(root) != hex.EncodeToString(dldr.pieces.Root()) ||
+		int64(len(bitmap)) != (n+7)/8 {
+		dldr.logVerbose("Ignoring the record of the pieces checked, it's for another file")
+		return trusted
+	}
+	for p := range trusted {
+		trusted[p] = bitmap[p/8]&(1<<(p%8)) != 0
+	}
+	return trusted
+}
+
+// Internal: record the pieces checked so far, for later runs
+func (dldr *MultiDownloader) saveTrusted() {
+	state := dldr.pieceState
+	state.mu.Lock()
+	bitmap := make([]byte, (len(state.verified)+7)/8)
+	for p, verified := range state.verified {
+		if verified {
+			bitmap[p/8] |= 1 << (p % 8)
+		}
+	}
+	state.mu.Unlock()
+	data := append([]byte(hex.EncodeToString(dldr.pieces.Root())+"\n"), bitmap...)
+	if err := os.WriteFile(trustedPath(dldr.partFilename), data, 0666); err != nil {
+		dldr.logVerbose("Error recording the pieces checked: ", err)
+	}
+}
+
+// Internal: check the pieces written before a download was interrupted, but
+// for the ones trusted from earlier runs, returning the corrupt ones
+func (dldr *MultiDownloader) resumePieces(ctl *controlFile) ([]Chunk, error) {
+	size := dldr.pieces.Size
+	n := (dldr.fileLength + size - 1) / size
+	if int64(len(dldr.pieces.Sums)) != n {
+		return nil, errors.New(fmt.Sprintf("%d piece digests for %d pieces", len(dldr.pieces.Sums), n))
+	}
+	file, err := os.Open(dldr.partFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	state := &pieceState{verified: dldr.loadTrusted(n), failures: make([]int, n)}
+	written := rangeSet(ctl.ranges(true))
+	var bad []Chunk
+	checked := 0
+	data := make([]byte, size)
+	for p := int64(0); p < n; p++ {
+		piece := Chunk{p * size, min((p+1)*size, dldr.fileLength)}
+		if !written.covers(piece.Begin, piece.End) {
+			state.verified[p] = false // Downloaded again
+			continue
+		}
+		if state.verified[p] {
+			continue
+		}
+		m, err := file.ReadAt(data[:piece.End-piece.Begin], piece.Begin)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		h := dldr.pieces.Hash()
+		h.Write(data[:m])
+		checked++
+		if bytes.Equal(h.Sum(nil), dldr.pieces.Sums[p]) {
+			state.verified[p] = true
+		} else {
+			bad = append(bad, piece)
+		}
+	}
+	dldr.logVerbose("Checked ", checked, " pieces of the partial file, ", len(bad), " corrupt")
+	dldr.pieceState = state
+	return bad, nil
+}
@@ -0,0 +1,109 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	leaf := func(s string) []byte {
+		sum := sha256.Sum256([]byte(s))
+		return sum[:]
+	}
+	node := func(left, right []byte) []byte {
+		sum := sha256.Sum256(append(append([]byte(nil), left...), right...))
+		return sum[:]
+	}
+	a, b, c := leaf("a"), leaf("b"), leaf("c")
+	for _, test := range []struct {
+		leaves [][]byte
+		root   []byte
+	}{
+		{nil, nil},
+		{[][]byte{a}, a},
+		{[][]byte{a, b}, node(a, b)},
+		{[][]byte{a, b, c}, node(node(a, b), c)},
+	} {
+		if root := MerkleRoot(sha256.New, test.leaves); !bytes.Equal(root, test.root) {
+			t.Errorf("Root of %d leaves is %x, should be %x", len(test.leaves), root, test.root)
+		}
+	}
+
+	_, err := NewMerkleTree(1024, sha256.New, node(a, b), [][]byte{a, b})
+	failOnError(t, err)
+	if _, err := NewMerkleTree(1024, sha256.New, node(a, b), [][]byte{b, a}); !errors.Is(err, ErrMerkleRoot) {
+		t.Errorf("Leaves of another tree should fail with ErrMerkleRoot: %v", err)
+	}
+}
+
+// A resumed download checks the pieces written before, downloading the
+// corrupt ones again, and records the ones checked for later runs
+func TestResumePieces(t *testing.T) {
+	data, err := os.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	fileServer := http.FileServer(http.Dir("./test"))
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w = &cuttingWriter{w, 5*fileWriteChunk + 100}
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer flaky.Close()
+	good := httptest.NewServer(fileServer)
+	defer good.Close()
+	output := filepath.Join(t.TempDir(), "quijote.txt")
+	pieces := makePieceHashes(t, data, fileWriteChunk)
+	tree, err := NewMerkleTree(pieces.Size, pieces.Hash, pieces.Root(), pieces.Sums)
+	failOnError(t, err)
+
+	dldr := NewMultiDownloader([]string{flaky.URL + "/quijote.txt"}, 4, 5*time.Second,
+		WithResume(true), WithPieceHashes(tree))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(output)
+	failOnError(t, err)
+	if err := dldr.Download(nil); err == nil {
+		t.Fatal("The download from the flaky server should fail")
+	}
+	time.Sleep(50 * time.Millisecond) // Let the dropped connections finish writing
+	trusted := 0
+	for _, verified := range dldr.loadTrusted(int64(len(tree.Sums))) {
+		if verified {
+			trusted++
+		}
+	}
+	if trusted == 0 {
+		t.Error("The pieces checked should be recorded")
+	}
+
+	// Corrupt a piece written, which isn't trusted without the record
+	os.Remove(trustedPath(dldr.partFilename))
+	part, err := os.OpenFile(dldr.partFilename, os.O_WRONLY, 0)
+	failOnError(t, err)
+	_, err = part.WriteAt([]byte("corrupted"), 100)
+	failOnError(t, err)
+	part.Close()
+
+	dldr = NewMultiDownloader([]string{good.URL + "/quijote.txt"}, 4, 5*time.Second,
+		WithResume(true), WithPieceHashes(tree))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(output)
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	downloaded, err := os.ReadFile(output)
+	failOnError(t, err)
+	if !bytes.Equal(downloaded, data) {
+		t.Error("The corrupt piece of the partial file should be downloaded again")
+	}
+	if _, err := os.Stat(trustedPath(dldr.partFilename)); err == nil {
+		t.Error("The record of the pieces checked should be removed with the partial file")
+	}
+}
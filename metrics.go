@@ -0,0 +1,121 @@
+package multipartdownloader
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Prometheus metrics of downloads, for long-running daemons. The metrics are
+// registered once with NewMetrics and shared by all the downloaders given
+// them with WithMetrics:
+//
+//	multipart_downloader_bytes_total             Bytes received
+//	multipart_downloader_active_connections      Chunks being downloaded
+//	multipart_downloader_retries_total           Requests of a chunk after a failed one
+//	multipart_downloader_mirror_errors_total     Failed requests, by mirror host
+//	multipart_downloader_download_duration_seconds
+//	                                             Duration of the downloads, by result
+
+// Namespace of the metrics
+const metricsNamespace = "multipart_downloader"
+
+// Metrics of the downloads of the downloaders sharing them
+type Metrics struct {
+	bytes        prometheus.Counter
+	active       prometheus.Gauge
+	retries      prometheus.Counter
+	mirrorErrors *prometheus.CounterVec
+	duration     *prometheus.HistogramVec
+}
+
+// Create the metrics of downloads and register them. Registering them twice
+// with the same registerer fails.
+func NewMetrics(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		bytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bytes_total",
+			Help:      "Bytes of files received.",
+		}),
+		active: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "active_connections",
+			Help:      "Chunks being downloaded.",
+		}),
+		retries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "retries_total",
+			Help:      "Requests of a chunk after a failed one.",
+		}),
+		mirrorErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "mirror_errors_total",
+			Help:      "Failed requests, by mirror host.",
+		}, []string{"mirror"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "download_duration_seconds",
+			Help:      "Duration of the downloads, by result (success or error).",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 4, 9),
+		}, []string{"result"}),
+	}
+	for _, c := range []prometheus.Collector{m.bytes, m.active, m.retries, m.mirrorErrors, m.duration} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Account the downloads in some metrics
+func WithMetrics(m *Metrics) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.metrics = m
+	}
+}
+
+// The methods below do nothing without metrics
+
+// Internal: account for n bytes received
+func (m *Metrics) transferred(n int) {
+	if m != nil {
+		m.bytes.Add(float64(n))
+	}
+}
+
+// Internal: account for a chunk being downloaded, until the returned
+// function is called
+func (m *Metrics) connect() func() {
+	if m == nil {
+		return func() {}
+	}
+	m.active.Inc()
+	return m.active.Dec
+}
+
+// Internal: account for a request of a chunk after a failed one
+func (m *Metrics) retried() {
+	if m != nil {
+		m.retries.Inc()
+	}
+}
+
+// Internal: account for a failed request to a mirror
+func (m *Metrics) mirrorFailed(host string) {
+	if m != nil {
+		m.mirrorErrors.WithLabelValues(host).Inc()
+	}
+}
+
+// Internal: account for a download that took some time, once it's over
+func (m *Metrics) downloaded(elapsed time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	m.duration.WithLabelValues(result).Observe(elapsed.Seconds())
+}
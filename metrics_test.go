@@ -0,0 +1,54 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	metrics, err := NewMetrics(reg)
+	failOnError(t, err)
+	if _, err := NewMetrics(reg); err == nil {
+		t.Error("Registering the metrics twice should fail")
+	}
+
+	good := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer good.Close()
+	bad := httptest.NewServer(failingChunks())
+	defer bad.Close()
+
+	dldr := NewMultiDownloader(
+		[]string{bad.URL + "/quijote.txt", good.URL + "/quijote.txt"},
+		2, 5*time.Second, WithMetrics(metrics))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+
+	if n := testutil.ToFloat64(metrics.bytes); n != 317621 {
+		t.Errorf("Expected 317621 bytes, got %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.active); n != 0 {
+		t.Errorf("No connection should be active after the download, got %v", n)
+	}
+	host, _ := url.Parse(bad.URL)
+	failures := testutil.ToFloat64(metrics.mirrorErrors.WithLabelValues(host.Host))
+	if failures < 1 {
+		t.Error("The errors of the failing mirror should be counted")
+	}
+	if n := testutil.ToFloat64(metrics.retries); n != failures {
+		t.Errorf("Each failed request should be retried, got %v retries for %v errors", n, failures)
+	}
+	if n := testutil.CollectAndCount(metrics.duration); n != 1 {
+		t.Errorf("Expected the duration of a successful download, got %d series", n)
+	}
+}
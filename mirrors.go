@@ -0,0 +1,320 @@
+package multipartdownloader
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The chunks are requested from the mirrors in turn, the first try of chunk
+// i going to mirror i, the next one to the following mirror, and so on. The
+// connections to each mirror (host) can be limited, so that a picky server
+// isn't flooded while the others take the rest: the tries then go to the
+// next mirror with connections left, or wait for one.
+//
+// The throughput and latency of each mirror are measured as the chunks are
+// downloaded. With WithMirrorWeighting, the tries go to the mirror with the
+// most throughput per connection open instead, those not measured yet first.
+// The mirrors can also be benchmarked before downloading, by fetching the
+// beginning of the file from each.
+//
+// With WithMirrorBreaker, mirrors failing repeatedly (timeouts, server
+// errors, bad ranges...) are left out of the rotation for a cool-down,
+// instead of being tried again for every chunk. If all the mirrors left are
+// out, the tries go to them anyway.
+
+// Bytes fetched from each mirror when benchmarking them
+const benchmarkSize = 64 << 10
+
+// Weight of a new latency measurement in its moving average
+const latencyWeight = 0.3
+
+// The mirrors of a downloader, and the connections open to each of them
+type mirrorSet struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int                     // Connections per host, zero for no limit
+	conns    map[string]int          // Connections open to each host
+	stats    map[string]*MirrorStats // Measurements of each host
+	weighted bool                    // Prefer the fastest mirrors
+	maxFails int                     // Failures in a row leaving a mirror out, zero to never
+	coolDown time.Duration           // Time a mirror is left out
+	failing  map[string]int          // Failures in a row of each host
+	outUntil map[string]time.Time    // End of the cool-down of the hosts left out
+	log      func(...interface{})    // Debug logging of the downloader
+}
+
+// Measurements of a mirror
+type MirrorStats struct {
+	Host        string
+	Requests    int           // Requests sent to it
+	Bytes       int64         // Bytes downloaded from it
+	Busy        time.Duration // Time spent downloading from it, adding up its connections
+	Latency     time.Duration // Moving average of the time to the response headers
+	Throughput  float64       // Mean bytes per second of a connection
+	Conns       int           // Connections open
+	Failures    int           // Failed requests
+	LastError   error         // Why the last failed request failed, nil if none did
+	LastFailure time.Time     // When it failed
+	Out         bool          // Left out of the rotation after failing
+}
+
+// Internal: create the set of mirrors
+func newMirrorSet() *mirrorSet {
+	m := &mirrorSet{
+		conns:    make(map[string]int),
+		stats:    make(map[string]*MirrorStats),
+		failing:  make(map[string]int),
+		outUntil: make(map[string]time.Time),
+		log:      logVerbose,
+	}
+	m.cond = sync.NewCond(&m.mu)
+	return m
+}
+
+// Send the tries of the chunks to the fastest mirrors instead of taking
+// them in turn. With benchmark, the mirrors are measured before downloading.
+func WithMirrorWeighting(benchmark bool) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.mirrors.weighted = true
+		dldr.benchmark = benchmark
+	}
+}
+
+// Leave out of the rotation for coolDown the mirrors failing maxFails times
+// in a row
+func WithMirrorBreaker(maxFails int, coolDown time.Duration) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.mirrors.maxFails = maxFails
+		dldr.mirrors.coolDown = coolDown
+	}
+}
+
+// Get the measurements of the mirrors, the fastest first. They can be
+// queried while downloading, and add up over the downloads of the downloader.
+func (dldr *MultiDownloader) MirrorStats() []MirrorStats {
+	m := dldr.mirrors
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var stats []MirrorStats
+	for host, s := range m.stats {
+		entry := *s
+		entry.Conns = m.conns[host]
+		entry.Out = time.Now().Before(m.outUntil[host])
+		stats = append(stats, entry)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Throughput > stats[j].Throughput
+	})
+	return stats
+}
+
+// Limit the connections open at once to each mirror (host), across all the
+// URLs it serves. The other mirrors take the rest.
+func WithMirrorConns(n int) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.mirrors.limit = n
+	}
+}
+
+// Internal: take a connection to the first of the URLs not tried yet, from
+// the given one on, whose mirror has connections left, waiting for one if
+// they are all busy. Returns the index of the URL and the function releasing
+// the connection, or -1 if all the URLs were tried or the context is done.
+func (m *mirrorSet) acquire(ctx context.Context, urls []string, first int, tried map[int]bool) (int, func()) {
+	// Wake up the waits when the context is done
+	stop := context.AfterFunc(ctx, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.cond.Broadcast()
+	})
+	defer stop()
+	return m.take(ctx, urls, first, tried, true)
+}
+
+// Internal: like acquire, but without waiting, nor taking mirrors left out
+func (m *mirrorSet) tryAcquire(urls []string, first int, tried map[int]bool) (int, func()) {
+	return m.take(context.Background(), urls, first, tried, false)
+}
+
+// Internal: take a connection for acquire and tryAcquire
+func (m *mirrorSet) take(ctx context.Context, urls []string, first int, tried map[int]bool, wait bool) (int, func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for {
+		if ctx.Err() != nil {
+			return -1, nil
+		}
+		untried := false
+		best, bestScore := -1, -1.0
+		out := -1 // First mirror left out, used if there is nothing else
+		now := time.Now()
+		for k := 0; k < len(urls); k++ {
+			i := (first + k) % len(urls)
+			if tried[i] {
+				continue
+			}
+			untried = true
+			host := mirrorHost(urls[i])
+			if m.limit > 0 && m.conns[host] >= m.limit {
+				continue
+			}
+			if now.Before(m.outUntil[host]) {
+				if out < 0 {
+					out = i
+				}
+				continue
+			}
+			if !m.weighted {
+				best = i
+				break
+			}
+			if score := m.score(host); score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 && !wait {
+			return -1, nil
+		}
+		if best < 0 && out >= 0 && !m.waitable(urls, tried) {
+			best = out
+		}
+		if best >= 0 {
+			host := mirrorHost(urls[best])
+			m.conns[host]++
+			return best, func() {
+				m.mu.Lock()
+				defer m.mu.Unlock()
+				m.conns[host]--
+				m.cond.Broadcast()
+			}
+		}
+		if !untried {
+			return -1, nil
+		}
+		m.cond.Wait()
+	}
+}
+
+// Internal: whether some untried mirror, not left out, is only busy. Must be
+// called with the lock held.
+func (m *mirrorSet) waitable(urls []string, tried map[int]bool) bool {
+	now := time.Now()
+	for i, u := range urls {
+		if !tried[i] && !now.Before(m.outUntil[mirrorHost(u)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// Internal: the measurements of a host, created if missing. Must be called
+// with the lock held.
+func (m *mirrorSet) hostStats(host string) *MirrorStats {
+	s, ok := m.stats[host]
+	if !ok {
+		s = &MirrorStats{Host: host}
+		m.stats[host] = s
+	}
+	return s
+}
+
+// Internal: record a request sent to a host
+func (m *mirrorSet) requested(host string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.hostStats(host).Requests++
+}
+
+// Internal: record a failed request to a host, leaving it out of the
+// rotation if it failed too many times in a row
+func (m *mirrorSet) failed(host string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.hostStats(host)
+	s.Failures++
+	s.LastError, s.LastFailure = err, time.Now()
+	m.failing[host]++
+	if m.maxFails > 0 && m.failing[host] >= m.maxFails {
+		m.log("Leaving out mirror ", host, " for ", m.coolDown)
+		m.outUntil[host] = time.Now().Add(m.coolDown)
+		m.failing[host] = 0
+	}
+}
+
+// Internal: the throughput a new connection to a host can expect, infinite
+// if it wasn't measured yet. Must be called with the lock held.
+func (m *mirrorSet) score(host string) float64 {
+	s, ok := m.stats[host]
+	if !ok || s.Busy == 0 {
+		return math.Inf(1)
+	}
+	return s.Throughput / float64(m.conns[host]+1)
+}
+
+// Internal: record a download of n bytes from a host, which took elapsed,
+// latency of it until the response headers
+func (m *mirrorSet) record(host string, n int64, elapsed, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.hostStats(host)
+	if s.Busy == 0 {
+		s.Latency = latency // First measurement
+	}
+	m.failing[host] = 0
+	s.Bytes += n
+	s.Busy += elapsed
+	s.Latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(s.Latency))
+	if s.Busy > 0 {
+		s.Throughput = float64(s.Bytes) / s.Busy.Seconds()
+	}
+}
+
+// Internal: measure the mirrors by fetching the beginning of the file from
+// each, concurrently
+func (dldr *MultiDownloader) benchmarkMirrors() {
+	size := min(int64(benchmarkSize), dldr.fileLength)
+	if size == 0 || dldr.noRanges {
+		return
+	}
+	var wg sync.WaitGroup
+	for _, u := range dldr.sourceURLs() {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			req, err := dldr.chunkRequest(0, Chunk{0, size}, u)
+			if err != nil {
+				return
+			}
+			start := time.Now()
+			dldr.mirrors.requested(mirrorHost(u))
+			resp, err := dldr.chunkClient(u).Do(req)
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			latency := time.Since(start)
+			n, err := io.Copy(io.Discard, resp.Body)
+			if err != nil || resp.StatusCode != http.StatusPartialContent {
+				return
+			}
+			dldr.mirrors.record(mirrorHost(u), n, time.Since(start), latency)
+			dldr.logVerbose("Mirror ", u, ": ", n, " bytes in ", time.Since(start))
+		}(u)
+	}
+	wg.Wait()
+}
+
+// Internal: the host of a mirror, or the URL itself if it can't be parsed
+func mirrorHost(urlStr string) string {
+	u, err := url.Parse(httpURL(urlStr))
+	if err != nil {
+		return urlStr
+	}
+	return u.Host
+}
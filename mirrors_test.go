@@ -0,0 +1,198 @@
+package multipartdownloader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Counts the requests for chunks served at once
+type peakHandler struct {
+	handler        http.Handler
+	inFlight, peak int32
+	gets           int32
+}
+
+func (h *peakHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		atomic.AddInt32(&h.gets, 1)
+		n := atomic.AddInt32(&h.inFlight, 1)
+		defer atomic.AddInt32(&h.inFlight, -1)
+		for {
+			p := atomic.LoadInt32(&h.peak)
+			if n <= p || atomic.CompareAndSwapInt32(&h.peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	h.handler.ServeHTTP(w, r)
+}
+
+// No mirror gets more connections than its limit, the others take the rest
+func TestMirrorConns(t *testing.T) {
+	picky := &peakHandler{handler: http.FileServer(http.Dir("./test"))}
+	other := &peakHandler{handler: http.FileServer(http.Dir("./test"))}
+	pickyServer := httptest.NewServer(picky)
+	defer pickyServer.Close()
+	otherServer := httptest.NewServer(other)
+	defer otherServer.Close()
+
+	dldr := NewMultiDownloader(
+		[]string{pickyServer.URL + "/quijote.txt", otherServer.URL + "/quijote.txt"},
+		8, 5*time.Second, WithChunkSize(20000), WithMirrorConns(2))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	if picky.peak > 2 || other.peak > 2 {
+		t.Errorf("Mirrors got %d and %d connections at once, the limit is 2", picky.peak, other.peak)
+	}
+	if picky.gets+other.gets != 16 {
+		t.Errorf("Expected 16 requests, got %d", picky.gets+other.gets)
+	}
+}
+
+// With weighting, the fast mirror takes most of the chunks
+func TestMirrorWeighting(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	slow := &peakHandler{handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fileServer.ServeHTTP(slowWriter{w}, r)
+	})}
+	fast := &peakHandler{handler: fileServer}
+	slowServer := httptest.NewServer(slow)
+	defer slowServer.Close()
+	fastServer := httptest.NewServer(fast)
+	defer fastServer.Close()
+
+	dldr := NewMultiDownloader(
+		[]string{slowServer.URL + "/quijote.txt", fastServer.URL + "/quijote.txt"},
+		2, 5*time.Second, WithChunkSize(20000), WithMirrorWeighting(true))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	if fast.gets <= 2*slow.gets {
+		t.Errorf("The fast mirror got %d requests, the slow one %d", fast.gets, slow.gets)
+	}
+	stats := dldr.MirrorStats()
+	if len(stats) != 2 || stats[0].Host != mirrorHost(fastServer.URL) {
+		t.Errorf("The fast mirror should be the first: %+v", stats)
+	}
+}
+
+// A mirror failing repeatedly is left out instead of tried for every chunk
+func TestMirrorBreaker(t *testing.T) {
+	broken := &peakHandler{handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			http.Error(w, "Oops", http.StatusInternalServerError)
+			return
+		}
+		http.ServeFile(w, r, "test/quijote.txt")
+	})}
+	good := &peakHandler{handler: http.FileServer(http.Dir("./test"))}
+	brokenServer := httptest.NewServer(broken)
+	defer brokenServer.Close()
+	goodServer := httptest.NewServer(good)
+	defer goodServer.Close()
+
+	dldr := NewMultiDownloader(
+		[]string{brokenServer.URL + "/quijote.txt", goodServer.URL + "/quijote.txt"},
+		2, 5*time.Second, WithChunkSize(20000), WithMirrorBreaker(2, time.Minute))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	if broken.gets > 3 {
+		t.Errorf("The broken mirror got %d requests after failing twice", broken.gets)
+	}
+	for _, s := range dldr.MirrorStats() {
+		if s.Host == mirrorHost(brokenServer.URL) && !s.Out {
+			t.Errorf("The broken mirror isn't out: %+v", s)
+		}
+	}
+}
+
+// When all the mirrors left are out, they are tried anyway
+func TestMirrorBreakerAllOut(t *testing.T) {
+	m := newMirrorSet()
+	m.maxFails, m.coolDown = 1, time.Minute
+	urls := []string{"http://a/file", "http://b/file"}
+	m.failed("a", errors.New("Oops"))
+	if k, _ := m.acquire(context.Background(), urls, 0, map[int]bool{}); k != 1 {
+		t.Errorf("Expected the mirror left, got %d", k)
+	}
+	if k, _ := m.acquire(context.Background(), urls, 0, map[int]bool{1: true}); k != 0 {
+		t.Errorf("Expected the mirror out, as there is no other, got %d", k)
+	}
+}
+
+// The requests, failures and last error of each mirror are recorded, and can
+// be queried while downloading
+func TestMirrorStatsErrors(t *testing.T) {
+	good := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer good.Close()
+	bad := httptest.NewServer(failingChunks())
+	defer bad.Close()
+
+	var dldr *MultiDownloader
+	var during atomic.Int32
+	dldr = NewMultiDownloader(
+		[]string{bad.URL + "/quijote.txt", good.URL + "/quijote.txt"}, 2, 5*time.Second,
+		WithHooks(Hooks{OnChunkComplete: func(ChunkEvent) {
+			for _, s := range dldr.MirrorStats() {
+				if s.Requests > 0 {
+					during.Add(1)
+				}
+			}
+		}}))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	if during.Load() == 0 {
+		t.Error("The mirrors should be measured while downloading")
+	}
+
+	stats := make(map[string]MirrorStats)
+	for _, s := range dldr.MirrorStats() {
+		stats[s.Host] = s
+	}
+	b, g := stats[mirrorHost(bad.URL)], stats[mirrorHost(good.URL)]
+	if b.Requests == 0 || b.Failures != b.Requests || b.LastFailure.IsZero() {
+		t.Errorf("All the requests of the bad mirror should fail: %+v", b)
+	}
+	if b.LastError == nil || !strings.Contains(b.LastError.Error(), "503") {
+		t.Errorf("Expected the 503 error of the bad mirror, got %v", b.LastError)
+	}
+	if g.Requests == 0 || g.Failures != 0 || g.LastError != nil || g.Bytes != 317621 || g.Throughput <= 0 {
+		t.Errorf("The good mirror should serve the whole file: %+v", g)
+	}
+}
+
+// Waiting for a busy mirror stops when the context is done
+func TestMirrorConnsCancel(t *testing.T) {
+	m := newMirrorSet()
+	m.limit = 1
+	urls := []string{"http://a.example/file"}
+	k, release := m.acquire(context.Background(), urls, 0, map[int]bool{})
+	if k != 0 {
+		t.Fatal("The free mirror wasn't taken")
+	}
+	defer release()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if k, _ := m.acquire(ctx, urls, 0, map[int]bool{}); k != -1 {
+		t.Errorf("Expected no mirror once canceled, got %d", k)
+	}
+}
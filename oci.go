@@ -0,0 +1,201 @@
+package multipartdownloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// OCI (Docker) registry support. Blobs are content addressed, so a blob is
+// fetched by digest from the registry API, going through the token
+// authentication of the registry if it asks for it, and checked against its
+// digest once downloaded.
+
+// A blob in a container registry
+type OCIReference struct {
+	Registry   string // Registry host, with an http:// prefix for plain HTTP registries
+	Repository string // Repository name, such as library/ubuntu
+	Digest     string // Digest of the blob, sha256:<hex>
+}
+
+var ociDigest = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// Parse a blob reference of the form [registry/]repository@sha256:<hex>.
+// Without registry, Docker Hub is assumed.
+func ParseOCIReference(ref string) (OCIReference, error) {
+	name, digest, ok := strings.Cut(ref, "@")
+	if !ok {
+		return OCIReference{}, errors.New(fmt.Sprintf("Missing digest in reference %q", ref))
+	}
+	if !ociDigest.MatchString(digest) {
+		return OCIReference{}, errors.New(fmt.Sprintf("Unsupported digest %q, only sha256 is", digest))
+	}
+	scheme := ""
+	for _, prefix := range []string{"http://", "https://"} {
+		if strings.HasPrefix(name, prefix) {
+			scheme, name = prefix, strings.TrimPrefix(name, prefix)
+		}
+	}
+	registry, repository := "registry-1.docker.io", name
+	if first, rest, ok := strings.Cut(name, "/"); ok &&
+		(strings.ContainsAny(first, ".:") || first == "localhost") {
+		registry, repository = first, rest
+	}
+	if registry == "docker.io" || registry == "index.docker.io" {
+		registry = "registry-1.docker.io"
+	}
+	if registry == "registry-1.docker.io" && !strings.Contains(repository, "/") {
+		repository = "library/" + repository
+	}
+	if repository == "" {
+		return OCIReference{}, errors.New(fmt.Sprintf("Missing repository in reference %q", ref))
+	}
+	if scheme == "http://" {
+		registry = scheme + registry
+	}
+	return OCIReference{Registry: registry, Repository: repository, Digest: digest}, nil
+}
+
+// URL of the blob in the registry API
+func (ref OCIReference) BlobURL() string {
+	registry := ref.Registry
+	if !strings.HasPrefix(registry, "http://") && !strings.HasPrefix(registry, "https://") {
+		registry = "https://" + registry
+	}
+	return fmt.Sprintf("%s/v2/%s/blobs/%s", registry, ref.Repository, ref.Digest)
+}
+
+// Locate a blob in its registry, authenticating if needed. This replaces
+// GatherInfo: the blob is downloaded in ranges if the registry supports
+// them, or through a single connection otherwise, and its digest is verified
+// before the file is given its final name. Credentials for private
+// repositories can be given with WithHeader("Authorization", ...).
+func (dldr *MultiDownloader) GatherOCIInfo(ref OCIReference) (chunks []Chunk, err error) {
+	if !ociDigest.MatchString(ref.Digest) {
+		return nil, errors.New(fmt.Sprintf("Unsupported digest %q, only sha256 is", ref.Digest))
+	}
+	blobURL := ref.BlobURL()
+	resp, err := dldr.headBlob(blobURL)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("Www-Authenticate")
+		if err := dldr.registryToken(challenge); err != nil {
+			return nil, err
+		}
+		if resp, err = dldr.headBlob(blobURL); err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(
+			fmt.Sprintf("Failed fetching blob %s: status %d", ref.Digest, resp.StatusCode))
+	}
+	length, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Unknown length of blob %s", ref.Digest))
+	}
+
+	dldr.urls = []string{blobURL}
+	dldr.fileLength = length
+	dldr.sha256 = strings.TrimPrefix(ref.Digest, "sha256:")
+	dldr.filename, err = dldr.resolveFilename(SourceInfo{
+		URL:        blobURL,
+		Header:     resp.Header,
+		FileLength: length,
+		ETag:       resp.Header.Get("Etag"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	dldr.setResolvedOutput(dldr.filename)
+
+	dldr.logVerbose("Blob: ", ref.Digest)
+	dldr.logVerbose("File length: ", dldr.fileLength, " bytes")
+	dldr.logVerbose("File name: ", dldr.filename)
+
+	if resp.Header.Get("Accept-Ranges") == "bytes" {
+		dldr.buildChunks()
+	} else {
+		// Fetch the whole blob through one connection
+		dldr.logVerbose("The registry doesn't support ranged requests")
+		dldr.chunks = []Chunk{{0, length}}
+		dldr.segments = []segment{{url: blobURL, offset: -1}}
+	}
+	return dldr.chunks, nil
+}
+
+// Internal: ask the registry for a blob, following redirects to its storage
+func (dldr *MultiDownloader) headBlob(blobURL string) (*http.Response, error) {
+	req, err := dldr.newRequest("HEAD", blobURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := dldr.httpClient(blobURL, dldr.timeout).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// Internal: get a bearer token as requested by the challenge of a registry,
+// and use it for the following requests
+func (dldr *MultiDownloader) registryToken(challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return errors.New(fmt.Sprintf("Registry authentication required (%s)", challenge))
+	}
+	attrs := parseAttributes(params)
+	realm, err := url.Parse(attrs["realm"])
+	if err != nil || attrs["realm"] == "" {
+		return errors.New(fmt.Sprintf("Invalid registry authentication challenge %q", challenge))
+	}
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if attrs[key] != "" {
+			query.Set(key, attrs[key])
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	// Credentials given for the registry are exchanged for the token
+	req, err := dldr.newRequest("GET", realm.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := dldr.httpClient(realm.String(), dldr.timeout).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(
+			fmt.Sprintf("Registry token request to %s failed: status %d", realm.Host, resp.StatusCode))
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return errors.New("The registry didn't issue a token")
+	}
+	if dldr.header == nil {
+		dldr.header = http.Header{}
+	}
+	dldr.header.Set("Authorization", "Bearer "+token.Token)
+	return nil
+}
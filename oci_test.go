@@ -0,0 +1,101 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseOCIReference(t *testing.T) {
+	digest := "sha256:" + strings.Repeat("ab", 32)
+	testTable := []struct {
+		ref      string
+		expected OCIReference
+	}{
+		{"ubuntu@" + digest, OCIReference{"registry-1.docker.io", "library/ubuntu", digest}},
+		{"docker.io/grafana/grafana@" + digest, OCIReference{"registry-1.docker.io", "grafana/grafana", digest}},
+		{"ghcr.io/org/tools/app@" + digest, OCIReference{"ghcr.io", "org/tools/app", digest}},
+		{"http://localhost:5000/app@" + digest, OCIReference{"http://localhost:5000", "app", digest}},
+	}
+	for _, test := range testTable {
+		ref, err := ParseOCIReference(test.ref)
+		failOnError(t, err)
+		if ref != test.expected {
+			t.Errorf("%s parsed as %+v, should be %+v", test.ref, ref, test.expected)
+		}
+	}
+	for _, ref := range []string{"ubuntu:22.04", "ubuntu@sha512:abc", "ubuntu@sha256:xyz"} {
+		if _, err := ParseOCIReference(ref); err == nil {
+			t.Errorf("%s should be rejected", ref)
+		}
+	}
+}
+
+// A registry requiring a token, that may or may not support ranged requests
+func newRegistryServer(t *testing.T, data []byte, ranges bool) *httptest.Server {
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			if r.URL.Query().Get("scope") != "repository:org/app:pull" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"token":"t0k3n"}`))
+		case "/v2/org/app/blobs/" + digest:
+			if r.Header.Get("Authorization") != "Bearer t0k3n" {
+				w.Header().Set("Www-Authenticate", fmt.Sprintf(
+					`Bearer realm="%s/token",service="test",scope="repository:org/app:pull"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			http.Redirect(w, r, "/storage/blob", http.StatusTemporaryRedirect)
+		case "/storage/blob":
+			if ranges {
+				http.ServeContent(w, r, "blob", time.Time{}, bytes.NewReader(data))
+				return
+			}
+			w.Header().Set("Content-Length", fmt.Sprint(len(data)))
+			w.Write(data)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return server
+}
+
+func TestOCIDownload(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	digest := fmt.Sprintf("sha256:%x", sha256.Sum256(data))
+
+	for _, ranges := range []bool{true, false} {
+		server := newRegistryServer(t, data, ranges)
+		defer server.Close()
+		ref, err := ParseOCIReference(server.URL + "/org/app@" + digest)
+		failOnError(t, err)
+
+		dldr := NewMultiDownloader(nil, 4, time.Duration(5000)*time.Millisecond)
+		chunks, err := dldr.GatherOCIInfo(ref)
+		failOnError(t, err)
+		if ranges && len(chunks) != 4 || !ranges && len(chunks) != 1 {
+			t.Errorf("Wrong chunks with ranged requests %v: %v", ranges, chunks)
+		}
+		_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "blob"))
+		failOnError(t, err)
+		failOnError(t, dldr.Download(nil))
+		downloaded, err := ioutil.ReadFile(dldr.filename)
+		failOnError(t, err)
+		if !bytes.Equal(data, downloaded) {
+			t.Error("The downloaded blob differs from the original")
+		}
+	}
+}
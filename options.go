@@ -0,0 +1,63 @@
+package multipartdownloader
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Optional settings of a MultiDownloader, applied by NewMultiDownloader
+type Option func(*MultiDownloader)
+
+// Add a header to every request sent to the sources, as needed for
+// authentication (Authorization, cookies...)
+func WithHeader(key, value string) Option {
+	return func(dldr *MultiDownloader) {
+		if dldr.header == nil {
+			dldr.header = http.Header{}
+		}
+		dldr.header.Add(key, value)
+	}
+}
+
+// Report the result of probing each source as soon as it arrives, while
+// GatherInfo waits for the others. The callback is called from the goroutine
+// of GatherInfo, one source at a time.
+func WithSourceCallback(callback func(SourceResult)) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.onSource = callback
+	}
+}
+
+// Set the SHA-256 the file must have. The download is checked before the
+// partial file is renamed, and left under its partial name if it doesn't match.
+// If the output file is already there with this digest, SetupFile returns
+// ErrAlreadyComplete and there is nothing to download.
+func WithSHA256(hash string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.sha256 = hash
+	}
+}
+
+// Cancel all the requests of the downloader with the context. Download then
+// returns the error of the context.
+func WithContext(ctx context.Context) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.ctx = ctx
+	}
+}
+
+// Set the number of connections, instead of the one given to NewMultiDownloader
+func WithConnections(n int) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.nConns = n
+	}
+}
+
+// Set the timeout of the probes and other small requests, instead of the one
+// given to NewMultiDownloader. See WithIdleTimeout for the chunks.
+func WithTimeout(timeout time.Duration) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.timeout = timeout
+	}
+}
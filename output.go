@@ -0,0 +1,101 @@
+package multipartdownloader
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+)
+
+// Where the file is saved. By default, the name chosen by the filename
+// resolver (or given to SetupFile) is relative to the working directory, and
+// the partial file is next to it. WithOutputDir puts relative names in
+// another directory, and WithOutputPath replaces the resolved name.
+//
+// The partial file can be kept in another directory with WithTempDir (a
+// scratch disk, a tenant's directory...). When it's on another file system,
+// the finished file is copied to its destination instead of renamed.
+
+// Save the file into the given directory, created if missing, unless it's
+// given an absolute path
+func WithOutputDir(dir string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.outputDir = dir
+	}
+}
+
+// Save the file to the given path, whatever the name of the sources. A name
+// given to SetupFile still overrides it.
+func WithOutputPath(path string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.outputPath = path
+	}
+}
+
+// Name the partial file with the given suffix instead of ".part"
+func WithTempSuffix(suffix string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.tempSuffix = suffix
+	}
+}
+
+// Keep the partial file in the given directory instead of next to the output
+// file. It overrides the directory of the tenant, if any.
+func WithTempDir(dir string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.tempDir = dir
+	}
+}
+
+// Internal: the suffix of partial files
+func (dldr *MultiDownloader) partSuffix() string {
+	if dldr.tempSuffix != "" {
+		return dldr.tempSuffix
+	}
+	return tmpFileSuffix
+}
+
+// Internal: the partial file of an output file, in the given directory if
+// any. There, the name is prefixed with a hash of the output path, so that
+// files of the same name downloaded to different places don't clash.
+func partPath(dir, filename, suffix string) string {
+	if dir == "" {
+		return filename + suffix
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		abs = filename
+	}
+	h := fnv.New32a()
+	h.Write([]byte(abs))
+	return filepath.Join(dir, fmt.Sprintf("%08x-%s%s", h.Sum32(), filepath.Base(filename), suffix))
+}
+
+// Internal: set the output file from the name resolved for the sources,
+// unless WithOutputPath chose it
+func (dldr *MultiDownloader) setResolvedOutput(name string) {
+	if dldr.outputPath != "" {
+		name = dldr.outputPath
+	}
+	dldr.setOutput(name)
+}
+
+// Internal: set the output file and its partial file, in the output
+// directory if the name is relative
+func (dldr *MultiDownloader) setOutput(name string) {
+	if dldr.outputDir != "" && !filepath.IsAbs(name) {
+		name = filepath.Join(dldr.outputDir, name)
+	}
+	dldr.filename = name
+	dldr.partFilename = dldr.partName(name)
+}
+
+// Internal: create the directories of the output and partial files
+func (dldr *MultiDownloader) makeOutputDirs() error {
+	for _, name := range []string{dldr.filename, dldr.partFilename} {
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			return err
+		}
+	}
+	return nil
+}
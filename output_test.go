@@ -0,0 +1,86 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The output options choose where the file is saved
+func TestOutputLocation(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer ts.Close()
+	dir := t.TempDir()
+	tests := []struct {
+		opts     []Option
+		filename string // Given to SetupFile
+		expected string
+	}{
+		{[]Option{WithOutputDir(filepath.Join(dir, "a", "b"))}, "", filepath.Join(dir, "a", "b", "quijote.txt")},
+		{[]Option{WithOutputDir(filepath.Join(dir, "c"))}, "other.txt", filepath.Join(dir, "c", "other.txt")},
+		{[]Option{WithOutputDir(filepath.Join(dir, "c"))}, filepath.Join(dir, "abs.txt"), filepath.Join(dir, "abs.txt")},
+		{[]Option{WithOutputPath(filepath.Join(dir, "d", "named.txt"))}, "", filepath.Join(dir, "d", "named.txt")},
+		{[]Option{WithOutputDir(dir), WithOutputPath("e.txt")}, "", filepath.Join(dir, "e.txt")},
+	}
+	for _, test := range tests {
+		dldr := NewMultiDownloader([]string{ts.URL + "/quijote.txt"}, 2, 5*time.Second, test.opts...)
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(test.filename)
+		failOnError(t, err)
+		if dldr.partFilename != test.expected+tmpFileSuffix {
+			t.Errorf("Expected the partial file %s, got %s", test.expected+tmpFileSuffix, dldr.partFilename)
+		}
+		failOnError(t, dldr.Download(nil))
+		if _, err := os.Stat(test.expected); err != nil {
+			t.Errorf("Expected the file at %s: %v", test.expected, err)
+		}
+	}
+}
+
+// The partial file can be kept elsewhere, with another suffix
+func TestTempLocation(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer ts.Close()
+	dir, tempDir := t.TempDir(), t.TempDir()
+	dldr := NewMultiDownloader([]string{ts.URL + "/quijote.txt"}, 2, 5*time.Second,
+		WithOutputDir(dir), WithTempDir(tempDir), WithTempSuffix(".tmp"))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile("")
+	failOnError(t, err)
+	if filepath.Dir(dldr.partFilename) != tempDir || filepath.Ext(dldr.partFilename) != ".tmp" {
+		t.Errorf("Expected a .tmp partial file in %s, got %s", tempDir, dldr.partFilename)
+	}
+	failOnError(t, dldr.Download(nil))
+	if _, err := os.Stat(filepath.Join(dir, "quijote.txt")); err != nil {
+		t.Error("Expected the file in the output directory: ", err)
+	}
+	if _, err := os.Stat(dldr.partFilename); !os.IsNotExist(err) {
+		t.Error("Expected the partial file to be moved")
+	}
+}
+
+// A file that can't be renamed is copied
+func TestMoveFileCopy(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	failOnError(t, os.WriteFile(src, []byte("content"), 0640))
+	// Renaming a file over a non-empty directory fails, and so does the copy
+	dst := filepath.Join(dir, "dst")
+	failOnError(t, os.MkdirAll(filepath.Join(dst, "child"), 0777))
+	if err := (&MultiDownloader{}).moveFile(src, dst); err == nil {
+		t.Error("Expected moving over a directory to fail")
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Error("Expected the source to be kept when the copy fails")
+	}
+	entries, err := os.ReadDir(dir)
+	failOnError(t, err)
+	if len(entries) != 2 {
+		t.Errorf("Expected the temporary copy to be removed, found %d files", len(entries))
+	}
+}
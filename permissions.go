@@ -0,0 +1,39 @@
+package multipartdownloader
+
+import (
+	"os"
+)
+
+// Permissions of the output file. By default it gets the mode of the files
+// created with os.Create, 0666 minus the umask, and belongs to the user
+// running the download. A daemon downloading files on behalf of other users
+// can give them other mode bits and, running privileged, another owner.
+
+// Give the output file these mode bits once complete
+func WithFileMode(mode os.FileMode) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.fileMode = mode.Perm()
+	}
+}
+
+// Give the output file this owner and group once complete, -1 keeping either.
+// This needs privileges, and isn't supported on Windows.
+func WithOwner(uid, gid int) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.owner = &[2]int{uid, gid}
+	}
+}
+
+// Internal: apply the requested owner and mode to the file. The owner goes
+// first, as changing it may clear the setuid and setgid bits.
+func (dldr *MultiDownloader) setPermissions(name string) error {
+	if dldr.owner != nil {
+		if err := os.Chown(name, dldr.owner[0], dldr.owner[1]); err != nil {
+			return withHint(err, "Changing the owner of files needs privileges (root or CAP_CHOWN)")
+		}
+	}
+	if dldr.fileMode != 0 {
+		return os.Chmod(name, dldr.fileMode)
+	}
+	return nil
+}
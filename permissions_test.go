@@ -0,0 +1,39 @@
+//go:build !windows
+
+package multipartdownloader
+
+import (
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFilePermissions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(strings.Repeat("some content", 100)))
+		zw.Close()
+	}))
+	defer server.Close()
+
+	for _, opts := range [][]Option{{}, {WithOutputFilters(GzipFilter)}} {
+		output := filepath.Join(t.TempDir(), "file")
+		opts = append(opts, WithFileMode(0640), WithOwner(-1, os.Getgid()))
+		dldr := NewMultiDownloader([]string{server.URL + "/file.gz"}, 1, 5*time.Second, opts...)
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(output)
+		failOnError(t, err)
+		failOnError(t, dldr.Download(nil))
+		info, err := os.Stat(output)
+		failOnError(t, err)
+		if info.Mode().Perm() != 0640 {
+			t.Errorf("The file should have mode 0640, has %v", info.Mode().Perm())
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// OpenPGP signatures of downloaded files. With WithPGPSignature, the detached
+// signature the publisher puts next to the file (file.iso.asc or
+// file.iso.sig) is fetched by GatherInfo, and once downloaded the file must
+// be signed by one of the keys of the given keyring. Otherwise Download fails
+// with ErrBadPGPSignature, and the partial file is kept to look into.
+
+// The file isn't signed by any key of the keyring
+var ErrBadPGPSignature = errors.New("Bad OpenPGP signature")
+
+// Largest signature accepted, as they are read whole
+const maxPGPSignatureSize = 1 << 20
+
+// Check the OpenPGP signature of the file once downloaded, against the keys
+// of the keyring, armored or binary (gpg --export). The detached signature is
+// fetched from sigURL, or when empty from the URL of the file with .asc or
+// .sig appended.
+func WithPGPSignature(sigURL string, keyring []byte) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.pgpURL = sigURL
+		dldr.pgpKeyring = keyring
+	}
+}
+
+// Internal: whether the file must be signed
+func (dldr *MultiDownloader) checksPGPSignature() bool {
+	return dldr.pgpKeyring != nil
+}
+
+// Internal: fetch the detached signature of the file from its URL, or the
+// ones guessed from the URL of the file
+func (dldr *MultiDownloader) loadPGPSignature(fileURL string) error {
+	urls := []string{dldr.pgpURL}
+	if dldr.pgpURL == "" {
+		urls = []string{fileURL + ".asc", fileURL + ".sig"}
+	}
+	var err error
+	for _, u := range urls {
+		if dldr.pgpSignature, err = dldr.fetchPGPSignature(u); err == nil {
+			dldr.logVerbose("Signature: ", u)
+			return nil
+		}
+	}
+	return withHint(err, "Give the URL of the signature of the file")
+}
+
+// Internal: fetch a signature
+func (dldr *MultiDownloader) fetchPGPSignature(url string) ([]byte, error) {
+	req, err := dldr.newRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := dldr.httpClient(url, dldr.timeout).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("Failed fetching %s: %v", url, statusError(resp.StatusCode)))
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxPGPSignatureSize))
+}
+
+// Internal: check that the file is signed by a key of the keyring
+func (dldr *MultiDownloader) verifyPGPSignature(filename string) error {
+	keyring, err := readKeyring(dldr.pgpKeyring)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	check := openpgp.CheckDetachedSignature
+	if isArmored(dldr.pgpSignature) {
+		check = openpgp.CheckArmoredDetachedSignature
+	}
+	signer, err := check(keyring, file, bytes.NewReader(dldr.pgpSignature), nil)
+	if err != nil {
+		return withHint(fmt.Errorf("%w of %s: %v", ErrBadPGPSignature, dldr.filename, err),
+			"The file may have been tampered with. It was kept as "+filename+" to look into")
+	}
+	for _, identity := range signer.Identities {
+		dldr.logVerbose("Good signature from ", identity.Name)
+		break
+	}
+	return nil
+}
+
+// Internal: parse an armored or binary keyring
+func readKeyring(data []byte) (openpgp.EntityList, error) {
+	var keyring openpgp.EntityList
+	var err error
+	if isArmored(data) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid keyring: %v", err))
+	}
+	return keyring, nil
+}
+
+// Internal: whether OpenPGP data is ASCII armored
+func isArmored(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN PGP"))
+}
@@ -0,0 +1,79 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// A new key, and its public part as a binary keyring
+func newPGPKey(t *testing.T) (*openpgp.Entity, []byte) {
+	entity, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	failOnError(t, err)
+	var keyring bytes.Buffer
+	failOnError(t, entity.Serialize(&keyring))
+	return entity, keyring.Bytes()
+}
+
+func TestPGPSignature(t *testing.T) {
+	data, err := os.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	signer, keyring := newPGPKey(t)
+	_, otherKeyring := newPGPKey(t)
+	var armored, binary bytes.Buffer
+	failOnError(t, openpgp.ArmoredDetachSign(&armored, signer, bytes.NewReader(data), nil))
+	failOnError(t, openpgp.DetachSign(&binary, signer, bytes.NewReader(data), nil))
+
+	fileServer := http.FileServer(http.Dir("./test"))
+	mux := http.NewServeMux()
+	mux.Handle("/quijote.txt", fileServer)
+	mux.HandleFunc("/quijote.txt.sig", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(binary.Bytes())
+	})
+	mux.HandleFunc("/signature.asc", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(armored.Bytes())
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, test := range []struct {
+		sigURL  string
+		keyring []byte
+		good    bool
+	}{
+		{"", keyring, true},
+		{server.URL + "/signature.asc", keyring, true},
+		{"", otherKeyring, false},
+	} {
+		dldr := NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 2,
+			5*time.Second, WithPGPSignature(test.sigURL, test.keyring))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+		failOnError(t, err)
+		err = dldr.Download(nil)
+		if test.good {
+			failOnError(t, err)
+			continue
+		}
+		if !errors.Is(err, ErrBadPGPSignature) {
+			t.Errorf("A file signed by another key should fail with ErrBadPGPSignature: %v", err)
+		}
+		if _, err := os.Stat(dldr.partFilename); err != nil {
+			t.Error("The partial file of a bad signature should be kept")
+		}
+	}
+
+	dldr := NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 2,
+		5*time.Second, WithPGPSignature(server.URL+"/missing.asc", keyring))
+	if _, err := dldr.GatherInfo(); err == nil {
+		t.Error("A missing signature should fail")
+	}
+}
@@ -0,0 +1,117 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"sync"
+)
+
+// Per-piece verification. With the digests of the fixed-size pieces of the
+// file, as published by metalinks and torrents, each piece is checked as soon
+// as it's written, and a corrupt one is downloaded again right away, from
+// other mirrors than the ones that served it, rather than the whole file
+// failing its digest at the end. A piece failing more times than there are
+// mirrors stops the download with ErrCorruptPiece.
+
+// Returned when a piece can't be downloaded without corruption
+var ErrCorruptPiece = errors.New("Corrupt piece")
+
+// The expected digests of the pieces of a file
+type PieceHashes struct {
+	Size int64            // Size of the pieces, but for the last one
+	Hash func() hash.Hash // Hash of the pieces, like sha1.New for torrents
+	Sums [][]byte         // Expected digest of each piece
+}
+
+// Parse the digests of the pieces of a file, given in hex
+func ParsePieceHashes(size int64, h func() hash.Hash, sums []string) (*PieceHashes, error) {
+	if size <= 0 {
+		return nil, errors.New(fmt.Sprintf("Invalid piece size %d", size))
+	}
+	pieces := &PieceHashes{Size: size, Hash: h, Sums: make([][]byte, len(sums))}
+	for i, s := range sums {
+		sum, err := hex.DecodeString(s)
+		if err != nil || len(sum) != h().Size() {
+			return nil, errors.New(fmt.Sprintf("Invalid digest of piece %d: %q", i, s))
+		}
+		pieces.Sums[i] = sum
+	}
+	return pieces, nil
+}
+
+// Check each piece of the file against its digest as soon as it's written
+func WithPieceHashes(pieces *PieceHashes) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.pieces = pieces
+	}
+}
+
+// The pieces checked so far in a download
+type pieceState struct {
+	mu       sync.Mutex
+	verified []bool
+	failures []int
+}
+
+// Internal: whether the pieces are checked as they're written
+func (dldr *MultiDownloader) checksPieces() bool {
+	return dldr.pieces != nil && dldr.segments == nil && dldr.encoding == ""
+}
+
+// Internal: start checking the pieces of a download, unless it's a repair of
+// one already checked or a resumed one whose pieces were checked when set up
+func (dldr *MultiDownloader) startPieces() error {
+	n := (dldr.fileLength + dldr.pieces.Size - 1) / dldr.pieces.Size
+	if int64(len(dldr.pieces.Sums)) != n {
+		return errors.New(fmt.Sprintf("%d piece digests for %d pieces", len(dldr.pieces.Sums), n))
+	}
+	if dldr.pieceState == nil {
+		dldr.pieceState = &pieceState{verified: make([]bool, n), failures: make([]int, n)}
+	}
+	return nil
+}
+
+// Internal: check the pieces overlapping a range that are written and not
+// checked yet, returning the corrupt ones. Returns ErrCorruptPiece if a piece
+// failed too many times.
+func (dldr *MultiDownloader) checkPieces(r Chunk) ([]Chunk, error) {
+	file, err := os.Open(dldr.partFilename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	state, size := dldr.pieceState, dldr.pieces.Size
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	var bad []Chunk
+	data := make([]byte, size)
+	for p := r.Begin / size; p*size < r.End && p < int64(len(state.verified)); p++ {
+		piece := Chunk{p * size, min((p+1)*size, dldr.fileLength)}
+		if state.verified[p] || !dldr.written.coversRange(piece) {
+			continue
+		}
+		n, err := file.ReadAt(data[:piece.End-piece.Begin], piece.Begin)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		h := dldr.pieces.Hash()
+		h.Write(data[:n])
+		if bytes.Equal(h.Sum(nil), dldr.pieces.Sums[p]) {
+			state.verified[p] = true
+			continue
+		}
+		state.failures[p]++
+		if state.failures[p] > len(dldr.sourceURLs()) {
+			return nil, fmt.Errorf("%w %d (%d-%d)", ErrCorruptPiece, p, piece.Begin, piece.End)
+		}
+		dldr.logVerbose("Piece ", p, " is corrupt, downloading it again")
+		bad = append(bad, piece)
+	}
+	return bad, nil
+}
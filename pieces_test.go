@@ -0,0 +1,81 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Digests of the pieces of some data
+func makePieceHashes(t *testing.T, data []byte, size int64) *PieceHashes {
+	var sums []string
+	for begin := int64(0); begin < int64(len(data)); begin += size {
+		sum := sha1.Sum(data[begin:min(begin+size, int64(len(data)))])
+		sums = append(sums, hex.EncodeToString(sum[:]))
+	}
+	pieces, err := ParsePieceHashes(size, sha1.New, sums)
+	failOnError(t, err)
+	return pieces
+}
+
+// A corrupt piece is downloaded again from the other mirror as soon as it's
+// written, and only that piece
+func TestPieceHashes(t *testing.T) {
+	data, err := os.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	corrupted := append([]byte(nil), data...)
+	copy(corrupted[50000:], "corrupted")
+	copy(corrupted[250000:], "corrupted")
+	serve := func(content []byte, gets *int64) *httptest.Server {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				atomic.AddInt64(gets, 1)
+			}
+			http.ServeContent(w, r, "quijote.txt", time.Time{}, bytes.NewReader(content))
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+
+	var goodGets, badGets int64
+	good, bad := serve(data, &goodGets), serve(corrupted, &badGets)
+	dldr := NewMultiDownloader(
+		[]string{good.URL + "/quijote.txt", bad.URL + "/quijote.txt"}, 2, 5*time.Second,
+		WithPieceHashes(makePieceHashes(t, data, 16<<10)), WithSplitting(false))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	downloaded, err := os.ReadFile(dldr.filename)
+	failOnError(t, err)
+	if !bytes.Equal(downloaded, data) {
+		t.Error("The corrupt piece should be downloaded again")
+	}
+	if n := atomic.LoadInt64(&badGets); n != 1 {
+		t.Errorf("The corrupt piece should be downloaded from the other mirror, the bad one got %d requests", n)
+	}
+	if n := atomic.LoadInt64(&goodGets); n != 2 {
+		t.Errorf("Only the corrupt piece should be downloaded again, the good mirror got %d requests", n)
+	}
+
+	// Without a good mirror, the download stops
+	dldr = NewMultiDownloader(
+		[]string{bad.URL + "/quijote.txt"}, 2, 5*time.Second,
+		WithPieceHashes(makePieceHashes(t, data, 16<<10)))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	if err := dldr.Download(nil); !errors.Is(err, ErrCorruptPiece) {
+		t.Errorf("The download should fail with ErrCorruptPiece, got %v", err)
+	}
+}
@@ -0,0 +1,44 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Preallocation of the partial file. By default it's only truncated to the
+// length of the file, which leaves it sparse: the file system allocates the
+// blocks as the connections write them, scattered, and a full disk is only
+// noticed in the middle of the download. Preallocating reserves all the
+// blocks up front instead (fallocate on Linux, F_PREALLOCATE on macOS,
+// SetFileValidData on Windows), failing right away if they don't fit.
+//
+// On Windows, SetFileValidData needs the SeManageVolumePrivilege, and leaves
+// the previous contents of the disk in the parts not written yet: RecoverFrom
+// can't tell them from downloaded data.
+
+// Returned by preallocate when the platform or file system can't do it
+var errPreallocUnsupported = errors.New("Preallocation not supported")
+
+// Reserve the disk space of the partial file before downloading. Disabled by
+// default.
+func WithPreallocation(enabled bool) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.preallocate = enabled
+	}
+}
+
+// Internal: reserve the space of the partial file, falling back to a sparse
+// file where preallocation isn't supported
+func (dldr *MultiDownloader) allocate(file *os.File) error {
+	err := preallocate(file, dldr.fileLength)
+	if errors.Is(err, errPreallocUnsupported) {
+		dldr.logVerbose(err, ", the partial file is sparse")
+		return nil
+	}
+	if err != nil {
+		return withHint(fmt.Errorf("Couldn't reserve %d bytes for %s: %w", dldr.fileLength, file.Name(), err),
+			"Free some disk space, or save the file to another disk")
+	}
+	return nil
+}
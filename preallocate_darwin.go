@@ -0,0 +1,39 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Internal: allocate the blocks of the file with F_PREALLOCATE, contiguous if
+// possible, then extend it to size
+func preallocate(f *os.File, size int64) error {
+	if size == 0 {
+		return nil
+	}
+	store := unix.Fstore_t{
+		Flags:   unix.F_ALLOCATECONTIG | unix.F_ALLOCATEALL,
+		Posmode: unix.F_PEOFPOSMODE,
+		Length:  size,
+	}
+	err := unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, &store)
+	if err != nil {
+		store.Flags = unix.F_ALLOCATEALL
+		err = unix.FcntlFstore(f.Fd(), unix.F_PREALLOCATE, &store)
+	}
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EINVAL) {
+		return fmt.Errorf("%w: %v", errPreallocUnsupported, err)
+	}
+	if err != nil {
+		return err
+	}
+	return f.Truncate(size)
+}
+
+// Internal: releasing the blocks of a range isn't supported here
+func punchHole(f *os.File, offset, length int64) error {
+	return errPreallocUnsupported
+}
@@ -0,0 +1,27 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Internal: allocate the blocks of the file with fallocate, extending it to size
+func preallocate(f *os.File, size int64) error {
+	if size == 0 {
+		return nil
+	}
+	err := unix.Fallocate(int(f.Fd()), 0, 0, size)
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.ENOSYS) {
+		return fmt.Errorf("%w: %v", errPreallocUnsupported, err)
+	}
+	return err
+}
+
+// Internal: release the blocks of a range of the file, which reads as zeros
+// then
+func punchHole(f *os.File, offset, length int64) error {
+	return unix.Fallocate(int(f.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, offset, length)
+}
@@ -0,0 +1,37 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// The blocks of the partial file are allocated before downloading
+func TestPreallocation(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer ts.Close()
+	dldr := NewMultiDownloader([]string{ts.URL + "/quijote.txt"}, 2, 5*time.Second, WithPreallocation(true))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	info, err := dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	if info.Size() != dldr.fileLength {
+		t.Errorf("Expected a partial file of %d bytes, got %d", dldr.fileLength, info.Size())
+	}
+	var st syscall.Stat_t
+	failOnError(t, syscall.Stat(dldr.partFilename, &st))
+	if st.Blocks*512 < dldr.fileLength {
+		f, err := os.Open(dldr.partFilename)
+		failOnError(t, err)
+		defer f.Close()
+		if preallocate(f, 1) != nil {
+			t.Skip("Preallocation not supported by the file system")
+		}
+		t.Errorf("Expected %d bytes allocated, got %d", dldr.fileLength, st.Blocks*512)
+	}
+	failOnError(t, dldr.Download(nil))
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !darwin && !windows
+
+package multipartdownloader
+
+import "os"
+
+// Internal: preallocation isn't supported on this platform
+func preallocate(f *os.File, size int64) error {
+	return errPreallocUnsupported
+}
+
+// Internal: releasing the blocks of a range isn't supported here
+func punchHole(f *os.File, offset, length int64) error {
+	return errPreallocUnsupported
+}
@@ -0,0 +1,27 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Internal: extend the file to size, then mark it all valid with
+// SetFileValidData so that it isn't zero-filled as it's written
+func preallocate(f *os.File, size int64) error {
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	err := windows.SetFileValidData(windows.Handle(f.Fd()), size)
+	if errors.Is(err, windows.ERROR_PRIVILEGE_NOT_HELD) {
+		return fmt.Errorf("%w: %v", errPreallocUnsupported, err)
+	}
+	return err
+}
+
+// Internal: releasing the blocks of a range isn't supported here
+func punchHole(f *os.File, offset, length int64) error {
+	return errPreallocUnsupported
+}
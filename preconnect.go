@@ -0,0 +1,57 @@
+package multipartdownloader
+
+import "sync"
+
+// Pre-connecting to the mirrors. After gathering the info of the file, the
+// connections of the chunks are opened with concurrent HEAD requests to each
+// mirror, and left idle in its transport for Download to reuse: the chunk
+// requests don't wait for TCP and TLS handshakes then, which is noticeable
+// with TLS on far away servers. Idle connections are closed by the transports
+// after 90 seconds, so Download should follow shortly.
+
+// Enable or disable opening the connections to the mirrors in GatherInfo.
+// Disabled by default.
+func WithPreconnect(enabled bool) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.preconnect = enabled
+	}
+}
+
+// Internal: open as many connections to each mirror as it will get chunks,
+// the probe's included
+func (dldr *MultiDownloader) warmUp() {
+	if dldr.single || dldr.segments != nil {
+		return
+	}
+	n := min(dldr.nConns, len(dldr.chunks))
+	perMirror := (n + len(dldr.urls) - 1) / len(dldr.urls)
+	if dldr.mirrors.limit > 0 {
+		perMirror = min(perMirror, dldr.mirrors.limit)
+	}
+	if perMirror <= 1 {
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, url := range dldr.urls {
+		client := dldr.httpClient(url, dldr.timeout)
+		for i := 0; i < perMirror; i++ {
+			wg.Add(1)
+			go func(url string) {
+				defer wg.Done()
+				req, err := dldr.newRequest("HEAD", url, nil)
+				if err != nil {
+					return
+				}
+				resp, err := client.Do(req)
+				if err != nil {
+					dldr.logVerbose("Preconnecting to ", url, ": ", err)
+					return
+				}
+				resp.Body.Close()
+			}(url)
+		}
+	}
+	wg.Wait()
+	dldr.logVerbose("Preconnected ", perMirror, " connections to each mirror")
+}
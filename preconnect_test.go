@@ -0,0 +1,40 @@
+package multipartdownloader
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The connections of the chunks are open before downloading
+func TestPreconnect(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	var conns atomic.Int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			time.Sleep(50 * time.Millisecond) // Keep the probes concurrent
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	dldr := NewMultiDownloader([]string{ts.URL + "/quijote.txt"}, 4, 5*time.Second, WithPreconnect(true))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	if n := conns.Load(); n != 4 {
+		t.Errorf("Expected 4 connections after gathering the info, got %d", n)
+	}
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+}
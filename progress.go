@@ -0,0 +1,294 @@
+package multipartdownloader
+
+import (
+	"sync"
+	"time"
+)
+
+// Speed and time left of downloads, for progress feedback. The speed of each
+// connection is a moving average of what it received over the last seconds,
+// so that a short stall or burst doesn't make the time left jump around. The
+// speed and time left of the whole download are those of all the connections
+// together (TotalSpeed, TimeLeft), summed up with the bytes downloaded in a
+// ProgressSummary for consumers that don't care about each connection.
+//
+// The connections never wait for the progress to be reported: they record
+// where they are, and the feedback function and callbacks are called from
+// another goroutine with the latest progress of all of them, skipping what
+// changed while they were busy. Progress offers the same as a channel. The
+// progress is reported at most every 100ms by default (WithProgressInterval),
+// as the connections make progress with every few KiB received.
+
+// Weight of the latest sample in the moving average of the speed
+const speedSmoothing = 0.3
+
+// Time over which the speed is sampled
+const speedInterval = 500 * time.Millisecond
+
+// Default shortest time between two reports of the progress
+const defaultProgressInterval = 100 * time.Millisecond
+
+// Moving average of the speed of a connection
+type speedMeter struct {
+	sampled time.Time // When the last sample was taken
+	current int64     // Position at the last sample
+	speed   float64   // Bytes per second, 0 until the first sample
+}
+
+// Internal: account for the connection being at a position, returning the
+// speed. A connection going back (a chunk taken again from its beginning)
+// starts over.
+func (m *speedMeter) update(now time.Time, current int64) float64 {
+	if m.sampled.IsZero() || current < m.current {
+		m.sampled, m.current, m.speed = now, current, 0
+		return m.speed
+	}
+	elapsed := now.Sub(m.sampled)
+	if elapsed < speedInterval {
+		return m.speed
+	}
+	sample := float64(current-m.current) / elapsed.Seconds()
+	if m.speed == 0 {
+		m.speed = sample
+	} else {
+		m.speed += speedSmoothing * (sample - m.speed)
+	}
+	m.sampled, m.current = now, current
+	return m.speed
+}
+
+// Internal: time to download the bytes left at some speed, 0 if unknown
+func timeLeft(left int64, speed float64) time.Duration {
+	if left <= 0 || speed <= 0 {
+		return 0
+	}
+	return time.Duration(float64(left) / speed * float64(time.Second))
+}
+
+// Internal: stamp the speed and time left of a connection on its progress
+func (m *speedMeter) measure(now time.Time, p *ConnectionProgress) {
+	p.Speed = m.update(now, p.Current)
+	p.ETA = timeLeft(p.End-p.Current, p.Speed)
+}
+
+// Bytes per second received by all the connections
+func TotalSpeed(progress []ConnectionProgress) float64 {
+	speed := 0.0
+	for _, p := range progress {
+		if p.Current < p.End {
+			speed += p.Speed
+		}
+	}
+	return speed
+}
+
+// Estimated time until the download is complete at the current speed of all
+// the connections, 0 if unknown
+func TimeLeft(progress []ConnectionProgress) time.Duration {
+	left := int64(0)
+	for _, p := range progress {
+		left += p.End - p.Current
+	}
+	return timeLeft(left, TotalSpeed(progress))
+}
+
+// Report the progress at most once per interval, with the latest progress of
+// the connections, instead of every 100ms. Zero reports it as often as the
+// feedback function keeps up. The final progress is always reported.
+func WithProgressInterval(interval time.Duration) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.progressInterval = interval
+	}
+}
+
+// Progress of a whole download
+type ProgressSummary struct {
+	TotalBytes        int64         `json:"total_bytes"`        // Size of the file
+	DownloadedBytes   int64         `json:"downloaded_bytes"`   // Bytes of the file written, including resumed ones
+	Percent           float64       `json:"percent"`            // Percentage of the file written, 0 to 100
+	ActiveConnections int           `json:"active_connections"` // Connections in the middle of a chunk
+	Speed             float64       `json:"speed"`              // Bytes per second of all the connections
+	ETA               time.Duration `json:"eta"`                // Time left at that speed, 0 if unknown
+}
+
+// Report the progress of the whole download along with the progress of each
+// connection, from the same goroutine as the feedback function of Download
+func WithProgressSummary(callback func(ProgressSummary)) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.onSummary = callback
+	}
+}
+
+// Sum up the progress of the connections downloading a file
+func Summarize(fileLength int64, progress []ConnectionProgress) ProgressSummary {
+	left := int64(0)
+	active := 0
+	for _, p := range progress {
+		left += p.End - p.Current
+		if p.Current > p.Begin && p.Current < p.End {
+			active++
+		}
+	}
+	summary := ProgressSummary{
+		TotalBytes:        fileLength,
+		DownloadedBytes:   max(fileLength-left, 0),
+		ActiveConnections: active,
+		Speed:             TotalSpeed(progress),
+	}
+	if fileLength > 0 {
+		summary.Percent = float64(summary.DownloadedBytes) * 100 / float64(fileLength)
+	}
+	summary.ETA = timeLeft(left, summary.Speed)
+	return summary
+}
+
+// Progress of a download, as sent by the channel of Progress
+type ProgressEvent struct {
+	Connections []ConnectionProgress
+	Summary     ProgressSummary
+}
+
+// Channel receiving the progress of the next download, as an alternative to
+// the feedback function of Download. It holds the latest progress only: a
+// consumer slower than the download misses the progress in between, and never
+// slows it down. The channel is closed once the download ends, after the
+// final progress. Call it before each download.
+func (dldr *MultiDownloader) Progress() <-chan ProgressEvent {
+	dldr.events = make(chan ProgressEvent, 1)
+	return dldr.events
+}
+
+// The progress of the connections of a download, updated by the connections
+// and reported from another goroutine
+type progressTracker struct {
+	mu      sync.Mutex
+	conns   []ConnectionProgress
+	meters  []speedMeter
+	changed chan struct{} // Signaled when some connection made progress
+	done    chan struct{} // Closed when the download ends
+}
+
+// Internal: track the progress of the chunks of a download. Split chunks are
+// added at the end.
+func newProgressTracker(chunks []Chunk) *progressTracker {
+	t := &progressTracker{
+		conns:   make([]ConnectionProgress, len(chunks)),
+		meters:  make([]speedMeter, len(chunks)),
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	for i, c := range chunks {
+		t.conns[i] = ConnectionProgress{Id: i, Begin: c.Begin, End: c.End, Current: c.Begin}
+	}
+	return t
+}
+
+// Internal: record the progress of a connection, without waiting
+func (t *progressTracker) update(p ConnectionProgress) {
+	t.mu.Lock()
+	for len(t.conns) <= p.Id {
+		t.conns = append(t.conns, ConnectionProgress{Id: len(t.conns)})
+		t.meters = append(t.meters, speedMeter{})
+	}
+	t.conns[p.Id] = p
+	t.mu.Unlock()
+	select {
+	case t.changed <- struct{}{}:
+	default:
+	}
+}
+
+// Internal: the progress of all the connections, with their speed. The
+// connections not heard from slow down.
+func (t *progressTracker) snapshot() []ConnectionProgress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for i := range t.conns {
+		t.meters[i].measure(now, &t.conns[i])
+	}
+	return append([]ConnectionProgress(nil), t.conns...)
+}
+
+// Internal: stop reporting, once the final progress is
+func (t *progressTracker) finish() {
+	close(t.done)
+}
+
+// Internal: report the progress of a download as it changes, no more than
+// once per interval, until it ends. The channel is closed once the final
+// progress is reported.
+func (dldr *MultiDownloader) reportProgress(t *progressTracker, feedbackFunc func([]ConnectionProgress)) <-chan struct{} {
+	reported := make(chan struct{})
+	go func() {
+		defer close(reported)
+		var last time.Time
+		for {
+			select {
+			case <-t.changed:
+				// What changed meanwhile is reported along
+				if wait := dldr.progressInterval - time.Since(last); wait > 0 {
+					timer := time.NewTimer(wait)
+					select {
+					case <-timer.C:
+					case <-t.done:
+						timer.Stop()
+						dldr.report(t.snapshot(), feedbackFunc)
+						return
+					}
+				}
+				dldr.report(t.snapshot(), feedbackFunc)
+				last = time.Now()
+			case <-t.done:
+				dldr.report(t.snapshot(), feedbackFunc)
+				return
+			}
+		}
+	}()
+	return reported
+}
+
+// Internal: hand the progress to the feedback function, the summary callback,
+// the event writer and the channel, replacing the progress the channel still holds if any
+func (dldr *MultiDownloader) report(conns []ConnectionProgress, feedbackFunc func([]ConnectionProgress)) {
+	summary := Summarize(dldr.fileLength, conns)
+	if feedbackFunc != nil {
+		feedbackFunc(conns)
+	}
+	if dldr.onSummary != nil {
+		dldr.onSummary(summary)
+	}
+	if dldr.eventWriter != nil {
+		dldr.eventWriter.progress(summary)
+	}
+	if dldr.events != nil {
+		event := ProgressEvent{conns, summary}
+		select {
+		case dldr.events <- event:
+		default:
+			select {
+			case <-dldr.events:
+			default:
+			}
+			select {
+			case dldr.events <- event:
+			default:
+			}
+		}
+	}
+}
+
+// Internal: count the downloads running, as repairs and restarts run one
+// inside another
+func (dldr *MultiDownloader) enterDownload() {
+	dldr.downloads++
+}
+
+// Internal: close the progress channel once the outermost download ends
+func (dldr *MultiDownloader) leaveDownload() {
+	dldr.downloads--
+	if dldr.downloads == 0 && dldr.events != nil {
+		close(dldr.events)
+		dldr.events = nil
+	}
+}
@@ -0,0 +1,148 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSpeedMeter(t *testing.T) {
+	var m speedMeter
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	if speed := m.update(start, 0); speed != 0 {
+		t.Errorf("Speed before any sample should be 0, got %v", speed)
+	}
+	if speed := m.update(at(100*time.Millisecond), 1000); speed != 0 {
+		t.Errorf("Speed shouldn't be sampled that often, got %v", speed)
+	}
+	if speed := m.update(at(time.Second), 10000); speed != 10000 {
+		t.Errorf("First speed sample should be 10000, got %v", speed)
+	}
+	// A stall slows it down gradually
+	if speed := m.update(at(2*time.Second), 10000); speed != 7000 {
+		t.Errorf("Speed after a stalled second should be 7000, got %v", speed)
+	}
+	if speed := m.update(at(3*time.Second), 0); speed != 0 {
+		t.Errorf("Speed should start over when going back, got %v", speed)
+	}
+}
+
+func TestTimeLeft(t *testing.T) {
+	progress := []ConnectionProgress{
+		{Id: 0, Begin: 0, End: 1000, Current: 1000, Speed: 500},
+		{Id: 1, Begin: 1000, End: 3000, Current: 1500, Speed: 100},
+		{Id: 2, Begin: 3000, End: 5000, Current: 3500, Speed: 400},
+	}
+	if speed := TotalSpeed(progress); speed != 500 {
+		t.Errorf("Only connections still running count in the speed, got %v", speed)
+	}
+	if left := TimeLeft(progress); left != 6*time.Second {
+		t.Errorf("3000 bytes at 500 bytes/s should take 6s, got %v", left)
+	}
+	if left := TimeLeft(progress[:1]); left != 0 {
+		t.Errorf("Nothing left should take 0, got %v", left)
+	}
+}
+
+func TestProgressSummary(t *testing.T) {
+	progress := []ConnectionProgress{
+		{Id: 0, Begin: 0, End: 1000, Current: 1000},
+		{Id: 1, Begin: 1000, End: 3000, Current: 1500, Speed: 100},
+		{Id: 2, Begin: 3000, End: 4000, Current: 3000},
+	}
+	summary := Summarize(4000, progress)
+	expected := ProgressSummary{
+		TotalBytes: 4000, DownloadedBytes: 1500, Percent: 37.5, ActiveConnections: 1,
+		Speed: 100, ETA: 25 * time.Second,
+	}
+	if summary != expected {
+		t.Errorf("Summary is %+v, should be %+v", summary, expected)
+	}
+
+	var mu sync.Mutex
+	var summaries []ProgressSummary
+	failOnError(t, downloadLocal(t, http.FileServer(http.Dir("./test")), 3,
+		WithProgressSummary(func(s ProgressSummary) {
+			mu.Lock()
+			summaries = append(summaries, s)
+			mu.Unlock()
+		})))
+	mu.Lock()
+	defer mu.Unlock()
+	if len(summaries) == 0 {
+		t.Fatal("The progress of the download should be summed up")
+	}
+	for _, s := range summaries {
+		if s.TotalBytes != 317621 || s.DownloadedBytes <= 0 || s.Percent > 100 {
+			t.Errorf("Wrong summary %+v", s)
+		}
+	}
+}
+
+// A consumer of the progress channel never slows the download down, and gets
+// the final progress
+func TestProgressChannel(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+	dldr := NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 4, 5*time.Second)
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	events := dldr.Progress()
+	failOnError(t, dldr.Download(nil))
+
+	var last ProgressEvent
+	received := 0
+	for event := range events {
+		last = event
+		received++
+	}
+	if received != 1 {
+		t.Errorf("Only the latest progress should be kept, got %d events", received)
+	}
+	if last.Summary.Percent != 100 || len(last.Connections) < 4 {
+		t.Errorf("The final progress should be of the whole file, got %+v", last.Summary)
+	}
+}
+
+// The feedback function is called at most once per interval, with the latest
+// progress, and once more at the end
+func TestProgressInterval(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fileServer.ServeHTTP(slowWriter{w}, r)
+	})
+	server := httptest.NewServer(slow)
+	defer server.Close()
+	dldr := NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 4, 5*time.Second,
+		WithProgressInterval(200*time.Millisecond))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+
+	var calls []time.Time
+	var last []ConnectionProgress
+	start := time.Now()
+	failOnError(t, dldr.Download(func(progress []ConnectionProgress) {
+		calls = append(calls, time.Now())
+		last = progress
+	}))
+	elapsed := time.Since(start)
+	if most := int(elapsed/(200*time.Millisecond)) + 2; len(calls) > most || len(calls) < 2 {
+		t.Errorf("Expected at most %d calls in %v, got %d", most, elapsed, len(calls))
+	}
+	for i := 1; i < len(calls)-1; i++ {
+		if gap := calls[i].Sub(calls[i-1]); gap < 190*time.Millisecond {
+			t.Errorf("Calls %d and %d are only %v apart", i-1, i, gap)
+		}
+	}
+	if Summarize(317621, last).Percent != 100 {
+		t.Errorf("The last call should have the final progress, got %+v", last)
+	}
+}
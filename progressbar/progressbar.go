@@ -0,0 +1,120 @@
+// Package progressbar renders the progress of a download on a terminal: a
+// bar for each connection and one for the whole download, with its speed and
+// time left, redrawn in place as the progress arrives.
+//
+//	events := dldr.Progress()
+//	done := progressbar.New(os.Stderr).Start(events)
+//	err := dldr.Download(nil)
+//	<-done
+package progressbar
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	md "github.com/alvatar/multipart-downloader"
+)
+
+// Default width of the bars, in characters
+const DefaultWidth = 40
+
+// Bars drawn on a terminal
+type Bars struct {
+	Width int // Characters of each bar, DefaultWidth if 0
+
+	mu    sync.Mutex
+	w     io.Writer
+	lines int // Lines drawn last time, to draw over
+}
+
+// Create the bars of a download, drawn on a terminal writer (os.Stderr, as
+// os.Stdout may be the downloaded file)
+func New(w io.Writer) *Bars {
+	return &Bars{w: w}
+}
+
+// Draw the bars of the progress of a download until the channel is closed,
+// from another goroutine. The returned channel is closed once the final
+// progress is drawn.
+func (b *Bars) Start(events <-chan md.ProgressEvent) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range events {
+			b.Render(event)
+		}
+	}()
+	return done
+}
+
+// Draw the bars of the progress of the connections alone, as given to the
+// feedback function of Download
+func (b *Bars) Update(progress []md.ConnectionProgress) {
+	total := int64(0)
+	for _, p := range progress {
+		total += p.End - p.Begin
+	}
+	b.Render(md.ProgressEvent{Connections: progress, Summary: md.Summarize(total, progress)})
+}
+
+// Draw the bars of some progress over the ones drawn before
+func (b *Bars) Render(event md.ProgressEvent) {
+	var sb strings.Builder
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.lines > 0 {
+		fmt.Fprintf(&sb, "\x1b[%dA", b.lines) // Back to the first line
+	}
+	for _, p := range event.Connections {
+		done := 1.0
+		if p.End > p.Begin {
+			done = float64(p.Current-p.Begin) / float64(p.End-p.Begin)
+		}
+		fmt.Fprintf(&sb, "\r\x1b[K%3d: %s %5.1f%% %10s/s %8s\n",
+			p.Id+1, b.bar(done), done*100, FormatBytes(int64(p.Speed)), formatETA(p.ETA))
+	}
+	s := event.Summary
+	fmt.Fprintf(&sb, "\r\x1b[K All %s %5.1f%% %10s/s %8s  %s/%s\n",
+		b.bar(s.Percent/100), s.Percent, FormatBytes(int64(s.Speed)), formatETA(s.ETA),
+		FormatBytes(s.DownloadedBytes), FormatBytes(s.TotalBytes))
+	b.lines = len(event.Connections) + 1
+	io.WriteString(b.w, sb.String())
+}
+
+// Internal: a bar filled to a fraction
+func (b *Bars) bar(fraction float64) string {
+	width := b.Width
+	if width <= 0 {
+		width = DefaultWidth
+	}
+	filled := int(min(max(fraction, 0), 1) * float64(width))
+	if filled == width {
+		return "[" + strings.Repeat("=", width) + "]"
+	}
+	return "[" + strings.Repeat("=", filled) + ">" + strings.Repeat(" ", width-filled-1) + "]"
+}
+
+// A number of bytes with a binary unit: 512 B, 1.5 KiB, 3.2 GiB...
+func FormatBytes(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, unit := float64(n)/1024, 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, units[unit])
+}
+
+// Internal: a time left, or nothing if unknown
+func formatETA(eta time.Duration) string {
+	if eta <= 0 {
+		return ""
+	}
+	return eta.Round(time.Second).String()
+}
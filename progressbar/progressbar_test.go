@@ -0,0 +1,70 @@
+package progressbar
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	md "github.com/alvatar/multipart-downloader"
+)
+
+func TestRender(t *testing.T) {
+	var buf bytes.Buffer
+	bars := New(&buf)
+	bars.Width = 10
+	progress := []md.ConnectionProgress{
+		{Id: 0, Begin: 0, End: 1000, Current: 500, Speed: 2048, ETA: time.Second},
+		{Id: 1, Begin: 1000, End: 2000, Current: 2000},
+	}
+	bars.Update(progress)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Expected a bar per connection and the total, got %q", buf.String())
+	}
+	for i, want := range []string{"[=====>    ]  50.0%", "[==========] 100.0%", "[=======>  ]  75.0%"} {
+		if !strings.Contains(lines[i], want) {
+			t.Errorf("Line %d should contain %q, got %q", i, want, lines[i])
+		}
+	}
+	if !strings.Contains(lines[0], "2.0 KiB/s") || !strings.Contains(lines[0], "1s") {
+		t.Errorf("Expected the speed and time left of the connection, got %q", lines[0])
+	}
+	if strings.HasPrefix(buf.String(), "\x1b[") {
+		t.Error("Nothing should be drawn over the first time")
+	}
+
+	// Then the bars are drawn over
+	buf.Reset()
+	bars.Update(progress)
+	if !strings.HasPrefix(buf.String(), "\x1b[3A") {
+		t.Errorf("Expected the cursor to go back 3 lines, got %q", buf.String())
+	}
+}
+
+func TestStart(t *testing.T) {
+	var buf bytes.Buffer
+	events := make(chan md.ProgressEvent, 1)
+	done := New(&buf).Start(events)
+	events <- md.ProgressEvent{Summary: md.ProgressSummary{TotalBytes: 10, DownloadedBytes: 10, Percent: 100}}
+	close(events)
+	<-done
+	if !strings.Contains(buf.String(), "10 B/10 B") {
+		t.Errorf("The final progress should be drawn, got %q", buf.String())
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:             "0 B",
+		1023:          "1023 B",
+		1536:          "1.5 KiB",
+		5 << 20:       "5.0 MiB",
+		3 << 30:       "3.0 GiB",
+		1<<62 + 1<<61: "6.0 EiB",
+	} {
+		if got := FormatBytes(n); got != want {
+			t.Errorf("FormatBytes(%d) = %q, expected %q", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package multipartdownloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Provenance of downloaded files: where they come from, and the checksums
+// they were verified with, recorded once the file is complete so later runs
+// and other tools can check it. They are stored in extended attributes, with
+// the names used by wget and curl (user.xdg.origin.url...), or in a JSON
+// sidecar file next to the output file, which is also used when the platform
+// or file system doesn't support extended attributes.
+
+// Where the provenance of the file is recorded
+type ProvenanceStore int
+
+const (
+	ProvenanceXattr   ProvenanceStore = iota + 1 // Extended attributes of the file
+	ProvenanceSidecar                            // A name.provenance.json file next to it
+)
+
+// Suffix of the sidecar file of the provenance
+const ProvenanceSuffix = ".provenance.json"
+
+// Returned when extended attributes aren't supported
+var errXattrUnsupported = errors.New("Extended attributes are not supported")
+
+// Provenance of a downloaded file
+type Provenance struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	SHA256       string `json:"sha256,omitempty"` // Only when verified
+	SHA1         string `json:"sha1,omitempty"`   // Only when verified
+	SHA512       string `json:"sha512,omitempty"` // Only when verified
+}
+
+// Extended attributes of each field of the provenance
+var provenanceXattrs = []struct {
+	name  string
+	field func(*Provenance) *string
+}{
+	{"user.xdg.origin.url", func(p *Provenance) *string { return &p.URL }},
+	{"user.etag", func(p *Provenance) *string { return &p.ETag }},
+	{"user.last_modified", func(p *Provenance) *string { return &p.LastModified }},
+	{"user.checksum.sha256", func(p *Provenance) *string { return &p.SHA256 }},
+	{"user.checksum.sha1", func(p *Provenance) *string { return &p.SHA1 }},
+	{"user.checksum.sha512", func(p *Provenance) *string { return &p.SHA512 }},
+}
+
+// Record the provenance of the file once it's saved. Files streamed to a
+// writer or through output filters have none.
+func WithProvenance(store ProvenanceStore) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.provenance = store
+	}
+}
+
+// Read the provenance recorded for a file, from its sidecar file if there is
+// one, or else from its extended attributes
+func ReadProvenance(filename string) (*Provenance, error) {
+	p := &Provenance{}
+	data, err := os.ReadFile(filename + ProvenanceSuffix)
+	if err == nil {
+		if err := json.Unmarshal(data, p); err != nil {
+			return nil, fmt.Errorf("%w in the provenance of %s", err, filename)
+		}
+		return p, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, x := range provenanceXattrs {
+		value, err := getXattr(filename, x.name)
+		if err != nil {
+			return nil, err
+		}
+		*x.field(p) = value
+	}
+	if p.URL == "" {
+		return nil, errors.New(fmt.Sprintf("No provenance recorded for %s", filename))
+	}
+	return p, nil
+}
+
+// Internal: record the provenance of the saved file, if requested
+func (dldr *MultiDownloader) recordProvenance() error {
+	if dldr.provenance == 0 {
+		return nil
+	}
+	p := &Provenance{
+		URL:          dldr.sourceURLs()[0],
+		ETag:         dldr.ETag,
+		LastModified: dldr.lastModified,
+		SHA256:       dldr.sha256,
+		SHA1:         dldr.sha1,
+		SHA512:       dldr.sha512,
+	}
+	if dldr.provenance == ProvenanceXattr {
+		err := writeXattrs(dldr.filename, p)
+		if !errors.Is(err, errXattrUnsupported) {
+			return err
+		}
+		dldr.logVerbose("Extended attributes are not supported, writing the provenance to a sidecar file")
+	}
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dldr.filename+ProvenanceSuffix, append(data, '\n'), 0644)
+}
+
+// Internal: store the provenance in extended attributes, skipping empty fields
+func writeXattrs(filename string, p *Provenance) error {
+	for _, x := range provenanceXattrs {
+		if value := *x.field(p); value != "" {
+			if err := setXattr(filename, x.name, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
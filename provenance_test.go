@@ -0,0 +1,46 @@
+package multipartdownloader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProvenance(t *testing.T) {
+	data, err := os.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+
+	for _, store := range []ProvenanceStore{ProvenanceXattr, ProvenanceSidecar} {
+		output := filepath.Join(t.TempDir(), "quijote.txt")
+		url := server.URL + "/quijote.txt"
+		dldr := NewMultiDownloader(
+			[]string{url}, 2, 5*time.Second, WithSHA256(hash), WithProvenance(store))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(output)
+		failOnError(t, err)
+		failOnError(t, dldr.Download(nil))
+
+		_, errSidecar := os.Stat(output + ProvenanceSuffix)
+		if store == ProvenanceSidecar && errSidecar != nil {
+			t.Errorf("The provenance should be in a sidecar file: %v", errSidecar)
+		}
+		p, err := ReadProvenance(output)
+		if store == ProvenanceXattr && errSidecar == nil {
+			t.Log("Extended attributes not supported here, the sidecar file was used")
+		}
+		failOnError(t, err)
+		if p.URL != url || p.SHA256 != hash || p.LastModified == "" {
+			t.Errorf("Wrong provenance %+v", p)
+		}
+	}
+}
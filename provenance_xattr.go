@@ -0,0 +1,38 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package multipartdownloader
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// Internal: set an extended attribute of the file
+func setXattr(filename, name, value string) error {
+	err := unix.Setxattr(filename, name, []byte(value), 0)
+	if errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP) {
+		return errXattrUnsupported
+	}
+	return err
+}
+
+// Internal: get an extended attribute of the file, empty if it's not set
+func getXattr(filename, name string) (string, error) {
+	buf := make([]byte, 1024)
+	for {
+		n, err := unix.Getxattr(filename, name, buf)
+		switch {
+		case errors.Is(err, unix.ERANGE):
+			buf = make([]byte, 2*len(buf))
+			continue
+		case errors.Is(err, errNoXattr):
+			return "", nil
+		case errors.Is(err, unix.ENOTSUP) || errors.Is(err, unix.EOPNOTSUPP):
+			return "", errXattrUnsupported
+		case err != nil:
+			return "", err
+		}
+		return string(buf[:n]), nil
+	}
+}
@@ -0,0 +1,8 @@
+//go:build darwin || freebsd || netbsd
+
+package multipartdownloader
+
+import "golang.org/x/sys/unix"
+
+// Internal: error of getting an extended attribute that isn't set
+const errNoXattr = unix.ENOATTR
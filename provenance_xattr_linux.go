@@ -0,0 +1,6 @@
+package multipartdownloader
+
+import "golang.org/x/sys/unix"
+
+// Internal: error of getting an extended attribute that isn't set
+const errNoXattr = unix.ENODATA
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package multipartdownloader
+
+// Internal: extended attributes aren't supported on this platform
+func setXattr(filename, name, value string) error {
+	return errXattrUnsupported
+}
+
+// Internal: extended attributes aren't supported on this platform
+func getXattr(filename, name string) (string, error) {
+	return "", errXattrUnsupported
+}
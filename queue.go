@@ -0,0 +1,187 @@
+package multipartdownloader
+
+import (
+	"context"
+	"sort"
+	"sync"
+)
+
+// Priority queues share a number of connections among downloads, handing
+// them to the downloads of highest priority first. Priorities can be changed
+// while the downloads wait or run: when a download of higher priority waits
+// for a connection, downloads of lower priority are preempted. Their
+// connections stop where they are, and what's left of their chunks waits in
+// the queue again. Nothing is canceled, so interactive downloads can jump
+// ahead of background ones.
+
+// A priority queue of connections, shared by downloaders with WithQueue
+type Queue struct {
+	mu      sync.Mutex
+	slots   int
+	held    []*queueConn // Connections granted
+	waiting []*queueConn // Connections waiting, in no particular order
+	seq     int
+}
+
+// Statistics of a queue
+type QueueStats struct {
+	Connections int // Connections open
+	Waiting     int // Connections waiting to be granted
+	Preempted   int // Connections asked to stop for downloads of higher priority
+}
+
+// A downloader in a queue
+type queueJob struct {
+	queue    *Queue
+	priority int
+}
+
+// A connection of a downloader, waiting or granted
+type queueConn struct {
+	job       *queueJob
+	seq       int
+	granted   chan struct{}
+	preempt   chan struct{} // Closed when the connection has to stop
+	preempted bool
+}
+
+// Create a queue of the given number of connections
+func NewQueue(conns int) *Queue {
+	return &Queue{slots: conns}
+}
+
+// Run the downloads in a queue, with the given priority (higher first)
+func WithQueue(q *Queue, priority int) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.queued = &queueJob{queue: q, priority: priority}
+	}
+}
+
+// Internal: run the downloads as a job of a queue, whose priority is changed
+// by its owner
+func withQueueJob(job *queueJob) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.queued = job
+	}
+}
+
+// Change the priority of the downloader in its queue, waiting or running.
+// Does nothing if it isn't in a queue.
+func (dldr *MultiDownloader) SetPriority(priority int) {
+	if dldr.queued == nil {
+		return
+	}
+	q := dldr.queued.queue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	dldr.queued.priority = priority
+	q.schedule()
+}
+
+// Get a snapshot of the statistics of the queue
+func (q *Queue) Stats() QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	stats := QueueStats{Connections: len(q.held), Waiting: len(q.waiting)}
+	for _, c := range q.held {
+		if c.preempted {
+			stats.Preempted++
+		}
+	}
+	return stats
+}
+
+// Internal: wait for a connection for the job, returning the function
+// releasing it and a channel closed if it's preempted. Stops waiting when the
+// context is done.
+func (q *Queue) acquire(ctx context.Context, job *queueJob) (func(), <-chan struct{}, error) {
+	q.mu.Lock()
+	q.seq++
+	c := &queueConn{
+		job:     job,
+		seq:     q.seq,
+		granted: make(chan struct{}),
+		preempt: make(chan struct{}),
+	}
+	q.waiting = append(q.waiting, c)
+	q.schedule()
+	q.mu.Unlock()
+
+	release := func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		for i, h := range q.held {
+			if h == c {
+				q.held = append(q.held[:i], q.held[i+1:]...)
+				break
+			}
+		}
+		q.schedule()
+	}
+	select {
+	case <-c.granted:
+		return release, c.preempt, nil
+	case <-ctx.Done():
+	}
+
+	// Granted meanwhile, or still waiting
+	q.mu.Lock()
+	for i, w := range q.waiting {
+		if w == c {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			break
+		}
+	}
+	q.mu.Unlock()
+	release()
+	return nil, nil, ctx.Err()
+}
+
+// Internal: grant the free connections to the waiting ones of highest
+// priority, and preempt connections of lower priority for those left. Must
+// be called with the lock held.
+func (q *Queue) schedule() {
+	sort.SliceStable(q.waiting, func(i, j int) bool {
+		a, b := q.waiting[i], q.waiting[j]
+		if a.job.priority != b.job.priority {
+			return a.job.priority > b.job.priority
+		}
+		return a.seq < b.seq
+	})
+	for len(q.waiting) > 0 && len(q.held) < q.slots {
+		c := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		q.held = append(q.held, c)
+		close(c.granted)
+	}
+
+	// Connections already preempted will be free soon
+	freeing := 0
+	for _, h := range q.held {
+		if h.preempted {
+			freeing++
+		}
+	}
+	for _, w := range q.waiting {
+		if freeing > 0 {
+			freeing--
+			continue
+		}
+		// The newest connection of lowest priority is stopped first
+		var victim *queueConn
+		for _, h := range q.held {
+			if h.preempted || h.job.priority >= w.job.priority {
+				continue
+			}
+			if victim == nil || h.job.priority < victim.job.priority ||
+				(h.job.priority == victim.job.priority && h.seq > victim.seq) {
+				victim = h
+			}
+		}
+		if victim == nil {
+			break
+		}
+		victim.preempted = true
+		close(victim.preempt)
+	}
+}
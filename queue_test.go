@@ -0,0 +1,121 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Connections are granted by priority, then in order of arrival
+func TestQueueOrder(t *testing.T) {
+	q := NewQueue(1)
+	low, high := &queueJob{q, 0}, &queueJob{q, 1}
+	release, _, _ := q.acquire(context.Background(), low)
+
+	granted := make(chan int, 3)
+	for i, job := range []*queueJob{low, high, low} {
+		go func(i int, job *queueJob) {
+			release, _, _ := q.acquire(context.Background(), job)
+			granted <- i
+			release()
+		}(i, job)
+		for q.Stats().Waiting != i+1 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if stats := q.Stats(); stats.Preempted != 1 {
+		t.Errorf("The connection of lower priority wasn't preempted: %+v", stats)
+	}
+	release()
+	for _, want := range []int{1, 0, 2} {
+		if got := <-granted; got != want {
+			t.Errorf("Expected connection %d to be granted, got %d", want, got)
+		}
+	}
+}
+
+// A connection stops waiting when its context is done, leaving the queue
+func TestQueueCancel(t *testing.T) {
+	q := NewQueue(1)
+	job := &queueJob{q, 0}
+	release, _, err := q.acquire(context.Background(), job)
+	failOnError(t, err)
+	defer release()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		_, _, err := q.acquire(ctx, job)
+		result <- err
+	}()
+	for q.Stats().Waiting != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the wait to be canceled, got %v", err)
+	}
+	if stats := q.Stats(); stats.Waiting != 0 || stats.Connections != 1 {
+		t.Errorf("The canceled connection is still in the queue: %+v", stats)
+	}
+}
+
+// Raising the priority of a waiting download preempts the running one,
+// which finishes afterwards
+func TestQueueReprioritize(t *testing.T) {
+	data := make([]byte, 512<<10)
+	rand.New(rand.NewSource(1)).Read(data)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow.bin" {
+			w = slowWriter{w}
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	q := NewQueue(1)
+	dir := t.TempDir()
+	start := func(name string) (*MultiDownloader, chan error) {
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/" + name}, 1, 5*time.Second, WithQueue(q, 0))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(filepath.Join(dir, name))
+		failOnError(t, err)
+		result := make(chan error, 1)
+		go func() { result <- dldr.Download(nil) }()
+		return dldr, result
+	}
+
+	_, background := start("slow.bin")
+	for q.Stats().Connections != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	interactive, first := start("fast.bin")
+	for q.Stats().Waiting != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	interactive.SetPriority(1)
+
+	select {
+	case err := <-first:
+		failOnError(t, err)
+	case <-background:
+		t.Fatal("The download of lower priority finished first")
+	}
+	failOnError(t, <-background)
+	for _, name := range []string{"slow.bin", "fast.bin"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		failOnError(t, err)
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s differs from the source", name)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package multipartdownloader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Transfer quotas, for metered connections. A Quota caps the bytes
+// downloaded per day or month, and can be shared by several downloaders and
+// kept in a file across runs. Downloads that don't fit in what is left of
+// the quota are refused before starting, and if the quota runs out anyway
+// (when shared with other downloads) they stop. Either way they return a
+// *QuotaError.
+
+// Length of the periods of a quota
+type QuotaPeriod int
+
+const (
+	Daily QuotaPeriod = iota
+	Monthly
+)
+
+// Bytes downloaded between two saves of the quota usage
+const quotaSaveInterval = 1 << 20
+
+// Returned (wrapped in a *QuotaError) when a download hits its quota
+var ErrQuotaExceeded = errors.New("Download quota exceeded")
+
+// Details of an exhausted quota
+type QuotaError struct {
+	Limit int64
+	Used  int64
+	Reset time.Time // When the next period starts
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("Download quota exceeded: %d of %d bytes used, resets at %s",
+		e.Used, e.Limit, e.Reset.Format(time.RFC3339))
+}
+
+func (e *QuotaError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+// A transfer quota
+type Quota struct {
+	Limit  int64 // Bytes allowed per period
+	Period QuotaPeriod
+
+	path    string // File the usage is persisted to, if any
+	mu      sync.Mutex
+	start   time.Time // Beginning of the current period
+	used    int64     // Bytes downloaded in the current period
+	unsaved int64     // Bytes counted since the last save
+	now     func() time.Time
+}
+
+// Persisted usage of a quota
+type quotaState struct {
+	PeriodStart time.Time `json:"period_start"`
+	Used        int64     `json:"used"`
+}
+
+// Create a quota of limit bytes per period. If path isn't empty, the usage is
+// loaded from that file and saved back to it as it grows.
+func NewQuota(limit int64, period QuotaPeriod, path string) (*Quota, error) {
+	q := &Quota{Limit: limit, Period: period, path: path, now: time.Now}
+	q.start = q.periodStart(q.now())
+	if path == "" {
+		return q, nil
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state quotaState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errors.New(fmt.Sprintf("Invalid quota file %s: %v", path, err))
+	}
+	if state.PeriodStart.Equal(q.start) {
+		q.used = state.Used
+	}
+	return q, nil
+}
+
+// Set a transfer quota shared with other downloads
+func WithQuota(q *Quota) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.quota = q
+	}
+}
+
+// Bytes downloaded in the current period
+func (q *Quota) Used() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	return q.used
+}
+
+// Bytes that can still be downloaded in the current period
+func (q *Quota) Remaining() int64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	if q.used >= q.Limit {
+		return 0
+	}
+	return q.Limit - q.used
+}
+
+// Persist the usage, if the quota has a file
+func (q *Quota) Save() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.save()
+}
+
+// Internal: fail if the quota doesn't leave room for n more bytes
+func (q *Quota) check(n int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	if q.used >= q.Limit || n > q.Limit-q.used {
+		return q.exceeded()
+	}
+	return nil
+}
+
+// Internal: count downloaded bytes. The bytes are already transferred, so
+// they are counted even if they go over the limit.
+func (q *Quota) consume(n int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.rollover()
+	q.used += n
+	q.unsaved += n
+	if q.unsaved >= quotaSaveInterval || q.used >= q.Limit {
+		// Not being able to keep the count is no reason to stop the transfer
+		if err := q.save(); err != nil {
+			logWarning("Error saving the quota usage: ", err)
+		}
+	}
+	if q.used >= q.Limit {
+		return q.exceeded()
+	}
+	return nil
+}
+
+// Internal: start over when a new period begins
+func (q *Quota) rollover() {
+	if start := q.periodStart(q.now()); !start.Equal(q.start) {
+		q.start = start
+		q.used = 0
+	}
+}
+
+// Internal: the beginning of the period containing t, in local time
+func (q *Quota) periodStart(t time.Time) time.Time {
+	y, m, d := t.Date()
+	if q.Period == Monthly {
+		d = 1
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// Internal: the error describing the exhausted quota
+func (q *Quota) exceeded() error {
+	reset := q.start.AddDate(0, 0, 1)
+	if q.Period == Monthly {
+		reset = q.start.AddDate(0, 1, 0)
+	}
+	return withHint(&QuotaError{Limit: q.Limit, Used: q.used, Reset: reset},
+		fmt.Sprintf("Wait until the quota resets at %s, or raise it", reset.Format(time.RFC3339)))
+}
+
+// Internal: write the usage to the quota file
+func (q *Quota) save() error {
+	q.unsaved = 0
+	if q.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(quotaState{PeriodStart: q.start, Used: q.used})
+	if err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, q.path)
+}
@@ -0,0 +1,97 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestQuotaDownload(t *testing.T) {
+	var gets int64
+	var shared *Quota // Also used by a concurrent transfer
+	fileServer := http.FileServer(http.Dir("./test"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt64(&gets, 1)
+			if shared != nil {
+				shared.consume(100000)
+			}
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	quotaFile := filepath.Join(dir, "quota.json")
+	download := func(quota *Quota) error {
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/quijote.txt"}, 2, time.Duration(5000)*time.Millisecond, WithQuota(quota))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(filepath.Join(dir, "quijote.txt"))
+		failOnError(t, err)
+		return dldr.Download(nil)
+	}
+
+	// Downloads that don't fit are refused before transferring anything
+	quota, err := NewQuota(300000, Daily, quotaFile)
+	failOnError(t, err)
+	err = download(quota)
+	var quotaErr *QuotaError
+	if !errors.Is(err, ErrQuotaExceeded) || !errors.As(err, &quotaErr) {
+		t.Fatalf("The download should be refused with a quota error, got %v", err)
+	}
+	if quotaErr.Limit != 300000 || !quotaErr.Reset.After(time.Now()) {
+		t.Errorf("Wrong quota error: %+v", quotaErr)
+	}
+	if atomic.LoadInt64(&gets) != 0 {
+		t.Error("Nothing should be downloaded when the quota is too small")
+	}
+
+	// The quota runs out in the middle, because of another transfer
+	quota, err = NewQuota(500000, Daily, quotaFile)
+	failOnError(t, err)
+	shared = quota
+	if err := download(quota); !errors.Is(err, ErrQuotaExceeded) {
+		t.Errorf("The download should stop when the quota runs out, got %v", err)
+	}
+	shared = nil
+
+	// The usage survives the process
+	reloaded, err := NewQuota(500000, Daily, quotaFile)
+	failOnError(t, err)
+	if reloaded.Remaining() != 0 {
+		t.Errorf("Reloaded quota has %d bytes left", reloaded.Remaining())
+	}
+
+	// Failing to save the usage doesn't stop the download
+	quota, err = NewQuota(1000000, Daily, filepath.Join(dir, "missing", "quota.json"))
+	failOnError(t, err)
+	failOnError(t, download(quota))
+}
+
+func TestQuotaPeriods(t *testing.T) {
+	now := time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC)
+	for _, period := range []QuotaPeriod{Daily, Monthly} {
+		q, err := NewQuota(100, period, "")
+		failOnError(t, err)
+		q.now = func() time.Time { return now }
+		q.rollover()
+		if err := q.consume(100); !errors.Is(err, ErrQuotaExceeded) {
+			t.Errorf("Quota %v should be exhausted", period)
+		}
+		now = now.Add(2 * time.Hour) // February 1st
+		if q.Used() != 0 {
+			t.Errorf("Quota %v should start over in the new period", period)
+		}
+		q.consume(50)
+		now = now.Add(24 * time.Hour)
+		if period == Monthly && q.Used() != 50 || period == Daily && q.Used() != 0 {
+			t.Errorf("Wrong usage %d for quota %v", q.Used(), period)
+		}
+		now = time.Date(2024, 1, 31, 23, 0, 0, 0, time.UTC)
+	}
+}
@@ -0,0 +1,71 @@
+package multipartdownloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Sources refusing HEAD. Presigned URLs (S3 and the like) are signed for GET
+// only, and some CDNs don't allow HEAD: they answer 403 or 405. Such sources
+// are probed with a GET of the first byte instead, the length of the file
+// being the one of the Content-Range of the response.
+
+// Internal: query a source refusing HEAD for the file info with a GET of its
+// first byte
+func (dldr *MultiDownloader) probeGet(ctx context.Context, client *http.Client, url string) (urlInfo, error) {
+	req, err := dldr.newRequest("GET", url, nil)
+	if err != nil {
+		return urlInfo{}, err
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	req.Header.Set("Want-Digest", "sha-512, sha-256, sha;q=0.5, md5;q=0.3")
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return urlInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	info := urlInfo{
+		url:         url,
+		etag:        resp.Header.Get("Etag"),
+		lastMod:     resp.Header.Get("Last-Modified"),
+		connSuccess: true,
+		statusCode:  http.StatusOK, // As the HEAD would have
+		encoding:    contentEncoding(resp.Header),
+		final:       resp.Request.URL.String(),
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable: // Empty file
+		info.fileLength, err = contentRangeLength(resp.Header.Get("Content-Range"))
+	case http.StatusOK: // The whole file, the source ignores ranges
+		info.fileLength, err = strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+		info.noRanges = true
+	default:
+		err = statusError(resp.StatusCode)
+	}
+	if err != nil {
+		return urlInfo{}, err
+	}
+	// The headers of the file, not of its first byte
+	info.header = resp.Header.Clone()
+	info.header.Set("Content-Length", strconv.FormatInt(info.fileLength, 10))
+	info.header.Del("Content-Range")
+	info.header.Del("Content-MD5")
+	return info, nil
+}
+
+// Internal: the length of the file in a Content-Range header, like
+// "bytes 0-0/1234" or "bytes */1234"
+func contentRangeLength(contentRange string) (int64, error) {
+	unit, rest, _ := strings.Cut(contentRange, " ")
+	_, total, found := strings.Cut(rest, "/")
+	if unit != "bytes" || !found || total == "*" {
+		return 0, errors.New(fmt.Sprintf("No length in Content-Range %q", contentRange))
+	}
+	return strconv.ParseInt(total, 10, 64)
+}
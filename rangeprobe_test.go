@@ -0,0 +1,57 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Sources refusing HEAD are probed with a GET of the first byte
+func TestRangeProbe(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	for _, status := range []int{http.StatusForbidden, http.StatusMethodNotAllowed} {
+		var gets atomic.Int32
+		handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" {
+				w.WriteHeader(status)
+				return
+			}
+			gets.Add(1)
+			fileServer.ServeHTTP(w, r)
+		})
+		failOnError(t, downloadLocal(t, handler, 3, WithSHA256(quijoteSHA256)))
+		if gets.Load() != 4 {
+			t.Errorf("HEAD refused with %d: expected a GET of the first byte and 3 chunks, got %d GETs", status, gets.Load())
+		}
+	}
+
+	// Without ranges, the whole file with a single request
+	var gets atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		gets.Add(1)
+		r.Header.Del("Range")
+		fileServer.ServeHTTP(w, r)
+	})
+	failOnError(t, downloadLocal(t, handler, 3, WithSHA256(quijoteSHA256)))
+	if gets.Load() != 2 {
+		t.Errorf("Expected a GET for the probe and one for the file, got %d", gets.Load())
+	}
+}
+
+func TestContentRangeLength(t *testing.T) {
+	for header, want := range map[string]int64{"bytes 0-0/317621": 317621, "bytes */0": 0} {
+		if got, err := contentRangeLength(header); err != nil || got != want {
+			t.Errorf("%s: expected %d, got %d (%v)", header, want, got, err)
+		}
+	}
+	for _, header := range []string{"", "bytes 0-0/*", "items 0-0/10", "bytes 0-0"} {
+		if _, err := contentRangeLength(header); err == nil || !strings.Contains(err.Error(), "Content-Range") {
+			t.Errorf("%s: expected an error, got %v", header, err)
+		}
+	}
+}
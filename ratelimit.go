@@ -0,0 +1,223 @@
+package multipartdownloader
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Bandwidth limits. WithMaxRate caps the bytes per second received by all
+// the connections of a downloader together, so that a download doesn't
+// saturate a shared link, and WithConnRate the rate of each connection, as
+// some mirrors take fast connections for abuse. The connections block once
+// the rate is used up, and the servers slow down as the TCP windows fill up.
+// The limit of all the connections can be changed while downloading, with
+// SetMaxRate, or follow a schedule by time of day (WithRateSchedule).
+
+// Limit the bytes per second received by all the connections of the downloader
+func WithMaxRate(bytesPerSec int64) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.limiter.setRate(bytesPerSec)
+	}
+}
+
+// Change the limit of the bytes per second received by all the connections,
+// taking effect right away, even in the middle of a download. Zero removes
+// the limit.
+func (dldr *MultiDownloader) SetMaxRate(bytesPerSec int64) {
+	dldr.limiter.setRate(bytesPerSec)
+}
+
+// Limit the bytes per second received by each connection, on top of the
+// limit of all of them if any
+func WithConnRate(bytesPerSec int64) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.connRate = bytesPerSec
+	}
+}
+
+// A token bucket limiting the bytes per second, shared by any number of
+// connections. Bursts are limited to one second worth of bytes.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    int64   // Bytes per second, 0 for no limit
+	tokens  float64 // Bytes that can go right away, negative if in debt
+	last    time.Time
+	changed chan struct{} // Closed when the rate changes
+}
+
+// Internal: create a limiter of rate bytes per second, 0 for no limit
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate, last: time.Now(), changed: make(chan struct{})}
+}
+
+// Internal: add the tokens earned since the last time. Must be called with
+// the lock held.
+func (l *rateLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if burst := float64(l.rate); l.tokens > burst {
+		l.tokens = burst
+	}
+	l.last = now
+}
+
+// Internal: account for n bytes, blocking until the rate allows them
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rate <= 0 {
+		return
+	}
+	l.refill(time.Now())
+	l.tokens -= float64(n)
+	for l.tokens < 0 && l.rate > 0 {
+		delay := time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+		changed := l.changed
+		l.mu.Unlock()
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-changed:
+			timer.Stop()
+		}
+		l.mu.Lock()
+		l.refill(time.Now())
+	}
+}
+
+// Internal: change the rate, waking the connections waiting for the old one
+func (l *rateLimiter) setRate(rate int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = rate
+	if rate <= 0 {
+		l.tokens = 0
+	}
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// A reader limited to the rate of its own limiter
+type limitedReader struct {
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+// Internal: limit the reading of the body of a response to the rate of a
+// connection, if limited
+func (dldr *MultiDownloader) limitConn(body io.Reader) io.Reader {
+	if dldr.connRate <= 0 {
+		return body
+	}
+	return &limitedReader{body, newRateLimiter(dldr.connRate)}
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	// Not more than a second worth at once, to keep the pace even
+	if int64(len(p)) > l.limiter.rate {
+		p = p[:l.limiter.rate]
+	}
+	n, err := l.r.Read(p)
+	if n > 0 {
+		l.limiter.wait(n)
+	}
+	return n, err
+}
+
+// A window of the day with its own bandwidth limit
+type RateWindow struct {
+	Start time.Duration // Time of day the window opens, from midnight
+	End   time.Duration // Time of day it closes, past midnight if before Start
+	Rate  int64         // Bytes per second, 0 for no limit
+}
+
+// Bandwidth limits of all the connections by time of day, such as no limit
+// at night and 1 MiB/s otherwise. The first window containing a time gives
+// its rate.
+type RateSchedule struct {
+	Windows  []RateWindow
+	Default  int64          // Bytes per second outside the windows, 0 for no limit
+	Location *time.Location // Time zone of the windows, nil for the local one
+}
+
+// Follow a schedule of bandwidth limits while downloading, changing the
+// limit of all the connections as the windows open and close. A rate set
+// with SetMaxRate lasts until the next change of the schedule.
+func WithRateSchedule(schedule RateSchedule) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.schedule = &schedule
+	}
+}
+
+// Internal: midnight of the day of a time, in the time zone of the schedule
+func (s *RateSchedule) midnight(t time.Time) time.Time {
+	loc := s.Location
+	if loc == nil {
+		loc = time.Local
+	}
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+}
+
+// The bandwidth limit at a time
+func (s *RateSchedule) RateAt(t time.Time) int64 {
+	day := t.Sub(s.midnight(t))
+	for _, w := range s.Windows {
+		if w.Start <= w.End && day >= w.Start && day < w.End ||
+			w.Start > w.End && (day >= w.Start || day < w.End) {
+			return w.Rate
+		}
+	}
+	return s.Default
+}
+
+// Internal: the next time after t a window opens or closes, zero if never
+func (s *RateSchedule) nextChange(t time.Time) time.Time {
+	midnight := s.midnight(t)
+	var next time.Time
+	for _, w := range s.Windows {
+		for _, offset := range []time.Duration{w.Start, w.End} {
+			change := midnight.Add(offset)
+			if !change.After(t) {
+				change = midnight.AddDate(0, 0, 1).Add(offset)
+			}
+			if next.IsZero() || change.Before(next) {
+				next = change
+			}
+		}
+	}
+	return next
+}
+
+// Internal: set the bandwidth limit as scheduled until the returned function
+// is called
+func (dldr *MultiDownloader) followSchedule() func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			now := time.Now()
+			rate := dldr.schedule.RateAt(now)
+			dldr.logVerbose("Scheduled bandwidth limit: ", rate, " bytes/s")
+			dldr.limiter.setRate(rate)
+			next := dldr.schedule.nextChange(now)
+			if next.IsZero() {
+				<-stop
+				return
+			}
+			timer := time.NewTimer(next.Sub(now))
+			select {
+			case <-timer.C:
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-stopped
+	}
+}
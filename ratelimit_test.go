@@ -0,0 +1,119 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Serves a file of n bytes
+func contentServer(n int) *httptest.Server {
+	content := bytes.Repeat([]byte("x"), n)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestMaxRate(t *testing.T) {
+	server := contentServer(40000)
+	defer server.Close()
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/file.bin"}, 4, 5*time.Second, WithMaxRate(100000))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "file.bin"))
+	failOnError(t, err)
+	start := time.Now()
+	failOnError(t, dldr.Download(nil))
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("40000 bytes at 100000 bytes/s took %v", elapsed)
+	}
+}
+
+func TestConnRate(t *testing.T) {
+	server := contentServer(40000)
+	defer server.Close()
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/file.bin"}, 4, 5*time.Second, WithConnRate(50000))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "file.bin"))
+	failOnError(t, err)
+	start := time.Now()
+	failOnError(t, dldr.Download(nil))
+	// 10000 bytes for each connection
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond || elapsed > 1500*time.Millisecond {
+		t.Errorf("4 connections of 10000 bytes at 50000 bytes/s took %v", elapsed)
+	}
+}
+
+// Removing the limit while downloading wakes the connections waiting for it
+func TestSetMaxRate(t *testing.T) {
+	server := contentServer(40000)
+	defer server.Close()
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/file.bin"}, 1, 5*time.Second, WithMaxRate(10000))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "file.bin"))
+	failOnError(t, err)
+	start := time.Now()
+	time.AfterFunc(200*time.Millisecond, func() { dldr.SetMaxRate(0) })
+	failOnError(t, dldr.Download(nil))
+	// 4 seconds at 10000 bytes/s
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("The download should speed up once unlimited, took %v", elapsed)
+	}
+}
+
+func TestRateSchedule(t *testing.T) {
+	schedule := &RateSchedule{
+		Windows: []RateWindow{
+			{Start: 0, End: 8 * time.Hour, Rate: 0},
+			{Start: 22 * time.Hour, End: 2 * time.Hour, Rate: 500}, // Past midnight, after the first
+		},
+		Default:  1000,
+		Location: time.UTC,
+	}
+	at := func(hour, min int) time.Time {
+		return time.Date(2024, 3, 10, hour, min, 0, 0, time.UTC)
+	}
+	for _, c := range []struct {
+		t    time.Time
+		rate int64
+		next time.Time
+	}{
+		{at(1, 0), 0, at(2, 0)},
+		{at(8, 0), 1000, at(22, 0)},
+		{at(12, 30), 1000, at(22, 0)},
+		{at(23, 0), 500, at(0, 0).AddDate(0, 0, 1)},
+	} {
+		if rate := schedule.RateAt(c.t); rate != c.rate {
+			t.Errorf("Rate at %v should be %d, got %d", c.t, c.rate, rate)
+		}
+		if next := schedule.nextChange(c.t); !next.Equal(c.next) {
+			t.Errorf("Next change after %v should be %v, got %v", c.t, c.next, next)
+		}
+	}
+	if next := (&RateSchedule{Default: 1000}).nextChange(at(1, 0)); !next.IsZero() {
+		t.Errorf("A schedule without windows never changes, got %v", next)
+	}
+
+	// Followed while downloading
+	server := contentServer(40000)
+	defer server.Close()
+	dldr := NewMultiDownloader([]string{server.URL + "/file.bin"}, 4, 5*time.Second,
+		WithRateSchedule(RateSchedule{Default: 100000}))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "file.bin"))
+	failOnError(t, err)
+	start := time.Now()
+	failOnError(t, dldr.Download(nil))
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("40000 bytes at 100000 bytes/s took only %v", elapsed)
+	}
+}
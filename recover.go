@@ -0,0 +1,120 @@
+package multipartdownloader
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Recovery of orphaned partial files, left without a control file by a crash,
+// by another tool or with resuming disabled. Partial files are created full of
+// zeros, so without anything better, every run of zeros is downloaded again
+// and the rest is kept. With the block checksums of a zsync control file,
+// every block is checked instead, and only the ones that don't match are
+// downloaded again.
+
+// Runs of data shorter than this between two missing ranges are downloaded
+// again with them, instead of splitting the download in many small requests
+const recoverMinKept = 1 << 16
+
+// Check the blocks of recovered partial files and of the ranges waited for
+// against the checksums of a zsync control file of the same file
+func WithBlockChecksums(ctrl *ZsyncControl) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.blockSums = ctrl
+	}
+}
+
+// Pick up a partial file found without its control file, setting the chunks
+// to the ranges that still need downloading. GatherInfo must have been called
+// before, and then Download completes the file.
+func (dldr *MultiDownloader) RecoverPartial(path string) ([]Chunk, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != dldr.fileLength {
+		return nil, errors.New(fmt.Sprintf(
+			"The partial file has %d bytes, the file has %d", info.Size(), dldr.fileLength))
+	}
+
+	var missing []Chunk
+	if ctrl := dldr.blockSums; ctrl != nil {
+		if ctrl.Length != dldr.fileLength {
+			return nil, errors.New("The zsync control file describes a different file")
+		}
+		missing, err = ctrl.unmatchedBlocks(file)
+	} else {
+		missing, err = zeroRuns(file, recoverMinKept)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dldr.partFilename = path
+	if strings.HasSuffix(path, dldr.partSuffix()) {
+		dldr.filename = strings.TrimSuffix(path, dldr.partSuffix())
+	}
+	dldr.chunks = balanceChunks(missing, dldr.nConns)
+	kept := dldr.fileLength
+	for _, c := range missing {
+		kept -= c.End - c.Begin
+	}
+	dldr.logVerbose("Recovered ", kept, " bytes from ", path)
+	return dldr.chunks, nil
+}
+
+// Internal: check every block of a file in place, returning the ranges of
+// the blocks that don't match
+func (ctrl *ZsyncControl) unmatchedBlocks(r io.Reader) ([]Chunk, error) {
+	br := bufio.NewReaderSize(r, 1<<16)
+	matched := make([]bool, len(ctrl.blocks))
+	block := make([]byte, ctrl.BlockSize)
+	for i := range ctrl.blocks {
+		n, err := io.ReadFull(br, block)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		// The last block is padded with zeros, as for computing the checksums
+		clear(block[n:])
+		matched[i] = ctrl.blockMatches(i, block)
+	}
+	return ctrl.missingChunks(matched), nil
+}
+
+// Internal: find the runs of zero bytes of a file, merging the ones separated
+// by less than minKept bytes of data
+func zeroRuns(r io.Reader, minKept int64) ([]Chunk, error) {
+	var runs []Chunk
+	buf := make([]byte, 1<<16)
+	pos := int64(0)
+	for {
+		n, err := r.Read(buf)
+		for i, c := range buf[:n] {
+			if c != 0 {
+				continue
+			}
+			offset := pos + int64(i)
+			if last := len(runs) - 1; last >= 0 && offset-runs[last].End < minKept {
+				runs[last].End = offset + 1
+			} else {
+				runs = append(runs, Chunk{offset, offset + 1})
+			}
+		}
+		pos += int64(n)
+		if err == io.EOF {
+			return runs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
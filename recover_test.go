@@ -0,0 +1,71 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Complete a partial file left without its control file, with the given
+// options, returning the bytes served
+func recoverLocal(t *testing.T, partial []byte, opts ...Option) int64 {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	var served int64
+	fileServer := http.FileServer(http.Dir("./test"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fileServer.ServeHTTP(&countingWriter{w, &served}, r)
+	}))
+	defer server.Close()
+	partFilename := filepath.Join(t.TempDir(), "quijote.txt") + tmpFileSuffix
+	failOnError(t, ioutil.WriteFile(partFilename, partial, 0644))
+
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/quijote.txt"}, 4, time.Duration(5000)*time.Millisecond, opts...)
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.RecoverPartial(partFilename)
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	downloaded, err := ioutil.ReadFile(filepath.Join(filepath.Dir(partFilename), "quijote.txt"))
+	failOnError(t, err)
+	if !bytes.Equal(downloaded, data) {
+		t.Error("The recovered file differs from the original")
+	}
+	return atomic.LoadInt64(&served)
+}
+
+func TestRecoverPartial(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	// Two connections were interrupted, the other two never started
+	partial := make([]byte, len(data))
+	copy(partial[:100000], data)
+	copy(partial[160000:200000], data[160000:])
+
+	served := recoverLocal(t, partial)
+	if missing := int64(len(data) - 140000); served < missing || served > missing+recoverMinKept {
+		t.Errorf("Served %d bytes to recover %d missing", served, missing)
+	}
+}
+
+func TestRecoverPartialChecksums(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	ctrl, err := ParseZsync(bytes.NewReader(makeZsync(data, 2048, 1, 4, 16)))
+	failOnError(t, err)
+	// Some of the data is corrupted, which zeros alone can't tell
+	partial := append([]byte(nil), data...)
+	copy(partial[50000:], bytes.Repeat([]byte("x"), 3000))
+	clear(partial[300000:])
+
+	served := recoverLocal(t, partial, WithBlockChecksums(ctrl))
+	if served > 30000 {
+		t.Errorf("Served %d bytes to recover a few blocks", served)
+	}
+}
@@ -0,0 +1,129 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// Mirrors often redirect to third-party CDNs, which may serve a stale or
+// different copy of the file. With WithRedirectCheck, redirects to another
+// host are followed only if the target serves a file of the same size and
+// ETag. The targets reached while gathering the info are checked along with
+// the mirrors; those found later, when downloading the chunks, are checked
+// with a HEAD request before being followed.
+
+// Returned (wrapped) when a redirect leads to a different file
+var ErrRedirectMismatch = errors.New("Redirect to a different file")
+
+// Follow redirects to other hosts only if they serve the same file. With
+// addMirrors, the targets of the redirects are used as mirrors too.
+func WithRedirectCheck(addMirrors bool) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.sameRedirect = true
+		dldr.addRedirects = addMirrors
+	}
+}
+
+// Internal: accept the targets of the redirects of the sources, which agree
+// on the file, adding them as mirrors if asked to
+func (dldr *MultiDownloader) acceptRedirects(sources []urlInfo) {
+	dldr.mu.Lock()
+	defer dldr.mu.Unlock()
+	if dldr.redirects == nil {
+		dldr.redirects = make(map[string]bool)
+	}
+	for _, r := range sources {
+		if r.final == "" || sameHost(r.url, r.final) {
+			continue
+		}
+		dldr.redirects[r.final] = true
+		if !dldr.addRedirects || containsString(dldr.urls, r.final) {
+			continue
+		}
+		dldr.logVerbose("Adding mirror ", r.final, " redirected to from ", r.url)
+		dldr.urls = append(dldr.urls, r.final)
+	}
+}
+
+// Internal: get an HTTP client for the chunks, checking the redirects if asked to
+func (dldr *MultiDownloader) chunkClient(urlStr string) *http.Client {
+	client := dldr.httpClient(urlStr, 0)
+	if dldr.sameRedirect {
+		client.CheckRedirect = dldr.checkRedirect
+	}
+	return client
+}
+
+// Internal: allow a redirect to another host only if the target serves the
+// same file. The result is remembered for each target.
+func (dldr *MultiDownloader) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= 10 {
+		return errors.New("Stopped after 10 redirects")
+	}
+	target := req.URL.String()
+	if sameHost(via[len(via)-1].URL.String(), target) {
+		return nil
+	}
+	dldr.mu.Lock()
+	same, checked := dldr.redirects[target]
+	dldr.mu.Unlock()
+	if !checked {
+		same = dldr.servesFile(target)
+		dldr.mu.Lock()
+		if dldr.redirects == nil {
+			dldr.redirects = make(map[string]bool)
+		}
+		dldr.redirects[target] = same
+		dldr.mu.Unlock()
+	}
+	if !same {
+		return fmt.Errorf("%w: %s", ErrRedirectMismatch, target)
+	}
+	return nil
+}
+
+// Internal: check with a HEAD request that a URL serves a file of the same
+// size and ETag
+func (dldr *MultiDownloader) servesFile(target string) bool {
+	req, err := dldr.newRequest("HEAD", target, nil)
+	if err != nil {
+		return false
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := dldr.httpClient(target, dldr.timeout).Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	length, err := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	if resp.StatusCode != http.StatusOK || err != nil || length != dldr.fileLength {
+		dldr.logVerbose("Redirect target ", target, " serves a file of a different size")
+		return false
+	}
+	etag := resp.Header.Get("Etag")
+	if !dldr.sameETag(etag) {
+		dldr.logVerbose("Redirect target ", target, " serves a file with ETag ", etag)
+		return false
+	}
+	return true
+}
+
+// Internal: whether two URLs are on the same host
+func sameHost(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	return errA == nil && errB == nil && ua.Host == ub.Host
+}
+
+// Internal: whether a list contains a string
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
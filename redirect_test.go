@@ -0,0 +1,79 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Serve content with an ETag
+func etagServer(content []byte, etag string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"`+etag+`"`)
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+// Redirects to a CDN serving another version of the file aren't followed,
+// the chunks are downloaded from the other mirrors
+func TestRedirectCheck(t *testing.T) {
+	data := bytes.Repeat([]byte("current "), 16<<10)
+	stale := bytes.Repeat([]byte("old one "), 16<<10)
+	cdn := etagServer(data, "v2")
+	defer cdn.Close()
+	staleCDN := etagServer(stale, "v1")
+	defer staleCDN.Close()
+	mirror := etagServer(data, "v2")
+	defer mirror.Close()
+
+	// Redirects to the stale CDN once the download starts
+	var switched atomic.Bool
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		target := cdn.URL
+		if switched.Load() {
+			target = staleCDN.URL
+		}
+		http.Redirect(w, r, target+r.URL.Path, http.StatusFound)
+	}))
+	defer origin.Close()
+
+	dldr := NewMultiDownloader([]string{origin.URL + "/file.bin", mirror.URL + "/file.bin"},
+		4, 5*time.Second, WithRedirectCheck(false))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	out := filepath.Join(t.TempDir(), "file.bin")
+	_, err = dldr.SetupFile(out)
+	failOnError(t, err)
+	switched.Store(true)
+	failOnError(t, dldr.Download(nil))
+	got, err := os.ReadFile(out)
+	failOnError(t, err)
+	if !bytes.Equal(got, data) {
+		t.Error("Chunks were downloaded from the stale CDN")
+	}
+	if same, checked := dldr.redirects[staleCDN.URL+"/file.bin"]; !checked || same {
+		t.Error("The stale CDN wasn't checked")
+	}
+}
+
+// The targets of redirects are added as mirrors if asked to
+func TestRedirectMirrors(t *testing.T) {
+	data := bytes.Repeat([]byte("content "), 16<<10)
+	cdn := etagServer(data, "v1")
+	defer cdn.Close()
+	origin := httptest.NewServer(http.RedirectHandler(cdn.URL+"/file.bin", http.StatusFound))
+	defer origin.Close()
+
+	dldr := NewMultiDownloader([]string{origin.URL + "/file.bin"},
+		2, 5*time.Second, WithRedirectCheck(true))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	if len(dldr.urls) != 2 || dldr.urls[1] != cdn.URL+"/file.bin" {
+		t.Errorf("The CDN wasn't added as a mirror: %v", dldr.urls)
+	}
+}
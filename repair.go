@@ -0,0 +1,95 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"os"
+)
+
+// Repair of corrupt downloads. With the block checksums of the file, a
+// download that fails its digest check doesn't have to start over: the
+// blocks that don't match are downloaded again, from other mirrors than the
+// ones that served them when there are others.
+
+// The mirror a range of the file was downloaded from
+type rangeSource struct {
+	Chunk
+	url string
+}
+
+// Download again the blocks of the downloaded file that don't match their
+// checksums, given with WithBlockChecksums. Meant for files that failed
+// CheckSHA256 or CheckMD5. Does nothing if all blocks match.
+func (dldr *MultiDownloader) Repair(feedbackFunc func([]ConnectionProgress)) error {
+	if dldr.blockSums == nil || dldr.segments != nil {
+		return errors.New("Repairing a file needs the block checksums of a single file")
+	}
+	bad, err := dldr.corruptRanges(dldr.filename)
+	if err != nil || len(bad) == 0 {
+		return err
+	}
+	if err := os.Rename(dldr.filename, dldr.partFilename); err != nil {
+		return err
+	}
+	return dldr.refetch(bad, feedbackFunc)
+}
+
+// Internal: the ranges of a file whose blocks don't match their checksums
+func (dldr *MultiDownloader) corruptRanges(path string) ([]Chunk, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return dldr.blockSums.unmatchedBlocks(file)
+}
+
+// Internal: download the given ranges again into the partial file, avoiding
+// the mirrors that served them before
+func (dldr *MultiDownloader) refetch(bad []Chunk, feedbackFunc func([]ConnectionProgress)) error {
+	dldr.logVerbose("Downloading again ", len(bad), " corrupt ranges")
+	urls := dldr.sourceURLs()
+	defer func() {
+		dldr.setSourceURLs(urls)
+		dldr.repairing = false
+	}()
+	if others := dldr.unsuspectedURLs(bad); len(others) > 0 {
+		dldr.setSourceURLs(others)
+	}
+	dldr.repairing = true
+	dldr.chunks = balanceChunks(bad, dldr.nConns)
+	return dldr.Download(feedbackFunc)
+}
+
+// Internal: the mirrors that didn't serve any of the given ranges
+func (dldr *MultiDownloader) unsuspectedURLs(bad []Chunk) []string {
+	suspect := dldr.suspectURLs(bad)
+	var others []string
+	for _, url := range dldr.sourceURLs() {
+		if !suspect[url] {
+			others = append(others, url)
+		}
+	}
+	return others
+}
+
+// Internal: the mirrors that served some of the given ranges
+func (dldr *MultiDownloader) suspectURLs(bad []Chunk) map[string]bool {
+	dldr.mu.Lock()
+	defer dldr.mu.Unlock()
+	suspect := make(map[string]bool)
+	for _, s := range dldr.sources {
+		for _, c := range bad {
+			if s.Begin < c.End && c.Begin < s.End {
+				suspect[s.url] = true
+			}
+		}
+	}
+	return suspect
+}
+
+// Internal: record the mirror a chunk was downloaded from
+func (dldr *MultiDownloader) recordSource(chunk Chunk, url string) {
+	dldr.mu.Lock()
+	defer dldr.mu.Unlock()
+	dldr.sources = append(dldr.sources, rangeSource{chunk, url})
+}
@@ -0,0 +1,79 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Download quijote.txt from a good mirror and one corrupting a block of each
+// half, so that the chunk it serves is corrupt whatever the order of mirrors
+func downloadCorrupt(t *testing.T, opts ...Option) (*MultiDownloader, *int64) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	corrupted := append([]byte(nil), data...)
+	copy(corrupted[50000:], "corrupted")
+	copy(corrupted[250000:], "corrupted")
+	var badRequests int64
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "quijote.txt", time.Time{}, bytes.NewReader(data))
+	}))
+	t.Cleanup(good.Close)
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt64(&badRequests, 1)
+		}
+		http.ServeContent(w, r, "quijote.txt", time.Time{}, bytes.NewReader(corrupted))
+	}))
+	t.Cleanup(bad.Close)
+	ctrl, err := ParseZsync(bytes.NewReader(makeZsync(data, 2048, 1, 4, 16)))
+	failOnError(t, err)
+
+	dldr := NewMultiDownloader(
+		[]string{good.URL + "/quijote.txt", bad.URL + "/quijote.txt"}, 2,
+		time.Duration(5000)*time.Millisecond, append(opts, WithBlockChecksums(ctrl))...)
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	return dldr, &badRequests
+}
+
+// A digest mismatch is fixed by downloading the corrupt blocks again
+func TestRepairOnDigestMismatch(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	dldr, badRequests := downloadCorrupt(t, WithSHA256(fmt.Sprintf("%x", sha256.Sum256(data))))
+	failOnError(t, dldr.Download(nil))
+	downloaded, err := ioutil.ReadFile(dldr.filename)
+	failOnError(t, err)
+	if !bytes.Equal(downloaded, data) {
+		t.Error("The repaired file differs from the original")
+	}
+	if n := atomic.LoadInt64(badRequests); n != 1 {
+		t.Errorf("The corrupt blocks should be downloaded from the other mirror, the bad one got %d requests", n)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	sum := fmt.Sprintf("%x", sha256.Sum256(data))
+	dldr, _ := downloadCorrupt(t)
+	failOnError(t, dldr.Download(nil))
+	if dldr.CheckSHA256(sum) == nil {
+		t.Fatal("The file should be corrupt")
+	}
+	failOnError(t, dldr.Repair(nil))
+	failOnError(t, dldr.CheckSHA256(sum))
+	if len(dldr.chunks) > 2 || dldr.chunks[0].End-dldr.chunks[0].Begin > 2048 {
+		t.Errorf("Only the corrupt block should be downloaded again, got %v", dldr.chunks)
+	}
+}
@@ -0,0 +1,133 @@
+package multipartdownloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Retries. A chunk is tried with each source in turn, and by default fails
+// when all of them failed once. With a retry policy, all the sources are
+// tried again after a delay growing exponentially, with some randomness so
+// that the connections don't retry all at once. Only the failures that may
+// go away are retried: timeouts, dropped connections, server errors (5xx)
+// and throttling (429). Sources answering 404, 403 and such aren't tried
+// again for the chunk.
+//
+// RetryPolicy decides so for each failure of a chunk with a source, as a
+// Retrier. Other rules (rotating credentials on 401, never retrying 410...)
+// go in a Retrier of the caller, given with WithRetrier, which can build on
+// Retryable and StatusCode, or on a RetryPolicy.
+
+// Returned (wrapped) when a response is cut before the end of its chunk
+var errTruncated = errors.New("Truncated response")
+
+// Retries of the chunks after all the sources failed
+type RetryPolicy struct {
+	MaxRetries int           // Rounds of tries after the first one
+	BaseDelay  time.Duration // Delay before the first retry, doubled for each of the next ones
+	MaxDelay   time.Duration // Longest delay, zero for no limit
+	Jitter     float64       // Fraction of the delay randomly taken out, from 0 to 1
+}
+
+// Decides whether and when the sources failing for a chunk are tried again
+type Retrier interface {
+	// Called when a chunk fails with a mirror, for the attempt-th time with
+	// that mirror, starting with 1. Returns whether to try the mirror again
+	// for the chunk, and the delay before that: once all the mirrors were
+	// tried, those left are tried again after the longest delay asked. The
+	// chunk fails when no mirror is left.
+	ShouldRetry(err error, attempt int, mirror string) (delay time.Duration, ok bool)
+}
+
+// A function as a Retrier
+type RetrierFunc func(err error, attempt int, mirror string) (time.Duration, bool)
+
+func (f RetrierFunc) ShouldRetry(err error, attempt int, mirror string) (time.Duration, bool) {
+	return f(err, attempt, mirror)
+}
+
+// Retry the chunks failing with all the sources with the given policy
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.retry = policy
+	}
+}
+
+// Retry the chunks as the given Retrier decides, instead of a RetryPolicy
+func WithRetrier(retrier Retrier) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.retry = retrier
+	}
+}
+
+// Retry the failures that may go away, up to MaxRetries times for each
+// mirror, waiting longer each time
+func (p RetryPolicy) ShouldRetry(err error, attempt int, mirror string) (time.Duration, bool) {
+	if !Retryable(err) || attempt > p.MaxRetries {
+		return 0, false
+	}
+	return p.delay(attempt - 1), true
+}
+
+// An unexpected HTTP status
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("Unexpected status %d", int(e))
+}
+
+// Get the HTTP status a request failed with, zero if it didn't get an
+// unexpected one
+func StatusCode(err error) int {
+	var status statusError
+	if errors.As(err, &status) {
+		return int(status)
+	}
+	return 0
+}
+
+// Tell whether a chunk failing with the error may succeed later: timeouts,
+// dropped connections, server errors and throttling
+func Retryable(err error) bool {
+	var status statusError
+	if errors.As(err, &status) {
+		return status >= 500 || status == http.StatusTooManyRequests ||
+			status == http.StatusRequestTimeout
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, errTruncated) || errors.Is(err, errStalled) ||
+		errors.Is(err, errIdle)
+}
+
+// Internal: the delay before the given retry, starting with zero
+func (p RetryPolicy) delay(retry int) time.Duration {
+	d := p.BaseDelay << min(retry, 30)
+	if d < p.BaseDelay {
+		d = math.MaxInt64 // Overflowed
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * min(p.Jitter, 1) * float64(d))
+	}
+	return d
+}
+
+// Internal: wait for the delay before a retry, unless the context is canceled
+func waitRetry(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
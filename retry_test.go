@@ -0,0 +1,123 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Delays double up to the maximum, the jitter taking out part of them
+func TestRetryDelay(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for retry, want := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		if got := p.delay(retry); got != want*time.Millisecond {
+			t.Errorf("Retry %d: expected %v, got %v", retry, want*time.Millisecond, got)
+		}
+	}
+	if got := p.delay(100); got != time.Second {
+		t.Errorf("Expected the maximum delay for a late retry, got %v", got)
+	}
+	p.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if got := p.delay(1); got < 100*time.Millisecond || got > 200*time.Millisecond {
+			t.Fatalf("Delay with jitter out of range: %v", got)
+		}
+	}
+}
+
+// Server errors are retried after a while
+func TestRetryServerErrors(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	failures := make(map[string]int)
+	var mu sync.Mutex
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			mu.Lock()
+			failures[r.Header.Get("Range")]++
+			n := failures[r.Header.Get("Range")]
+			mu.Unlock()
+			if n <= 2 {
+				http.Error(w, "Busy", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+	if err := downloadLocal(t, handler, 2); err == nil {
+		t.Error("The server errors weren't reported without retries")
+	}
+	failures = make(map[string]int)
+	policy := RetryPolicy{MaxRetries: 2, BaseDelay: 10 * time.Millisecond, Jitter: 0.5}
+	failOnError(t, downloadLocal(t, handler, 2, WithRetryPolicy(policy)))
+}
+
+// Missing files aren't retried
+func TestRetryNotFound(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	var gets int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+			http.NotFound(w, r)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+	start := time.Now()
+	policy := RetryPolicy{MaxRetries: 5, BaseDelay: time.Second}
+	if err := downloadLocal(t, handler, 2, WithRetryPolicy(policy)); err == nil {
+		t.Error("Missing file downloaded")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second || gets != 2 {
+		t.Errorf("Expected to fail fast with a request per chunk, took %v and %d requests", elapsed, gets)
+	}
+}
+
+// A Retrier of the caller decides which failures are retried
+func TestRetrier(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	var unauthorized, gone atomic.Int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" && unauthorized.Add(1) <= 2 {
+			http.Error(w, "Expired token", http.StatusUnauthorized)
+			return
+		}
+		if r.Method == "GET" && r.Header.Get("X-Gone") != "" {
+			gone.Add(1)
+			http.Error(w, "Gone", http.StatusGone)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	})
+	var mu sync.Mutex
+	var attempts []int
+	retrier := RetrierFunc(func(err error, attempt int, mirror string) (time.Duration, bool) {
+		mu.Lock()
+		attempts = append(attempts, attempt)
+		mu.Unlock()
+		if strings.HasSuffix(mirror, "/quijote.txt") && StatusCode(err) == http.StatusUnauthorized {
+			return 10 * time.Millisecond, attempt < 3
+		}
+		return 0, false
+	})
+	failOnError(t, downloadLocal(t, handler, 1, WithRetrier(retrier)))
+	if !reflect.DeepEqual(attempts, []int{1, 2}) {
+		t.Errorf("Expected the 401s to be retried twice, got the attempts %v", attempts)
+	}
+
+	// Never retried, even by a RetryPolicy for everything else
+	retrier = RetrierFunc(func(err error, attempt int, mirror string) (time.Duration, bool) {
+		if StatusCode(err) == http.StatusGone {
+			return 0, false
+		}
+		return RetryPolicy{MaxRetries: 5, BaseDelay: time.Second}.ShouldRetry(err, attempt, mirror)
+	})
+	err := downloadLocal(t, handler, 2, WithRetrier(retrier), WithHeader("X-Gone", "1"))
+	if err == nil || gone.Load() != 2 {
+		t.Errorf("Expected the 410s to fail fast with a request per chunk, got %v after %d", err, gone.Load())
+	}
+}
@@ -0,0 +1,109 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+)
+
+// The ranges of an interrupted download may not be worth keeping when it's
+// resumed: the partial file may have been damaged, or the file changed on a
+// mirror without its validator changing. Fetching a few random samples of
+// them again and comparing them with what's in the partial file catches that
+// before building on them.
+
+// Size of the samples checked when resuming
+const resumeSampleSize = fileWriteChunk
+
+// Check a number of random samples of the ranges already downloaded against
+// the sources when resuming a download, starting over if any differs
+func WithResumeSampling(samples int) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.samples = samples
+	}
+}
+
+// Internal: compare random samples of the written ranges with the sources.
+// Returns false if any of them differs.
+func (dldr *MultiDownloader) checkSamples(ctl *controlFile) bool {
+	written := ctl.ranges(true)
+	total := int64(0)
+	for _, c := range written {
+		total += c.End - c.Begin
+	}
+	if total == 0 {
+		return true
+	}
+	file, err := os.Open(dldr.partFilename)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	for k := 0; k < dldr.samples; k++ {
+		// A random position among the written bytes
+		pos := rand.Int63n(total)
+		var sample Chunk
+		for _, c := range written {
+			if pos < c.End-c.Begin {
+				sample = Chunk{c.Begin + pos, min(c.Begin+pos+resumeSampleSize, c.End)}
+				break
+			}
+			pos -= c.End - c.Begin
+		}
+		url := dldr.urls[k%len(dldr.urls)]
+		err := dldr.checkSample(file, url, sample)
+		if errors.Is(err, errSampleMismatch) {
+			dldr.logVerbose("Sample ", sample, " from ", url, " differs from the partial file")
+			return false
+		}
+		if err != nil {
+			// Doesn't tell anything about the partial file
+			dldr.logVerbose("Can't check sample ", sample, " from ", url, ": ", err)
+		}
+	}
+	return true
+}
+
+// The data of a source differs from the partial file
+var errSampleMismatch = errors.New("Sample mismatch")
+
+// Internal: compare a range of the partial file with a source
+func (dldr *MultiDownloader) checkSample(file *os.File, url string, sample Chunk) error {
+	req, err := dldr.newRequest("GET", httpURL(url), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", sample.Begin, sample.End-1))
+	req.Header.Set("Accept-Encoding", "identity")
+	if validator := dldr.validator(); validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+	resp, err := dldr.httpClient(url, dldr.timeout).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && req.Header.Get("If-Range") != "" {
+		return errSampleMismatch // The file changed
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return errors.New(fmt.Sprintf("Unexpected status %d", resp.StatusCode))
+	}
+	remote := make([]byte, sample.End-sample.Begin)
+	if _, err := io.ReadFull(resp.Body, remote); err != nil {
+		return err
+	}
+	local := make([]byte, len(remote))
+	if _, err := file.ReadAt(local, sample.Begin); err != nil {
+		return err
+	}
+	if !bytes.Equal(local, remote) {
+		return errSampleMismatch
+	}
+	return nil
+}
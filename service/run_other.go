@@ -0,0 +1,19 @@
+//go:build !windows
+
+package service
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// Run a program until it returns, canceling its context on SIGINT or SIGTERM
+// (systemctl stop), and pinging the watchdog of systemd meanwhile
+func Run(name string, run func(ctx context.Context) error) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go watchdog(ctx)
+	return run(ctx)
+}
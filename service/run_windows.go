@@ -0,0 +1,67 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"os"
+	"os/signal"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// Run a program until it returns. Started by the service control manager, it
+// runs as the service of the given name, its context canceled on stop or
+// shutdown. Started from a console, its context is canceled on Ctrl+C.
+func Run(name string, run func(ctx context.Context) error) error {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !isService {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		return run(ctx)
+	}
+	h := &handler{run: run}
+	if err := svc.Run(name, h); err != nil {
+		return err
+	}
+	return h.err
+}
+
+// Internal: the handler of the requests of the service control manager
+type handler struct {
+	run func(ctx context.Context) error
+	err error // Returned by run
+}
+
+func (h *handler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.err = h.run(ctx)
+	}()
+	const accepted = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.Running, Accepts: accepted}
+	for {
+		select {
+		case <-done:
+			if h.err != nil {
+				return true, 1
+			}
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				cancel()
+			}
+		}
+	}
+}
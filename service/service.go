@@ -0,0 +1,114 @@
+// Package service runs a long-running program, such as the download manager
+// daemon of godl, as a system service: a systemd service of Type=notify on
+// Linux, or a service of the Windows service control manager.
+//
+// Under systemd, the program tells when it's ready and stopping, and pings
+// the watchdog (WatchdogSec= in the unit) while it runs, through the socket
+// of NOTIFY_SOCKET. Outside systemd, notifications do nothing. On Windows,
+// the stop and shutdown requests of the service control manager cancel the
+// context of the program, as SIGINT and SIGTERM do elsewhere.
+//
+//	err := service.Run("godl", func(ctx context.Context) error {
+//		... // Start
+//		service.Notify(service.Ready)
+//		<-ctx.Done()
+//		service.Notify(service.Stopping)
+//		... // Checkpoint and stop
+//		return nil
+//	})
+package service
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// States of the notifications to systemd
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Send a notification to systemd: a state above, or "STATUS=..." with a line
+// shown by systemctl status. Does nothing if not run by systemd.
+func Notify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+	if strings.HasPrefix(socket, "@") {
+		socket = "\x00" + socket[1:] // Abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// Get the interval of the pings systemd expects for the watchdog of this
+// process, zero if there is no watchdog
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0 // For another process
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Internal: ping the watchdog twice per interval until the context is done
+func watchdog(ctx context.Context) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			Notify(Watchdog)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Get the directory for the state of a service, following the conventions
+// of the system:
+//
+//   - $STATE_DIRECTORY, set by systemd for the StateDirectory= of the unit
+//   - %ProgramData%\name on Windows
+//   - /var/lib/name when run by root elsewhere
+//   - $XDG_STATE_HOME/name, or ~/.local/state/name, for users
+func StateDir(name string) string {
+	if dir := os.Getenv("STATE_DIRECTORY"); dir != "" {
+		return strings.Split(dir, ":")[0] // The first one, if the unit has several
+	}
+	if runtime.GOOS == "windows" {
+		if dir := os.Getenv("ProgramData"); dir != "" {
+			return filepath.Join(dir, name)
+		}
+	} else if os.Geteuid() == 0 {
+		return filepath.Join("/var/lib", name)
+	}
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, name)
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".local", "state", name)
+	}
+	return name
+}
@@ -0,0 +1,77 @@
+//go:build !windows
+
+package service
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	// Nothing to do outside systemd
+	t.Setenv("NOTIFY_SOCKET", "")
+	if err := Notify(Ready); err != nil {
+		t.Error(err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+	for _, state := range []string{Ready, "STATUS=1 running", Stopping} {
+		if err := Notify(state); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 256)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != state {
+			t.Errorf("Received %q, should be %q", buf[:n], state)
+		}
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	for _, c := range []struct {
+		usec, pid string
+		interval  time.Duration
+	}{
+		{"", "", 0},
+		{"30000000", "", 30 * time.Second},
+		{"30000000", strconv.Itoa(os.Getpid()), 30 * time.Second},
+		{"30000000", "1", 0},
+		{"nonsense", "", 0},
+	} {
+		t.Setenv("WATCHDOG_USEC", c.usec)
+		t.Setenv("WATCHDOG_PID", c.pid)
+		if interval := WatchdogInterval(); interval != c.interval {
+			t.Errorf("Interval %v for %q and %q, should be %v", interval, c.usec, c.pid, c.interval)
+		}
+	}
+}
+
+func TestStateDir(t *testing.T) {
+	t.Setenv("STATE_DIRECTORY", "/var/lib/godl:/var/lib/other")
+	if dir := StateDir("godl"); dir != "/var/lib/godl" {
+		t.Errorf("State directory %s, should be the one of systemd", dir)
+	}
+	t.Setenv("STATE_DIRECTORY", "")
+	t.Setenv("XDG_STATE_HOME", "/home/user/.state")
+	expected := "/home/user/.state/godl"
+	if os.Geteuid() == 0 {
+		expected = "/var/lib/godl"
+	}
+	if dir := StateDir("godl"); dir != expected {
+		t.Errorf("State directory %s, should be %s", dir, expected)
+	}
+}
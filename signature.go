@@ -0,0 +1,160 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Bytes requested when checking a signature, enough to sniff the content type
+// of whatever the source is serving instead
+const sniffLength = 512
+
+// Magic bytes identifying a file type, found at a fixed offset of the file
+type Signature struct {
+	Name   string
+	Offset int64
+	Magic  []byte
+}
+
+// Signatures of some common file types
+var (
+	SignatureZIP   = Signature{"ZIP", 0, []byte("PK\x03\x04")}
+	SignatureGzip  = Signature{"gzip", 0, []byte{0x1f, 0x8b}}
+	SignatureBzip2 = Signature{"bzip2", 0, []byte("BZh")}
+	SignatureXZ    = Signature{"xz", 0, []byte("\xfd7zXZ\x00")}
+	SignatureZstd  = Signature{"zstd", 0, []byte{0x28, 0xb5, 0x2f, 0xfd}}
+	SignatureTar   = Signature{"tar", 257, []byte("ustar")}
+	SignatureISO   = Signature{"ISO 9660", 0x8001, []byte("CD001")}
+	SignatureELF   = Signature{"ELF", 0, []byte("\x7fELF")}
+	SignaturePDF   = Signature{"PDF", 0, []byte("%PDF-")}
+	SignaturePNG   = Signature{"PNG", 0, []byte("\x89PNG\r\n\x1a\n")}
+)
+
+// Find one of the known signatures by its short name (zip, iso, elf...)
+func LookupSignature(name string) (Signature, bool) {
+	switch strings.ToLower(name) {
+	case "zip":
+		return SignatureZIP, true
+	case "gzip", "gz":
+		return SignatureGzip, true
+	case "bzip2", "bz2":
+		return SignatureBzip2, true
+	case "xz":
+		return SignatureXZ, true
+	case "zstd", "zst":
+		return SignatureZstd, true
+	case "tar":
+		return SignatureTar, true
+	case "iso":
+		return SignatureISO, true
+	case "elf":
+		return SignatureELF, true
+	case "pdf":
+		return SignaturePDF, true
+	case "png":
+		return SignaturePNG, true
+	}
+	return Signature{}, false
+}
+
+// Check that every source serves a file starting with the given signature
+// before the download begins. Mirrors answering with an HTML error page or
+// the wrong file make the download abort early instead of wasting a whole
+// transfer on garbage. For segmented downloads (HLS, DASH...) the first
+// segment is checked.
+func WithSignature(sig Signature) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.signature = &sig
+	}
+}
+
+// Internal: check the signature on every source before downloading. For
+// segmented downloads the beginning of the file is the first segment, which
+// must then be long enough to hold the signature.
+func (dldr *MultiDownloader) checkSignatures() error {
+	if dldr.encoding != "" {
+		return errors.New(fmt.Sprintf(
+			"Can't check the signature of a file served with Content-Encoding %s", dldr.encoding))
+	}
+	if dldr.segments == nil {
+		for _, url := range dldr.urls {
+			if err := dldr.checkSignature(url, 0, dldr.fileLength); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(dldr.chunks) == 0 {
+		return errors.New(
+			fmt.Sprintf("File is too short to be a %s file", dldr.signature.Name))
+	}
+	seg := dldr.segments[0]
+	offset := seg.offset
+	if offset < 0 {
+		offset = 0
+	}
+	err := dldr.checkSignature(seg.url, offset, dldr.chunks[0].End-dldr.chunks[0].Begin)
+	if err != nil && len(dldr.chunks) > 1 {
+		return fmt.Errorf("%w (checked on the first segment only)", err)
+	}
+	return err
+}
+
+// Internal: fetch the first bytes of the file from a source and compare them
+// with the expected signature. The file starts at offset in the resource of
+// the URL, and length bytes of it can be fetched from there.
+func (dldr *MultiDownloader) checkSignature(url string, offset, length int64) error {
+	sig := dldr.signature
+	end := sig.Offset + int64(len(sig.Magic))
+	if end > length {
+		return errors.New(
+			fmt.Sprintf("File is too short to be a %s file", sig.Name))
+	}
+	fetchLength := end
+	if fetchLength < sniffLength {
+		fetchLength = sniffLength
+	}
+	if fetchLength > length {
+		fetchLength = length
+	}
+
+	req, err := dldr.newRequest("GET", httpURL(url), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+fetchLength-1))
+	resp, err := dldr.httpClient(url, dldr.timeout).Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		return errors.New(
+			fmt.Sprintf("Failed checking signature of URL %s: status %d", url, resp.StatusCode))
+	}
+
+	// A 200 response carries the whole resource, only the beginning is needed
+	if resp.StatusCode == http.StatusOK && offset > 0 {
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			return err
+		}
+	}
+	head := make([]byte, fetchLength)
+	n, err := io.ReadFull(resp.Body, head)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return err
+	}
+	head = head[:n]
+	if int64(n) < end || !bytes.Equal(head[sig.Offset:end], sig.Magic) {
+		return withHint(errors.New(
+			fmt.Sprintf("URL %s doesn't serve a %s file (looks like %s)",
+				url, sig.Name, http.DetectContentType(head))),
+			"Check the URL: servers often answer with an error or login page instead of the file")
+	}
+	dldr.logVerbose("Signature of ", url, " matches ", sig.Name)
+	return nil
+}
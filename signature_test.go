@@ -0,0 +1,96 @@
+package multipartdownloader
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignature(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+
+	quijote := Signature{"Quijote", 3, []byte("INGENIOSO")}
+	testTable := []struct {
+		sig Signature
+		ok  bool
+	}{
+		{quijote, true},
+		{SignatureZIP, false},
+		{SignatureISO, false},
+	}
+	for _, test := range testTable {
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/quijote.txt"},
+			2,
+			time.Duration(5000)*time.Millisecond,
+			WithSignature(test.sig))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+		failOnError(t, err)
+		err = dldr.Download(nil)
+		if test.ok && err != nil {
+			t.Errorf("Signature %s should match: %s", test.sig.Name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("Signature %s shouldn't match", test.sig.Name)
+		}
+	}
+}
+
+// A mirror answering with an HTML page is caught before downloading
+func TestSignatureHTML(t *testing.T) {
+	page := "<!DOCTYPE html><html><body>Access denied</body></html>"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.zip", time.Time{}, strings.NewReader(page))
+	}))
+	defer server.Close()
+
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/file.zip"},
+		1,
+		time.Duration(5000)*time.Millisecond,
+		WithSignature(SignatureZIP))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "file.zip"))
+	failOnError(t, err)
+	err = dldr.Download(nil)
+	if err == nil || !strings.Contains(err.Error(), "text/html") {
+		t.Errorf("Download should abort pointing at the HTML page, got: %v", err)
+	}
+}
+
+// Segmented downloads are checked on their first segment
+func TestSignatureSegments(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	server := newHLSServer(t, data, 7)
+	defer server.Close()
+
+	for _, playlist := range []string{"media.m3u8", "byterange.m3u8"} {
+		for _, sig := range []Signature{{"Quijote", 3, []byte("INGENIOSO")}, SignatureZIP} {
+			dldr := NewMultiDownloader(
+				[]string{server.URL + "/hls/" + playlist},
+				2,
+				time.Duration(5000)*time.Millisecond,
+				WithSignature(sig))
+			_, err := dldr.GatherHLSInfo()
+			failOnError(t, err)
+			_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+			failOnError(t, err)
+			err = dldr.Download(nil)
+			if sig.Name == "Quijote" && err != nil {
+				t.Errorf("Signature should match on %s: %s", playlist, err)
+			}
+			if sig.Name != "Quijote" && err == nil {
+				t.Errorf("Signature %s shouldn't match on %s", sig.Name, playlist)
+			}
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Sources added and removed while downloading, for example when a discovery
+// service finds a closer mirror mid-transfer. A source added once the info is
+// gathered is probed first, and must serve the same file as the others. The
+// chunks take the sources as they are when they are tried: the requests
+// already sent to a removed source go on, but the next ones go to the others.
+//
+// Sources failing their probe in GatherInfo are left out, as long as enough
+// others answered (one by default, WithMinSources): one flaky mirror out of
+// ten doesn't hold back the download. DeadSources tells which were left out.
+
+// Require at least n sources to answer the probes of GatherInfo, instead of
+// one. The others are left out.
+func WithMinSources(n int) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.minSources = n
+	}
+}
+
+// Get the sources left out by GatherInfo because their probe failed, with
+// the reason
+func (dldr *MultiDownloader) DeadSources() []SourceResult {
+	return dldr.deadSources
+}
+
+// Add a source of the file. Before GatherInfo, it's probed along with the
+// others. Afterwards, it's probed right away, and refused if it doesn't serve
+// the same file.
+func (dldr *MultiDownloader) AddSource(url string) error {
+	if dldr.segments != nil {
+		return errors.New("Sources can't be added to a stream")
+	}
+	if dldr.gathered.Load() {
+		info := dldr.probe(dldr.context(), url)
+		switch {
+		case !info.connSuccess || info.statusCode != http.StatusOK:
+			return withHint(errors.New(fmt.Sprintf("Failed connection to URL %s", url)), probeRemedy(info))
+		case info.fileLength != dldr.fileLength || info.encoding != dldr.encoding || !dldr.sameETag(info.etag):
+			return errors.New(fmt.Sprintf("Source %s serves a different file", url))
+		case info.noRanges && !dldr.noRanges:
+			return errors.New(fmt.Sprintf("Source %s doesn't accept range requests", url))
+		}
+	}
+
+	dldr.mu.Lock()
+	defer dldr.mu.Unlock()
+	if containsString(dldr.urls, url) {
+		return nil
+	}
+	dldr.logVerbose("Adding source ", url)
+	dldr.urls = append(dldr.urls[:len(dldr.urls):len(dldr.urls)], url)
+	return nil
+}
+
+// Remove a source of the file. The requests already sent to it go on, but no
+// new one is sent. The last source can't be removed.
+func (dldr *MultiDownloader) RemoveSource(url string) error {
+	dldr.mu.Lock()
+	defer dldr.mu.Unlock()
+	if !containsString(dldr.urls, url) {
+		return errors.New(fmt.Sprintf("Unknown source %s", url))
+	}
+	if len(dldr.urls) == 1 {
+		return errors.New("The last source can't be removed")
+	}
+	dldr.logVerbose("Removing source ", url)
+	urls := make([]string, 0, len(dldr.urls)-1)
+	for _, u := range dldr.urls {
+		if u != url {
+			urls = append(urls, u)
+		}
+	}
+	dldr.urls = urls
+	return nil
+}
+
+// Get the sources of the file, as they are now
+func (dldr *MultiDownloader) Sources() []string {
+	return append([]string(nil), dldr.sourceURLs()...)
+}
+
+// Internal: the sources of the file, as they are now. The slice isn't
+// modified afterwards.
+func (dldr *MultiDownloader) sourceURLs() []string {
+	dldr.mu.Lock()
+	defer dldr.mu.Unlock()
+	return dldr.urls
+}
+
+// Internal: replace the sources of the file, not modifying the given slice
+// afterwards
+func (dldr *MultiDownloader) setSourceURLs(urls []string) {
+	dldr.mu.Lock()
+	defer dldr.mu.Unlock()
+	dldr.urls = urls
+}
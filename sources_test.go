@@ -0,0 +1,151 @@
+package multipartdownloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A source added while downloading takes the next chunks, none going to a
+// removed one
+func TestAddRemoveSource(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	var first, second atomic.Int32
+	started := make(chan struct{}, 1)
+	proceed := make(chan struct{})
+	old := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			if first.Add(1) == 1 {
+				started <- struct{}{}
+				<-proceed
+			}
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer old.Close()
+	closer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			second.Add(1)
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer closer.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "12")
+	}))
+	defer other.Close()
+
+	dldr := NewMultiDownloader([]string{old.URL + "/quijote.txt"}, 1, 5*time.Second, WithChunkSize(64<<10), WithSHA256(quijoteSHA256))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	out := filepath.Join(t.TempDir(), "quijote.txt")
+	_, err = dldr.SetupFile(out)
+	failOnError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- dldr.Download(nil)
+	}()
+
+	<-started
+	if err := dldr.AddSource(other.URL + "/quijote.txt"); err == nil || !strings.Contains(err.Error(), "different file") {
+		t.Errorf("Expected a source serving another file to be refused, got %v", err)
+	}
+	failOnError(t, dldr.AddSource(closer.URL+"/quijote.txt"))
+	failOnError(t, dldr.RemoveSource(old.URL+"/quijote.txt"))
+	if err := dldr.RemoveSource(closer.URL + "/quijote.txt"); err == nil {
+		t.Error("The last source was removed")
+	}
+	close(proceed)
+	failOnError(t, <-done)
+
+	if first.Load() != 1 || second.Load() != 4 {
+		t.Errorf("Expected 1 chunk from the removed source and 4 from the added one, got %d and %d",
+			first.Load(), second.Load())
+	}
+	if sources := dldr.Sources(); len(sources) != 1 || sources[0] != closer.URL+"/quijote.txt" {
+		t.Errorf("Unexpected sources %v", sources)
+	}
+}
+
+// Sources are added while the connections take them, without waiting for
+// them (run with -race)
+func TestAddSourceWhileDownloading(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	started := make(chan struct{})
+	var once sync.Once
+	servers := make([]*httptest.Server, 4)
+	for i := range servers {
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				once.Do(func() { close(started) })
+			}
+			fileServer.ServeHTTP(w, r)
+		}))
+		defer servers[i].Close()
+	}
+
+	dldr := NewMultiDownloader([]string{servers[0].URL + "/quijote.txt"}, 4, 5*time.Second,
+		WithChunkSize(16<<10), WithSHA256(quijoteSHA256))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	done := make(chan error)
+	go func() {
+		done <- dldr.Download(nil)
+	}()
+	<-started
+	for _, server := range servers[1:] {
+		failOnError(t, dldr.AddSource(server.URL+"/quijote.txt"))
+	}
+	// Until the end
+	last := servers[len(servers)-1].URL + "/quijote.txt"
+	for {
+		select {
+		case err := <-done:
+			failOnError(t, err)
+			if sources := dldr.Sources(); len(sources) < len(servers)-1 {
+				t.Errorf("Expected at least %d sources, got %v", len(servers)-1, sources)
+			}
+			return
+		default:
+		}
+		failOnError(t, dldr.RemoveSource(last))
+		failOnError(t, dldr.AddSource(last))
+	}
+}
+
+// Sources failing their probe are left out, unless too few are left
+func TestDeadSources(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+	urls := []string{server.URL + "/missing.txt", server.URL + "/quijote.txt", "http://127.0.0.1:1/quijote.txt"}
+
+	result, err := Fetch(context.Background(), urls, filepath.Join(t.TempDir(), "quijote.txt"),
+		WithSHA256(quijoteSHA256))
+	failOnError(t, err)
+	if len(result.Sources) != 1 || result.Sources[0] != urls[1] {
+		t.Errorf("Expected the file to be downloaded from the source left, got %v", result.Sources)
+	}
+	dead := make(map[string]int)
+	for _, r := range result.Dead {
+		dead[r.URL] = r.StatusCode
+		if r.Err == nil {
+			t.Errorf("No error for the dead source %s", r.URL)
+		}
+	}
+	if len(dead) != 2 || dead[urls[0]] != http.StatusNotFound || dead[urls[2]] != 0 {
+		t.Errorf("Unexpected dead sources %+v", result.Dead)
+	}
+
+	dldr := NewMultiDownloader(urls, 2, 5*time.Second, WithMinSources(2))
+	if _, err := dldr.GatherInfo(); err == nil || len(dldr.DeadSources()) != 2 {
+		t.Errorf("Expected to fail with 2 dead sources out of 3 and at least 2 required, got %v", err)
+	}
+}
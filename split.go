@@ -0,0 +1,204 @@
+package multipartdownloader
+
+import "sync"
+
+// Dynamic splitting of slow chunks. When a connection is done and no chunk
+// is waiting for one, the chunk with the most left to download is split: its
+// connection stops at the middle of what's left, and the rest becomes a new
+// chunk for the idle connection. A slow mirror holding a big chunk then
+// doesn't hold the whole download back.
+
+// Chunks with less than twice this left aren't split
+const minSplitSize = 256 << 10
+
+// Enable or disable splitting slow chunks for idle connections. Enabled by default.
+func WithSplitting(enabled bool) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.noSplitting = !enabled
+	}
+}
+
+// The chunks of a running download, whose ends move when they are split
+type chunkTable struct {
+	mu      sync.Mutex
+	chunks  []Chunk
+	cursors []int64 // Next byte to write of each chunk
+	started []bool
+	avoid   map[int]map[string]bool // Mirrors not to download a chunk from
+	pending int                     // Chunks waiting for their first connection
+}
+
+// Internal: create the table of the chunks of a download
+func newChunkTable(chunks []Chunk) *chunkTable {
+	t := &chunkTable{
+		chunks:  append([]Chunk(nil), chunks...),
+		cursors: make([]int64, len(chunks)),
+		started: make([]bool, len(chunks)),
+		pending: len(chunks),
+	}
+	for i, c := range chunks {
+		t.cursors[i] = c.Begin
+	}
+	return t
+}
+
+// Internal: get a chunk as it is now
+func (t *chunkTable) get(i int) Chunk {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.chunks[i]
+}
+
+// Internal: number of chunks, split ones included
+func (t *chunkTable) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.chunks)
+}
+
+// Internal: copy of the chunks
+func (t *chunkTable) snapshot() []Chunk {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]Chunk(nil), t.chunks...)
+}
+
+// Internal: take the first chunk waiting for a connection
+func (t *chunkTable) next() (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.chunks {
+		if !t.started[i] {
+			t.started[i] = true
+			t.pending--
+			t.cursors[i] = t.chunks[i].Begin
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Internal: put back a chunk that failed, for another connection
+func (t *chunkTable) retry(i int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.started[i] {
+		t.started[i] = false
+		t.pending++
+	}
+}
+
+// Internal: record that a request for a chunk starts, returning the chunk
+func (t *chunkTable) start(i int) Chunk {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cursors[i] = t.chunks[i].Begin
+	return t.chunks[i]
+}
+
+// Internal: record the progress of a chunk, returning where it ends now
+func (t *chunkTable) advance(i int, cursor int64) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cursors[i] = cursor
+	return t.chunks[i].End
+}
+
+// Internal: split the started chunk with the most left to download, if there
+// are no chunks waiting and it's worth it. Returns the index of the new chunk.
+func (t *chunkTable) split() (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.pending > 0 {
+		return 0, false
+	}
+	largest, left := -1, int64(2*minSplitSize-1)
+	for i, c := range t.chunks {
+		if t.started[i] && c.End-t.cursors[i] > left {
+			largest, left = i, c.End-t.cursors[i]
+		}
+	}
+	if largest < 0 {
+		return 0, false
+	}
+	end := t.chunks[largest].End
+	middle := t.cursors[largest] + left/2
+	t.chunks[largest].End = middle
+	t.chunks = append(t.chunks, Chunk{middle, end})
+	t.cursors = append(t.cursors, middle)
+	t.started = append(t.started, false)
+	t.pending++
+	return len(t.chunks) - 1, true
+}
+
+// Internal: stop a started chunk at cursor, returning the new chunk with the
+// rest, waiting for a connection
+func (t *chunkTable) yield(i int, cursor int64) Chunk {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	end := t.chunks[i].End
+	t.chunks[i].End = cursor
+	t.chunks = append(t.chunks, Chunk{cursor, end})
+	t.cursors = append(t.cursors, cursor)
+	t.started = append(t.started, false)
+	t.pending++
+	return Chunk{cursor, end}
+}
+
+// Internal: add a chunk to download again, from other mirrors than the
+// given ones if possible. Returns its index.
+func (t *chunkTable) add(c Chunk, avoid map[string]bool) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.chunks = append(t.chunks, c)
+	t.cursors = append(t.cursors, c.Begin)
+	t.started = append(t.started, false)
+	t.pending++
+	if t.avoid == nil {
+		t.avoid = make(map[int]map[string]bool)
+	}
+	t.avoid[len(t.chunks)-1] = avoid
+	return len(t.chunks) - 1
+}
+
+// Internal: the mirrors a chunk shouldn't be downloaded from
+func (t *chunkTable) avoided(i int) map[string]bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.avoid[i]
+}
+
+// Internal: where the copy of a chunk is
+func (t *chunkTable) cursor(i int) int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cursors[i]
+}
+
+// Internal: drop the part of a chunk already written, returning it
+func (t *chunkTable) trim(i int) Chunk {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	written := Chunk{t.chunks[i].Begin, t.cursors[i]}
+	t.chunks[i].Begin = t.cursors[i]
+	return written
+}
+
+// Internal: whether all the chunks have had a connection, which is the tail
+// of the download
+func (t *chunkTable) tail() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pending == 0
+}
+
+// Internal: the state of the chunks
+func (t *chunkTable) states() []ChunkState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	states := make([]ChunkState, len(t.chunks))
+	for i, c := range t.chunks {
+		states[i] = ChunkState{Chunk: c, Current: t.cursors[i], Started: t.started[i]}
+	}
+	return states
+}
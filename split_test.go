@@ -0,0 +1,97 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The started chunk with the most left is split in the middle of what's left
+func TestChunkTableSplit(t *testing.T) {
+	table := newChunkTable([]Chunk{{0, 1 << 20}, {1 << 20, 4 << 20}})
+	if _, ok := table.split(); ok {
+		t.Fatal("Chunks were split while others waited for a connection")
+	}
+	table.next()
+	table.next()
+	table.advance(1, 2<<20)
+	j, ok := table.split()
+	if !ok || j != 2 {
+		t.Fatalf("Expected a new chunk 2, got %d, %v", j, ok)
+	}
+	if table.get(1) != (Chunk{1 << 20, 3 << 20}) || table.get(2) != (Chunk{3 << 20, 4 << 20}) {
+		t.Errorf("Wrong split: %v", table.snapshot())
+	}
+	table.next()
+	table.advance(0, 1<<20-minSplitSize)
+	table.advance(1, 3<<20-minSplitSize)
+	table.advance(2, 4<<20-minSplitSize)
+	if _, ok := table.split(); ok {
+		t.Errorf("Chunks too small were split: %v", table.snapshot())
+	}
+}
+
+// A slow first chunk is taken over by the connection left idle
+func TestSplitSlowChunk(t *testing.T) {
+	data := make([]byte, 2<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+		}
+		if strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			w = slowWriter{w}
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	dldr := NewMultiDownloader([]string{server.URL + "/data.bin"}, 2, 5*time.Second)
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	out := filepath.Join(t.TempDir(), "data.bin")
+	_, err = dldr.SetupFile(out)
+	failOnError(t, err)
+	start := time.Now()
+	failOnError(t, dldr.Download(nil))
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("The slow chunk wasn't split, the download took %v", elapsed)
+	}
+	if gets <= 2 {
+		t.Errorf("Expected more requests than connections, got %d", gets)
+	}
+	got, err := os.ReadFile(out)
+	failOnError(t, err)
+	if !bytes.Equal(got, data) {
+		t.Error("Downloaded file differs from the source")
+	}
+}
+
+// Writes slowly, in small pieces
+type slowWriter struct {
+	http.ResponseWriter
+}
+
+func (w slowWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), 16<<10)
+		time.Sleep(40 * time.Millisecond)
+		n, err := w.ResponseWriter.Write(p[:n])
+		written += n
+		if err != nil {
+			return written, err
+		}
+		w.ResponseWriter.(http.Flusher).Flush()
+		p = p[n:]
+	}
+	return written, nil
+}
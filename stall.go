@@ -0,0 +1,78 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Stalled transfers. With a low speed limit, like curl's --speed-limit and
+// --speed-time, a request for a chunk downloading slower than the limit for
+// the given time is dropped. What was written is kept, and the rest of the
+// chunk is requested from the next mirror.
+
+// Returned (wrapped) when a request is dropped for being too slow
+var errStalled = errors.New("Transfer below the speed limit")
+
+// Drop the requests for chunks transferring less than limit bytes per
+// second during period, and request the rest from the next mirror
+func WithLowSpeedLimit(limit int64, period time.Duration) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.lowSpeed = limit
+		dldr.lowSpeedTime = period
+	}
+}
+
+// Internal: check every period that position advances at least at the low
+// speed limit, calling cancel if it doesn't. Returns the function stopping
+// the checks, which tells whether the transfer stalled.
+func (dldr *MultiDownloader) watchSpeed(position func() int64, cancel func()) func() bool {
+	var stalled atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(dldr.lowSpeedTime)
+		defer ticker.Stop()
+		last := position()
+		for {
+			select {
+			case <-ticker.C:
+				current := position()
+				if float64(current-last) < float64(dldr.lowSpeed)*dldr.lowSpeedTime.Seconds() {
+					stalled.Store(true)
+					cancel()
+					return
+				}
+				last = current
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() bool {
+		close(done)
+		return stalled.Load()
+	}
+}
+
+// Internal: drop the request for a chunk below the low speed limit, or idle
+// for the idle timeout, calling cancel. Returns the function stopping the
+// checks, which tells why the request was dropped, if it was.
+func (dldr *MultiDownloader) watchChunk(position func() int64, cancel func()) func() error {
+	stalled, idle := func() bool { return false }, func() bool { return false }
+	if dldr.lowSpeed > 0 && dldr.lowSpeedTime > 0 {
+		stalled = dldr.watchSpeed(position, cancel)
+	}
+	if dldr.idleTimeout > 0 {
+		idle = dldr.watchIdle(position, cancel)
+	}
+	return func() error {
+		isStalled, isIdle := stalled(), idle()
+		switch {
+		case isStalled:
+			return errStalled
+		case isIdle:
+			return errIdle
+		}
+		return nil
+	}
+}
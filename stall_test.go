@@ -0,0 +1,73 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Sends the first bytes, then hangs until the request is dropped
+type stallingWriter struct {
+	http.ResponseWriter
+	r    *http.Request
+	sent int
+}
+
+func (w *stallingWriter) Write(p []byte) (int, error) {
+	if w.sent+len(p) > 100<<10 {
+		<-w.r.Context().Done()
+		return 0, w.r.Context().Err()
+	}
+	w.sent += len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+// A stalled transfer is dropped, and the rest of its chunk requested from
+// the other mirror
+func TestStalledTransfer(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	var stalledRanges []string
+	var resumed atomic.Bool
+	stalling := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			stalledRanges = append(stalledRanges, r.Header.Get("Range"))
+			w = &stallingWriter{ResponseWriter: w, r: r}
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer stalling.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The rest of the stalled chunk, not all of it
+		if strings.HasPrefix(r.Header.Get("Range"), "bytes=") && !strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			resumed.Store(true)
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer good.Close()
+
+	dldr := NewMultiDownloader([]string{stalling.URL + "/data.bin", good.URL + "/data.bin"},
+		1, 5*time.Second, WithLowSpeedLimit(10<<10, 200*time.Millisecond))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	dldr.urls = []string{stalling.URL + "/data.bin", good.URL + "/data.bin"} // In the order of the probes
+	out := filepath.Join(t.TempDir(), "data.bin")
+	_, err = dldr.SetupFile(out)
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	got, err := os.ReadFile(out)
+	failOnError(t, err)
+	if !bytes.Equal(got, data) {
+		t.Error("Downloaded file differs from the source")
+	}
+	if len(stalledRanges) != 1 || !resumed.Load() {
+		t.Errorf("Expected the stalled chunk to be resumed from the other mirror, requested %v", stalledRanges)
+	}
+}
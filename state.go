@@ -0,0 +1,77 @@
+package multipartdownloader
+
+import "errors"
+
+// Read-only accessors of a download, for integrations polling it (a status
+// endpoint, a cron check...) instead of getting callbacks. They can be called
+// from any goroutine while the download runs.
+
+// State of a downloader
+type DownloadState int32
+
+const (
+	StateNew         DownloadState = iota // The file isn't set up yet
+	StateReady                            // SetupFile returned, ready to download
+	StateDownloading                      // Download is running
+	StateDone                             // Downloaded, or nothing to download
+	StateFailed                           // Download failed
+)
+
+func (s DownloadState) String() string {
+	return [...]string{"new", "ready", "downloading", "done", "failed"}[s]
+}
+
+// State of a chunk of a download
+type ChunkState struct {
+	Chunk         // As split so far
+	Current int64 // Next byte to download
+	Started bool  // A connection took it
+}
+
+// Whether all of the chunk is downloaded
+func (c ChunkState) Done() bool {
+	return c.Current >= c.End
+}
+
+// Get the state of the downloader
+func (dldr *MultiDownloader) State() DownloadState {
+	return DownloadState(dldr.state.Load())
+}
+
+// Get the state of the chunks of the download running or last run, or of the
+// chunks to download if it didn't start
+func (dldr *MultiDownloader) Chunks() []ChunkState {
+	if table := dldr.table.Load(); table != nil {
+		return table.states()
+	}
+	states := make([]ChunkState, len(dldr.chunks))
+	for i, c := range dldr.chunks {
+		states[i] = ChunkState{Chunk: c, Current: c.Begin}
+	}
+	return states
+}
+
+// Get the bytes of the file written so far by the download running or last
+// run, those already there when resuming included
+func (dldr *MultiDownloader) BytesCompleted() int64 {
+	return dldr.written.total()
+}
+
+// Internal: record the state once SetupFile returns
+func (dldr *MultiDownloader) setupDone(err error) {
+	switch {
+	case err == nil:
+		dldr.state.Store(int32(StateReady))
+	case errors.Is(err, ErrAlreadyComplete) || errors.Is(err, ErrNotModified) || errors.Is(err, ErrSkipped):
+		dldr.state.Store(int32(StateDone))
+	}
+}
+
+// Internal: record the state once Download returns
+func (dldr *MultiDownloader) downloadDone(err error) {
+	if err != nil {
+		dldr.state.Store(int32(StateFailed))
+	} else {
+		dldr.state.Store(int32(StateDone))
+	}
+}
@@ -0,0 +1,73 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The state, the chunks and the bytes completed can be polled while
+// downloading
+func TestAccessors(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	started := make(chan struct{}, 3)
+	proceed := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			started <- struct{}{}
+			<-proceed
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	dldr := NewMultiDownloader([]string{server.URL + "/quijote.txt"}, 3, 5*time.Second)
+	if state := dldr.State(); state != StateNew {
+		t.Errorf("State %s before setting up the file", state)
+	}
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	if chunks := dldr.Chunks(); len(chunks) != 3 || chunks[1].Current != chunks[1].Begin || chunks[1].Started {
+		t.Errorf("Unexpected chunks before downloading: %v", chunks)
+	}
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	if state := dldr.State(); state != StateReady {
+		t.Errorf("State %s once the file is set up", state)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- dldr.Download(nil)
+	}()
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+	if state := dldr.State(); state != StateDownloading {
+		t.Errorf("State %s while downloading", state)
+	}
+	for _, c := range dldr.Chunks() {
+		if !c.Started || c.Done() {
+			t.Errorf("Unexpected chunk while waiting for the responses: %+v", c)
+		}
+	}
+	if n := dldr.BytesCompleted(); n != 0 {
+		t.Errorf("%d bytes completed before any response", n)
+	}
+	close(proceed)
+	failOnError(t, <-done)
+
+	if state := dldr.State(); state != StateDone {
+		t.Errorf("State %s once downloaded", state)
+	}
+	for _, c := range dldr.Chunks() {
+		if !c.Done() {
+			t.Errorf("Chunk not done once downloaded: %+v", c)
+		}
+	}
+	if n := dldr.BytesCompleted(); n != 317621 {
+		t.Errorf("%d bytes completed, expected 317621", n)
+	}
+}
@@ -0,0 +1,117 @@
+package multipartdownloader
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Statistics of a download, for reporting and the analysis of the mirrors:
+// how long it took, what was transferred and how fast, how often chunks had
+// to be requested again, and what each mirror contributed down to the ranges
+// of the file it served. They are those of the last download, and can be
+// queried with Stats while it runs.
+
+// Statistics of a download. Ranges downloaded again (repairs of corrupt
+// ranges) are listed after their first download, with the mirror they came
+// from the second time.
+type Stats struct {
+	Started   time.Time
+	Duration  time.Duration // Wall time, so far if still running
+	Bytes     int64         // Bytes received from the sources, including failed requests
+	AvgSpeed  float64       // Bytes per second over the whole download
+	PeakSpeed float64       // Highest bytes per second of all the connections together
+	Retries   int64         // Requests of a chunk after a failed one
+	Mirrors   []MirrorStats // What each mirror contributed, the fastest first
+	Ranges    []RangeSource // Mirror each range was downloaded from, in the order of the file
+}
+
+// The source a range of the file was downloaded from
+type RangeSource struct {
+	Begin int64
+	End   int64 // Not included
+	URL   string
+}
+
+// Internal: the statistics of the download running or last run
+type statsRecorder struct {
+	bytes   atomic.Int64
+	retries atomic.Int64
+
+	mu      sync.Mutex
+	started time.Time
+	ended   time.Time // Zero while running
+	peak    float64
+}
+
+// Internal: start recording the statistics of a download, sampling its speed.
+// The returned function stops it.
+func (s *statsRecorder) begin() func() {
+	s.bytes.Store(0)
+	s.retries.Store(0)
+	s.mu.Lock()
+	s.started, s.ended, s.peak = time.Now(), time.Time{}, 0
+	last := s.started
+	s.mu.Unlock()
+
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(speedInterval)
+		defer ticker.Stop()
+		lastBytes := int64(0)
+		for {
+			select {
+			case now := <-ticker.C:
+				n := s.bytes.Load()
+				s.mu.Lock()
+				s.peak = max(s.peak, float64(n-lastBytes)/now.Sub(last).Seconds())
+				s.mu.Unlock()
+				last, lastBytes = now, n
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-sampled
+		s.mu.Lock()
+		s.ended = time.Now()
+		s.mu.Unlock()
+	}
+}
+
+// Get the statistics of the last download, or of the one running
+func (dldr *MultiDownloader) Stats() Stats {
+	s := &dldr.stats
+	s.mu.Lock()
+	stats := Stats{Started: s.started, PeakSpeed: s.peak}
+	switch {
+	case !s.ended.IsZero():
+		stats.Duration = s.ended.Sub(s.started)
+	case !s.started.IsZero():
+		stats.Duration = time.Since(s.started)
+	}
+	s.mu.Unlock()
+	stats.Bytes = s.bytes.Load()
+	stats.Retries = s.retries.Load()
+	if stats.Duration > 0 {
+		stats.AvgSpeed = float64(stats.Bytes) / stats.Duration.Seconds()
+	}
+	// Downloads shorter than a sample are as fast as their average
+	stats.PeakSpeed = max(stats.PeakSpeed, stats.AvgSpeed)
+	stats.Mirrors = dldr.MirrorStats()
+
+	dldr.mu.Lock()
+	for _, r := range dldr.sources {
+		stats.Ranges = append(stats.Ranges, RangeSource{r.Begin, r.End, r.url})
+	}
+	dldr.mu.Unlock()
+	sort.SliceStable(stats.Ranges, func(i, j int) bool {
+		return stats.Ranges[i].Begin < stats.Ranges[j].Begin
+	})
+	return stats
+}
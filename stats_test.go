@@ -0,0 +1,61 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	good := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer good.Close()
+	bad := httptest.NewServer(failingChunks())
+	defer bad.Close()
+
+	dldr := NewMultiDownloader(
+		[]string{bad.URL + "/quijote.txt", good.URL + "/quijote.txt"}, 4, 5*time.Second)
+	if stats := dldr.Stats(); stats.Duration != 0 || stats.Bytes != 0 {
+		t.Errorf("Nothing should be recorded before downloading, got %+v", stats)
+	}
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+
+	stats := dldr.Stats()
+	if stats.Bytes != 317621 || stats.Duration <= 0 || stats.Started.IsZero() {
+		t.Errorf("Expected the bytes and time of the download, got %+v", stats)
+	}
+	if stats.AvgSpeed <= 0 || stats.PeakSpeed < stats.AvgSpeed {
+		t.Errorf("Expected a peak speed above the average, got %v and %v", stats.PeakSpeed, stats.AvgSpeed)
+	}
+	if stats.Retries == 0 {
+		t.Error("The requests after those failing should be counted as retries")
+	}
+	if later := dldr.Stats(); later.Duration != stats.Duration {
+		t.Errorf("The duration of a finished download shouldn't change, got %v then %v", stats.Duration, later.Duration)
+	}
+
+	// The good mirror served the whole file, in ranges following each other
+	end := int64(0)
+	for _, r := range stats.Ranges {
+		if r.Begin != end || !strings.HasPrefix(r.URL, good.URL) {
+			t.Errorf("Unexpected range %+v after %d", r, end)
+		}
+		end = r.End
+	}
+	if end != 317621 {
+		t.Errorf("The ranges should cover the file, got up to %d", end)
+	}
+	contributed := int64(0)
+	for _, m := range stats.Mirrors {
+		contributed += m.Bytes
+	}
+	if contributed != 317621 {
+		t.Errorf("The mirrors should have contributed the file, got %d bytes", contributed)
+	}
+}
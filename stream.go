@@ -0,0 +1,205 @@
+package multipartdownloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Streaming the file to a writer (stdout in a pipeline, a socket...) instead
+// of saving it. The chunks are still downloaded to the partial file, which
+// is written in any order, and copied to the writer in order as soon as they
+// are contiguous. Once streamed, the partial file is removed. Encoded files
+// are streamed once decoded, at the end. Streamed downloads can't be resumed.
+
+// Size of the blocks copied to the output writer
+const streamBlockSize = 1 << 20
+
+// Write the file to w instead of saving it under its name. Download returns
+// once it's all written.
+func WithOutputWriter(w io.Writer) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.output = w
+	}
+}
+
+// Write the file to the given writers too (hashes, uploaders...), in order as
+// it's downloaded, so that it doesn't need to be read again afterwards. They
+// get the downloaded bytes, before the output filters if any.
+func WithSinks(sinks ...io.Writer) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.sinks = append(dldr.sinks, sinks...)
+	}
+}
+
+// Internal: whether the partial file is streamed while downloading
+func (dldr *MultiDownloader) streams() bool {
+	return dldr.replacesFile() || len(dldr.sinks) > 0
+}
+
+// Internal: whether the file is streamed instead of renamed once complete
+func (dldr *MultiDownloader) replacesFile() bool {
+	return dldr.output != nil || len(dldr.filters) > 0 || dldr.extractDir != ""
+}
+
+// Internal: run the download, copying the partial file to the sinks and the
+// output writer (or file) in order as it's written, through the filters if any
+func (dldr *MultiDownloader) streamDownload(feedbackFunc func([]ConnectionProgress)) (err error) {
+	file, err := os.OpenFile(dldr.partFilename, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if dldr.replacesFile() {
+		defer func() {
+			if err == nil {
+				os.Remove(dldr.partFilename)
+				os.Remove(controlPath(dldr.partFilename))
+			}
+		}()
+	}
+
+	// The output, unless the file is only streamed to the sinks. Without an
+	// output writer, the output file is written next to its destination, and
+	// renamed once complete.
+	writers := dldr.sinks
+	if dldr.replacesFile() {
+		output := dldr.output
+		if dldr.extractDir != "" {
+			extractor := dldr.extractWriter(dldr.extractDir)
+			defer func() {
+				if errExtract := extractor.Close(); err == nil && errExtract != nil {
+					err = fmt.Errorf("Extracting the archive: %w", errExtract)
+				}
+			}()
+			output = extractor
+		} else if output == nil {
+			out, errCreate := os.CreateTemp(filepath.Dir(dldr.filename), "."+filepath.Base(dldr.filename)+".*")
+			if errCreate != nil {
+				return errCreate
+			}
+			defer func() {
+				if errClose := out.Close(); err == nil {
+					err = errClose
+				}
+				if err == nil {
+					err = dldr.outputMode(file, out.Name())
+				}
+				if err == nil {
+					err = os.Rename(out.Name(), dldr.filename)
+				}
+				if err == nil {
+					dldr.setModTime()
+				}
+				if err != nil {
+					os.Remove(out.Name())
+				}
+			}()
+			output = out
+		}
+		if len(dldr.filters) > 0 {
+			filtered := filterWriter(output, dldr.filters)
+			defer func() {
+				if errFilter := filtered.Close(); err == nil && errFilter != nil {
+					err = fmt.Errorf("Filtering the file: %w", errFilter)
+				}
+			}()
+			output = filtered
+		}
+		writers = append([]io.Writer{output}, dldr.sinks...)
+	}
+	output := io.MultiWriter(writers...)
+
+	// A failed output stops the download
+	ctx, cancel := context.WithCancel(dldr.context())
+	defer cancel()
+	parent := dldr.ctx
+	dldr.ctx = ctx
+	dldr.streaming = true
+	defer func() {
+		dldr.ctx = parent
+		dldr.streaming = false
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- dldr.Download(feedbackFunc)
+	}()
+	var pos int64
+	var errStream error
+	if dldr.encoding == "" {
+		pos, errStream = dldr.streamWritten(file, output)
+		if errStream != nil {
+			cancel()
+		}
+	}
+	if err := <-done; err != nil {
+		return err
+	}
+	if errStream != nil {
+		return fmt.Errorf("Streaming the file: %w", errStream)
+	}
+
+	// What's left, or all of the file once decoded
+	rest, err := os.Open(dldr.partFilename)
+	if err != nil {
+		return err
+	}
+	defer rest.Close()
+	if _, err := rest.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err = io.CopyBuffer(output, rest, make([]byte, streamBlockSize)); err != nil {
+		return err
+	}
+
+	// Saved as usual with only the sinks, once they're done with it
+	if !dldr.replacesFile() {
+		file.Close()
+		rest.Close()
+		return dldr.finishFile()
+	}
+	return nil
+}
+
+// Internal: copy the file to the output in order, as it's written. Returns
+// how much was copied.
+func (dldr *MultiDownloader) streamWritten(file *os.File, output io.Writer) (int64, error) {
+	buf := make([]byte, streamBlockSize)
+	pos := int64(0)
+	for pos < dldr.fileLength {
+		end := min(pos+streamBlockSize, dldr.fileLength)
+		if err := dldr.WaitForRange(pos, end); err != nil {
+			return pos, err
+		}
+		n, err := file.ReadAt(buf[:end-pos], pos)
+		if _, errWr := output.Write(buf[:n]); errWr != nil {
+			return pos, errWr
+		}
+		// What's streamed isn't needed anymore, unless it's checked at the end
+		if dldr.replacesFile() && !dldr.hasDigests() && !dldr.checksPGPSignature() &&
+			dldr.blockSums == nil {
+			punchHole(file, pos, int64(n))
+		}
+		pos += int64(n)
+		if err != nil {
+			return pos, err
+		}
+	}
+	return pos, nil
+}
+
+// Internal: give the temporary output file the mode of the partial file,
+// rather than the private one of temporary files, or the requested one
+func (dldr *MultiDownloader) outputMode(part *os.File, name string) error {
+	info, err := part.Stat()
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(name, info.Mode().Perm()); err != nil {
+		return err
+	}
+	return dldr.setPermissions(name)
+}
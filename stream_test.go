@@ -0,0 +1,89 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// The file is written to the output writer in order, and not saved
+func TestOutputWriter(t *testing.T) {
+	content, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	ts := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer ts.Close()
+	for _, opts := range [][]Option{{}, {WithChunkSize(16 << 10)}} {
+		var out bytes.Buffer
+		dir := t.TempDir()
+		dldr := NewMultiDownloader([]string{ts.URL + "/quijote.txt"}, 4, 5*time.Second,
+			append(opts, WithOutputDir(dir), WithOutputWriter(&out))...)
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile("")
+		failOnError(t, err)
+		failOnError(t, dldr.Download(nil))
+		if !bytes.Equal(out.Bytes(), content) {
+			t.Errorf("Streamed %d bytes differing from the file", out.Len())
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("Expected nothing saved, found %s", entries[0].Name())
+		}
+	}
+}
+
+// A failing writer stops the download
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, os.ErrClosed
+}
+
+func TestOutputWriterFailure(t *testing.T) {
+	ts := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer ts.Close()
+	dldr := NewMultiDownloader([]string{ts.URL + "/quijote.txt"}, 2, 5*time.Second,
+		WithOutputWriter(failingWriter{}))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	if err := dldr.Download(nil); err == nil {
+		t.Error("Expected the download to fail with the writer")
+	}
+}
+
+// The sinks get the file as it's downloaded, and it's saved as usual
+func TestSinks(t *testing.T) {
+	content, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	ts := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer ts.Close()
+	h := sha256.New()
+	var copied bytes.Buffer
+	dldr := NewMultiDownloader([]string{ts.URL + "/quijote.txt"}, 4, 5*time.Second,
+		WithChunkSize(16<<10), WithSinks(h, &copied))
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	output := filepath.Join(t.TempDir(), "quijote.txt")
+	_, err = dldr.SetupFile(output)
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	expected := sha256.Sum256(content)
+	if !bytes.Equal(h.Sum(nil), expected[:]) || !bytes.Equal(copied.Bytes(), content) {
+		t.Error("The sinks didn't get the file")
+	}
+	saved, err := ioutil.ReadFile(output)
+	failOnError(t, err)
+	if !bytes.Equal(saved, content) {
+		t.Error("The file wasn't saved")
+	}
+	if _, err := os.Stat(dldr.partFilename); !os.IsNotExist(err) {
+		t.Error("Expected the partial file to be renamed")
+	}
+}
@@ -0,0 +1,80 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Filename templates, for batches of downloads that need organized names that
+// don't collide, such as "{host}/{path}" to mirror the layout of the servers
+// or "{sha256}-{name}" for a content-addressed store. The variables are:
+//
+//	{host}    Host name of the source
+//	{path}    Path of the URL, without the leading slash
+//	{name}    Name the default resolver would choose
+//	{etag}    ETag of the file
+//	{sha256}  Expected SHA-256 of the file (WithSHA256)
+//	{date}    Date of the download, as 2006-01-02
+//
+// A variable without a value for the file is an error, rather than leaving
+// names that could collide. Values never escape the directory of the
+// template: slashes in them are replaced, except for {path}, whose ".."
+// elements are dropped.
+
+// Matches the variables of templates
+var templateVariable = regexp.MustCompile(`\{(\w+)\}`)
+
+// Resolver naming the file from a template. The template is checked for
+// unknown variables when resolving.
+func TemplateResolver(template string) FilenameResolver {
+	return FilenameResolverFunc(func(info SourceInfo) (string, error) {
+		u, err := url.Parse(info.URL)
+		if err != nil {
+			return "", err
+		}
+		var errTemplate error
+		name := templateVariable.ReplaceAllStringFunc(template, func(v string) string {
+			var value string
+			switch v[1 : len(v)-1] {
+			case "host":
+				value = safeTemplateValue(u.Hostname())
+			case "path":
+				value = strings.TrimPrefix(path.Clean("/"+strings.Replace(u.Path, "\\", "_", -1)), "/")
+			case "name":
+				value = safeTemplateValue(info.defaultName())
+			case "etag":
+				etag, _ := parseETag(info.ETag)
+				value = safeTemplateValue(etag)
+			case "sha256":
+				value = info.SHA256
+			case "date":
+				value = time.Now().Format("2006-01-02")
+			default:
+				errTemplate = errors.New(fmt.Sprintf("Unknown variable %s in the filename template", v))
+			}
+			if value == "" && errTemplate == nil {
+				errTemplate = errors.New(fmt.Sprintf("No value for %s in the filename template for %s", v, info.URL))
+			}
+			return value
+		})
+		if errTemplate != nil {
+			return "", errTemplate
+		}
+		return filepath.FromSlash(name), nil
+	})
+}
+
+// Internal: a value that can't change the directory of the file
+func safeTemplateValue(value string) string {
+	value = strings.NewReplacer("/", "_", "\\", "_").Replace(value)
+	if value == "." || value == ".." {
+		return ""
+	}
+	return value
+}
@@ -0,0 +1,39 @@
+package multipartdownloader
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTemplateResolver(t *testing.T) {
+	date := time.Now().Format("2006-01-02")
+	info := SourceInfo{
+		URL:    "https://example.com:8080/pub/../iso/debian.iso?x=1",
+		ETag:   `W/"ab/cd"`,
+		SHA256: "e3b0c442",
+	}
+	testTable := []struct {
+		template string
+		filename string
+	}{
+		{"{host}/{path}", "example.com/iso/debian.iso"},
+		{"{sha256}-{name}", "e3b0c442-debian.iso"},
+		{"{date}/{etag}.iso", date + "/ab_cd.iso"},
+		{"files/{name}", "files/debian.iso"},
+	}
+	for _, test := range testTable {
+		name, err := TemplateResolver(test.template).ResolveFilename(info)
+		failOnError(t, err)
+		if name != filepath.FromSlash(test.filename) {
+			t.Errorf("Template %q resolved to %q, should be %q", test.template, name, test.filename)
+		}
+	}
+
+	for _, template := range []string{"{size}", "{etag}"} {
+		_, err := TemplateResolver(template).ResolveFilename(SourceInfo{URL: "https://example.com/a"})
+		if err == nil {
+			t.Errorf("Template %q should fail without a value", template)
+		}
+	}
+}
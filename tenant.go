@@ -0,0 +1,153 @@
+package multipartdownloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Tenants isolate the downloads of different users of an embedding service,
+// so that they can run in a single process. The downloaders of a tenant
+// share its limits: connections, bandwidth and disk used by the files being
+// downloaded, which are kept in the tenant's own directory if it has one.
+// Each tenant keeps its own statistics.
+
+// Returned (wrapped) when a download doesn't fit in the disk left to its tenant
+var ErrTenantDiskQuota = errors.New("Tenant disk quota exceeded")
+
+// Limits of a tenant. Zero values mean no limit.
+type TenantLimits struct {
+	MaxConns     int    // Connections open at once, across all its downloads
+	MaxRate      int64  // Bytes per second, across all its downloads
+	MaxDiskUsage int64  // Bytes of the files being downloaded at once
+	TempDir      string // Directory for its partial files, instead of next to the output
+}
+
+// Statistics of a tenant
+type TenantStats struct {
+	Downloads       int   // Downloads started
+	Active          int   // Downloads running
+	Failed          int   // Downloads that returned an error
+	Connections     int   // Connections open
+	BytesDownloaded int64 // Bytes received by all its downloads
+	DiskUsage       int64 // Bytes reserved by the running downloads
+}
+
+// A tenant, shared by its downloaders with WithTenant
+type Tenant struct {
+	Name string
+
+	limits  TenantLimits
+	conns   chan bool // Connection slots, nil if unlimited
+	limiter *rateLimiter
+	mu      sync.Mutex
+	running map[*MultiDownloader]int64 // Disk reserved by each running download
+	stats   TenantStats
+}
+
+// Create a tenant with the given limits
+func NewTenant(name string, limits TenantLimits) *Tenant {
+	t := &Tenant{
+		Name:    name,
+		limits:  limits,
+		running: make(map[*MultiDownloader]int64),
+	}
+	if limits.MaxConns > 0 {
+		t.conns = make(chan bool, limits.MaxConns)
+	}
+	if limits.MaxRate > 0 {
+		t.limiter = newRateLimiter(limits.MaxRate)
+	}
+	return t
+}
+
+// Run the downloads on behalf of a tenant, sharing its limits
+func WithTenant(t *Tenant) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.tenant = t
+	}
+}
+
+// Get a snapshot of the statistics of the tenant
+func (t *Tenant) Stats() TenantStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := t.stats
+	stats.Active = len(t.running)
+	stats.DiskUsage = 0
+	for _, size := range t.running {
+		stats.DiskUsage += size
+	}
+	return stats
+}
+
+// Internal: register a download of size bytes, refusing it if it doesn't fit
+// in the disk left. A download restarted before finishing is only counted once.
+func (t *Tenant) begin(dldr *MultiDownloader, size int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	reserved, restarted := t.running[dldr]
+	if t.limits.MaxDiskUsage > 0 {
+		used := int64(0)
+		for _, s := range t.running {
+			used += s
+		}
+		if used-reserved+size > t.limits.MaxDiskUsage {
+			return withHint(fmt.Errorf("%w: %s needs %d bytes, %d of %d in use",
+				ErrTenantDiskQuota, t.Name, size, used-reserved, t.limits.MaxDiskUsage),
+				"Wait for other downloads of the tenant to finish, or raise its disk limit")
+		}
+	}
+	t.running[dldr] = size
+	if !restarted {
+		t.stats.Downloads++
+	}
+	return nil
+}
+
+// Internal: unregister a finished download
+func (t *Tenant) end(dldr *MultiDownloader, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.running[dldr]; !ok {
+		return
+	}
+	delete(t.running, dldr)
+	if err != nil {
+		t.stats.Failed++
+	}
+}
+
+// Internal: wait for a connection of the tenant to be available, returning
+// the function releasing it. Stops waiting when the context is done.
+func (t *Tenant) acquireConn(ctx context.Context) (func(), error) {
+	if t.conns != nil {
+		select {
+		case t.conns <- true:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	t.mu.Lock()
+	t.stats.Connections++
+	t.mu.Unlock()
+	return func() {
+		t.mu.Lock()
+		t.stats.Connections--
+		t.mu.Unlock()
+		if t.conns != nil {
+			<-t.conns
+		}
+	}, nil
+}
+
+// Internal: account for n bytes received, blocking as needed by the bandwidth limit
+func (t *Tenant) transferred(n int) {
+	t.mu.Lock()
+	t.stats.BytesDownloaded += int64(n)
+	t.mu.Unlock()
+	if t.limiter != nil {
+		t.limiter.wait(n)
+	}
+}
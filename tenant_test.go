@@ -0,0 +1,137 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The downloads of a tenant share its connections and keep their partial
+// files in its directory
+func TestTenantLimits(t *testing.T) {
+	data, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	fileServer := http.FileServer(http.Dir("./test"))
+	var open, maxOpen int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			n := atomic.AddInt32(&open, 1)
+			defer atomic.AddInt32(&open, -1)
+			for {
+				m := atomic.LoadInt32(&maxOpen)
+				if n <= m || atomic.CompareAndSwapInt32(&maxOpen, m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	tempDir := t.TempDir()
+	tenant := NewTenant("acme", TenantLimits{MaxConns: 2, TempDir: tempDir})
+	outDir := t.TempDir()
+	var wg sync.WaitGroup
+	for i, name := range []string{"a", "b", "c"} {
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/quijote.txt"}, 3, time.Duration(5000)*time.Millisecond, WithTenant(tenant))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(filepath.Join(outDir, name))
+		failOnError(t, err)
+		if filepath.Dir(dldr.partFilename) != tempDir {
+			t.Errorf("Partial file %d should be in the tenant directory, is %s", i, dldr.partFilename)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := dldr.Download(nil); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxOpen > 2 {
+		t.Errorf("The tenant allows 2 connections, %d were open at once", maxOpen)
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		downloaded, err := ioutil.ReadFile(filepath.Join(outDir, name))
+		failOnError(t, err)
+		if !bytes.Equal(downloaded, data) {
+			t.Errorf("File %s differs from the original", name)
+		}
+	}
+	if left, _ := os.ReadDir(tempDir); len(left) != 0 {
+		t.Errorf("Partial files left in the tenant directory: %v", left)
+	}
+	stats := tenant.Stats()
+	if stats.Downloads != 3 || stats.Active != 0 || stats.Failed != 0 ||
+		stats.Connections != 0 || stats.BytesDownloaded != 3*int64(len(data)) {
+		t.Errorf("Wrong tenant stats: %+v", stats)
+	}
+}
+
+func TestTenantDiskQuota(t *testing.T) {
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+	tenant := NewTenant("acme", TenantLimits{MaxDiskUsage: 1000})
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/quijote.txt"}, 2, time.Duration(5000)*time.Millisecond, WithTenant(tenant))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	if err := dldr.Download(nil); !errors.Is(err, ErrTenantDiskQuota) {
+		t.Errorf("Files larger than the tenant disk quota should be refused, got %v", err)
+	}
+	if stats := tenant.Stats(); stats.Downloads != 0 || stats.BytesDownloaded != 0 {
+		t.Errorf("Refused downloads shouldn't count, got %+v", stats)
+	}
+}
+
+func TestTenantRate(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 40000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+	tenant := NewTenant("acme", TenantLimits{MaxRate: 100000})
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/file.bin"}, 4, time.Duration(5000)*time.Millisecond, WithTenant(tenant))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "file.bin"))
+	failOnError(t, err)
+	start := time.Now()
+	failOnError(t, dldr.Download(nil))
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("40000 bytes at 100000 bytes/s took only %v", elapsed)
+	}
+}
+
+// A connection stops waiting for the tenant when its context is done
+func TestTenantConnCancel(t *testing.T) {
+	tenant := NewTenant("alice", TenantLimits{MaxConns: 1})
+	release, err := tenant.acquireConn(context.Background())
+	failOnError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := tenant.acquireConn(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the wait to time out, got %v", err)
+	}
+	release()
+	if stats := tenant.Stats(); stats.Connections != 0 {
+		t.Errorf("%d connections left open", stats.Connections)
+	}
+}
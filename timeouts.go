@@ -0,0 +1,117 @@
+package multipartdownloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Timeouts. The timeout given to NewMultiDownloader bounds the probes and the
+// other small requests (HEAD, checksum and signature files...), but not the
+// chunks, which take as long as the file is big. These are bounded instead
+// by the time to connect (TCP and TLS handshakes), the time the server takes
+// to answer, and the time without receiving anything, after which the rest
+// of the chunk is requested from the next mirror. WithMaxTime bounds the
+// whole download.
+
+// Returned (wrapped) when a download takes longer than its maximum time
+var ErrMaxTime = errors.New("Download took longer than its maximum time")
+
+// Returned (wrapped) when a request receives nothing for the idle timeout
+var errIdle = errors.New("Nothing received within the idle timeout")
+
+// Set the time to open a connection to a mirror, TCP and TLS handshakes
+// included
+func WithConnectTimeout(timeout time.Duration) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.connectTimeout = timeout
+	}
+}
+
+// Set the time to wait for the headers of a response once the request is
+// sent
+func WithResponseHeaderTimeout(timeout time.Duration) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.headerTimeout = timeout
+	}
+}
+
+// Drop the requests for chunks that receive nothing for this long, and
+// request the rest from the next mirror
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.idleTimeout = timeout
+	}
+}
+
+// Stop the download once it has taken this long, Download returning
+// ErrMaxTime. What was written is kept for resuming it.
+func WithMaxTime(d time.Duration) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.maxTime = d
+	}
+}
+
+// Internal: apply the connect and response header timeouts to the transport
+// of a mirror
+func (dldr *MultiDownloader) setTimeouts(t *http.Transport) {
+	if dldr.connectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: dldr.connectTimeout, KeepAlive: 30 * time.Second}
+		t.DialContext = dialer.DialContext
+		t.TLSHandshakeTimeout = dldr.connectTimeout
+	}
+	if dldr.headerTimeout > 0 {
+		t.ResponseHeaderTimeout = dldr.headerTimeout
+	}
+}
+
+// Internal: cancel the requests of the download once past its maximum time.
+// Returns the function ending it, which tells the error of the download.
+func (dldr *MultiDownloader) limitTime() func(err error) error {
+	ctx, cancel := context.WithTimeout(dldr.context(), dldr.maxTime)
+	parent := dldr.ctx
+	dldr.ctx = ctx
+	return func(err error) error {
+		dldr.ctx = parent
+		cancel()
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) && dldr.context().Err() == nil {
+			return fmt.Errorf("%w (%s): %v", ErrMaxTime, dldr.maxTime, err)
+		}
+		return err
+	}
+}
+
+// Internal: check that position advances at least once per idle timeout,
+// calling cancel if it doesn't. Returns the function stopping the checks,
+// which tells whether the transfer was idle.
+func (dldr *MultiDownloader) watchIdle(position func() int64, cancel func()) func() bool {
+	var idle atomic.Bool
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(dldr.idleTimeout / 4)
+		defer ticker.Stop()
+		last, since := position(), time.Now()
+		for {
+			select {
+			case <-ticker.C:
+				if current := position(); current != last {
+					last, since = current, time.Now()
+				} else if time.Since(since) >= dldr.idleTimeout {
+					idle.Store(true)
+					cancel()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() bool {
+		close(done)
+		return idle.Load()
+	}
+}
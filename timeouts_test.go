@@ -0,0 +1,86 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// The transports of the mirrors get the connect and header timeouts
+func TestTransportTimeouts(t *testing.T) {
+	dldr := NewMultiDownloader(nil, 1, time.Second,
+		WithConnectTimeout(2*time.Second), WithResponseHeaderTimeout(3*time.Second))
+	transport := dldr.transport("http://example.com/file")
+	if transport.TLSHandshakeTimeout != 2*time.Second || transport.DialContext == nil {
+		t.Errorf("TLS handshake timeout %s, should be 2s", transport.TLSHandshakeTimeout)
+	}
+	if transport.ResponseHeaderTimeout != 3*time.Second {
+		t.Errorf("Response header timeout %s, should be 3s", transport.ResponseHeaderTimeout)
+	}
+}
+
+// A request receiving nothing is dropped, and the rest of its chunk
+// requested from the other mirror
+func TestIdleTimeout(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	var resumed atomic.Bool
+	stalling := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w = &stallingWriter{ResponseWriter: w, r: r}
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer stalling.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.Header.Get("Range"), "bytes=") && !strings.HasPrefix(r.Header.Get("Range"), "bytes=0-") {
+			resumed.Store(true)
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(data))
+	}))
+	defer good.Close()
+
+	dldr := NewMultiDownloader([]string{stalling.URL + "/data.bin", good.URL + "/data.bin"},
+		1, 5*time.Second, WithIdleTimeout(200*time.Millisecond))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	dldr.urls = []string{stalling.URL + "/data.bin", good.URL + "/data.bin"} // In the order of the probes
+	out := filepath.Join(t.TempDir(), "data.bin")
+	_, err = dldr.SetupFile(out)
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	got, err := os.ReadFile(out)
+	failOnError(t, err)
+	if !bytes.Equal(got, data) {
+		t.Error("Downloaded file differs from the source")
+	}
+	if !resumed.Load() {
+		t.Error("Expected the idle chunk to be resumed from the other mirror")
+	}
+}
+
+// A download taking longer than its maximum time stops with ErrMaxTime
+func TestMaxTime(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	started := time.Now()
+	err := downloadLocal(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w = &stallingWriter{ResponseWriter: w, r: r}
+		}
+		fileServer.ServeHTTP(w, r)
+	}), 2, WithMaxTime(300*time.Millisecond))
+	if !errors.Is(err, ErrMaxTime) {
+		t.Errorf("Expected ErrMaxTime, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 3*time.Second {
+		t.Errorf("Stopped after %s", elapsed)
+	}
+}
@@ -0,0 +1,73 @@
+package multipartdownloader
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Timestamping, wget -N style, for scripts mirroring files periodically. When
+// the output file already exists, SetupFile asks the server whether it
+// changed since, and returns ErrNotModified if it didn't, so there is nothing
+// to download. Downloaded files get the modification time of the remote file,
+// which WithRemoteTime does without timestamping.
+
+// Returned by SetupFile when the output file is up to date
+var ErrNotModified = errors.New("The file is up to date")
+
+// Skip the download if the output file exists and the server reports that it
+// didn't change since it was modified. If etag isn't empty, it's the ETag the
+// file had when downloaded, which the server compares too.
+func WithTimestamping(etag string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.timestamping = true
+		dldr.knownETag = etag
+	}
+}
+
+// Give the downloaded file the modification time of the remote one, from its
+// Last-Modified header, as mirroring and backup tools expect
+func WithRemoteTime(enabled bool) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.remoteTime = enabled
+	}
+}
+
+// Internal: ask the server if the existing output file is up to date
+func (dldr *MultiDownloader) notModified(info os.FileInfo) (bool, error) {
+	if dldr.segments != nil || info.Size() != dldr.fileLength {
+		return false, nil
+	}
+	url := dldr.urls[0]
+	req, err := dldr.newRequest("HEAD", httpURL(url), nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("If-Modified-Since", info.ModTime().UTC().Format(http.TimeFormat))
+	if dldr.knownETag != "" {
+		req.Header.Set("If-None-Match", `"`+dldr.knownETag+`"`)
+	}
+	resp, err := dldr.httpClient(url, dldr.timeout).Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	dldr.logVerbose("Timestamping check of ", url, ": status ", resp.StatusCode)
+	return resp.StatusCode == http.StatusNotModified, nil
+}
+
+// Internal: give the downloaded file the modification time of the remote one,
+// if wanted
+func (dldr *MultiDownloader) setModTime() {
+	if !dldr.timestamping && !dldr.remoteTime {
+		return
+	}
+	modTime, err := http.ParseTime(dldr.lastModified)
+	if err != nil {
+		return
+	}
+	if err := os.Chtimes(dldr.filename, time.Now(), modTime); err != nil {
+		dldr.logVerbose("Error setting the modification time: ", err)
+	}
+}
@@ -0,0 +1,111 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTimestamping(t *testing.T) {
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	content := "some content"
+	var gets int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+		}
+		http.ServeContent(w, r, "file.txt", modTime, strings.NewReader(content))
+	}))
+	defer server.Close()
+	output := filepath.Join(t.TempDir(), "file.txt")
+
+	download := func() error {
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/file.txt"}, 2, time.Duration(5000)*time.Millisecond, WithTimestamping(""))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		if _, err := dldr.SetupFile(output); err != nil {
+			return err
+		}
+		return dldr.Download(nil)
+	}
+
+	failOnError(t, download())
+	info, err := os.Stat(output)
+	failOnError(t, err)
+	if !info.ModTime().Equal(modTime) {
+		t.Errorf("The file should have the remote modification time, has %v", info.ModTime())
+	}
+
+	atomic.StoreInt32(&gets, 0)
+	if err := download(); !errors.Is(err, ErrNotModified) {
+		t.Errorf("An up to date file shouldn't be downloaded, got %v", err)
+	}
+	if gets != 0 {
+		t.Errorf("An up to date file was requested %d times", gets)
+	}
+
+	modTime = modTime.Add(time.Hour)
+	failOnError(t, download())
+	if gets == 0 {
+		t.Error("A newer file should be downloaded")
+	}
+}
+
+func TestTimestampingETag(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Etag", `"v2"`)
+		http.ServeContent(w, r, "file.txt", time.Time{}, strings.NewReader("some content"))
+	}))
+	defer server.Close()
+	output := filepath.Join(t.TempDir(), "file.txt")
+	failOnError(t, os.WriteFile(output, []byte("old content!"), 0644))
+
+	for _, etag := range []string{"v1", "v2"} {
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/file.txt"}, 2, time.Duration(5000)*time.Millisecond, WithTimestamping(etag))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(output)
+		if unchanged := etag == "v2"; errors.Is(err, ErrNotModified) != unchanged {
+			t.Errorf("With ETag %s, SetupFile returned %v", etag, err)
+		}
+	}
+}
+
+// Files get the remote modification time without timestamping too, including
+// decompressed ones
+func TestRemoteTime(t *testing.T) {
+	modTime := time.Date(2021, 6, 7, 8, 9, 10, 0, time.UTC)
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write([]byte("some content"))
+	zw.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", modTime, bytes.NewReader(compressed.Bytes()))
+	}))
+	defer server.Close()
+
+	for _, opts := range [][]Option{{WithRemoteTime(true)}, {WithRemoteTime(true), WithOutputFilters(GzipFilter)}, {}} {
+		output := filepath.Join(t.TempDir(), "file")
+		dldr := NewMultiDownloader([]string{server.URL + "/file.gz"}, 2, 5*time.Second, opts...)
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(output)
+		failOnError(t, err)
+		failOnError(t, dldr.Download(nil))
+		info, err := os.Stat(output)
+		failOnError(t, err)
+		if info.ModTime().Equal(modTime) != dldr.remoteTime {
+			t.Errorf("Remote time %v, the file was modified at %v", dldr.remoteTime, info.ModTime())
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package multipartdownloader
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenTelemetry tracing of downloads. GatherInfo, Download, each attempt at
+// a chunk and the verification of the file are spans, those of a download
+// nested in its span, itself a child of the span of the context given with
+// WithContext if any. The requests of a chunk carry the context of its
+// attempt, so that the spans of an instrumented transport nest in it too.
+//
+// Spans go to the tracer provider given with WithTracerProvider, or else to
+// the global one, which drops them unless the application sets one.
+
+// Name of the tracer of the library
+const tracerName = "github.com/alvatar/multipart-downloader"
+
+// Trace the downloads with the tracers of a provider instead of the global one
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.tracerProvider = provider
+	}
+}
+
+// Internal: the tracer of the downloader
+func (dldr *MultiDownloader) tracer() trace.Tracer {
+	provider := dldr.tracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(tracerName)
+}
+
+// Internal: the context the spans of the downloader are children of, the
+// one of the download running if any
+func (dldr *MultiDownloader) traceContext() context.Context {
+	if dldr.spanCtx != nil {
+		return dldr.spanCtx
+	}
+	return dldr.context()
+}
+
+// Internal: start a span of the downloader
+func (dldr *MultiDownloader) startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return dldr.tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Internal: end a span, recording the error it ended with if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Internal: start the span of the download, unless it's one inside another.
+// The returned function ends it.
+func (dldr *MultiDownloader) traceDownload() func(error) {
+	if dldr.spanCtx != nil {
+		return func(error) {}
+	}
+	ctx, span := dldr.startSpan(dldr.context(), "Download",
+		attribute.String("download.file", dldr.filename),
+		attribute.Int64("download.size", dldr.fileLength),
+		attribute.Int("download.sources", len(dldr.urls)),
+		attribute.Int("download.connections", dldr.nConns))
+	dldr.spanCtx = ctx
+	return func(err error) {
+		dldr.spanCtx = nil
+		endSpan(span, err)
+	}
+}
+
+// Internal: start the span of an attempt at a chunk, giving its context to
+// the request. Retry is the round of retries of all the sources.
+func (dldr *MultiDownloader) traceChunk(req *http.Request, i int, chunk Chunk, retry int) (*http.Request, trace.Span) {
+	ctx, span := dldr.startSpan(dldr.traceContext(), "chunk",
+		attribute.Int("download.chunk", i),
+		attribute.Int64("download.chunk.begin", chunk.Begin),
+		attribute.Int64("download.chunk.end", chunk.End),
+		attribute.Int("download.chunk.retry", retry))
+	return req.WithContext(trace.ContextWithSpan(req.Context(), trace.SpanFromContext(ctx))), span
+}
+
+// Internal: end the span of an attempt at a chunk with the mirror it was
+// requested from (which hedging may change), the status of the response (0
+// if none) and the bytes written
+func endChunkSpan(span trace.Span, req *http.Request, status int, written int64, err error) {
+	span.SetAttributes(
+		attribute.String("download.mirror", req.URL.Host),
+		attribute.Int64("download.chunk.bytes", written))
+	if status != 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+	}
+	endSpan(span, err)
+}
+
+// Internal: start the span of GatherInfo, returning the context of its
+// requests. The returned function ends it.
+func (dldr *MultiDownloader) traceGatherInfo() (context.Context, func(error)) {
+	ctx, span := dldr.startSpan(dldr.context(), "GatherInfo",
+		attribute.Int("download.sources", len(dldr.urls)))
+	return ctx, func(err error) {
+		if err == nil {
+			span.SetAttributes(
+				attribute.String("download.file", dldr.filename),
+				attribute.Int64("download.size", dldr.fileLength),
+				attribute.Int("download.sources", len(dldr.urls)))
+		}
+		endSpan(span, err)
+	}
+}
+
+// Internal: start the span of the verification of the downloaded file. The
+// returned function ends it.
+func (dldr *MultiDownloader) traceVerify() func(error) {
+	_, span := dldr.startSpan(dldr.traceContext(), "verify")
+	return func(err error) {
+		endSpan(span, err)
+	}
+}
@@ -0,0 +1,58 @@
+package multipartdownloader
+
+import (
+	"net/http"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	err := downloadLocal(t, http.FileServer(http.Dir("./test")), 3,
+		WithTracerProvider(provider), WithSHA256(quijoteSHA256))
+	failOnError(t, err)
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, s := range recorder.Ended() {
+		spans[s.Name()] = append(spans[s.Name()], s)
+	}
+	for _, name := range []string{"GatherInfo", "Download", "verify"} {
+		if len(spans[name]) != 1 {
+			t.Fatalf("Expected one %s span, got %d", name, len(spans[name]))
+		}
+	}
+	if len(spans["chunk"]) != 3 {
+		t.Fatalf("Expected a span for each of the 3 chunks, got %d", len(spans["chunk"]))
+	}
+	download := spans["Download"][0].SpanContext()
+	written := int64(0)
+	for _, s := range append(spans["chunk"], spans["verify"]...) {
+		if s.Parent().SpanID() != download.SpanID() {
+			t.Errorf("Span %s should be a child of the download", s.Name())
+		}
+		for _, a := range s.Attributes() {
+			if a.Key == attribute.Key("download.chunk.bytes") {
+				written += a.Value.AsInt64()
+			}
+		}
+	}
+	if size := spans["GatherInfo"][0].Attributes(); !hasAttribute(size, "download.size") {
+		t.Errorf("GatherInfo span should have the size of the file, got %v", size)
+	}
+	if written != 317621 {
+		t.Errorf("Chunk spans should add up to the 317621 bytes of the file, got %d", written)
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, key string) bool {
+	for _, a := range attrs {
+		if a.Key == attribute.Key(key) {
+			return true
+		}
+	}
+	return false
+}
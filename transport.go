@@ -0,0 +1,149 @@
+package multipartdownloader
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Size of the TLS session cache of each mirror by default
+const defaultTLSSessionCacheSize = 64
+
+// Each mirror gets its own transport, with its own pool of idle connections
+// and TLS session cache. Retried and follow-up chunk requests to a mirror
+// then reuse an idle connection or, when a new one is needed, resume the TLS
+// session with an abbreviated handshake instead of a full one, which matters
+// on high-latency links.
+//
+// Note that TLS 1.3 early data (0-RTT) can't be used: Go's crypto/tls client
+// doesn't implement it, so resumed sessions still take one round trip.
+
+// Set the TLS configuration (root CAs, client certificates...) used for all
+// mirrors. Every mirror gets its own copy, with its own session cache.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.tlsConfig = config
+	}
+}
+
+// Set the number of TLS sessions cached for each mirror. Zero disables TLS
+// session resumption.
+func WithTLSSessionCache(size int) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.tlsSessionCacheSize = size
+	}
+}
+
+// Internal: get an HTTP client for the given URL, sharing the transport of
+// its mirror
+func (dldr *MultiDownloader) httpClient(urlStr string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: dldr.transport(urlStr),
+		Timeout:   timeout,
+	}
+}
+
+// Internal: get the transport of the mirror serving the given URL, creating
+// it on first use
+func (dldr *MultiDownloader) transport(urlStr string) *http.Transport {
+	key := urlStr
+	if u, err := url.Parse(httpURL(urlStr)); err == nil {
+		key = u.Scheme + "://" + u.Host
+	}
+
+	if dldr.sharedTransports != nil {
+		return dldr.sharedTransports.get(key+" "+dldr.transportSettings(), dldr.newTransport)
+	}
+
+	dldr.mu.Lock()
+	defer dldr.mu.Unlock()
+	if t, ok := dldr.transports[key]; ok {
+		return t
+	}
+	t := dldr.newTransport()
+	if dldr.transports == nil {
+		dldr.transports = make(map[string]*http.Transport)
+	}
+	dldr.transports[key] = t
+	return t
+}
+
+// Internal: the settings shaping the transports of the downloader. They are
+// only shared with downloaders of the same settings, not to use the TLS
+// configuration (client certificates...) or timeouts of another one.
+func (dldr *MultiDownloader) transportSettings() string {
+	return fmt.Sprintf("%p %d %v %v %d", dldr.tlsConfig, dldr.tlsSessionCacheSize,
+		dldr.connectTimeout, dldr.headerTimeout, dldr.nConns)
+}
+
+// Internal: create the transport of a mirror
+func (dldr *MultiDownloader) newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if dldr.tlsConfig != nil {
+		t.TLSClientConfig = dldr.tlsConfig.Clone()
+	} else {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	if dldr.tlsSessionCacheSize > 0 {
+		t.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(dldr.tlsSessionCacheSize)
+	} else {
+		t.TLSClientConfig.ClientSessionCache = nil // Even if the given config had one
+	}
+	dldr.setTimeouts(t)
+	// Keep enough idle connections for all chunks to reuse them
+	if t.MaxIdleConnsPerHost < dldr.nConns {
+		t.MaxIdleConnsPerHost = dldr.nConns
+	}
+	return t
+}
+
+// Internal: close the idle connections kept for all mirrors, unless they are
+// shared with other downloaders
+func (dldr *MultiDownloader) closeIdleConnections() {
+	dldr.mu.Lock()
+	defer dldr.mu.Unlock()
+	for _, t := range dldr.transports {
+		t.CloseIdleConnections()
+	}
+}
+
+// Internal: transports of the mirrors shared by downloaders, which keep the
+// idle connections and TLS sessions of each other
+type transportSet struct {
+	mu         sync.Mutex
+	transports map[string]*http.Transport
+}
+
+// Internal: share the transports of the mirrors with other downloaders
+func withTransports(s *transportSet) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.sharedTransports = s
+	}
+}
+
+// Internal: get the transport of a mirror, creating it on first use
+func (s *transportSet) get(key string, create func() *http.Transport) *http.Transport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.transports[key]; ok {
+		return t
+	}
+	if s.transports == nil {
+		s.transports = make(map[string]*http.Transport)
+	}
+	t := create()
+	s.transports[key] = t
+	return t
+}
+
+// Internal: close the idle connections kept for all mirrors
+func (s *transportSet) closeIdleConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.transports {
+		t.CloseIdleConnections()
+	}
+}
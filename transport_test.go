@@ -0,0 +1,135 @@
+package multipartdownloader
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Every chunk request to the same mirror after the first one resumes the TLS
+// session, even when the server closes connections after each response
+func TestTLSSessionResumption(t *testing.T) {
+	var requests, resumed int64
+	fileServer := http.FileServer(http.Dir("./test"))
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		if r.TLS.DidResume {
+			atomic.AddInt64(&resumed, 1)
+		}
+		w.Header().Set("Connection", "close")
+		fileServer.ServeHTTP(w, r)
+	}))
+	server.StartTLS()
+	defer server.Close()
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig
+
+	for _, cacheSize := range []int{defaultTLSSessionCacheSize, 0} {
+		atomic.StoreInt64(&requests, 0)
+		atomic.StoreInt64(&resumed, 0)
+		dldr := NewMultiDownloader(
+			[]string{server.URL + "/quijote.txt"},
+			4,
+			time.Duration(5000)*time.Millisecond,
+			WithTLSConfig(tlsConfig),
+			WithTLSSessionCache(cacheSize))
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+		failOnError(t, err)
+		failOnError(t, dldr.Download(nil))
+
+		if cacheSize > 0 && resumed == 0 {
+			t.Errorf("No TLS session was resumed out of %d requests", requests)
+		}
+		if cacheSize == 0 && resumed != 0 {
+			t.Errorf("%d TLS sessions resumed with the cache disabled", resumed)
+		}
+	}
+}
+
+// The session cache belongs to each mirror, not to the shared configuration
+func TestMirrorTransports(t *testing.T) {
+	dldr := NewMultiDownloader(nil, 4, time.Second, WithTLSConfig(&tls.Config{ServerName: "x"}))
+	a := dldr.transport("https://a.example.com/file")
+	if dldr.transport("https://a.example.com/other") != a {
+		t.Error("URLs of the same mirror should share a transport")
+	}
+	b := dldr.transport("davs://b.example.com/file")
+	if b == a || b.TLSClientConfig.ClientSessionCache == a.TLSClientConfig.ClientSessionCache {
+		t.Error("Each mirror should have its own transport and session cache")
+	}
+	if a.MaxIdleConnsPerHost < 4 || a.TLSClientConfig.ServerName != "x" {
+		t.Error("Mirror transports should keep idle connections and inherit the TLS config")
+	}
+}
+
+// Downloaders only share the transports of a mirror with the same TLS
+// configuration and timeouts
+func TestSharedTransports(t *testing.T) {
+	server := httptest.NewTLSServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+	trusted := WithTLSConfig(server.Client().Transport.(*http.Transport).TLSClientConfig)
+	shared := &transportSet{}
+	url := server.URL + "/quijote.txt"
+
+	dldr := NewMultiDownloader([]string{url}, 2, 5*time.Second, trusted, withTransports(shared))
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	other := NewMultiDownloader([]string{url}, 2, 5*time.Second, withTransports(shared))
+	if _, err := other.GatherInfo(); err == nil {
+		t.Error("A downloader without the certificate trusted the server with the transport of another one")
+	}
+	same := NewMultiDownloader([]string{url}, 2, 5*time.Second, trusted, withTransports(shared))
+	if same.transport(url) != dldr.transport(url) {
+		t.Error("Downloaders with the same settings should share the transport")
+	}
+	slower := NewMultiDownloader([]string{url}, 2, 5*time.Second, trusted, withTransports(shared),
+		WithResponseHeaderTimeout(time.Minute))
+	if slower.transport(url) == dldr.transport(url) {
+		t.Error("Downloaders with different timeouts shouldn't share the transport")
+	}
+}
+
+// Disabling the cache also drops the one of the given TLS configuration
+func TestTLSSessionCacheDisabled(t *testing.T) {
+	config := &tls.Config{ClientSessionCache: tls.NewLRUClientSessionCache(8)}
+	dldr := NewMultiDownloader(nil, 2, time.Second, WithTLSConfig(config), WithTLSSessionCache(0))
+	if dldr.transport("https://example.com/file").TLSClientConfig.ClientSessionCache != nil {
+		t.Error("WithTLSSessionCache(0) should disable session resumption")
+	}
+}
+
+// No connection is left open once the download is over
+func TestIdleConnectionsClosed(t *testing.T) {
+	var open int64
+	server := httptest.NewUnstartedServer(http.FileServer(http.Dir("./test")))
+	server.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		switch state {
+		case http.StateNew:
+			atomic.AddInt64(&open, 1)
+		case http.StateClosed, http.StateHijacked:
+			atomic.AddInt64(&open, -1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	dldr := NewMultiDownloader(
+		[]string{server.URL + "/quijote.txt"}, 4, time.Duration(5000)*time.Millisecond)
+	_, err := dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	failOnError(t, dldr.Download(nil))
+	for i := 0; i < 100 && atomic.LoadInt64(&open) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&open); n != 0 {
+		t.Errorf("%d connections still open after the download", n)
+	}
+}
@@ -1,14 +1,38 @@
 package multipartdownloader
 
 import (
+	"fmt"
 	"log"
+	"log/slog"
 )
 
+// Logging of the library. Each downloader logs to the logger given with
+// WithLogger, debug messages (what it's doing) and warnings (what went wrong
+// without stopping it), so that applications can route, filter and silence
+// them with a slog.Handler. Downloaders without one, and what isn't tied to a
+// downloader (shared quotas), log to the logger set with SetLogger, or else
+// to the standard log, debug messages only with SetVerbose.
+
 var verbose = false
 
+// Logger of what isn't logged to the logger of a downloader, nil for the
+// standard log
+var defaultLogger *slog.Logger
+
 // Verbose logging utility
 func logVerbose(e ...interface{}) {
-	if (verbose) {
+	if defaultLogger != nil {
+		defaultLogger.Debug(fmt.Sprint(e...))
+	} else if verbose {
+		log.Print(e...)
+	}
+}
+
+// Internal: log something that went wrong without stopping the download
+func logWarning(e ...interface{}) {
+	if defaultLogger != nil {
+		defaultLogger.Warn(fmt.Sprint(e...))
+	} else {
 		log.Print(e...)
 	}
 }
@@ -18,3 +42,35 @@ func SetVerbose(verb bool) {
 	verbose = verb
 }
 
+// Set the logger of the downloaders without their own, replacing the
+// standard log. Debug messages are logged if its handler enables them,
+// regardless of SetVerbose.
+func SetLogger(logger *slog.Logger) {
+	defaultLogger = logger
+}
+
+// Log the messages of the downloader to a logger, at the Debug and Warn
+// levels, instead of the default one
+func WithLogger(logger *slog.Logger) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.logger = logger
+	}
+}
+
+// Internal: log a debug message of the downloader
+func (dldr *MultiDownloader) logVerbose(e ...interface{}) {
+	if dldr.logger != nil {
+		dldr.logger.Debug(fmt.Sprint(e...))
+	} else {
+		logVerbose(e...)
+	}
+}
+
+// Internal: log a warning of the downloader
+func (dldr *MultiDownloader) logWarning(e ...interface{}) {
+	if dldr.logger != nil {
+		dldr.logger.Warn(fmt.Sprint(e...))
+	} else {
+		logWarning(e...)
+	}
+}
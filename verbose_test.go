@@ -0,0 +1,28 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	err := downloadLocal(t, http.FileServer(http.Dir("./test")), 2, WithLogger(logger))
+	failOnError(t, err)
+	if !strings.Contains(buf.String(), "level=DEBUG") || !strings.Contains(buf.String(), "File length: ") {
+		t.Errorf("Debug messages should go to the logger of the downloader, got:\n%s", buf.String())
+	}
+
+	// A logger not enabling debug messages silences them
+	buf.Reset()
+	logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	err = downloadLocal(t, http.FileServer(http.Dir("./test")), 2, WithLogger(logger))
+	failOnError(t, err)
+	if buf.Len() != 0 {
+		t.Errorf("Nothing should be logged above the level of the logger, got:\n%s", buf.String())
+	}
+}
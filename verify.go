@@ -0,0 +1,372 @@
+package multipartdownloader
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+	"lukechampine.com/blake3"
+)
+
+// Verification of downloaded files against the digests their publishers
+// give. Expected digests set with options (WithSHA256, WithSHA1...) are
+// checked before the partial file is renamed, and the Check methods check the
+// output file afterwards.
+//
+// When the caller gives no digest, those the sources publish in their
+// headers are picked up by GatherInfo and checked: RFC 3230 Digest (asked
+// for with Want-Digest), Repr-Digest, Content-MD5, and the CRC-32C and MD5
+// of object stores like GCS (x-goog-hash).
+
+// Table of the CRC-32C (Castagnoli) polynomial
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Set the SHA-1 the file must have, as legacy mirrors and Maven repositories
+// publish. It's checked like the SHA-256 of WithSHA256.
+func WithSHA1(hash string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.sha1 = hash
+	}
+}
+
+// Set the SHA-512 the file must have, as in the SHA512SUMS of Debian and
+// Ubuntu releases. It's checked like the SHA-256 of WithSHA256.
+func WithSHA512(hash string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.sha512 = hash
+	}
+}
+
+// Set the CRC-32 (IEEE) the file must have, as 8 hex digits. It's checked
+// like the SHA-256 of WithSHA256.
+func WithCRC32(checksum string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.crc32 = checksum
+	}
+}
+
+// Set the CRC-32C (Castagnoli) the file must have, as 8 hex digits. It's
+// checked like the SHA-256 of WithSHA256.
+func WithCRC32C(checksum string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.crc32c = checksum
+	}
+}
+
+// Set the BLAKE2b-512 the file must have, as computed by b2sum. It's checked
+// like the SHA-256 of WithSHA256.
+func WithBLAKE2b(hash string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.blake2b = hash
+	}
+}
+
+// Set the BLAKE3 (256 bits) the file must have, as computed by b3sum. It's
+// checked like the SHA-256 of WithSHA256, several times faster.
+func WithBLAKE3(hash string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.blake3 = hash
+	}
+}
+
+// Set the XXH64 the file must have, as 16 hex digits. It's checked like the
+// SHA-256 of WithSHA256, but only catches accidental corruption.
+func WithXXHash(hash string) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.xxhash = hash
+	}
+}
+
+// Set a digest the file must have, computed with any hash, for algorithms
+// the library doesn't know. It's checked like the SHA-256 of WithSHA256, and
+// can be given several times.
+func WithHash(h func() hash.Hash, expected []byte) Option {
+	return func(dldr *MultiDownloader) {
+		dldr.hashes = append(dldr.hashes, customHash{h, hex.EncodeToString(expected)})
+	}
+}
+
+// Check downloaded file against a digest computed with any hash
+func (dldr *MultiDownloader) CheckHash(h func() hash.Hash, expected []byte) error {
+	return checkFileDigest(dldr.filename, "digest", h(), hex.EncodeToString(expected))
+}
+
+// Check SHA-1 of downloaded file
+func (dldr *MultiDownloader) CheckSHA1(sha1hash string) error {
+	return checkFileDigest(dldr.filename, "SHA-1", sha1.New(), sha1hash)
+}
+
+// Check SHA-512 of downloaded file
+func (dldr *MultiDownloader) CheckSHA512(sha512hash string) error {
+	return checkFileDigest(dldr.filename, "SHA-512", sha512.New(), sha512hash)
+}
+
+// Check CRC-32 (IEEE) of downloaded file
+func (dldr *MultiDownloader) CheckCRC32(checksum string) error {
+	return checkFileDigest(dldr.filename, "CRC-32", crc32.NewIEEE(), checksum)
+}
+
+// Check CRC-32C (Castagnoli) of downloaded file
+func (dldr *MultiDownloader) CheckCRC32C(checksum string) error {
+	return checkFileDigest(dldr.filename, "CRC-32C", crc32.New(castagnoliTable), checksum)
+}
+
+// Check BLAKE2b-512 of downloaded file
+func (dldr *MultiDownloader) CheckBLAKE2b(hash string) error {
+	return checkFileDigest(dldr.filename, "BLAKE2b", newBLAKE2b(), hash)
+}
+
+// Check BLAKE3 of downloaded file
+func (dldr *MultiDownloader) CheckBLAKE3(hash string) error {
+	return checkFileDigest(dldr.filename, "BLAKE3", blake3.New(32, nil), hash)
+}
+
+// Check XXH64 of downloaded file
+func (dldr *MultiDownloader) CheckXXHash(hash string) error {
+	return checkFileDigest(dldr.filename, "XXH64", xxhash.New(), hash)
+}
+
+// A digest set with WithHash, in hex
+type customHash struct {
+	h        func() hash.Hash
+	expected string
+}
+
+// An expected digest of the file, with the hash computing it
+type expectedDigest struct {
+	name     string
+	h        hash.Hash
+	expected string
+}
+
+// Internal: the digests the file must have, with fresh hashes
+func (dldr *MultiDownloader) expectedDigests() []expectedDigest {
+	all := []expectedDigest{
+		{"MD5", md5.New(), dldr.md5},
+		{"SHA256", sha256.New(), dldr.sha256},
+		{"SHA-1", sha1.New(), dldr.sha1},
+		{"SHA-512", sha512.New(), dldr.sha512},
+		{"CRC-32", crc32.NewIEEE(), dldr.crc32},
+		{"CRC-32C", crc32.New(castagnoliTable), dldr.crc32c},
+		{"BLAKE2b", newBLAKE2b(), dldr.blake2b},
+		{"BLAKE3", blake3.New(32, nil), dldr.blake3},
+		{"XXH64", xxhash.New(), dldr.xxhash},
+	}
+	digests := all[:0]
+	for _, d := range all {
+		if d.expected != "" {
+			digests = append(digests, d)
+		}
+	}
+	for _, c := range dldr.hashes {
+		digests = append(digests, expectedDigest{"digest", c.h(), c.expected})
+	}
+	return append(digests, dldr.integrityDigest()...)
+}
+
+// Internal: check the digests the file must have, if any, reading it once
+func (dldr *MultiDownloader) verifyDigests(filename string) error {
+	digests := dldr.expectedDigests()
+	if len(digests) == 0 {
+		return nil
+	}
+	if err := hashDigests(filename, digests, dldr.readBuffers); err != nil {
+		return err
+	}
+	return checkDigests(digests)
+}
+
+// Internal: check the digests of the downloaded partial file. They were
+// computed as it was written, unless the download didn't write all of it in
+// place, or wrote some parts again after they were hashed.
+func (dldr *MultiDownloader) verifyDownload(hashed <-chan []expectedDigest) error {
+	if hashed != nil && dldr.written.intact(dldr.fileLength) {
+		if digests := <-hashed; digests != nil {
+			return checkDigests(digests)
+		}
+	}
+	return dldr.verifyDigests(dldr.partFilename)
+}
+
+// Internal: hash the partial file in order as it's written, while it's still
+// in the page cache, so that checking it once downloaded adds next to
+// nothing. The channel gets the digests once all the file is hashed, or nil
+// if the download ends without it. Encoded files are only checked once
+// decoded.
+func (dldr *MultiDownloader) hashWhileWritten() <-chan []expectedDigest {
+	digests := dldr.expectedDigests()
+	if len(digests) == 0 || dldr.encoding != "" || dldr.fileLength <= 0 {
+		return nil
+	}
+	hashed := make(chan []expectedDigest, 1)
+	go func() {
+		file, err := os.Open(dldr.partFilename)
+		if err != nil {
+			hashed <- nil
+			return
+		}
+		defer file.Close()
+		w := digestWriter(digests)
+		buf := make([]byte, streamBlockSize)
+		for pos := int64(0); pos < dldr.fileLength; {
+			end := min(pos+streamBlockSize, dldr.fileLength)
+			if err := dldr.written.wait(pos, end); err != nil {
+				hashed <- nil
+				return
+			}
+			n, err := file.ReadAt(buf[:end-pos], pos)
+			if int64(n) < end-pos {
+				dldr.logVerbose("Hashing the partial file: ", err)
+				hashed <- nil
+				return
+			}
+			w.Write(buf[:n])
+			pos = end
+		}
+		hashed <- digests
+	}()
+	return hashed
+}
+
+// Internal: compute the digests of a whole file, reading it through a buffer
+// of the pool
+func hashDigests(filename string, digests []expectedDigest, pool *bufferPool) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	buf := pool.get()
+	defer pool.put(buf)
+	_, err = io.CopyBuffer(digestWriter(digests), file, *buf)
+	return err
+}
+
+// Internal: a writer feeding all the digests
+func digestWriter(digests []expectedDigest) io.Writer {
+	writers := make([]io.Writer, len(digests))
+	for i, d := range digests {
+		writers[i] = d.h
+	}
+	return io.MultiWriter(writers...)
+}
+
+// Internal: compare the computed digests with the expected ones, in hex
+func checkDigests(digests []expectedDigest) error {
+	for _, d := range digests {
+		if computed := fmt.Sprintf("%x", d.h.Sum(nil)); computed != strings.ToLower(d.expected) {
+			return withHint(errors.New(
+				fmt.Sprintf(
+					"Computed %s does not match: provided=%s computed=%s",
+					d.name, d.expected, computed)),
+				"Some source may serve a corrupt copy: download again from the others, or give "+
+					"block checksums (zsync) to download only the corrupt blocks again")
+		}
+	}
+	return nil
+}
+
+// Internal: whether the file has expected digests to check
+func (dldr *MultiDownloader) hasDigests() bool {
+	return dldr.md5 != "" || dldr.sha256 != "" || dldr.sha1 != "" || dldr.sha512 != "" ||
+		dldr.crc32 != "" || dldr.crc32c != "" ||
+		dldr.blake2b != "" || dldr.blake3 != "" || dldr.xxhash != "" || dldr.integrity != "" ||
+		len(dldr.hashes) > 0
+}
+
+// Internal: whether the caller gave cryptographic digests of the file, so
+// that an existing file having them is taken as already downloaded. CRCs and
+// digests published by the sources don't tell it wasn't tampered with.
+func (dldr *MultiDownloader) trustsDigests() bool {
+	return !dldr.headerSums && (dldr.md5 != "" || dldr.sha256 != "" || dldr.sha1 != "" ||
+		dldr.sha512 != "" || dldr.blake2b != "" || dldr.blake3 != "" || dldr.integrity != "" ||
+		len(dldr.hashes) > 0)
+}
+
+// Internal: a BLAKE2b-512 hash, which can't fail without a key
+func newBLAKE2b() hash.Hash {
+	h, _ := blake2b.New512(nil)
+	return h
+}
+
+// Internal: take the checksums the sources publish in their headers, those
+// they all agree on, unless the caller gave some. Encoded files are left out,
+// as the checksums may be those of the encoded file.
+func (dldr *MultiDownloader) headerDigests(infos []urlInfo) {
+	if dldr.encoding != "" || dldr.hasDigests() {
+		return
+	}
+	sums := headerChecksums(infos[0].header)
+	for _, info := range infos[1:] {
+		others := headerChecksums(info.header)
+		for algorithm, sum := range sums {
+			if others[algorithm] != sum {
+				delete(sums, algorithm)
+			}
+		}
+	}
+	for algorithm, sum := range sums {
+		dldr.logVerbose("The sources publish a ", algorithm, " of ", sum, ", it will be checked")
+		dldr.setDigest(ChecksumEntry{Algorithm: algorithm, Sum: sum})
+		dldr.headerSums = true
+	}
+}
+
+// Internal: the digests of the file in headers, in hex by algorithm
+func headerChecksums(header http.Header) map[string]string {
+	sums := make(map[string]string)
+	add := func(algorithm, encoded string, size int) {
+		sum, err := base64.StdEncoding.DecodeString(encoded)
+		if err == nil && len(sum) == size {
+			sums[algorithm] = hex.EncodeToString(sum)
+		}
+	}
+	// Lists of algorithm=base64, the value keeping its padding. Repr-Digest
+	// has the value between colons.
+	for _, name := range []string{"Digest", "Repr-Digest", "X-Goog-Hash"} {
+		for _, value := range header.Values(name) {
+			for _, field := range strings.Split(value, ",") {
+				algorithm, encoded, _ := strings.Cut(strings.TrimSpace(field), "=")
+				encoded = strings.Trim(encoded, ":")
+				switch strings.ToLower(algorithm) {
+				case "md5":
+					add("MD5", encoded, md5.Size)
+				case "sha":
+					add("SHA1", encoded, sha1.Size)
+				case "sha-256":
+					add("SHA256", encoded, sha256.Size)
+				case "sha-512":
+					add("SHA512", encoded, sha512.Size)
+				case "crc32c":
+					add("CRC32C", encoded, crc32.Size)
+				}
+			}
+		}
+	}
+	if value := header.Get("Content-MD5"); value != "" {
+		add("MD5", value, md5.Size)
+	}
+	return sums
+}
+
+// Internal: compare the digest of a file with the expected one, in hex
+func checkFileDigest(filename, name string, h hash.Hash, expected string) error {
+	digests := []expectedDigest{{name, h, expected}}
+	if err := hashDigests(filename, digests, buffers(defaultReadBufferSize)); err != nil {
+		return err
+	}
+	return checkDigests(digests)
+}
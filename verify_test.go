@@ -0,0 +1,194 @@
+package multipartdownloader
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	quijoteCRC32   = "bade9bd2"
+	quijoteCRC32C  = "76bc381f"
+	quijoteBLAKE2b = "fd9ed27cb1a35b98eb400f4155cbbefcb83714e331ea867aa9914b4459f5aebb" +
+		"07a5bc755d1b0546950a1edc4d34a878d2ca19e6cbd5b62b14cace6828a40f61"
+	quijoteBLAKE3 = "04d3c5931b13941d1012dcc2c619fd2f70ab096e6177e8c29c2565957cb6dfba"
+	quijoteXXHash = "634586621acd5199"
+	quijoteSHA1   = "e10ddbc97ae8104b77a2006e5d2d017fc04ecd27"
+	quijoteSHA512 = "dab84f006d203357b56b0de2d0779f78a0de555aa98a7a29a67e42b10c450896" +
+		"b4cd9ad901678ef67d3f352414b832ef398c170dfb2bda0c62cc3ecd9240b0ad"
+)
+
+func TestSHA1(t *testing.T) {
+	dldr := &MultiDownloader{filename: "test/quijote.txt"}
+	failOnError(t, dldr.CheckSHA1(quijoteSHA1))
+	if dldr.CheckSHA1("wrong-hash") == nil {
+		t.Error("A wrong SHA-1 should fail the check")
+	}
+
+	fileServer := http.FileServer(http.Dir("./test"))
+	failOnError(t, downloadLocal(t, fileServer, 2, WithSHA1(quijoteSHA1)))
+	if downloadLocal(t, fileServer, 2, WithSHA1("e10ddbc97ae8104b77a2006e5d2d017fc04ecd28")) == nil {
+		t.Error("A download with the wrong SHA-1 should fail")
+	}
+}
+
+func TestSHA512(t *testing.T) {
+	dldr := &MultiDownloader{filename: "test/quijote.txt"}
+	failOnError(t, dldr.CheckSHA512(quijoteSHA512))
+	if dldr.CheckSHA512(quijoteSHA1) == nil {
+		t.Error("A wrong SHA-512 should fail the check")
+	}
+
+	fileServer := http.FileServer(http.Dir("./test"))
+	failOnError(t, downloadLocal(t, fileServer, 2, WithSHA512(quijoteSHA512)))
+	if downloadLocal(t, fileServer, 2, WithSHA512(quijoteSHA512[1:]+"0")) == nil {
+		t.Error("A download with the wrong SHA-512 should fail")
+	}
+}
+
+func TestCRC32(t *testing.T) {
+	dldr := &MultiDownloader{filename: "test/quijote.txt"}
+	failOnError(t, dldr.CheckCRC32(quijoteCRC32))
+	failOnError(t, dldr.CheckCRC32C(quijoteCRC32C))
+	if dldr.CheckCRC32C(quijoteCRC32) == nil {
+		t.Error("A wrong CRC-32C should fail the check")
+	}
+
+	// The CRC-32C of GCS headers is checked
+	fileServer := http.FileServer(http.Dir("./test"))
+	withHash := func(hash string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Goog-Hash", "crc32c="+hash+",md5=RbtfyWu0xnd40oj7qY7uSA==")
+			fileServer.ServeHTTP(w, r)
+		})
+	}
+	failOnError(t, downloadLocal(t, withHash("drw4Hw=="), 2))
+	if downloadLocal(t, withHash("AAAAAA=="), 2) == nil {
+		t.Error("A download with the wrong CRC-32C header should fail")
+	}
+	failOnError(t, downloadLocal(t, fileServer, 2, WithCRC32(quijoteCRC32)))
+}
+
+func TestFastHashes(t *testing.T) {
+	dldr := &MultiDownloader{filename: "test/quijote.txt"}
+	failOnError(t, dldr.CheckBLAKE2b(quijoteBLAKE2b))
+	failOnError(t, dldr.CheckBLAKE3(quijoteBLAKE3))
+	failOnError(t, dldr.CheckXXHash(quijoteXXHash))
+	if dldr.CheckBLAKE3(quijoteBLAKE2b[:64]) == nil {
+		t.Error("A wrong BLAKE3 should fail the check")
+	}
+
+	fileServer := http.FileServer(http.Dir("./test"))
+	failOnError(t, downloadLocal(t, fileServer, 2, WithBLAKE3(quijoteBLAKE3), WithXXHash(quijoteXXHash)))
+	if downloadLocal(t, fileServer, 2, WithBLAKE2b(quijoteBLAKE2b[1:]+"0")) == nil {
+		t.Error("A download with the wrong BLAKE2b should fail")
+	}
+}
+
+// The file is hashed as the ranges are written, in any order
+func TestHashWhileWritten(t *testing.T) {
+	data, err := os.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	part := filepath.Join(t.TempDir(), "quijote.txt.part")
+	failOnError(t, os.WriteFile(part, data, 0666))
+	length := int64(len(data))
+
+	for _, expected := range []string{quijoteSHA1, quijoteSHA1[1:] + "0"} {
+		dldr := &MultiDownloader{
+			partFilename: part, fileLength: length, sha1: expected, written: newRangeProgress()}
+		dldr.written.start(length, []Chunk{{0, length}})
+		hashed := dldr.hashWhileWritten()
+		for end := length; end > 0; end -= 50000 {
+			dldr.written.add(max(end-50000, 0), end)
+		}
+		digests := <-hashed
+		if digests == nil {
+			t.Fatal("The file should be hashed once written")
+		}
+		if err := checkDigests(digests); (err == nil) != (expected == quijoteSHA1) {
+			t.Errorf("Checking SHA-1 %s: %v", expected, err)
+		}
+	}
+}
+
+func TestHeaderDigests(t *testing.T) {
+	fileServer := http.FileServer(http.Dir("./test"))
+	withHeader := func(name, value string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set(name, value)
+			fileServer.ServeHTTP(w, r)
+		})
+	}
+	testTable := []struct {
+		name, value string
+		opts        []Option
+		ok          bool
+	}{
+		{"Digest", "SHA-256=HpuxsW+IEORNbV7ecAUlhRj6l2cZvC7SVDCOc8NXz8w=", nil, true},
+		{"Digest", "sha-256=AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA=", nil, false},
+		{"Repr-Digest", "sha-256=:HpuxsW+IEORNbV7ecAUlhRj6l2cZvC7SVDCOc8NXz8w=:", nil, true},
+		{"Content-MD5", "RbtfyWu0xnd40oj7qY7uSA==", nil, true},
+		{"Content-MD5", "AAAAAAAAAAAAAAAAAAAAAA==", nil, false},
+		// The digests given by the caller take precedence
+		{"Content-MD5", "AAAAAAAAAAAAAAAAAAAAAA==", []Option{WithSHA1(quijoteSHA1)}, true},
+	}
+	for _, test := range testTable {
+		err := downloadLocal(t, withHeader(test.name, test.value), 2, test.opts...)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: %s: %v", test.name, test.value, err)
+		}
+	}
+}
+
+func TestIntegrity(t *testing.T) {
+	for _, s := range []string{
+		"sha256-HpuxsW+IEORNbV7ecAUlhRj6l2cZvC7SVDCOc8NXz8w=",
+		"md5-RbtfyWu0xnd40oj7qY7uSA== sha256-HpuxsW+IEORNbV7ecAUlhRj6l2cZvC7SVDCOc8NXz8w=?ct=text/plain",
+		"sha256:" + quijoteSHA256,
+	} {
+		e, err := ParseIntegrity(s)
+		failOnError(t, err)
+		if e.Algorithm != "sha256" || e.Sum != quijoteSHA256 {
+			t.Errorf("%q parsed as %v", s, e)
+		}
+	}
+	for _, s := range []string{"", "sha256-bm90IGEgZGlnZXN0", "whirlpool-AAAA", "sha1:xyz"} {
+		if _, err := ParseIntegrity(s); err == nil {
+			t.Errorf("%q should be refused", s)
+		}
+	}
+
+	dldr := &MultiDownloader{filename: "test/quijote.txt"}
+	failOnError(t, dldr.Verify("sha1:"+quijoteSHA1))
+	if dldr.Verify("sha512-"+base64.StdEncoding.EncodeToString(make([]byte, 64))) == nil {
+		t.Error("A wrong SHA-512 should fail the check")
+	}
+
+	fileServer := http.FileServer(http.Dir("./test"))
+	failOnError(t, downloadLocal(t, fileServer, 2,
+		WithIntegrity("sha256-HpuxsW+IEORNbV7ecAUlhRj6l2cZvC7SVDCOc8NXz8w=")))
+	if downloadLocal(t, fileServer, 2, WithIntegrity("sha1:"+quijoteSHA1[1:]+"0")) == nil {
+		t.Error("A download with the wrong integrity should fail")
+	}
+}
+
+func TestCustomHash(t *testing.T) {
+	sum, err := hex.DecodeString(quijoteSHA1)
+	failOnError(t, err)
+	dldr := &MultiDownloader{filename: "test/quijote.txt"}
+	failOnError(t, dldr.CheckHash(sha1.New, sum))
+	if dldr.CheckHash(md5.New, sum) == nil {
+		t.Error("A wrong digest should fail the check")
+	}
+
+	fileServer := http.FileServer(http.Dir("./test"))
+	failOnError(t, downloadLocal(t, fileServer, 2, WithHash(sha1.New, sum)))
+	if downloadLocal(t, fileServer, 2, WithHash(sha1.New, sum), WithHash(md5.New, sum)) == nil {
+		t.Error("A download with a wrong digest should fail")
+	}
+}
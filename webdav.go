@@ -0,0 +1,134 @@
+package multipartdownloader
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// WebDAV shares (Nextcloud, ownCloud, Apache mod_dav...) can be used as
+// mirrors. They are addressed either with the dav:// and davs:// schemes, in
+// which case the file info is always read with PROPFIND, or with plain HTTP
+// URLs, in which case PROPFIND is only used when HEAD is not good enough.
+// The chunks themselves are always fetched with ranged GETs.
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:getcontentlength/>
+    <d:getetag/>
+    <d:getlastmodified/>
+    <d:getcontenttype/>
+    <d:resourcetype/>
+  </d:prop>
+</d:propfind>`
+
+// PROPFIND response, only the properties we ask for
+type davMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ContentLength string `xml:"DAV: getcontentlength"`
+				ETag          string `xml:"DAV: getetag"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+				ContentType   string `xml:"DAV: getcontenttype"`
+				ResourceType  struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// Tell whether the URL uses one of the WebDAV schemes
+func isDAV(urlStr string) bool {
+	return strings.HasPrefix(urlStr, "dav://") || strings.HasPrefix(urlStr, "davs://")
+}
+
+// Map the dav:// and davs:// schemes to the HTTP scheme they travel over
+func httpURL(urlStr string) string {
+	u, err := url.Parse(urlStr)
+	if err != nil {
+		return urlStr
+	}
+	switch u.Scheme {
+	case "dav":
+		u.Scheme = "http"
+	case "davs":
+		u.Scheme = "https"
+	default:
+		return urlStr
+	}
+	return u.String()
+}
+
+// Get the length, ETag and modification date of a WebDAV resource with
+// PROPFIND. They are also given as the headers a HEAD would have returned.
+func (dldr *MultiDownloader) propfind(ctx context.Context, client *http.Client, urlStr string) (urlInfo, error) {
+	req, err := dldr.newRequest("PROPFIND", httpURL(urlStr), strings.NewReader(propfindBody))
+	if err != nil {
+		return urlInfo{}, err
+	}
+	req.Header.Set("Depth", "0")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return urlInfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus {
+		return urlInfo{}, errors.New(
+			fmt.Sprintf("PROPFIND returned status %d", resp.StatusCode))
+	}
+
+	var ms davMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return urlInfo{}, err
+	}
+	for _, r := range ms.Responses {
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			if ps.Prop.ResourceType.Collection != nil {
+				return urlInfo{}, errors.New("WebDAV resource is a collection")
+			}
+			if ps.Prop.ContentLength == "" {
+				continue
+			}
+			flen, err := strconv.ParseInt(strings.TrimSpace(ps.Prop.ContentLength), 10, 64)
+			if err != nil {
+				return urlInfo{}, err
+			}
+			info := urlInfo{
+				url:         urlStr,
+				fileLength:  flen,
+				etag:        strings.TrimSpace(ps.Prop.ETag),
+				connSuccess: true,
+				statusCode:  http.StatusOK, // The resource exists and is readable
+				header:      http.Header{},
+			}
+			// An HTTP date, normalized to compare it with those of the other sources
+			if t, err := http.ParseTime(strings.TrimSpace(ps.Prop.LastModified)); err == nil {
+				info.lastMod = t.UTC().Format(http.TimeFormat)
+				info.header.Set("Last-Modified", info.lastMod)
+			}
+			info.header.Set("Content-Length", strconv.FormatInt(flen, 10))
+			if info.etag != "" {
+				info.header.Set("Etag", info.etag)
+			}
+			if contentType := strings.TrimSpace(ps.Prop.ContentType); contentType != "" {
+				info.header.Set("Content-Type", contentType)
+			}
+			return info, nil
+		}
+	}
+	return urlInfo{}, errors.New("PROPFIND response lacks getcontentlength")
+}
@@ -0,0 +1,107 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A minimal WebDAV server exposing the test directory. HEAD is rejected, as
+// some DAV servers do, so the downloader has to resort to PROPFIND.
+func newDAVServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Join("test", filepath.Base(r.URL.Path))
+		switch r.Method {
+		case "HEAD":
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case "PROPFIND":
+			stat, err := os.Stat(name)
+			if err != nil {
+				http.NotFound(w, r)
+				return
+			}
+			w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+			w.WriteHeader(http.StatusMultiStatus)
+			fmt.Fprintf(w, `<?xml version="1.0"?>
+<d:multistatus xmlns:d="DAV:">
+  <d:response>
+    <d:href>%s</d:href>
+    <d:propstat>
+      <d:prop>
+        <d:getcontentlength>%d</d:getcontentlength>
+        <d:getetag>"dav-etag"</d:getetag>
+        <d:getlastmodified>Wed, 21 Oct 2015 07:28:00 GMT</d:getlastmodified>
+        <d:resourcetype/>
+      </d:prop>
+      <d:status>HTTP/1.1 200 OK</d:status>
+    </d:propstat>
+  </d:response>
+</d:multistatus>`, r.URL.Path, stat.Size())
+		case "GET":
+			http.ServeFile(w, r, name)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	}))
+}
+
+func TestHTTPURL(t *testing.T) {
+	testTable := []struct {
+		in  string
+		out string
+	}{
+		{"dav://example.com/remote.php/webdav/file.iso", "http://example.com/remote.php/webdav/file.iso"},
+		{"davs://example.com/file.iso", "https://example.com/file.iso"},
+		{"https://example.com/file.iso", "https://example.com/file.iso"},
+	}
+	for _, test := range testTable {
+		if out := httpURL(test.in); out != test.out {
+			t.Errorf("httpURL(%q) = %q, should be %q", test.in, out, test.out)
+		}
+	}
+}
+
+// Download from a WebDAV share, both with dav:// and plain http:// URLs
+func TestWebDAVSource(t *testing.T) {
+	server := newDAVServer(t)
+	defer server.Close()
+
+	reference, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+
+	for _, u := range []string{
+		server.URL + "/quijote.txt",
+		strings.Replace(server.URL, "http://", "dav://", 1) + "/quijote.txt",
+	} {
+		dldr := NewMultiDownloader([]string{u}, 3, time.Duration(5000)*time.Millisecond)
+		_, err := dldr.GatherInfo()
+		failOnError(t, err)
+		if dldr.fileLength != int64(len(reference)) {
+			t.Errorf("PROPFIND length is %d, should be %d", dldr.fileLength, len(reference))
+		}
+		if dldr.ETag != "dav-etag" {
+			t.Errorf("PROPFIND ETag is %q", dldr.ETag)
+		}
+		if dldr.lastModified != "Wed, 21 Oct 2015 07:28:00 GMT" {
+			t.Errorf("PROPFIND modification date is %q", dldr.lastModified)
+		}
+
+		_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+		failOnError(t, err)
+		err = dldr.Download(nil)
+		failOnError(t, err)
+
+		downloaded, err := ioutil.ReadFile(dldr.filename)
+		failOnError(t, err)
+		if !bytes.Equal(reference, downloaded) {
+			t.Errorf("File downloaded from %s differs from the original", u)
+		}
+	}
+}
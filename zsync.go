@@ -0,0 +1,341 @@
+package multipartdownloader
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/md4"
+)
+
+// Delta downloads, zsync style. A zsync control file, generated next to the
+// file on the server, holds a weak rolling checksum and a strong (MD4)
+// checksum of every block of the file. Scanning an older local version of the
+// file for blocks with the same checksums tells which parts of the new file
+// are already available, so only the rest has to be downloaded.
+
+// Parsed zsync control file
+type ZsyncControl struct {
+	Filename  string   // Name of the target file
+	Length    int64    // Length of the target file
+	BlockSize int      // Size of the blocks covered by the checksums
+	URLs      []string // Locations of the target file, maybe relative to the control file
+	SHA1      string   // Hex SHA-1 of the whole target file
+
+	seqMatches    int // Consecutive blocks that must match to accept a match
+	rsumBytes     int // Stored bytes of each weak checksum
+	checksumBytes int // Stored bytes of each strong checksum
+	blocks        []zsyncBlock
+}
+
+// Checksums of a block of the target file
+type zsyncBlock struct {
+	rsum     uint32 // Weak checksum, as a<<16 | b with a masked to rsumBytes
+	checksum []byte // Truncated MD4
+}
+
+// Parse a zsync control file
+func ParseZsync(r io.Reader) (*ZsyncControl, error) {
+	br := bufio.NewReader(r)
+	ctrl := &ZsyncControl{Length: -1}
+
+	// Text headers, up to an empty line
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, errors.New("Truncated zsync header")
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			return nil, errors.New(fmt.Sprintf("Malformed zsync header line: %q", line))
+		}
+		key, value := line[:colon], strings.TrimSpace(line[colon+1:])
+		switch key {
+		case "Filename":
+			ctrl.Filename = value
+		case "Length":
+			ctrl.Length, err = strconv.ParseInt(value, 10, 64)
+		case "Blocksize":
+			ctrl.BlockSize, err = strconv.Atoi(value)
+		case "URL":
+			ctrl.URLs = append(ctrl.URLs, value)
+		case "SHA-1":
+			ctrl.SHA1 = strings.ToLower(value)
+		case "Hash-Lengths":
+			parts := strings.Split(value, ",")
+			if len(parts) != 3 {
+				return nil, errors.New("Malformed zsync Hash-Lengths")
+			}
+			if ctrl.seqMatches, err = strconv.Atoi(parts[0]); err != nil {
+				break
+			}
+			if ctrl.rsumBytes, err = strconv.Atoi(parts[1]); err != nil {
+				break
+			}
+			ctrl.checksumBytes, err = strconv.Atoi(parts[2])
+		case "Z-URL", "Z-Map2":
+			return nil, errors.New("Compressed zsync targets are not supported")
+		}
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("Malformed zsync header %s: %s", key, err))
+		}
+	}
+	if ctrl.Length < 0 || ctrl.BlockSize <= 0 {
+		return nil, errors.New("zsync header lacks Length or Blocksize")
+	}
+	if ctrl.seqMatches == 0 {
+		ctrl.seqMatches, ctrl.rsumBytes, ctrl.checksumBytes = 1, 4, 16
+	}
+	if ctrl.seqMatches < 1 || ctrl.seqMatches > 2 ||
+		ctrl.rsumBytes < 1 || ctrl.rsumBytes > 4 ||
+		ctrl.checksumBytes < 3 || ctrl.checksumBytes > 16 {
+		return nil, errors.New("Unsupported zsync Hash-Lengths")
+	}
+
+	// Binary block checksums
+	nBlocks := (ctrl.Length + int64(ctrl.BlockSize) - 1) / int64(ctrl.BlockSize)
+	mask := ctrl.rsumMask()
+	record := make([]byte, ctrl.rsumBytes+ctrl.checksumBytes)
+	ctrl.blocks = make([]zsyncBlock, nBlocks)
+	for i := range ctrl.blocks {
+		if _, err := io.ReadFull(br, record); err != nil {
+			return nil, errors.New("Truncated zsync block checksums")
+		}
+		var rsum [4]byte
+		copy(rsum[4-ctrl.rsumBytes:], record[:ctrl.rsumBytes])
+		a := uint32(rsum[0])<<8 | uint32(rsum[1])
+		b := uint32(rsum[2])<<8 | uint32(rsum[3])
+		ctrl.blocks[i] = zsyncBlock{
+			rsum:     (a&mask)<<16 | b,
+			checksum: append([]byte(nil), record[ctrl.rsumBytes:]...),
+		}
+	}
+	return ctrl, nil
+}
+
+// Resolve the target URLs of the control file, which may be relative to the
+// location the control file was fetched from
+func (ctrl *ZsyncControl) ResolveURLs(base string) []string {
+	baseURL, err := url.Parse(base)
+	urls := make([]string, 0, len(ctrl.URLs))
+	for _, u := range ctrl.URLs {
+		ref, errRef := url.Parse(u)
+		if err != nil || errRef != nil {
+			urls = append(urls, u)
+			continue
+		}
+		urls = append(urls, baseURL.ResolveReference(ref).String())
+	}
+	return urls
+}
+
+// Download only the parts of the file that are not already present in seed,
+// an older local version of the file, according to the zsync control file.
+// GatherInfo and SetupFile must have been called before.
+func (dldr *MultiDownloader) DownloadDelta(
+	seed string,
+	ctrl *ZsyncControl,
+	feedbackFunc func([]ConnectionProgress)) error {
+	if ctrl.Length != dldr.fileLength {
+		return errors.New("The zsync control file describes a different file")
+	}
+	in, err := os.Open(seed)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dldr.partFilename, os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+
+	// Copy every block found in the seed to its place in the new file
+	reused := int64(0)
+	matched, err := ctrl.scan(in, func(block int, data []byte) error {
+		offset := int64(block) * int64(ctrl.BlockSize)
+		if rest := ctrl.Length - offset; rest < int64(len(data)) {
+			data = data[:rest]
+		}
+		reused += int64(len(data))
+		_, err := out.WriteAt(data, offset)
+		return err
+	})
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return err
+	}
+	dldr.logVerbose("Reused ", reused, " bytes from ", seed)
+
+	// Download the blocks that weren't found, the result is checked against
+	// the SHA-1 of the control file before it gets its final name
+	dldr.chunks = balanceChunks(ctrl.missingChunks(matched), dldr.nConns)
+	dldr.sha1 = ctrl.SHA1
+	return dldr.Download(feedbackFunc)
+}
+
+// Internal: mask of the stored bits of the a half of the weak checksum
+func (ctrl *ZsyncControl) rsumMask() uint32 {
+	switch ctrl.rsumBytes {
+	case 4:
+		return 0xffff
+	case 3:
+		return 0xff
+	}
+	return 0
+}
+
+// Internal: weak checksum of a block, as zsync computes it
+func rsum(data []byte) (a, b uint16) {
+	l := uint16(len(data))
+	for _, c := range data {
+		a += uint16(c)
+		b += l * uint16(c)
+		l--
+	}
+	return
+}
+
+// Internal: strong checksum of a block
+func (ctrl *ZsyncControl) strongSum(data []byte) []byte {
+	h := md4.New()
+	h.Write(data)
+	return h.Sum(nil)[:ctrl.checksumBytes]
+}
+
+// Internal: check a block of data against the checksums of the given block
+func (ctrl *ZsyncControl) blockMatches(block int, data []byte) bool {
+	if len(data) < ctrl.BlockSize {
+		return false
+	}
+	a, b := rsum(data[:ctrl.BlockSize])
+	if (uint32(a)&ctrl.rsumMask())<<16|uint32(b) != ctrl.blocks[block].rsum {
+		return false
+	}
+	return bytes.Equal(ctrl.strongSum(data[:ctrl.BlockSize]), ctrl.blocks[block].checksum)
+}
+
+// Internal: scan the seed with a rolling checksum, calling found for every
+// block of the target file present in it. Returns which blocks were found.
+func (ctrl *ZsyncControl) scan(seed io.Reader, found func(int, []byte) error) ([]bool, error) {
+	bs := ctrl.BlockSize
+	mask := ctrl.rsumMask()
+	index := make(map[uint32][]int)
+	for i, blk := range ctrl.blocks {
+		index[blk.rsum] = append(index[blk.rsum], i)
+	}
+	matched := make([]bool, len(ctrl.blocks))
+
+	// Sliding window over the seed. The end of the seed is padded with zeros,
+	// the same way the last block of the target is when computing checksums.
+	reader := bufio.NewReaderSize(seed, 1<<16)
+	readBuf := make([]byte, 1<<16)
+	var window []byte
+	pos := 0
+	eof := false
+	fill := func(need int) error {
+		for len(window)-pos < need && !eof {
+			if pos > 0 {
+				window = window[:copy(window, window[pos:])]
+				pos = 0
+			}
+			n, err := reader.Read(readBuf)
+			window = append(window, readBuf[:n]...)
+			if err == io.EOF {
+				eof = true
+				window = append(window, make([]byte, bs)...)
+			} else if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	lookahead := bs * ctrl.seqMatches
+	if err := fill(lookahead); err != nil {
+		return nil, err
+	}
+	if len(window)-pos < bs {
+		return matched, nil
+	}
+	a, b := rsum(window[pos : pos+bs])
+	for {
+		hit := false
+		if candidates := index[(uint32(a)&mask)<<16|uint32(b)]; len(candidates) > 0 {
+			strong := ctrl.strongSum(window[pos : pos+bs])
+			for _, k := range candidates {
+				if matched[k] || !bytes.Equal(strong, ctrl.blocks[k].checksum) {
+					continue
+				}
+				// Short checksums need the following block to match too
+				if ctrl.seqMatches > 1 && k+1 < len(ctrl.blocks) &&
+					!ctrl.blockMatches(k+1, window[pos+bs:]) {
+					continue
+				}
+				matched[k] = true
+				if err := found(k, window[pos:pos+bs]); err != nil {
+					return nil, err
+				}
+				hit = true
+			}
+		}
+
+		if hit {
+			// Skip the matched block, and start over the checksum after it
+			pos += bs
+			if err := fill(lookahead); err != nil {
+				return nil, err
+			}
+			if len(window)-pos < bs {
+				break
+			}
+			a, b = rsum(window[pos : pos+bs])
+			continue
+		}
+
+		// Roll the checksum one byte forward
+		if err := fill(lookahead + 1); err != nil {
+			return nil, err
+		}
+		if len(window)-pos < bs+1 {
+			break
+		}
+		oldc, newc := uint16(window[pos]), uint16(window[pos+bs])
+		a += newc - oldc
+		b += a - uint16(bs)*oldc
+		pos++
+	}
+	return matched, nil
+}
+
+// Internal: coalesce the blocks not found into chunks to download
+func (ctrl *ZsyncControl) missingChunks(matched []bool) []Chunk {
+	var chunks []Chunk
+	bs := int64(ctrl.BlockSize)
+	for i := 0; i < len(matched); i++ {
+		if matched[i] {
+			continue
+		}
+		begin := int64(i) * bs
+		for i+1 < len(matched) && !matched[i+1] {
+			i++
+		}
+		end := (int64(i) + 1) * bs
+		if end > ctrl.Length {
+			end = ctrl.Length
+		}
+		chunks = append(chunks, Chunk{begin, end})
+	}
+	return chunks
+}
@@ -0,0 +1,142 @@
+package multipartdownloader
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/md4"
+)
+
+// Build a zsync control file for data, as zsyncmake would
+func makeZsync(data []byte, blockSize, seqMatches, rsumBytes, checksumBytes int) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "zsync: 0.6.2\nFilename: quijote.txt\nBlocksize: %d\nLength: %d\n",
+		blockSize, len(data))
+	fmt.Fprintf(&buf, "Hash-Lengths: %d,%d,%d\nURL: quijote.txt\nSHA-1: %x\n\n",
+		seqMatches, rsumBytes, checksumBytes, sha1.Sum(data))
+	for offset := 0; offset < len(data); offset += blockSize {
+		block := make([]byte, blockSize)
+		copy(block, data[offset:])
+		a, b := rsum(block)
+		r := []byte{byte(a >> 8), byte(a), byte(b >> 8), byte(b)}
+		buf.Write(r[4-rsumBytes:])
+		h := md4.New()
+		h.Write(block)
+		buf.Write(h.Sum(nil)[:checksumBytes])
+	}
+	return buf.Bytes()
+}
+
+func TestParseZsync(t *testing.T) {
+	data := []byte("a small file, spanning a few blocks of sixteen bytes")
+	ctrl, err := ParseZsync(bytes.NewReader(makeZsync(data, 16, 2, 3, 5)))
+	failOnError(t, err)
+	if ctrl.Length != int64(len(data)) || ctrl.BlockSize != 16 || len(ctrl.blocks) != 4 {
+		t.Errorf("Wrong zsync header: %+v", ctrl)
+	}
+	urls := ctrl.ResolveURLs("http://example.com/files/quijote.txt.zsync")
+	if len(urls) != 1 || urls[0] != "http://example.com/files/quijote.txt" {
+		t.Errorf("Wrong resolved URLs: %v", urls)
+	}
+	if _, err := ParseZsync(bytes.NewReader(makeZsync(data, 16, 1, 4, 16)[:150])); err == nil {
+		t.Error("Truncated control files should be rejected")
+	}
+}
+
+// Download a new version of a file, reusing an older local copy
+func TestDownloadDelta(t *testing.T) {
+	target, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+
+	// The old version has some text inserted at the beginning, which shifts
+	// all blocks, and a region changed in the middle
+	seed := append([]byte("Prólogo de una edición antigua\n"), target...)
+	copy(seed[100000:], bytes.Repeat([]byte("x"), 5000))
+	seedName := filepath.Join(t.TempDir(), "quijote-old.txt")
+	failOnError(t, ioutil.WriteFile(seedName, seed, 0644))
+
+	var served int64
+	fileServer := http.FileServer(http.Dir("./test"))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fileServer.ServeHTTP(&countingWriter{w, &served}, r)
+	}))
+	defer server.Close()
+
+	for _, hashLengths := range [][3]int{{1, 4, 16}, {2, 2, 5}, {2, 3, 8}} {
+		atomic.StoreInt64(&served, 0)
+		ctrl, err := ParseZsync(bytes.NewReader(
+			makeZsync(target, 2048, hashLengths[0], hashLengths[1], hashLengths[2])))
+		failOnError(t, err)
+
+		dldr := NewMultiDownloader(
+			ctrl.ResolveURLs(server.URL+"/quijote.txt.zsync"), 4, time.Duration(5000)*time.Millisecond)
+		_, err = dldr.GatherInfo()
+		failOnError(t, err)
+		_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+		failOnError(t, err)
+		err = dldr.DownloadDelta(seedName, ctrl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		downloaded, err := ioutil.ReadFile(dldr.filename)
+		failOnError(t, err)
+		if !bytes.Equal(target, downloaded) {
+			t.Errorf("Delta download with hash lengths %v differs from the original", hashLengths)
+		}
+		if n := atomic.LoadInt64(&served); n > 4*2048+5000 {
+			t.Errorf("Delta download with hash lengths %v fetched %d bytes", hashLengths, n)
+		}
+		os.Remove(dldr.filename)
+	}
+}
+
+// A delta result not matching the SHA-1 of the control file never gets the
+// final name
+func TestDownloadDeltaMismatch(t *testing.T) {
+	target, err := ioutil.ReadFile("test/quijote.txt")
+	failOnError(t, err)
+	seedName := filepath.Join(t.TempDir(), "quijote-old.txt")
+	failOnError(t, ioutil.WriteFile(seedName, target, 0644))
+	server := httptest.NewServer(http.FileServer(http.Dir("./test")))
+	defer server.Close()
+
+	ctrl, err := ParseZsync(bytes.NewReader(makeZsync(target, 2048, 1, 4, 16)))
+	failOnError(t, err)
+	ctrl.SHA1 = fmt.Sprintf("%x", sha1.Sum([]byte("another file")))
+	dldr := NewMultiDownloader(
+		ctrl.ResolveURLs(server.URL+"/quijote.txt.zsync"), 2, time.Duration(5000)*time.Millisecond)
+	_, err = dldr.GatherInfo()
+	failOnError(t, err)
+	_, err = dldr.SetupFile(filepath.Join(t.TempDir(), "quijote.txt"))
+	failOnError(t, err)
+	if err := dldr.DownloadDelta(seedName, ctrl, nil); err == nil {
+		t.Fatal("The SHA-1 mismatch should be reported")
+	}
+	if _, err := os.Stat(dldr.filename); !os.IsNotExist(err) {
+		t.Error("The mismatching result shouldn't get the final name")
+	}
+	if _, err := os.Stat(dldr.partFilename); err != nil {
+		t.Error("The mismatching result should be left under the partial name")
+	}
+}
+
+// Count the body bytes sent by a handler
+type countingWriter struct {
+	http.ResponseWriter
+	n *int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	atomic.AddInt64(w.n, int64(len(p)))
+	return w.ResponseWriter.Write(p)
+}